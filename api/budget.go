@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/profile"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// /api/trips/{id}/budget -> { "total", "categories": [...], "members": [...], "currencies": [...], "rates" }
+// チャットの /pay で記録した支払いをカテゴリ・メンバー・通貨ごとに円で集計する。
+// vercel.json の rewrites で /api/budget?trip_id={id} に転送される。
+func Handler(w http.ResponseWriter, r *http.Request) {
+	messageStore, err := store.Open()
+	if err == nil {
+		var trips trip.Store
+		if trips, err = trip.Open(messageStore); err == nil {
+			people := trip.Directory{Store: messageStore}
+			// 名前がキャッシュにないメンバーは LINE から取得する
+			if bot, err := lineapi.New(); err == nil {
+				people.Profiles = profile.New(messageStore, bot)
+			}
+			trip.BudgetHandler(trips, people).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	log.Printf("Error opening trip store: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Trip store not configured"})
+}
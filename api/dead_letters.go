@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/admin"
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Handler lets operators list, inspect, replay and discard webhook events
+// that failed processing. It requires Authorization: Bearer $ADMIN_TOKEN.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	deadLetters, err := queue.OpenDeadLetters()
+	if err != nil {
+		log.Printf("❌ Error opening dead letters: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to open dead letters"})
+		return
+	}
+	admin.DeadLetters(deadLetters, replay).ServeHTTP(w, r)
+}
+
+// replay puts a job back on the webhook queue, or processes it right away
+// when the webhook runs inline.
+func replay(ctx context.Context, job queue.Job) error {
+	q, err := queue.Open()
+	if err != nil {
+		return err
+	}
+	if q != nil {
+		return q.Enqueue(ctx, job)
+	}
+
+	messageStore, err := store.Open()
+	if err != nil {
+		return err
+	}
+	event, err := webhook.UnmarshalEvent(job.Event)
+	if err != nil {
+		return err
+	}
+	return ingest.FromEnv(messageStore).HandleEvent(ctx, event)
+}
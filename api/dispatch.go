@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// dispatchBudget leaves headroom below Vercel's default 10 second limit.
+const dispatchBudget = 8 * time.Second
+
+// Handler sends the scheduled messages that are due. Vercel Cron calls it
+// every minute with Authorization: Bearer $CRON_SECRET and refuses to run
+// while CRON_SECRET is unset.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// 認証なしで誰でも呼べないよう、CRON_SECRET がなければ動かさない
+	secret := os.Getenv("CRON_SECRET")
+	if secret == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "CRON_SECRET not configured"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+secret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	if os.Getenv("LINE_CHANNEL_TOKEN") == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "LINE_CHANNEL_TOKEN not configured"})
+		return
+	}
+	bot, err := lineapi.New()
+	if err != nil {
+		log.Printf("❌ Error creating bot: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to create LINE client"})
+		return
+	}
+	messageStore, err := store.Open()
+	if err != nil {
+		log.Printf("❌ Error opening message store: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to open message store"})
+		return
+	}
+	schedule, err := outbound.OpenSchedule(messageStore)
+	if err != nil {
+		log.Printf("❌ Error opening schedule: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to open schedule"})
+		return
+	}
+
+	sender := outbound.NewSender(bot, messageStore)
+	sender.Timeline = messageStore
+	if trips, err := trip.Open(messageStore); err == nil {
+		sender.ActiveTrip = func(ctx context.Context, conversationID string) (string, error) {
+			return trip.ActiveID(ctx, trips, conversationID)
+		}
+	} else {
+		log.Printf("⚠️ Error opening trip store: %v", err)
+	}
+	dispatcher := outbound.NewDispatcher(schedule, sender)
+	ctx, cancel := context.WithTimeout(r.Context(), dispatchBudget)
+	defer cancel()
+
+	stats, err := dispatcher.Dispatch(ctx, time.Now())
+	if err != nil {
+		log.Printf("❌ Dispatcher error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("📅 Dispatched %d scheduled messages (%d retrying, %d failed)", stats.Sent, stats.Retrying, stats.Failed)
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// /api/groups -> { "groups": [...], "count": n }
+// iOSアプリのグループ選択用。メッセージ本体は含めない。
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	messageStore, err := store.Open()
+	if err != nil {
+		log.Printf("Error opening message store: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Message store not configured"})
+		return
+	}
+
+	groups, err := messageStore.Groups(r.Context())
+	if err != nil {
+		log.Printf("Error reading groups: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load groups"})
+		return
+	}
+	if groups == nil {
+		groups = []store.Group{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groups": groups,
+		"count":  len(groups),
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
 )
 
 func Handler(w http.ResponseWriter, r *http.Request) {
@@ -15,6 +17,13 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		"timestamp": time.Now().Unix(),
 		"service":   "LINE Trip List Webhook",
 	}
+
+	// Webhook 取り込みの件数（保存・重複・再送）
+	if messageStore, err := store.Open(); err == nil {
+		if counters, err := messageStore.Counters(r.Context()); err == nil {
+			response["ingest"] = counters
+		}
+	}
 	
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
@@ -12,7 +12,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status": "ok",
 		"service": "LINE Trip List Webhook Server",
-		"endpoints": []string{"/api/health", "/api/webhook", "/api/send", "/api/messages", "/api/search_image"},
+		"endpoints": []string{"/api/health", "/api/webhook", "/api/worker", "/api/dead_letters", "/api/send", "/api/scheduled", "/api/dispatch", "/api/messages", "/api/groups", "/api/trips", "/api/trips/items", "/api/trips/{id}/itinerary", "/api/trips/{id}/settlement", "/api/trips/{id}/budget", "/api/trips/{id}/share", "/api/rates", "/api/content", "/api/locations", "/api/search_image"},
 		"version": "1.0.0",
 	}
 	
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// /api/trips/{id}/itinerary[?status=proposed] -> { "trip_id", "entries": [...], "count": n }
+// POST /api/trips/{id}/itinerary {date, time?, period?, title} -> 追加した予定（確定済み）
+// PATCH /api/trips/{id}/itinerary?id=3 {status?, date?, time?, period?, title?}
+// チャットの「3日目の10時に金閣寺」などから提案された予定を iOS アプリで確定・却下する。
+// vercel.json の rewrites で /api/itinerary?trip_id={id} に転送される。
+func Handler(w http.ResponseWriter, r *http.Request) {
+	messageStore, err := store.Open()
+	if err == nil {
+		var trips trip.Store
+		if trips, err = trip.Open(messageStore); err == nil {
+			trip.ItineraryHandler(trips).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	log.Printf("Error opening trip store: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Trip store not configured"})
+}
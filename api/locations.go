@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/geojson"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// /api/locations?group_id=...            -> { "locations": [...], "count": n }
+// /api/locations?group_id=...&format=geojson -> GeoJSON FeatureCollection
+// グループで共有された位置情報を地図表示用に返す。Accept: application/geo+json でも GeoJSON になる。
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	messageStore, err := store.Open()
+	if err != nil {
+		log.Printf("Error opening message store: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Message store not configured"})
+		return
+	}
+
+	var groupIDs []string
+	if groupID := r.URL.Query().Get("group_id"); groupID != "" {
+		groupIDs = []string{groupID}
+	}
+
+	locations, err := store.Locations(r.Context(), messageStore, groupIDs)
+	if err != nil {
+		log.Printf("Error reading locations: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load locations"})
+		return
+	}
+
+	if r.URL.Query().Get("format") == "geojson" || strings.Contains(r.Header.Get("Accept"), "application/geo+json") {
+		w.Header().Set("Content-Type", "application/geo+json")
+		json.NewEncoder(w).Encode(geojson.FromMessages(locations))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locations": locations,
+		"count":     len(locations),
+	})
+}
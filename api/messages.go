@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html"
@@ -11,54 +12,13 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/attachment"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
 )
 
 // Message represents a stored LINE message
-type Message struct {
-	GroupID   string `json:"group_id"`
-	UserID    string `json:"user_id"`
-	Message   string `json:"message"`
-	UserName  string `json:"user_name"`
-	Timestamp int64  `json:"timestamp"`
-}
-
-const redisMessagesKey = "line_messages"
-
-// Upstash Redis REST API helper for messages
-func redisGet(key string) (interface{}, error) {
-	url := os.Getenv("KV_REST_API_URL")
-	token := os.Getenv("KV_REST_API_TOKEN")
-	
-	if url == "" || token == "" {
-		return nil, fmt.Errorf("redis credentials not set")
-	}
-	
-	reqBody, _ := json.Marshal([]interface{}{"GET", key})
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, err
-	}
-	
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	body, _ := io.ReadAll(resp.Body)
-	
-	var result struct {
-		Result interface{} `json:"result"`
-	}
-	
-	json.Unmarshal(body, &result)
-	return result.Result, nil
-}
+type Message = store.Message
 
 // Helper to set a key in Upstash Redis with optional TTL (seconds). ttlSeconds==0 means no expiry.
 func redisSet(key string, value string, ttlSeconds int) error {
@@ -135,71 +95,51 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 func serveJSON(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
     
-    messages := loadMessages()
-
-    // クエリパラメータでフィルタ可能 (line_id)
-    lineID := r.URL.Query().Get("line_id")
-    filtered := messages
-    if lineID != "" {
-        // 指定 userId が参加している group_id を収集
-        groupSet := make(map[string]struct{})
-        for _, m := range messages {
-            if m.UserID == lineID {
-                groupSet[m.GroupID] = struct{}{}
-            }
-        }
+    // group_id / line_id（メンバー登録から所属グループを引く）などの解釈は webhook-server と共通
+    query, err := store.ParseQuery(r.URL.Query())
+    if err != nil {
+        w.WriteHeader(http.StatusBadRequest)
+        json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+        return
+    }
 
-        // その group_id に属するすべてのメッセージを返す
-        var tmp []Message
-        if len(groupSet) > 0 {
-            for _, m := range messages {
-                if _, ok := groupSet[m.GroupID]; ok {
-                    tmp = append(tmp, m)
-                }
-            }
-        }
-        filtered = tmp
+    messageStore, err := store.Open()
+    if err != nil {
+        log.Printf("Error opening message store: %v", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Message store not configured"})
+        return
     }
 
-    response := map[string]interface{}{
-        "messages": filtered,
-        "count":    len(filtered),
-        "note":     "Note: Messages are stored in /tmp and may be cleared. Use a database for production.",
+    page, err := store.Paginate(r.Context(), messageStore, query)
+    if err != nil {
+        log.Printf("Error reading messages: %v", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load messages"})
+        return
     }
-	json.NewEncoder(w).Encode(response)
+
+    // 画像・動画などは /api/content 経由で取得できる URL を付ける
+    attachment.Link(page.Messages, attachment.Endpoint(r, "/api/content"))
+	json.NewEncoder(w).Encode(page.Response())
 }
 
-func loadMessages() []Message {
-	var messages []Message
-	
-	data, err := redisGet(redisMessagesKey)
+// loadMessages reads the given groups, or every group when groupIDs is nil.
+func loadMessages(ctx context.Context, groupIDs []string) []Message {
+	messageStore, err := store.Open()
 	if err != nil {
-		log.Printf("Error reading from Redis: %v", err)
-		return messages
+		log.Printf("Error opening message store: %v", err)
+		return nil
 	}
-	
-	if data == nil {
-		log.Printf("No messages found in Redis")
-		return messages
-	}
-	
-	var jsonStr string
-	switch v := data.(type) {
-	case string:
-		jsonStr = v
-	case []byte:
-		jsonStr = string(v)
-	default:
-		log.Printf("Unexpected data type from Redis: %T", data)
-		return messages
-	}
-	
-	if err := json.Unmarshal([]byte(jsonStr), &messages); err != nil {
-		log.Printf("Error unmarshaling messages: %v", err)
+
+	// グループごとのログを一定件数ずつ読む（巨大な JSON を一度に読まない）
+	messages, err := store.AllGroups(ctx, messageStore, groupIDs)
+	if err != nil {
+		log.Printf("Error reading messages: %v", err)
 		return messages
 	}
-	
-	log.Printf("✅ Loaded %d messages from Redis", len(messages))
+
+	log.Printf("✅ Loaded %d messages", len(messages))
 	return messages
 }
 
@@ -207,7 +147,8 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	
 	// 最初にメッセージを読み込む
-	messages := loadMessages()
+	messages := loadMessages(r.Context(), nil)
+	attachment.Link(messages, attachment.Endpoint(r, "/api/content"))
 	
 	htmlContent := `<!DOCTYPE html>
 <html lang="ja">
@@ -426,15 +367,15 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 				getInitial(msg.UserName),
 				html.EscapeString(msg.UserName),
 				timestamp,
-				html.EscapeString(msg.Message),
+				messageText(msg),
 				truncate(msg.GroupID, 20))
 		}
 	}
 
 	htmlContent += `
         <div class="note">
-            ⚠️ メッセージはメモリ内に保存されており、サーバー再起動時に消えます。<br>
-            本番環境ではデータベースの使用を推奨します。
+            ⚠️ 保存先は環境変数 MESSAGE_STORE（upstash / memory / bolt）で切り替えられます。<br>
+            memory を選んだ場合はサーバー再起動時に消えます。
         </div>
     </div>
 </body>
@@ -455,4 +396,42 @@ func truncate(s string, maxLen int) string {
 		return s
 	}
 	return s[:maxLen] + "..."
-}
\ No newline at end of file
+}
+
+// messageText renders a message body, replacing unsent messages with a note
+// and media messages with a preview or link.
+func messageText(msg Message) string {
+	if msg.Deleted {
+		return `<span style="color: #999;">🗑️ 送信取消されたメッセージ</span>`
+	}
+	if msg.Location != nil {
+		return fmt.Sprintf(`📍 <a href="https://maps.google.com/?q=%f,%f">%s</a><br><span style="color: #999;">%s</span>`,
+			msg.Location.Latitude, msg.Location.Longitude,
+			html.EscapeString(msg.Location.Title), html.EscapeString(msg.Location.Address))
+	}
+	if msg.Content == nil || msg.Content.URL == "" {
+		switch msg.Type {
+		case store.TypeImage:
+			return "🖼️ 画像"
+		case store.TypeVideo:
+			return "🎬 動画"
+		case store.TypeAudio:
+			return "🎵 音声"
+		case store.TypeFile:
+			return "📎 ファイル"
+		}
+		return html.EscapeString(msg.Message)
+	}
+
+	src := html.EscapeString(msg.Content.URL)
+	switch msg.Type {
+	case store.TypeImage:
+		return fmt.Sprintf(`<a href="%s"><img src="%s" style="max-width: 100%%; border-radius: 8px;"></a>`, src, src)
+	case store.TypeVideo:
+		return fmt.Sprintf(`<video src="%s" controls style="max-width: 100%%;"></video>`, src)
+	case store.TypeAudio:
+		return fmt.Sprintf(`<audio src="%s" controls></audio>`, src)
+	default:
+		return fmt.Sprintf(`<a href="%s">📎 %s</a>`, src, html.EscapeString(msg.Content.FileName))
+	}
+}
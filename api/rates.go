@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/admin"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// Handler lets operators manage the exchange rate table (JPY per unit of
+// each currency) that trips snapshot when a foreign expense is recorded.
+// It requires Authorization: Bearer $ADMIN_TOKEN.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	messageStore, err := store.Open()
+	if err == nil {
+		var trips trip.Store
+		if trips, err = trip.Open(messageStore); err == nil {
+			admin.Rates(trips).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	log.Printf("Error opening trip store: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Trip store not configured"})
+}
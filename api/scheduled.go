@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// /api/scheduled -> GET: 予約送信の一覧（?id= で 1 件）
+// PATCH ?id=: { "to"?, "messages"?, "send_at"? } で送信前の予約を編集
+// DELETE ?id=: 送信前の予約を取り消す（送信に失敗した予約は一覧から消す）
+func Handler(w http.ResponseWriter, r *http.Request) {
+	var schedule outbound.Schedule
+	if messageStore, err := store.Open(); err == nil {
+		if schedule, err = outbound.OpenSchedule(messageStore); err != nil {
+			log.Printf("Error opening schedule: %v", err)
+		}
+	} else {
+		log.Printf("Error opening message store: %v", err)
+	}
+	outbound.ScheduledHandler(schedule).ServeHTTP(w, r)
+}
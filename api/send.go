@@ -1,79 +1,58 @@
 package handler
 
 import (
-	"encoding/json"
+	"context"
 	"log"
 	"net/http"
 	"os"
 
-	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
 )
 
-type SendMessageRequest struct {
-	GroupID string `json:"group_id"`
-	Message string `json:"message"`
-}
-
+// /api/send -> POST: { "to", "messages": [...] }
+// テキスト・画像・スタンプ・位置情報・Flex・テンプレートを最大 5 件まとめて送る。
+// LINE の上限を超えるメッセージは送信前に 400 で返す（従来の group_id / message も使える）。
+// 直前のメッセージの返信トークンが残っていれば Reply API で送り、無料枠を節約する。
+// send_at（RFC 3339）を付けると予約送信になり、/api/dispatch がその時刻に Push する。
+// 送信できたメッセージは direction: outbound として /api/messages にも残る。
 func Handler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	channelToken := os.Getenv("LINE_CHANNEL_TOKEN")
-	if channelToken == "" {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "LINE_CHANNEL_TOKEN not configured"})
-		return
-	}
-
-	var req SendMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
-		return
+	var sender *outbound.Sender
+	if os.Getenv("LINE_CHANNEL_TOKEN") != "" {
+		if bot, err := lineapi.New(); err == nil {
+			sender = outbound.NewSender(bot, nil)
+		} else {
+			log.Printf("Error creating bot: %v", err)
+		}
 	}
-
-	if req.GroupID == "" || req.Message == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "group_id and message are required"})
-		return
+	var schedule outbound.Schedule
+	// 保存先がなければ返信トークンを使わず Push で送り、予約送信は受け付けない
+	if messageStore, err := store.Open(); err == nil {
+		if sender != nil {
+			sender.Store = messageStore
+			recordTimeline(sender, messageStore)
+		}
+		if schedule, err = outbound.OpenSchedule(messageStore); err != nil {
+			log.Printf("Error opening schedule: %v", err)
+		}
+	} else {
+		log.Printf("Error opening message store: %v", err)
 	}
+	outbound.SendHandler(sender, schedule).ServeHTTP(w, r)
+}
 
-	bot, err := messaging_api.NewMessagingApiAPI(channelToken)
+// recordTimeline keeps sent messages in /api/messages, attached to the
+// conversation's active trip.
+func recordTimeline(sender *outbound.Sender, messageStore store.MessageStore) {
+	sender.Timeline = messageStore
+	trips, err := trip.Open(messageStore)
 	if err != nil {
-		log.Printf("Error creating bot: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to initialize LINE bot"})
+		log.Printf("Error opening trip store: %v", err)
 		return
 	}
-
-	_, err = bot.PushMessage(&messaging_api.PushMessageRequest{
-		To: req.GroupID,
-		Messages: []messaging_api.MessageInterface{
-			&messaging_api.TextMessage{
-				Text: req.Message,
-			},
-		},
-	}, "")
-
-	if err != nil {
-		log.Printf("Send message error: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to send message"})
-		return
+	sender.ActiveTrip = func(ctx context.Context, conversationID string) (string, error) {
+		return trip.ActiveID(ctx, trips, conversationID)
 	}
-
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-}
\ No newline at end of file
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// /api/trips/{id}/share -> GET: { "alt_text", "text", "contents" }, POST: グループに送信
+// 旅程を 1 日 1 枚の Flex カルーセルにしてトークに送る。
+// vercel.json の rewrites で /api/share?trip_id={id} に転送される。
+func Handler(w http.ResponseWriter, r *http.Request) {
+	messageStore, err := store.Open()
+	if err == nil {
+		var trips trip.Store
+		if trips, err = trip.Open(messageStore); err == nil {
+			var sender *outbound.Sender
+			if bot, err := lineapi.New(); err == nil {
+				sender = outbound.NewSender(bot, messageStore)
+				// 送った旅程を /api/messages に残す（旅行は ShareHandler が付ける）
+				sender.Timeline = messageStore
+			} else {
+				log.Printf("Error creating bot: %v", err)
+			}
+			trip.ShareHandler(trips, sender).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	log.Printf("Error opening trip store: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Trip store not configured"})
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// /api/trips/items?group_id=...[&trip_id=...] -> { "group_id", "trip_id", "items": [...], "count": n }
+// POST /api/trips/items {group_id, title, trip_id?} -> 追加した項目
+// PATCH /api/trips/items?group_id=...&id=3 {title?, done?}
+// DELETE /api/trips/items?group_id=...&id=3
+// チャットの /add・/done・/remove と同じリストを iOS アプリから編集する。
+// trip_id を省略すると進行中の旅行の項目が対象になる。
+func Handler(w http.ResponseWriter, r *http.Request) {
+	messageStore, err := store.Open()
+	if err == nil {
+		var trips trip.Store
+		if trips, err = trip.Open(messageStore); err == nil {
+			trip.ItemsHandler(trips).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	log.Printf("Error opening trip store: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Trip store not configured"})
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// /api/trips?group_id=...[&status=archived] -> { "group_id", "active_trip_id", "trips": [...], "count": n }
+// /api/trips?id=C...-2                      -> { "trip", "items": [...] }
+// POST /api/trips {group_id, title, destination?, start_date?, end_date?} -> 作成した旅行（進行中になる）
+// PATCH /api/trips?id=C...-2 {title?, destination?, start_date?, end_date?, status?}
+// チャットの /trip new・switch・archive と同じ旅行を iOS アプリから扱う。
+func Handler(w http.ResponseWriter, r *http.Request) {
+	messageStore, err := store.Open()
+	if err == nil {
+		var trips trip.Store
+		if trips, err = trip.Open(messageStore); err == nil {
+			trip.TripsHandler(trips).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	log.Printf("Error opening trip store: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Trip store not configured"})
+}
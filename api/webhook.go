@@ -1,71 +1,22 @@
 package handler
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
-	"github.com/line/line-bot-sdk-go/v8/linebot"
 	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/archive"
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
 )
 
-type AppMessage struct {
-	GroupID   string `json:"group_id"`
-	UserID    string `json:"user_id"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
-	UserName  string `json:"user_name"`
-}
-
-const redisKey = "line_messages"
-
-func redisCommand(command []interface{}) (interface{}, error) {
-	url := os.Getenv("KV_REST_API_URL")
-	token := os.Getenv("KV_REST_API_TOKEN")
-	
-	if url == "" || token == "" {
-		return nil, fmt.Errorf("redis credentials not set")
-	}
-	
-	reqBody, err := json.Marshal(command)
-	if err != nil {
-		return nil, err
-	}
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, err
-	}
-	
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	
-	var result struct {
-		Result interface{} `json:"result"`
-	}
-	
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, err
-	}
-	
-	return result.Result, nil
-}
+// AppMessage is the message shape shared with /api/messages and the iOS app.
+type AppMessage = store.Message
 
 func Handler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -84,93 +35,93 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cb, err := webhook.ParseRequest(channelSecret, r)
+	body, err := queue.Verify(channelSecret, r)
 	if err != nil {
 		log.Printf("Webhook parse error: %v", err)
-		if err == linebot.ErrInvalidSignature {
+		if err == webhook.ErrInvalidSignature {
 			w.WriteHeader(http.StatusBadRequest)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
+	jobs, err := queue.Jobs(body)
+	if err != nil {
+		log.Printf("Webhook parse error: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	archiveCallback(r.Context(), body)
 
-	for _, event := range cb.Events {
-		switch e := event.(type) {
-		case webhook.MessageEvent:
-			switch message := e.Message.(type) {
-			case webhook.TextMessageContent:
-				handleTextMessage(e, message)
-			}
+	q, err := queue.Open()
+	if err != nil {
+		log.Printf("❌ Error opening webhook queue: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if q == nil {
+		// WEBHOOK_QUEUE=inline: キューを使わずにその場で処理する
+		if err := handleInline(r.Context(), jobs); err != nil {
+			log.Printf("❌ Error preparing inline processing: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
+	} else if err := q.Enqueue(r.Context(), jobs...); err != nil {
+		// 500 を返せば LINE の再送に任せられる
+		log.Printf("❌ Error enqueueing %d events: %v", len(jobs), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else {
+		log.Printf("📥 Enqueued %d events", len(jobs))
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func handleTextMessage(event webhook.MessageEvent, message webhook.TextMessageContent) {
-	groupSource, ok := event.Source.(webhook.GroupSource)
-	if !ok {
-		log.Printf("Not a group message, skipping")
-		return
+// handleInline processes events before answering, as the webhook did before
+// the queue existed. Failed events are dead-lettered; LINE is still told
+// success.
+func handleInline(ctx context.Context, jobs []queue.Job) error {
+	messageStore, err := store.Open()
+	if err != nil {
+		return err
 	}
-
-	userName := "Unknown User"
-	userID := ""
-	
-	if groupSource.UserId != "" {
-		userID = groupSource.UserId
-		if len(userID) > 8 {
-			userName = fmt.Sprintf("User-%s", userID[:8])
-		} else {
-			userName = fmt.Sprintf("User-%s", userID)
+	deadLetters, err := queue.OpenDeadLetters()
+	if err != nil {
+		return err
+	}
+	ingester := ingest.FromEnv(messageStore)
+	ingester.OnStored = notifyiOSApp
+	for _, job := range jobs {
+		event, err := webhook.UnmarshalEvent(job.Event)
+		if err == nil {
+			err = ingester.HandleEvent(ctx, event)
+		}
+		if err != nil {
+			log.Printf("❌ Error handling event %s: %v", job.ID, err)
+			if err := deadLetters.Add(ctx, queue.NewDeadLetter(job, 1, err)); err != nil {
+				log.Printf("❌ Could not dead-letter event %s, it is lost: %v", job.ID, err)
+			}
 		}
 	}
+	return nil
+}
 
-	appMessage := AppMessage{
-		GroupID:   groupSource.GroupId,
-		UserID:    userID,
-		Message:   message.Text,
-		Timestamp: event.Timestamp,
-		UserName:  userName,
+// archiveCallback keeps the verified body for later rebuilds. Archiving is
+// best effort: events are still processed when it fails.
+func archiveCallback(ctx context.Context, body []byte) {
+	a, err := archive.Open()
+	if err == nil && a != nil {
+		err = a.Append(ctx, time.Now(), body)
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to archive webhook callback: %v", err)
 	}
-
-	notifyiOSApp(appMessage)
-
-	log.Printf("📱 Group Message: %s from %s in group %s", 
-		message.Text, userName, groupSource.GroupId)
 }
 
 func notifyiOSApp(message AppMessage) {
+	// iOSアプリは /api/messages をポーリングして取得する
 	messageJSON, _ := json.MarshalIndent(message, "", "  ")
 	log.Printf("📲 Received LINE Message:\n%s", messageJSON)
-	
-	saveToRedis(message)
-}
-
-func saveToRedis(message AppMessage) {
-	var messages []AppMessage
-	result, err := redisCommand([]interface{}{"GET", redisKey})
-	if err == nil && result != nil {
-		if str, ok := result.(string); ok {
-			json.Unmarshal([]byte(str), &messages)
-		}
-	}
-	
-	messages = append(messages, message)
-	
-	jsonData, err := json.Marshal(messages)
-	if err != nil {
-		log.Printf("❌ Error marshaling messages: %v", err)
-		return
-	}
-	
-	_, err = redisCommand([]interface{}{"SET", redisKey, string(jsonData)})
-	if err != nil {
-		log.Printf("❌ Error saving to Redis: %v", err)
-		return
-	}
-	
-	log.Printf("✅ Message saved to Redis. Total messages: %d", len(messages))
 }
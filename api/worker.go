@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// drainBudget leaves headroom below Vercel's default 10 second limit.
+const drainBudget = 8 * time.Second
+
+// Handler drains the webhook queue. Vercel Cron calls it every minute with
+// Authorization: Bearer $CRON_SECRET and refuses to run while CRON_SECRET
+// is unset.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// 認証なしで誰でも呼べないよう、CRON_SECRET がなければ動かさない
+	secret := os.Getenv("CRON_SECRET")
+	if secret == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "CRON_SECRET not configured"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+secret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	q, err := queue.Open()
+	if err == nil && q == nil {
+		// WEBHOOK_QUEUE=inline: Webhook がその場で処理するので積まれたイベントはない
+		json.NewEncoder(w).Encode(map[string]string{"status": "inline"})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Webhook queue unavailable: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "webhook queue is not configured"})
+		return
+	}
+	messageStore, err := store.Open()
+	if err != nil {
+		log.Printf("❌ Error opening message store: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to open message store"})
+		return
+	}
+
+	deadLetters, err := queue.OpenDeadLetters()
+	if err != nil {
+		log.Printf("❌ Error opening dead letters: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to open dead letters"})
+		return
+	}
+
+	ingester := ingest.FromEnv(messageStore)
+	worker := queue.NewWorker(q, ingester.HandleEvent)
+	worker.DeadLetters = deadLetters
+	ctx, cancel := context.WithTimeout(r.Context(), drainBudget)
+	defer cancel()
+
+	stats, err := worker.Drain(ctx)
+	if err != nil {
+		log.Printf("❌ Worker error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("✅ Worker processed %d events (%d failed, %d waiting)", stats.Processed, stats.Failed, stats.Remaining)
+	json.NewEncoder(w).Encode(stats)
+}
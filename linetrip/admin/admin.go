@@ -0,0 +1,32 @@
+// Package admin guards operator-only endpoints with a shared bearer token.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// Authorize checks that r carries Authorization: Bearer $ADMIN_TOKEN. When
+// it does not, it writes the error response and returns false. Without
+// ADMIN_TOKEN admin endpoints are disabled.
+func Authorize(w http.ResponseWriter, r *http.Request) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		writeError(w, http.StatusServiceUnavailable, "admin endpoints are disabled: ADMIN_TOKEN is not set")
+		return false
+	}
+	got := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
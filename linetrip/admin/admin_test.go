@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+func request(h http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	return requestBody(h, method, target, token, "")
+}
+
+func requestBody(h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthorize(t *testing.T) {
+	h := DeadLetters(queue.NewMemoryDeadLetters(), nil)
+
+	t.Setenv("ADMIN_TOKEN", "")
+	if w := request(h, "GET", "/", "anything"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without ADMIN_TOKEN: %d", w.Code)
+	}
+	t.Setenv("ADMIN_TOKEN", "secret")
+	if w := request(h, "GET", "/", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", w.Code)
+	}
+	if w := request(h, "GET", "/", "secret"); w.Code != http.StatusOK {
+		t.Errorf("right token: %d", w.Code)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	ctx := context.Background()
+	dl := queue.NewMemoryDeadLetters()
+	event := json.RawMessage(`{"type":"message","webhookEventId":"e1"}`)
+	dl.Add(ctx, queue.NewDeadLetter(queue.Job{ID: "e1", Event: event}, 4, errors.New("boom")))
+	dl.Add(ctx, queue.NewDeadLetter(queue.Job{ID: "e2", Event: event}, 4, errors.New("boom")))
+	dl.Add(ctx, queue.NewDeadLetter(queue.Job{ID: "e3", Event: event}, 4, errors.New("boom")))
+
+	var replayed []string
+	h := DeadLetters(dl, func(ctx context.Context, job queue.Job) error {
+		replayed = append(replayed, job.ID)
+		return nil
+	})
+
+	w := request(h, "GET", "/", "secret")
+	var list struct {
+		DeadLetters []DeadLetterSummary `json:"dead_letters"`
+		Count       int                 `json:"count"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if list.Count != 3 || list.DeadLetters[0].EventType != "message" || list.DeadLetters[0].Error != "boom" {
+		t.Fatalf("list = %+v", list)
+	}
+
+	w = request(h, "GET", "/?id=e1", "secret")
+	var d queue.DeadLetter
+	json.NewDecoder(w.Body).Decode(&d)
+	if d.Attempts != 4 || string(d.Job.Event) != string(event) {
+		t.Errorf("inspect = %+v", d)
+	}
+	if w := request(h, "GET", "/?id=missing", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("missing id: %d", w.Code)
+	}
+
+	if w := request(h, "POST", "/?id=e1", "secret"); w.Code != http.StatusOK || len(replayed) != 1 {
+		t.Fatalf("replay: %d %v", w.Code, replayed)
+	}
+	if w := request(h, "DELETE", "/?id=e2", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("discard: %d", w.Code)
+	}
+	if w := request(h, "POST", "/", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("replay all: %d", w.Code)
+	}
+	if len(replayed) != 2 || replayed[1] != "e3" {
+		t.Errorf("replayed %v, want [e1 e3]", replayed)
+	}
+	if left, _ := dl.List(ctx); len(left) != 0 {
+		t.Errorf("left over: %+v", left)
+	}
+}
+
+func TestRates(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	table := trip.NewMemory()
+	h := Rates(table)
+
+	if w := requestBody(h, "PUT", "/", "", `{"rates":{"KRW":0.11}}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: %d", w.Code)
+	}
+	if w := requestBody(h, "PUT", "/", "secret", `{"rates":{"KRW":-1}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("negative rate: %d", w.Code)
+	}
+	if w := requestBody(h, "PUT", "/", "secret", `{"rates":{"KRW":0.11,"USD":150}}`); w.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	if w := request(h, "DELETE", "/?currency=usd", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d", w.Code)
+	}
+
+	w := request(h, "GET", "/", "secret")
+	var res struct {
+		Base  string     `json:"base"`
+		Rates trip.Rates `json:"rates"`
+	}
+	json.NewDecoder(w.Body).Decode(&res)
+	if res.Base != "JPY" || len(res.Rates) != 1 || res.Rates["KRW"] != 0.11 {
+		t.Errorf("rates = %+v", res)
+	}
+}
@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+)
+
+// DeadLetterSummary is a dead letter without its payload, as listed.
+type DeadLetterSummary struct {
+	ID        string `json:"id"`
+	EventType string `json:"event_type"`
+	Error     string `json:"error"`
+	Attempts  int    `json:"attempts"`
+	FailedAt  int64  `json:"failed_at"`
+}
+
+// DeadLetters serves the dead letter admin API:
+//
+//	GET    ?            list dead letters
+//	GET    ?id=ID       one dead letter with its raw event
+//	POST   ?id=ID       replay it (omit id to replay all)
+//	DELETE ?id=ID       discard it
+//
+// replay hands a job back for processing; the dead letter is removed once
+// it returns nil. Requests must pass Authorize.
+func DeadLetters(dl queue.DeadLetters, replay func(context.Context, queue.Job) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !Authorize(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		ctx := r.Context()
+		id := r.URL.Query().Get("id")
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			letters, err := dl.List(ctx)
+			if err != nil {
+				serverError(w, "list dead letters", err)
+				return
+			}
+			summaries := make([]DeadLetterSummary, 0, len(letters))
+			for _, d := range letters {
+				summaries = append(summaries, summarize(d))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"dead_letters": summaries,
+				"count":        len(summaries),
+			})
+
+		case r.Method == http.MethodGet:
+			d, err := dl.Get(ctx, id)
+			if err != nil {
+				lookupError(w, id, err)
+				return
+			}
+			json.NewEncoder(w).Encode(d)
+
+		case r.Method == http.MethodPost:
+			ids := []string{id}
+			if id == "" {
+				letters, err := dl.List(ctx)
+				if err != nil {
+					serverError(w, "list dead letters", err)
+					return
+				}
+				ids = ids[:0]
+				for _, d := range letters {
+					ids = append(ids, d.ID)
+				}
+			}
+			replayed := []string{}
+			for _, id := range ids {
+				d, err := dl.Get(ctx, id)
+				if err == nil {
+					err = replay(ctx, d.Job)
+				}
+				if err == nil {
+					err = dl.Remove(ctx, id)
+				}
+				if err != nil {
+					if len(ids) == 1 {
+						lookupError(w, id, err)
+						return
+					}
+					log.Printf("❌ Replay of dead letter %s failed: %v", id, err)
+					continue
+				}
+				log.Printf("🔁 Replayed dead letter %s", id)
+				replayed = append(replayed, id)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"replayed": replayed,
+				"failed":   len(ids) - len(replayed),
+			})
+
+		case r.Method == http.MethodDelete && id != "":
+			if err := dl.Remove(ctx, id); err != nil {
+				lookupError(w, id, err)
+				return
+			}
+			log.Printf("🗑️ Discarded dead letter %s", id)
+			json.NewEncoder(w).Encode(map[string]string{"status": "discarded", "id": id})
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "use GET, POST or DELETE ?id=")
+		}
+	}
+}
+
+func summarize(d queue.DeadLetter) DeadLetterSummary {
+	var event struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(d.Job.Event, &event)
+	return DeadLetterSummary{
+		ID:        d.ID,
+		EventType: event.Type,
+		Error:     d.Error,
+		Attempts:  d.Attempts,
+		FailedAt:  d.FailedAt,
+	}
+}
+
+func lookupError(w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, queue.ErrNoDeadLetter) {
+		writeError(w, http.StatusNotFound, "dead letter "+id+" not found")
+		return
+	}
+	serverError(w, "dead letter "+id, err)
+}
+
+func serverError(w http.ResponseWriter, what string, err error) {
+	log.Printf("❌ Admin %s: %v", what, err)
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
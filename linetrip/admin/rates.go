@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// RateTable is the exchange rate table kept in the trip store.
+type RateTable interface {
+	Rates(ctx context.Context) (trip.Rates, error)
+	SetRates(ctx context.Context, rates trip.Rates) error
+	RemoveRate(ctx context.Context, code string) error
+}
+
+// Rates serves the exchange rate admin API. Rates are JPY per one unit of
+// the currency; trips copy a rate the first time the currency is spent, so
+// changes here apply to trips that have not used it yet.
+//
+//	GET                            the rate table
+//	PUT    {"rates": {"KRW": 0.11}} add or replace rates
+//	DELETE ?currency=KRW           remove a rate
+//
+// Requests must pass Authorize.
+func Rates(table RateTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !Authorize(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		ctx := r.Context()
+		switch r.Method {
+		case http.MethodGet:
+			// 下で表をそのまま返す
+
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Rates trip.Rates `json:"rates"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			if len(req.Rates) == 0 {
+				writeError(w, http.StatusBadRequest, "rates is required")
+				return
+			}
+			if err := req.Rates.Validate(); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err := table.SetRates(ctx, req.Rates); err != nil {
+				serverError(w, "set rates", err)
+				return
+			}
+			log.Printf("💱 Updated exchange rates: %v", req.Rates)
+
+		case http.MethodDelete:
+			code := strings.ToUpper(r.URL.Query().Get("currency"))
+			if !trip.ValidCurrency(code) {
+				writeError(w, http.StatusBadRequest, "currency is required")
+				return
+			}
+			if err := table.RemoveRate(ctx, code); err != nil {
+				serverError(w, "remove rate", err)
+				return
+			}
+			log.Printf("🗑️ Removed exchange rate of %s", code)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "use GET, PUT or DELETE ?currency=")
+			return
+		}
+
+		rates, err := table.Rates(ctx)
+		if err != nil {
+			serverError(w, "list rates", err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"base":  trip.Currency,
+			"rates": rates,
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Package archive keeps every verified webhook callback body exactly as LINE
+// sent it, so derived data (messages, memberships, ...) can be rebuilt by
+// replaying history through the current handlers. Callbacks are grouped
+// into hourly UTC buckets.
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// Backend names accepted in ARCHIVE_STORE.
+const (
+	BackendUpstash = "upstash"
+	BackendFS      = "fs"
+	BackendOff     = "off"
+)
+
+// BucketLayout formats the hourly bucket a callback is archived in. Bucket
+// names sort chronologically.
+const BucketLayout = "2006-01-02T15"
+
+// Entry is one archived callback.
+type Entry struct {
+	// ReceivedAt is when the webhook accepted the callback, in milliseconds.
+	ReceivedAt int64           `json:"received_at"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// Archive stores callback bodies by time bucket.
+type Archive interface {
+	Append(ctx context.Context, receivedAt time.Time, body []byte) error
+	// Buckets lists the buckets holding callbacks, oldest first.
+	Buckets(ctx context.Context) ([]string, error)
+	// Read calls fn for each callback of bucket in arrival order.
+	Read(ctx context.Context, bucket string, fn func(Entry) error) error
+}
+
+// Bucket returns the bucket of a callback received at t.
+func Bucket(t time.Time) string {
+	return t.UTC().Format(BucketLayout)
+}
+
+// Open returns the archive selected by ARCHIVE_STORE: upstash (the default
+// when KV_REST_API_URL is set), fs (under ARCHIVE_DIR, default "archive")
+// or off, which returns nil.
+func Open() (Archive, error) {
+	backend := os.Getenv("ARCHIVE_STORE")
+	if backend == "" {
+		backend = BackendFS
+		if os.Getenv("KV_REST_API_URL") != "" {
+			backend = BackendUpstash
+		}
+	}
+
+	switch backend {
+	case BackendUpstash:
+		client, err := upstash.NewFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewUpstash(client), nil
+	case BackendFS:
+		dir := os.Getenv("ARCHIVE_DIR")
+		if dir == "" {
+			dir = "archive"
+		}
+		return NewFS(dir), nil
+	case BackendOff:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown ARCHIVE_STORE %q", backend)
+	}
+}
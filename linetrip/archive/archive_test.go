@@ -0,0 +1,59 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash/upstashtest"
+)
+
+func TestArchive(t *testing.T) {
+	backends := map[string]func() Archive{
+		BackendUpstash: func() Archive {
+			srv := upstashtest.NewServer()
+			t.Cleanup(srv.Close)
+			return NewUpstash(srv.Client())
+		},
+		BackendFS: func() Archive { return NewFS(t.TempDir()) },
+	}
+	for name, newArchive := range backends {
+		t.Run(name, func(t *testing.T) {
+			a := newArchive()
+			ctx := context.Background()
+			base := time.Date(2026, 10, 18, 9, 59, 0, 0, time.UTC)
+			bodies := []string{`{"events":[1]}`, `{"events":[2]}`, `{"events":[3]}`}
+			// 2 件目と 3 件目は次の時間帯、3 件目は同時刻
+			times := []time.Time{base, base.Add(2 * time.Minute), base.Add(2 * time.Minute)}
+			for i, body := range bodies {
+				if err := a.Append(ctx, times[i], []byte(body)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			buckets, err := a.Buckets(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(buckets) != "[2026-10-18T09 2026-10-18T10]" {
+				t.Fatalf("buckets = %v", buckets)
+			}
+
+			var got []string
+			for _, b := range buckets {
+				err := a.Read(ctx, b, func(e Entry) error {
+					got = append(got, fmt.Sprintf("%d %s", e.ReceivedAt-base.UnixMilli(), e.Body))
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := `[0 {"events":[1]} 120000 {"events":[2]} 120000 {"events":[3]}]`
+			if fmt.Sprint(got) != want {
+				t.Errorf("entries = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FS archives callbacks as files named by their receive time in
+// nanoseconds, one directory per bucket.
+type FS struct {
+	Dir string
+}
+
+// NewFS returns an archive rooted at dir.
+func NewFS(dir string) *FS {
+	return &FS{Dir: dir}
+}
+
+func (a *FS) Append(ctx context.Context, receivedAt time.Time, body []byte) error {
+	dir := filepath.Join(a.Dir, Bucket(receivedAt))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// 同じナノ秒に届いた場合も上書きしないよう O_EXCL で作り直す
+	for n := receivedAt.UnixNano(); ; n++ {
+		f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%d.json", n)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(body); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+func (a *FS) Buckets(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(a.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var buckets []string
+	for _, e := range entries {
+		if _, err := time.Parse(BucketLayout, e.Name()); e.IsDir() && err == nil {
+			buckets = append(buckets, e.Name())
+		}
+	}
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+func (a *FS) Read(ctx context.Context, bucket string, fn func(Entry) error) error {
+	if _, err := time.Parse(BucketLayout, bucket); err != nil {
+		return fmt.Errorf("invalid bucket %q", bucket)
+	}
+	dir := filepath.Join(a.Dir, bucket)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type file struct {
+		name string
+		nano int64
+	}
+	var files []file
+	for _, e := range entries {
+		n, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), ".json"), 10, 64)
+		if err != nil || e.IsDir() {
+			continue
+		}
+		files = append(files, file{e.Name(), n})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].nano < files[j].nano })
+
+	for _, f := range files {
+		body, err := os.ReadFile(filepath.Join(dir, f.name))
+		if err != nil {
+			return err
+		}
+		if err := fn(Entry{ReceivedAt: f.nano / int64(time.Millisecond), Body: body}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// Redis keys: line_archive:{bucket} is a list of Entry JSON, and
+// line_archive:buckets the set of bucket names in use.
+const (
+	keyPrefix  = "line_archive:"
+	bucketsKey = "line_archive:buckets"
+)
+
+// readChunk is how many entries Read fetches per request.
+const readChunk = 100
+
+// Upstash archives callbacks in one Redis list per bucket.
+type Upstash struct {
+	client *upstash.Client
+}
+
+// NewUpstash returns an archive using client.
+func NewUpstash(client *upstash.Client) *Upstash {
+	return &Upstash{client: client}
+}
+
+func (a *Upstash) Append(ctx context.Context, receivedAt time.Time, body []byte) error {
+	data, err := json.Marshal(Entry{ReceivedAt: receivedAt.UnixMilli(), Body: body})
+	if err != nil {
+		return err
+	}
+	bucket := Bucket(receivedAt)
+	_, err = a.client.Pipeline(ctx,
+		[]interface{}{"RPUSH", keyPrefix + bucket, string(data)},
+		[]interface{}{"SADD", bucketsKey, bucket},
+	)
+	return err
+}
+
+func (a *Upstash) Buckets(ctx context.Context) ([]string, error) {
+	res, err := a.client.Do(ctx, "SMEMBERS", bucketsKey)
+	if err != nil {
+		return nil, err
+	}
+	buckets := upstash.Strings(res)
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+func (a *Upstash) Read(ctx context.Context, bucket string, fn func(Entry) error) error {
+	for start := 0; ; start += readChunk {
+		res, err := a.client.Do(ctx, "LRANGE", keyPrefix+bucket, start, start+readChunk-1)
+		if err != nil {
+			return err
+		}
+		items := upstash.Strings(res)
+		for _, item := range items {
+			var e Entry
+			if err := json.Unmarshal([]byte(item), &e); err != nil {
+				return err
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(items) < readChunk {
+			return nil
+		}
+	}
+}
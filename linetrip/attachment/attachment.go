@@ -0,0 +1,125 @@
+// Package attachment stores the binary content of media messages (images,
+// videos, audio and files) downloaded from LINE. Content is addressed by a
+// key derived from the group and LINE message ID.
+package attachment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Backend names accepted in ATTACHMENT_STORE.
+const (
+	BackendFS = "fs"
+	BackendS3 = "s3"
+)
+
+// ErrNotFound is returned by Get when no content is stored under a key.
+var ErrNotFound = errors.New("attachment not found")
+
+// Store keeps attachment content.
+type Store interface {
+	// Put stores body under key. size may be -1 when unknown.
+	Put(ctx context.Context, key, contentType string, body io.Reader, size int64) error
+	// Get opens the content stored under key together with its content
+	// type. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+}
+
+// Key returns the attachment key of a LINE message.
+func Key(groupID, messageID string) string {
+	return groupID + "/" + messageID
+}
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+/[A-Za-z0-9_-]+$`)
+
+// ValidKey reports whether key has the shape produced by Key. Keys arrive in
+// query strings, so anything else (e.g. "../") is rejected before use.
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
+
+// Link fills Content.URL of messages whose content is held in an attachment
+// store. ATTACHMENT_PUBLIC_URL, when set, is used as a base URL for direct
+// access (e.g. a public MinIO bucket); otherwise URLs point at endpoint,
+// the content handler of the calling server.
+func Link(messages []store.Message, endpoint string) {
+	base := strings.TrimRight(os.Getenv("ATTACHMENT_PUBLIC_URL"), "/")
+	for i := range messages {
+		c := messages[i].Content
+		if c == nil || c.Key == "" || c.URL != "" {
+			continue
+		}
+		linked := *c
+		if base != "" {
+			linked.URL = base + "/" + c.Key
+		} else {
+			linked.URL = endpoint + "?key=" + url.QueryEscape(c.Key)
+		}
+		messages[i].Content = &linked
+	}
+}
+
+// Endpoint returns the absolute URL of path on the server handling r,
+// honouring X-Forwarded-Proto set by Vercel and other proxies.
+func Endpoint(r *http.Request, path string) string {
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host + path
+}
+
+var (
+	openMu sync.Mutex
+	opened Store
+)
+
+// Open returns the store selected by the environment:
+//
+//	ATTACHMENT_STORE      fs | s3 (default: fs)
+//	ATTACHMENT_DIR        directory for fs (default: attachments)
+//	S3_ENDPOINT           e.g. https://s3.ap-northeast-1.amazonaws.com or
+//	                      http://localhost:9000 for MinIO
+//	S3_BUCKET, S3_REGION (default: us-east-1),
+//	S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY
+//
+// The store is opened once per process and shared.
+func Open() (Store, error) {
+	openMu.Lock()
+	defer openMu.Unlock()
+	if opened != nil {
+		return opened, nil
+	}
+
+	switch backend := os.Getenv("ATTACHMENT_STORE"); backend {
+	case "", BackendFS:
+		dir := os.Getenv("ATTACHMENT_DIR")
+		if dir == "" {
+			dir = "attachments"
+		}
+		opened = NewFS(dir)
+	case BackendS3:
+		s3, err := NewS3FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		opened = s3
+	default:
+		return nil, fmt.Errorf("unknown ATTACHMENT_STORE %q", backend)
+	}
+	return opened, nil
+}
@@ -0,0 +1,107 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// fakeS3 keeps objects in memory and checks that requests are signed.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = data
+		f.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.types[r.URL.Path])
+		w.Write(data)
+	}
+}
+
+func stores(t *testing.T) map[string]Store {
+	fake := &fakeS3{objects: map[string][]byte{}, types: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return map[string]Store{
+		"fs": NewFS(t.TempDir()),
+		"s3": &S3{Endpoint: srv.URL, Bucket: "trip", Region: "us-east-1", AccessKeyID: "AKID", SecretAccessKey: "secret"},
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			key := Key("C1", "m1")
+			// サイズ不明のレスポンスもそのまま保存できる
+			if err := s.Put(ctx, key, "image/jpeg", strings.NewReader("jpeg-bytes"), -1); err != nil {
+				t.Fatal(err)
+			}
+
+			body, contentType, err := s.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+			data, _ := io.ReadAll(body)
+			if string(data) != "jpeg-bytes" || contentType != "image/jpeg" {
+				t.Fatalf("got %q (%s)", data, contentType)
+			}
+
+			if _, _, err := s.Get(ctx, Key("C1", "missing")); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("missing key: err = %v", err)
+			}
+			if err := s.Put(ctx, "../etc/passwd", "", strings.NewReader("x"), 1); err == nil {
+				t.Fatal("path traversal key accepted")
+			}
+		})
+	}
+}
+
+func TestLink(t *testing.T) {
+	t.Setenv("ATTACHMENT_PUBLIC_URL", "")
+	messages := []store.Message{
+		{Message: "text"},
+		{Type: store.TypeImage, Content: &store.Content{Key: "C1/m1"}},
+		{Type: store.TypeImage, Content: &store.Content{URL: "https://example.com/a.jpg"}},
+	}
+	Link(messages, "https://api.example.com/api/content")
+	if got := messages[1].Content.URL; got != "https://api.example.com/api/content?key=C1%2Fm1" {
+		t.Errorf("content url = %s", got)
+	}
+	if got := messages[2].Content.URL; got != "https://example.com/a.jpg" {
+		t.Errorf("external url rewritten to %s", got)
+	}
+
+	t.Setenv("ATTACHMENT_PUBLIC_URL", "http://localhost:9000/trip/")
+	messages = []store.Message{{Type: store.TypeImage, Content: &store.Content{Key: "C1/m1"}}}
+	Link(messages, "https://api.example.com/api/content")
+	if got := messages[0].Content.URL; got != "http://localhost:9000/trip/C1/m1" {
+		t.Errorf("public url = %s", got)
+	}
+}
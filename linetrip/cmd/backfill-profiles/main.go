@@ -0,0 +1,104 @@
+// Command backfill-profiles fetches the LINE profile of every sender in the
+// stored history whose messages still carry a placeholder name
+// ("User-Ue41eb55"). Messages are append-only, so nothing is rewritten:
+// cached profiles are applied to those messages when they are read.
+//
+//	go run ./cmd/backfill-profiles [-group C...] [-refresh] [-dry-run]
+//
+// It uses the same environment as the servers (MESSAGE_STORE, KV_REST_API_*,
+// LINE_CHANNEL_TOKEN, LINE_API_ENDPOINT).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/profile"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func main() {
+	groupID := flag.String("group", "", "only backfill this group")
+	refresh := flag.Bool("refresh", false, "refetch every sender, not only unresolved ones")
+	dryRun := flag.Bool("dry-run", false, "list the senders without calling LINE")
+	flag.Parse()
+
+	ctx := context.Background()
+	s, err := store.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	bot, err := lineapi.New()
+	if err != nil && !*dryRun {
+		log.Fatal(err)
+	}
+	resolver := profile.New(s, bot)
+
+	var groupIDs []string
+	if *groupID != "" {
+		groupIDs = []string{*groupID}
+	} else {
+		groups, err := s.Groups(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, g := range groups {
+			groupIDs = append(groupIDs, g.ID)
+		}
+	}
+
+	var fetched, failed int
+	for i, gid := range groupIDs {
+		users, err := senders(ctx, s, gid, *refresh)
+		if err != nil {
+			log.Fatalf("read group %s: %v", gid, err)
+		}
+		fmt.Printf("[%d/%d] group %s: %d senders to fetch\n", i+1, len(groupIDs), gid, len(users))
+		if *dryRun {
+			continue
+		}
+		for _, uid := range users {
+			p, err := resolver.Fetch(ctx, gid, uid)
+			if err != nil {
+				// 退出済みのメンバーはプロフィールを取得できない
+				fmt.Printf("  ❌ %s: %v\n", uid, err)
+				failed++
+				continue
+			}
+			fmt.Printf("  ✅ %s: %s\n", uid, p.DisplayName)
+			fetched++
+		}
+	}
+
+	fmt.Printf("Done: %d profiles fetched, %d failed\n", fetched, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// senders lists the user IDs in groupID's history that still need a
+// profile, or every sender when all is set.
+func senders(ctx context.Context, s store.MessageStore, groupID string, all bool) ([]string, error) {
+	messages, err := store.All(ctx, s, groupID)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range messages {
+		if m.UserID == "" || seen[m.UserID] {
+			continue
+		}
+		if all || m.UserName == store.FallbackName(m.UserID) {
+			seen[m.UserID] = true
+			out = append(out, m.UserID)
+		}
+	}
+	return out, nil
+}
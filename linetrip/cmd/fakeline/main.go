@@ -0,0 +1,47 @@
+// Command fakeline serves the linetest fake of the LINE Messaging API for
+// local development. Point the servers at it with
+// LINE_API_ENDPOINT=http://localhost:9090 and LINE_CHANNEL_TOKEN=test-channel-token.
+//
+//	go run ./cmd/fakeline -addr :9090 -members members.json
+//
+// members.json maps group IDs to members:
+//
+//	{"C123": [{"userId": "U1", "displayName": "たくと", "pictureUrl": "https://..."}]}
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	membersPath := flag.String("members", "", "JSON file of group members")
+	flag.Parse()
+
+	server := linetest.New()
+	if *membersPath != "" {
+		data, err := os.ReadFile(*membersPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var groups map[string][]linetest.Member
+		if err := json.Unmarshal(data, &groups); err != nil {
+			log.Fatalf("parse %s: %v", *membersPath, err)
+		}
+		for groupID, members := range groups {
+			for _, m := range members {
+				server.AddMember(groupID, m)
+			}
+		}
+	}
+
+	fmt.Printf("🧪 Fake LINE API on %s (token %q)\n", *addr, linetest.Token)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
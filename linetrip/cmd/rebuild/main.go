@@ -0,0 +1,88 @@
+// Command rebuild replays the webhook archive through the current handlers
+// to regenerate messages and memberships, e.g. after a new message type is
+// supported. Already stored messages are skipped and chat commands are
+// idempotent, so it is safe to rerun.
+//
+//	go run ./cmd/rebuild [-since 2026-10-01] [-until 2026-10-31] [-offline]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/archive"
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/rebuild"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func main() {
+	since := flag.String("since", "", "first day to replay (YYYY-MM-DD, UTC)")
+	until := flag.String("until", "", "last day to replay (YYYY-MM-DD, UTC)")
+	offline := flag.Bool("offline", false, "do not call the LINE API (no profiles, member lists or media)")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	a, err := archive.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if a == nil {
+		log.Fatal("ARCHIVE_STORE is off; nothing to replay")
+	}
+	s, err := store.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	rb := &rebuild.Rebuilder{Archive: a, Store: s, NewIngester: ingest.FromEnv}
+	if *offline {
+		// LINE API を使わず、チャットコマンドだけは反映する
+		rb.NewIngester = func(s store.MessageStore) *ingest.Ingester {
+			in := ingest.FromEnv(s)
+			in.Profiles, in.Members, in.Replier, in.Blob = nil, nil, nil, nil
+			return in
+		}
+	}
+	if rb.Since, err = parseDay(*since, 0); err != nil {
+		log.Fatal(err)
+	}
+	if rb.Until, err = parseDay(*until, 24*time.Hour-time.Nanosecond); err != nil {
+		log.Fatal(err)
+	}
+	rb.OnProgress = func(p rebuild.Progress) {
+		fmt.Printf("[%d/%d] %s: %d events, %d messages stored, %d already stored, %d memberships, %d trip items, %d failed\n",
+			p.BucketsDone, p.Buckets, p.Bucket, p.Events, p.Messages, p.Skipped, p.Memberships, p.TripItems, p.Failed)
+	}
+
+	p, err := rb.Run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("✅ Replayed %d callbacks (%d events): %d messages stored, %d already stored, %d memberships, %d trip items, %d failed\n",
+		p.Callbacks, p.Events, p.Messages, p.Skipped, p.Memberships, p.TripItems, p.Failed)
+	if p.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseDay parses a YYYY-MM-DD flag in UTC and adds offset. An empty value
+// gives the zero time.
+func parseDay(value string, offset time.Duration) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid day %q: %w", value, err)
+	}
+	return t.Add(offset), nil
+}
@@ -0,0 +1,63 @@
+// Command seed-members fills the membership registry of known groups from
+// LINE's member ID list, for groups the bot joined before membership events
+// were recorded. Listing members is only permitted for verified and premium
+// accounts.
+//
+//	go run ./cmd/seed-members [-group C...]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/membership"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func main() {
+	groupID := flag.String("group", "", "only seed this group")
+	flag.Parse()
+
+	ctx := context.Background()
+	s, err := store.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	bot, err := lineapi.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var groupIDs []string
+	if *groupID != "" {
+		groupIDs = []string{*groupID}
+	} else {
+		groups, err := s.Groups(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, g := range groups {
+			groupIDs = append(groupIDs, g.ID)
+		}
+	}
+
+	failed := 0
+	for i, gid := range groupIDs {
+		n, err := membership.Seed(ctx, s, bot, gid)
+		if err != nil {
+			fmt.Printf("[%d/%d] ❌ group %s: %v\n", i+1, len(groupIDs), gid, err)
+			failed++
+			continue
+		}
+		fmt.Printf("[%d/%d] ✅ group %s: %d members\n", i+1, len(groupIDs), gid, n)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
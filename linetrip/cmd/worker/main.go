@@ -0,0 +1,47 @@
+// Command worker processes the webhook queue continuously, for deployments
+// that want lower latency than the /api/worker cron.
+//
+//	WEBHOOK_QUEUE=redis go run ./cmd/worker
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	q, err := queue.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if q == nil {
+		log.Fatal("WEBHOOK_QUEUE is inline; nothing to drain")
+	}
+
+	s, err := store.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	deadLetters, err := queue.OpenDeadLetters()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	worker := queue.NewWorker(q, ingest.FromEnv(s).HandleEvent)
+	worker.DeadLetters = deadLetters
+	log.Printf("🚀 Worker started")
+	worker.Run(ctx)
+	log.Printf("👋 Worker stopped")
+}
@@ -0,0 +1,157 @@
+// Package command dispatches chat commands such as "/add 清水寺" sent in a
+// LINE conversation. Features register their commands in a Registry; the
+// ingester runs it for every stored text message and replies with the
+// returned text or Flex Message.
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Request is one command invocation.
+type Request struct {
+	// Name is the command name without the slash, lower-cased.
+	Name string
+	// Args is the text after the name, trimmed.
+	Args string
+	// Message is the stored message carrying the command.
+	Message store.Message
+	// Replay is set when the message is replayed from the archive rather
+	// than received live; commands must not notify anyone then.
+	Replay bool
+}
+
+// Func runs a command and returns the reply text. Mistakes by the user
+// (unknown item, bad arguments) are reported in the reply with a nil
+// error; errors are reserved for failures on our side.
+type Func func(ctx context.Context, req Request) (string, error)
+
+// Reply is a command's answer. Flex, when set, is a Flex Message container
+// to send instead of Text, with AltText as its notification text; Text is
+// then the plain-text version of the same answer.
+type Reply struct {
+	Text    string
+	AltText string
+	Flex    json.RawMessage
+}
+
+// RichFunc runs a command whose answer may be a Flex Message.
+type RichFunc func(ctx context.Context, req Request) (Reply, error)
+
+// Command describes a chat command.
+type Command struct {
+	Name    string
+	Usage   string
+	Summary string
+	// ReadOnly commands only report state, e.g. /list.
+	ReadOnly bool
+	Run      Func
+	// Rich is used instead of Run by commands that can answer with a Flex
+	// Message.
+	Rich RichFunc
+}
+
+// Registry maps names to commands.
+type Registry struct {
+	commands map[string]Command
+	order    []string
+	// OnRun, when set, is called after each command with its outcome.
+	OnRun func(cmd Command, req Request, err error)
+}
+
+// NewRegistry returns a registry with the built-in /help command.
+func NewRegistry() *Registry {
+	r := &Registry{commands: make(map[string]Command)}
+	r.Register(Command{
+		Name:     "help",
+		Usage:    "/help",
+		Summary:  "コマンドの一覧",
+		ReadOnly: true,
+		Run:      r.help,
+	})
+	return r
+}
+
+// Register adds cmd, replacing any command of the same name.
+func (r *Registry) Register(cmd Command) {
+	if _, ok := r.commands[cmd.Name]; !ok {
+		r.order = append(r.order, cmd.Name)
+	}
+	r.commands[cmd.Name] = cmd
+}
+
+// Parse splits a command message into its name and arguments. Full-width
+// slashes and spaces, which Japanese keyboards produce, are accepted.
+func Parse(text string) (name, args string, ok bool) {
+	text = strings.TrimSpace(text)
+	switch {
+	case strings.HasPrefix(text, "/"):
+		text = text[1:]
+	case strings.HasPrefix(text, "／"):
+		text = text[len("／"):]
+	default:
+		return "", "", false
+	}
+	text = strings.ReplaceAll(text, "　", " ")
+	name, args, _ = strings.Cut(text, " ")
+	if name == "" {
+		return "", "", false
+	}
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// Dispatch runs the command in m, if its text is one. ok is false for
+// ordinary messages. On failure the reply is a generic apology and err
+// holds the cause. Flex answers are returned as their plain text.
+func (r *Registry) Dispatch(ctx context.Context, m store.Message, replay bool) (reply string, ok bool, err error) {
+	rich, ok, err := r.DispatchReply(ctx, m, replay)
+	return rich.Text, ok, err
+}
+
+// DispatchReply is Dispatch for callers that can send Flex Messages.
+func (r *Registry) DispatchReply(ctx context.Context, m store.Message, replay bool) (reply Reply, ok bool, err error) {
+	if m.Type != "" && m.Type != store.TypeText {
+		return Reply{}, false, nil
+	}
+	name, args, ok := Parse(m.Message)
+	if !ok {
+		return Reply{}, false, nil
+	}
+
+	cmd, known := r.commands[name]
+	if !known {
+		return Reply{Text: fmt.Sprintf("「/%s」というコマンドはありません。/help で一覧を表示します", name)}, true, nil
+	}
+
+	req := Request{Name: name, Args: args, Message: m, Replay: replay}
+	if cmd.Rich != nil {
+		reply, err = cmd.Rich(ctx, req)
+	} else {
+		reply.Text, err = cmd.Run(ctx, req)
+	}
+	if r.OnRun != nil {
+		r.OnRun(cmd, req, err)
+	}
+	if err != nil {
+		log.Printf("❌ Command /%s failed in %s: %v", name, m.GroupID, err)
+		return Reply{Text: "⚠️ エラーが発生しました。しばらくしてからもう一度お試しください"}, true, err
+	}
+	log.Printf("🤖 Ran /%s in %s", name, m.GroupID)
+	return reply, true, nil
+}
+
+func (r *Registry) help(ctx context.Context, req Request) (string, error) {
+	var b strings.Builder
+	b.WriteString("使えるコマンド:")
+	for _, name := range r.order {
+		cmd := r.commands[name]
+		fmt.Fprintf(&b, "\n%s … %s", cmd.Usage, cmd.Summary)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,68 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text, name, args string
+		ok               bool
+	}{
+		{"/add 清水寺", "add", "清水寺", true},
+		{"  /ADD   清水寺 ", "add", "清水寺", true},
+		{"／add　金閣寺", "add", "金閣寺", true},
+		{"/list", "list", "", true},
+		{"/add 清水寺\n金閣寺", "add", "清水寺\n金閣寺", true},
+		{"清水寺に行きたい", "", "", false},
+		{"/", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := Parse(tt.text)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("Parse(%q) = %q, %q, %v; want %q, %q, %v", tt.text, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	r := NewRegistry()
+	var ran []string
+	r.Register(Command{Name: "echo", Usage: "/echo text", Summary: "そのまま返す", Run: func(ctx context.Context, req Request) (string, error) {
+		ran = append(ran, req.Args)
+		return req.Args, nil
+	}})
+	r.Register(Command{Name: "fail", Usage: "/fail", Summary: "失敗する", Run: func(ctx context.Context, req Request) (string, error) {
+		return "", errors.New("boom")
+	}})
+	ctx := context.Background()
+
+	if reply, ok, err := r.Dispatch(ctx, store.Message{Message: "/echo こんにちは"}, false); !ok || err != nil || reply != "こんにちは" {
+		t.Errorf("echo = %q, %v, %v", reply, ok, err)
+	}
+	if _, ok, _ := r.Dispatch(ctx, store.Message{Message: "ただの発言"}, false); ok {
+		t.Error("plain message handled as a command")
+	}
+	if _, ok, _ := r.Dispatch(ctx, store.Message{Type: store.TypeImage, Message: "/echo x"}, false); ok {
+		t.Error("image handled as a command")
+	}
+	if reply, ok, err := r.Dispatch(ctx, store.Message{Message: "/nope"}, false); !ok || err != nil || !strings.Contains(reply, "/help") {
+		t.Errorf("unknown = %q, %v, %v", reply, ok, err)
+	}
+	if reply, ok, err := r.Dispatch(ctx, store.Message{Message: "/fail"}, false); !ok || err == nil || reply == "" {
+		t.Errorf("fail = %q, %v, %v", reply, ok, err)
+	}
+	reply, _, _ := r.Dispatch(ctx, store.Message{Message: "/help"}, false)
+	if !strings.Contains(reply, "/echo text … そのまま返す") || !strings.Contains(reply, "/help") {
+		t.Errorf("help = %q", reply)
+	}
+	if len(ran) != 1 {
+		t.Errorf("echo ran %d times", len(ran))
+	}
+}
@@ -0,0 +1,154 @@
+// Package flex checks LINE Flex Message containers against the limits the
+// Messaging API enforces, so an oversized or malformed message is caught
+// before it is pushed rather than rejected by LINE with a generic 400.
+package flex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// Limits of the Messaging API for Flex Messages.
+const (
+	// MaxAltText is the longest notification text, in characters.
+	MaxAltText = 1500
+	// MaxBubbles is the number of bubbles a carousel may hold.
+	MaxBubbles = 12
+	// MaxBubbleSize and MaxCarouselSize bound the JSON of one bubble and
+	// of a whole carousel, in bytes.
+	MaxBubbleSize   = 30 * 1024
+	MaxCarouselSize = 50 * 1024
+	// MaxURL is the longest image or action URL, in characters.
+	MaxURL = 2000
+	// MaxDepth bounds how deeply boxes nest inside a bubble block. LINE
+	// documents no number; layouts deeper than this render unreadably on
+	// phones and are treated as mistakes.
+	MaxDepth = 10
+)
+
+// Size returns the length of v as JSON.
+func Size(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// Check validates a Flex container (a bubble or a carousel) and its
+// notification text.
+func Check(altText string, contents json.RawMessage) error {
+	if altText == "" {
+		return fmt.Errorf("altText is required")
+	}
+	if n := utf8.RuneCountInString(altText); n > MaxAltText {
+		return fmt.Errorf("altText is %d characters, over %d", n, MaxAltText)
+	}
+
+	var container struct {
+		Type     string            `json:"type"`
+		Contents []json.RawMessage `json:"contents"`
+	}
+	if err := json.Unmarshal(contents, &container); err != nil {
+		return fmt.Errorf("invalid flex container: %v", err)
+	}
+	switch container.Type {
+	case "bubble":
+		return checkBubble(contents)
+	case "carousel":
+		if len(container.Contents) == 0 {
+			return fmt.Errorf("carousel has no bubbles")
+		}
+		if len(container.Contents) > MaxBubbles {
+			return fmt.Errorf("carousel has %d bubbles, over %d", len(container.Contents), MaxBubbles)
+		}
+		if len(contents) > MaxCarouselSize {
+			return fmt.Errorf("carousel is %d bytes, over %d", len(contents), MaxCarouselSize)
+		}
+		for i, bubble := range container.Contents {
+			if err := checkBubble(bubble); err != nil {
+				return fmt.Errorf("bubble %d: %w", i+1, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("flex container type must be bubble or carousel, not %q", container.Type)
+	}
+}
+
+func checkBubble(data json.RawMessage) error {
+	if len(data) > MaxBubbleSize {
+		return fmt.Errorf("bubble is %d bytes, over %d", len(data), MaxBubbleSize)
+	}
+	var bubble map[string]interface{}
+	if err := json.Unmarshal(data, &bubble); err != nil {
+		return err
+	}
+	if bubble["type"] != "bubble" {
+		return fmt.Errorf("carousel may only hold bubbles, not %v", bubble["type"])
+	}
+	for _, block := range []string{"header", "hero", "body", "footer"} {
+		if c, ok := bubble[block]; ok {
+			if err := checkComponent(c, 0); err != nil {
+				return fmt.Errorf("%s: %w", block, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkComponent walks a component, counting the boxes around it.
+func checkComponent(v interface{}, depth int) error {
+	c, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("component must be an object")
+	}
+	for _, key := range []string{"url", "backgroundImage"} {
+		if s, ok := c[key].(string); ok {
+			if err := CheckURL(s); err != nil {
+				return err
+			}
+		}
+	}
+	if c["type"] != "box" {
+		return nil
+	}
+	if depth++; depth > MaxDepth {
+		return fmt.Errorf("boxes nest deeper than %d", MaxDepth)
+	}
+	contents, _ := c["contents"].([]interface{})
+	for _, child := range contents {
+		if err := checkComponent(child, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckURL checks an image or link URL: LINE only loads HTTPS.
+func CheckURL(s string) error {
+	if n := utf8.RuneCountInString(s); n > MaxURL {
+		return fmt.Errorf("URL is %d characters, over %d", n, MaxURL)
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("URL %q must be https", s)
+	}
+	return nil
+}
+
+// Message checks a container and wraps it in a Flex Message.
+func Message(altText string, contents json.RawMessage) (*messaging_api.FlexMessage, error) {
+	if err := Check(altText, contents); err != nil {
+		return nil, err
+	}
+	container, err := messaging_api.UnmarshalFlexContainer(contents)
+	if err != nil {
+		return nil, err
+	}
+	return &messaging_api.FlexMessage{AltText: altText, Contents: container}, nil
+}
@@ -0,0 +1,62 @@
+package flex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func bubble(body string) string {
+	return `{"type":"bubble","body":` + body + `}`
+}
+
+func TestCheck(t *testing.T) {
+	text := `{"type":"box","layout":"vertical","contents":[{"type":"text","text":"hi"}]}`
+	nested := `{"type":"text","text":"deep"}`
+	for i := 0; i < MaxDepth+1; i++ {
+		nested = `{"type":"box","layout":"vertical","contents":[` + nested + `]}`
+	}
+	many := make([]string, MaxBubbles+1)
+	for i := range many {
+		many[i] = bubble(text)
+	}
+	big := bubble(`{"type":"box","layout":"vertical","contents":[{"type":"text","text":"` + strings.Repeat("あ", MaxBubbleSize/3) + `"}]}`)
+
+	tests := []struct {
+		name     string
+		altText  string
+		contents string
+		ok       bool
+	}{
+		{"bubble", "予定", bubble(text), true},
+		{"carousel", "予定", `{"type":"carousel","contents":[` + bubble(text) + `,` + bubble(text) + `]}`, true},
+		{"hero image", "予定", `{"type":"bubble","hero":{"type":"image","url":"https://example.com/a.jpg"}}`, true},
+		{"no alt text", "", bubble(text), false},
+		{"long alt text", strings.Repeat("あ", MaxAltText+1), bubble(text), false},
+		{"unknown type", "予定", `{"type":"text","text":"hi"}`, false},
+		{"empty carousel", "予定", `{"type":"carousel","contents":[]}`, false},
+		{"too many bubbles", "予定", `{"type":"carousel","contents":[` + strings.Join(many, ",") + `]}`, false},
+		{"large bubble", "予定", big, false},
+		{"deep nesting", "予定", bubble(nested), false},
+		{"http image", "予定", `{"type":"bubble","hero":{"type":"image","url":"http://example.com/a.jpg"}}`, false},
+		{"not json", "予定", `{`, false},
+	}
+	for _, tt := range tests {
+		err := Check(tt.altText, json.RawMessage(tt.contents))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Check = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	m, err := Message("予定", json.RawMessage(`{"type":"carousel","contents":[`+bubble(`{"type":"box","layout":"vertical","contents":[{"type":"text","text":"hi"}]}`)+`]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(m)
+	if got := fmt.Sprint(strings.Contains(string(data), `"type":"flex"`), strings.Contains(string(data), `"altText":"予定"`)); got != "true true" {
+		t.Errorf("message = %s", data)
+	}
+}
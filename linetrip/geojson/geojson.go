@@ -0,0 +1,53 @@
+// Package geojson renders stored location messages as a GeoJSON
+// FeatureCollection (RFC 7946) for map views.
+package geojson
+
+import "github.com/takuto277/line-trip-list-api/linetrip/store"
+
+// FeatureCollection is a GeoJSON FeatureCollection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON Feature with a Point geometry.
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   Point                  `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON Point. Coordinates are [longitude, latitude].
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// FromMessages builds a FeatureCollection from the location messages among
+// messages. Other messages are skipped.
+func FromMessages(messages []store.Message) FeatureCollection {
+	fc := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for _, m := range messages {
+		if m.Location == nil {
+			continue
+		}
+		fc.Features = append(fc.Features, Feature{
+			Type: "Feature",
+			ID:   m.MessageID,
+			Geometry: Point{
+				Type:        "Point",
+				Coordinates: [2]float64{m.Location.Longitude, m.Location.Latitude},
+			},
+			Properties: map[string]interface{}{
+				"title":     m.Location.Title,
+				"address":   m.Location.Address,
+				"group_id":  m.GroupID,
+				"user_id":   m.UserID,
+				"user_name": m.UserName,
+				"timestamp": m.Timestamp,
+			},
+		})
+	}
+	return fc
+}
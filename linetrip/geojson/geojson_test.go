@@ -0,0 +1,30 @@
+package geojson
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestFromMessages(t *testing.T) {
+	fc := FromMessages([]store.Message{
+		{MessageID: "m1", Type: store.TypeText, Message: "hi"},
+		{MessageID: "m2", GroupID: "C1", Type: store.TypeLocation, Location: &store.Location{Title: "清水寺", Latitude: 34.9949, Longitude: 135.785}},
+	})
+
+	data, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GeoJSON の座標は [経度, 緯度] の順
+	want := `{"type":"FeatureCollection","features":[{"type":"Feature","id":"m2","geometry":{"type":"Point","coordinates":[135.785,34.9949]},"properties":{"address":"","group_id":"C1","timestamp":0,"title":"清水寺","user_id":"","user_name":""}}]}`
+	if string(data) != want {
+		t.Errorf("got  %s\nwant %s", data, want)
+	}
+
+	empty, _ := json.Marshal(FromMessages(nil))
+	if string(empty) != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("empty collection = %s", empty)
+	}
+}
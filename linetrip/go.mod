@@ -0,0 +1,10 @@
+module github.com/takuto277/line-trip-list-api/linetrip
+
+go 1.21
+
+require (
+	github.com/line/line-bot-sdk-go/v8 v8.6.0
+	go.etcd.io/bbolt v1.3.10
+)
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,49 @@
+package ingest
+
+import (
+	"log"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/attachment"
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/profile"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// FromEnv returns an Ingester writing to s with the chat commands and the
+// optional LINE API and attachment integrations configured from the
+// environment. Missing pieces are logged and left out rather than failing
+// ingestion.
+func FromEnv(s store.MessageStore) *Ingester {
+	in := New(s)
+	if bot, err := lineapi.New(); err != nil {
+		log.Printf("⚠️ Messaging API unavailable, senders are stored without display names: %v", err)
+	} else {
+		in.Profiles = profile.New(s, bot)
+		in.Members = bot
+		in.Replier = bot
+	}
+	if trips, err := trip.Open(s); err != nil {
+		log.Printf("⚠️ Trip store unavailable, chat commands are disabled: %v", err)
+	} else {
+		in.Commands = command.NewRegistry()
+		in.Trips = trips
+		trip.Register(in.Commands, trips, trip.Directory{Store: s, Profiles: in.Profiles})
+	}
+
+	// 添付ストアと Blob API が揃ったときだけメディア本体を保存する
+	attachments, err := attachment.Open()
+	if err != nil {
+		log.Printf("⚠️ Attachment store unavailable, media content will not be saved: %v", err)
+		return in
+	}
+	blob, err := lineapi.NewBlob()
+	if err != nil {
+		log.Printf("⚠️ Blob API unavailable, media content will not be saved: %v", err)
+		return in
+	}
+	in.Blob = blob
+	in.Attachments = attachments
+	return in
+}
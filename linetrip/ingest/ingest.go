@@ -0,0 +1,542 @@
+// Package ingest turns verified LINE webhook events into stored messages. The
+// Vercel handlers and the standalone server both feed their callbacks
+// through it so they behave the same way.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf16"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/attachment"
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/flex"
+	"github.com/takuto277/line-trip-list-api/linetrip/membership"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/profile"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+// DefaultDedupeTTL is how long webhook event and message IDs are remembered.
+// LINE gives up redelivering well within a day.
+const DefaultDedupeTTL = 24 * time.Hour
+
+// Counter names recorded through store.MessageStore.IncrCounter.
+const (
+	CounterStored      = "stored"
+	CounterDuplicates  = "duplicates"
+	CounterRedelivered = "redelivered"
+)
+
+// ContentSource downloads the binary content of a LINE message.
+// *messaging_api.MessagingApiBlobAPI implements it.
+type ContentSource interface {
+	GetMessageContent(messageId string) (*http.Response, error)
+}
+
+// Ingester stores the messages contained in webhook events.
+type Ingester struct {
+	Store     store.MessageStore
+	DedupeTTL time.Duration
+	// Blob and Attachments receive the content of image, video, audio and
+	// file messages. When either is nil only the message metadata is kept.
+	Blob        ContentSource
+	Attachments attachment.Store
+	// Profiles, when set, resolves the sender's display name and picture.
+	// Without it senders are stored under store.FallbackName.
+	Profiles *profile.Resolver
+	// Members, when set, seeds the membership registry when the bot joins
+	// a group or room.
+	Members membership.Source
+	// Commands, when set, runs chat commands ("/add 清水寺") found in text
+	// messages. Replies go through Replier when it is set, which also
+	// keeps unused reply tokens for the iOS app's sends (see outbound).
+	Commands *command.Registry
+	Replier  Replier
+	// Trips, when set, attaches new messages to their conversation's active
+	// trip.
+	Trips trip.Store
+	// Replay marks events replayed from the archive: commands still update
+	// state but nobody is replied to.
+	Replay bool
+	// OnStored, when set, is called with every newly stored message.
+	OnStored func(store.Message)
+}
+
+// Replier answers a message with its reply token, or pushes the answer
+// once the token has expired. *messaging_api.MessagingApiAPI implements it.
+type Replier = outbound.Client
+
+// New returns an Ingester writing to s.
+func New(s store.MessageStore) *Ingester {
+	return &Ingester{Store: s, DedupeTTL: DefaultDedupeTTL}
+}
+
+// HandleCallback processes every event of a verified callback. Events are
+// independent, so a failure is logged and the remaining events still run.
+func (in *Ingester) HandleCallback(ctx context.Context, cb *webhook.CallbackRequest) error {
+	var errs []error
+	for _, event := range cb.Events {
+		if err := in.HandleEvent(ctx, event); err != nil {
+			log.Printf("❌ Error handling %s event: %v", event.GetType(), err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HandleEvent processes a single webhook event.
+func (in *Ingester) HandleEvent(ctx context.Context, event webhook.EventInterface) error {
+	switch e := event.(type) {
+	case webhook.MessageEvent:
+		switch message := e.Message.(type) {
+		case webhook.TextMessageContent:
+			return in.handleTextMessage(ctx, e, message)
+		case webhook.ImageMessageContent:
+			return in.handleMedia(ctx, e, message.Id, store.TypeImage, mediaContent(message.ContentProvider, 0))
+		case webhook.VideoMessageContent:
+			return in.handleMedia(ctx, e, message.Id, store.TypeVideo, mediaContent(message.ContentProvider, message.Duration))
+		case webhook.AudioMessageContent:
+			return in.handleMedia(ctx, e, message.Id, store.TypeAudio, mediaContent(message.ContentProvider, message.Duration))
+		case webhook.LocationMessageContent:
+			return in.handleLocation(ctx, e, message)
+		case webhook.FileMessageContent:
+			return in.handleMedia(ctx, e, message.Id, store.TypeFile, &store.Content{FileName: message.FileName, Size: int64(message.FileSize)})
+		}
+	case webhook.UnsendEvent:
+		return in.handleUnsend(ctx, e)
+	case webhook.JoinEvent:
+		return in.handleJoin(ctx, e)
+	case webhook.LeaveEvent:
+		return in.handleLeave(ctx, e)
+	case webhook.MemberJoinedEvent:
+		if e.Joined == nil {
+			return nil
+		}
+		return in.updateMembers(ctx, e.Source, e.Joined.Members, in.Store.AddMembers)
+	case webhook.MemberLeftEvent:
+		if e.Left == nil {
+			return nil
+		}
+		return in.updateMembers(ctx, e.Source, e.Left.Members, in.Store.RemoveMembers)
+	}
+	return nil
+}
+
+// handleJoin seeds the members of a group or room the bot was just added
+// to. Seeding is best effort: most accounts may not list members, and
+// MemberJoined events and posts fill the registry in anyway.
+func (in *Ingester) handleJoin(ctx context.Context, event webhook.JoinEvent) error {
+	conversationID, typ, _, ok := conversationOf(event.Source)
+	if !ok || typ == store.ConversationUser {
+		return nil
+	}
+	log.Printf("👋 Joined %s %s", typ, conversationID)
+	if in.Members == nil {
+		return nil
+	}
+	n, err := membership.Seed(ctx, in.Store, in.Members, conversationID)
+	if err != nil {
+		log.Printf("⚠️ Could not seed members of %s: %v", conversationID, err)
+		return nil
+	}
+	log.Printf("✅ Seeded %d members of %s %s", n, typ, conversationID)
+	return nil
+}
+
+// handleLeave forgets the membership of a group or room the bot was removed
+// from. Its messages stay stored.
+func (in *Ingester) handleLeave(ctx context.Context, event webhook.LeaveEvent) error {
+	conversationID, typ, _, ok := conversationOf(event.Source)
+	if !ok || typ == store.ConversationUser {
+		return nil
+	}
+	members, err := in.Store.Members(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	log.Printf("👋 Left %s %s, forgetting %d members", typ, conversationID, len(members))
+	return in.Store.RemoveMembers(ctx, conversationID, members...)
+}
+
+func (in *Ingester) updateMembers(ctx context.Context, source webhook.SourceInterface, users []webhook.UserSource, update func(context.Context, string, ...string) error) error {
+	conversationID, _, _, ok := conversationOf(source)
+	if !ok {
+		return nil
+	}
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		if u.UserId != "" {
+			ids = append(ids, u.UserId)
+		}
+	}
+	log.Printf("👥 Membership change in %s: %v", conversationID, ids)
+	return update(ctx, conversationID, ids...)
+}
+
+// handleUnsend tombstones the message the sender unsent. Tombstones are
+// idempotent, so redeliveries need no dedupe claim.
+func (in *Ingester) handleUnsend(ctx context.Context, event webhook.UnsendEvent) error {
+	conversationID, _, _, ok := conversationOf(event.Source)
+	if !ok || event.Unsend == nil {
+		log.Printf("Unknown unsend source, skipping")
+		return nil
+	}
+
+	t := store.Tombstone{
+		GroupID:   conversationID,
+		MessageID: event.Unsend.MessageId,
+		DeletedAt: event.Timestamp,
+	}
+	if err := in.Store.Tombstone(ctx, t); err != nil {
+		return fmt.Errorf("tombstone %s: %w", t.MessageID, err)
+	}
+	log.Printf("🗑️ Message %s unsent in %s", t.MessageID, t.GroupID)
+	return nil
+}
+
+func (in *Ingester) handleTextMessage(ctx context.Context, event webhook.MessageEvent, message webhook.TextMessageContent) error {
+	m, ok := conversationMessage(event, message.Id)
+	if !ok {
+		log.Printf("Unknown message source, skipping")
+		return nil
+	}
+	m.Message = message.Text
+	m.Mentions = mentions(message.Text, message.Mention)
+
+	log.Printf("📱 %s message: %s from %s in %s",
+		m.ConversationType, message.Text, m.UserName, m.GroupID)
+
+	saved, stored, err := in.save(ctx, m, isRedelivery(event.DeliveryContext), nil)
+	if err != nil {
+		return err
+	}
+	if in.Commands != nil {
+		// コマンドは冪等なので重複配信でも実行するが、返信は初回だけ
+		reply, ok, err := in.Commands.DispatchReply(ctx, saved, in.Replay)
+		if ok {
+			if stored && !in.Replay {
+				in.reply(ctx, saved.GroupID, event.ReplyToken, reply)
+			}
+			return err
+		}
+	}
+	if in.Trips != nil && stored {
+		in.propose(ctx, saved)
+	}
+	in.keepReplyToken(ctx, saved, stored, event)
+	return nil
+}
+
+// keepReplyToken saves the reply token of a message nobody answered, so
+// the next send to its conversation can reply for free instead of pushing.
+func (in *Ingester) keepReplyToken(ctx context.Context, m store.Message, stored bool, event webhook.MessageEvent) {
+	if !stored || in.Replay || in.Replier == nil || event.ReplyToken == "" {
+		return
+	}
+	expires := time.UnixMilli(event.Timestamp).Add(store.ReplyTokenTTL)
+	if !time.Now().Before(expires) {
+		return
+	}
+	if err := in.Store.SaveReplyToken(ctx, m.GroupID, event.ReplyToken, expires); err != nil {
+		log.Printf("⚠️ Could not keep reply token of %s: %v", m.GroupID, err)
+	}
+}
+
+// propose adds the date and time a message mentions to its trip's
+// itinerary for the iOS app to confirm. Failures are logged only: the
+// message itself is stored.
+func (in *Ingester) propose(ctx context.Context, m store.Message) {
+	e, added, err := trip.Propose(ctx, in.Trips, m)
+	if err != nil {
+		log.Printf("⚠️ Itinerary proposal failed for %s: %v", m.MessageID, err)
+		return
+	}
+	if added {
+		log.Printf("📅 Proposed itinerary entry %d: %s %s %s", e.ID, e.Date, e.Time, e.Title)
+	}
+}
+
+// reply answers a command, pushing to the conversation when the reply
+// token has expired, e.g. after the event waited in the queue, and records
+// the answer in the conversation's timeline. A failed reply is logged
+// only: the command already took effect and the user can check with
+// /list. A Flex answer that does not pass flex.Check is sent as
+// its text instead.
+func (in *Ingester) reply(ctx context.Context, conversationID, replyToken string, reply command.Reply) {
+	if in.Replier == nil || reply.Text == "" && reply.Flex == nil {
+		return
+	}
+	var message messaging_api.MessageInterface = messaging_api.TextMessage{Text: reply.Text}
+	sent := outbound.Message{Type: outbound.TypeText, Text: reply.Text}
+	if reply.Flex != nil {
+		if m, err := flex.Message(reply.AltText, reply.Flex); err == nil {
+			message = m
+			sent = outbound.Message{Type: outbound.TypeFlex, AltText: reply.AltText}
+		} else {
+			log.Printf("⚠️ Flex reply rejected, sending text: %v", err)
+		}
+	}
+	sender := outbound.NewSender(in.Replier, in.Store)
+	delivery, err := sender.Reply(ctx, conversationID, replyToken, []messaging_api.MessageInterface{message})
+	if err != nil {
+		log.Printf("⚠️ Reply failed: %v", err)
+		return
+	}
+	// ボットの返信もアプリの会話に残す
+	sender.Timeline = in.Store
+	if in.Trips != nil {
+		sender.ActiveTrip = func(ctx context.Context, conversationID string) (string, error) {
+			return trip.ActiveID(ctx, in.Trips, conversationID)
+		}
+	}
+	sender.Record(ctx, conversationID, "", []outbound.Message{sent}, delivery)
+}
+
+func (in *Ingester) handleLocation(ctx context.Context, event webhook.MessageEvent, message webhook.LocationMessageContent) error {
+	m, ok := conversationMessage(event, message.Id)
+	if !ok {
+		log.Printf("Unknown message source, skipping")
+		return nil
+	}
+	m.Type = store.TypeLocation
+	m.Location = &store.Location{
+		Title:     message.Title,
+		Address:   message.Address,
+		Latitude:  message.Latitude,
+		Longitude: message.Longitude,
+	}
+
+	log.Printf("📍 Location: %s (%f, %f) from %s in %s",
+		message.Title, message.Latitude, message.Longitude, m.UserName, m.GroupID)
+
+	saved, stored, err := in.save(ctx, m, isRedelivery(event.DeliveryContext), nil)
+	if err != nil {
+		return err
+	}
+	in.keepReplyToken(ctx, saved, stored, event)
+	return nil
+}
+
+// handleMedia stores an image, video, audio or file message. Content held by
+// LINE is downloaded into the attachment store once the message is claimed.
+func (in *Ingester) handleMedia(ctx context.Context, event webhook.MessageEvent, messageID, typ string, content *store.Content) error {
+	m, ok := conversationMessage(event, messageID)
+	if !ok {
+		log.Printf("Unknown message source, skipping")
+		return nil
+	}
+	m.Type = typ
+	m.Content = content
+
+	log.Printf("📱 %s %s message from %s in %s", m.ConversationType, typ, m.UserName, m.GroupID)
+
+	var fetch func(context.Context, *store.Message) error
+	if content.URL == "" && in.Blob != nil && in.Attachments != nil {
+		fetch = in.download
+	}
+	saved, stored, err := in.save(ctx, m, isRedelivery(event.DeliveryContext), fetch)
+	if err != nil {
+		return err
+	}
+	in.keepReplyToken(ctx, saved, stored, event)
+	return nil
+}
+
+// mediaContent describes image, video and audio content. Content provided
+// by an external server is only referenced, never downloaded.
+func mediaContent(provider *webhook.ContentProvider, duration int64) *store.Content {
+	c := &store.Content{Duration: duration}
+	if provider != nil && provider.Type == webhook.ContentProviderTYPE_EXTERNAL {
+		c.URL = provider.OriginalContentUrl
+		c.PreviewURL = provider.PreviewImageUrl
+	}
+	return c
+}
+
+func (in *Ingester) download(ctx context.Context, m *store.Message) error {
+	resp, err := in.Blob.GetMessageContent(m.MessageID)
+	if err != nil {
+		return fmt.Errorf("get content of %s: %w", m.MessageID, err)
+	}
+	defer resp.Body.Close()
+
+	key := attachment.Key(m.GroupID, m.MessageID)
+	contentType := resp.Header.Get("Content-Type")
+	if err := in.Attachments.Put(ctx, key, contentType, resp.Body, resp.ContentLength); err != nil {
+		return fmt.Errorf("store content of %s: %w", m.MessageID, err)
+	}
+
+	content := *m.Content
+	content.Key = key
+	content.ContentType = contentType
+	if resp.ContentLength > 0 {
+		content.Size = resp.ContentLength
+	}
+	m.Content = &content
+	log.Printf("🗄️ Stored %s content of %s (%d bytes)", m.Type, m.MessageID, content.Size)
+	return nil
+}
+
+// conversationOf identifies the chat an event came from: a group, a room,
+// or a 1:1 chat keyed by the user's ID. ok is false for unknown sources.
+func conversationOf(source webhook.SourceInterface) (id, typ, userID string, ok bool) {
+	switch src := source.(type) {
+	case webhook.GroupSource:
+		return src.GroupId, store.ConversationGroup, src.UserId, true
+	case webhook.RoomSource:
+		return src.RoomId, store.ConversationRoom, src.UserId, true
+	case webhook.UserSource:
+		return src.UserId, store.ConversationUser, src.UserId, src.UserId != ""
+	default:
+		return "", "", "", false
+	}
+}
+
+// conversationMessage builds the stored form of a message event. It reports
+// false for events from unknown sources.
+func conversationMessage(event webhook.MessageEvent, messageID string) (store.Message, bool) {
+	id, typ, userID, ok := conversationOf(event.Source)
+	if !ok {
+		return store.Message{}, false
+	}
+
+	return store.Message{
+		MessageID:        messageID,
+		WebhookEventID:   event.WebhookEventId,
+		GroupID:          id,
+		ConversationType: typ,
+		UserID:           userID,
+		Timestamp:        event.Timestamp,
+		UserName:         store.FallbackName(userID),
+		Direction:        store.DirectionInbound,
+	}, true
+}
+
+// mentions lists the @mentions of a text message. LINE gives their
+// positions in UTF-16 code units.
+func mentions(text string, mention *webhook.Mention) []store.Mention {
+	if mention == nil {
+		return nil
+	}
+	units := utf16.Encode([]rune(text))
+	span := func(index, length int32) string {
+		if index < 0 || length < 0 || int(index+length) > len(units) {
+			return ""
+		}
+		return string(utf16.Decode(units[index : index+length]))
+	}
+
+	var out []store.Mention
+	for _, m := range mention.Mentionees {
+		switch m := m.(type) {
+		case webhook.UserMentionee:
+			out = append(out, store.Mention{UserID: m.UserId, Text: span(m.Index, m.Length)})
+		case *webhook.UserMentionee:
+			out = append(out, store.Mention{UserID: m.UserId, Text: span(m.Index, m.Length)})
+		case webhook.AllMentionee:
+			out = append(out, store.Mention{All: true, Text: span(m.Index, m.Length)})
+		case *webhook.AllMentionee:
+			out = append(out, store.Mention{All: true, Text: span(m.Index, m.Length)})
+		}
+	}
+	return out
+}
+
+func isRedelivery(dc *webhook.DeliveryContext) bool {
+	return dc != nil && dc.IsRedelivery
+}
+
+// save stores m unless the same webhook event or LINE message has already
+// been stored, and reports whether it did. fetch, when set, runs after the
+// claims are taken and may fill in m. Claims are released again if fetch or
+// the append fails, so a later redelivery gets another chance.
+func (in *Ingester) save(ctx context.Context, m store.Message, redelivery bool, fetch func(context.Context, *store.Message) error) (store.Message, bool, error) {
+	if redelivery {
+		log.Printf("🔁 Redelivered webhook event %s (message %s)", m.WebhookEventID, m.MessageID)
+		in.count(ctx, CounterRedelivered)
+	}
+
+	var keys []string
+	if m.WebhookEventID != "" {
+		keys = append(keys, "event:"+m.WebhookEventID)
+	}
+	if m.MessageID != "" {
+		keys = append(keys, "message:"+m.MessageID)
+	}
+
+	var claimed []string
+	for _, key := range keys {
+		ok, err := in.Store.Claim(ctx, key, in.DedupeTTL)
+		if err != nil {
+			in.release(ctx, claimed)
+			return m, false, fmt.Errorf("claim %s: %w", key, err)
+		}
+		if !ok {
+			in.release(ctx, claimed)
+			log.Printf("⏭️ Duplicate %s, skipping", key)
+			in.count(ctx, CounterDuplicates)
+			return m, false, nil
+		}
+		claimed = append(claimed, key)
+	}
+
+	if in.Profiles != nil && m.UserID != "" {
+		// 名前が取れなくてもメッセージは保存する
+		if p, err := in.Profiles.Lookup(ctx, m.GroupID, m.UserID); err != nil {
+			log.Printf("⚠️ Profile lookup failed, storing as %s: %v", m.UserName, err)
+		} else {
+			m.UserName = p.DisplayName
+			m.PictureURL = p.PictureURL
+		}
+	}
+
+	if in.Trips != nil {
+		if id, err := trip.ActiveID(ctx, in.Trips, m.GroupID); err != nil {
+			log.Printf("⚠️ Active trip lookup failed, storing without a trip: %v", err)
+		} else {
+			m.TripID = id
+		}
+	}
+
+	if fetch != nil {
+		if err := fetch(ctx, &m); err != nil {
+			in.release(ctx, claimed)
+			return m, false, err
+		}
+	}
+
+	saved, err := in.Store.Append(ctx, m)
+	if err != nil {
+		in.release(ctx, claimed)
+		return m, false, err
+	}
+	in.count(ctx, CounterStored)
+	log.Printf("✅ Message saved with ID %s", saved.ID)
+
+	if in.OnStored != nil {
+		in.OnStored(saved)
+	}
+	return saved, true, nil
+}
+
+func (in *Ingester) release(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := in.Store.Release(ctx, key); err != nil {
+			log.Printf("⚠️ Failed to release %s: %v", key, err)
+		}
+	}
+}
+
+func (in *Ingester) count(ctx context.Context, name string) {
+	if err := in.Store.IncrCounter(ctx, name, 1); err != nil {
+		log.Printf("⚠️ Failed to count %s: %v", name, err)
+	}
+}
@@ -0,0 +1,506 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/attachment"
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/profile"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+func textEvent(eventID, messageID, text string, redelivery bool) webhook.MessageEvent {
+	return webhook.MessageEvent{
+		Source:          webhook.GroupSource{GroupId: "C1", UserId: "Ue41eb551b7025cf6"},
+		Timestamp:       1700000000000,
+		WebhookEventId:  eventID,
+		DeliveryContext: &webhook.DeliveryContext{IsRedelivery: redelivery},
+		Message:         webhook.TextMessageContent{Id: messageID, Text: text},
+	}
+}
+
+func TestIngestDropsDuplicates(t *testing.T) {
+	s := store.NewMemory()
+	in := New(s)
+	ctx := context.Background()
+
+	cb := &webhook.CallbackRequest{Events: []webhook.EventInterface{
+		textEvent("01EVENT1", "m1", "清水寺", false),
+		// 同じイベントの再送
+		textEvent("01EVENT1", "m1", "清水寺", true),
+		// イベントIDは違うが同じ LINE メッセージ
+		textEvent("01EVENT2", "m1", "清水寺", false),
+		textEvent("01EVENT3", "m2", "金閣寺", false),
+	}}
+	if err := in.HandleCallback(ctx, cb); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := store.All(ctx, s, "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("stored %d messages, want 2: %+v", len(messages), messages)
+	}
+	if messages[0].MessageID != "m1" || messages[0].WebhookEventID != "01EVENT1" || messages[0].UserName != "User-Ue41eb55" {
+		t.Errorf("unexpected first message %+v", messages[0])
+	}
+
+	counters, _ := s.Counters(ctx)
+	if counters[CounterStored] != 2 || counters[CounterDuplicates] != 2 || counters[CounterRedelivered] != 1 {
+		t.Errorf("counters = %v", counters)
+	}
+}
+
+func TestIngestStoresFirstRedelivery(t *testing.T) {
+	// 初回配信が届かず再送だけが届いた場合は保存する
+	s := store.NewMemory()
+	in := New(s)
+	ctx := context.Background()
+
+	if err := in.HandleEvent(ctx, textEvent("01EVENT1", "m1", "hi", true)); err != nil {
+		t.Fatal(err)
+	}
+	messages, _ := store.All(ctx, s, "C1")
+	if len(messages) != 1 {
+		t.Fatalf("stored %d messages, want 1", len(messages))
+	}
+}
+
+func TestIngestUnsend(t *testing.T) {
+	s := store.NewMemory()
+	in := New(s)
+	ctx := context.Background()
+
+	cb := &webhook.CallbackRequest{Events: []webhook.EventInterface{
+		textEvent("01EVENT1", "m1", "清水寺", false),
+		webhook.UnsendEvent{
+			Source:    webhook.GroupSource{GroupId: "C1", UserId: "Ue41eb551b7025cf6"},
+			Timestamp: 1700000001000,
+			Unsend:    &webhook.UnsendDetail{MessageId: "m1"},
+		},
+	}}
+	if err := in.HandleCallback(ctx, cb); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := store.All(ctx, s, "C1")
+	if len(messages) != 1 || !messages[0].Deleted || messages[0].Message != "" {
+		t.Fatalf("messages = %+v", messages)
+	}
+}
+
+type fakeBlob map[string]string
+
+func (b fakeBlob) GetMessageContent(messageID string) (*http.Response, error) {
+	data, ok := b[messageID]
+	if !ok {
+		return nil, fmt.Errorf("unexpected status code: 404")
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"image/jpeg"}},
+		Body:          io.NopCloser(strings.NewReader(data)),
+		ContentLength: int64(len(data)),
+	}, nil
+}
+
+func TestIngestStoresMediaContent(t *testing.T) {
+	s := store.NewMemory()
+	in := New(s)
+	in.Blob = fakeBlob{"m1": "jpeg-bytes"}
+	in.Attachments = attachment.NewFS(t.TempDir())
+	ctx := context.Background()
+
+	image := func(eventID, messageID string) webhook.MessageEvent {
+		e := textEvent(eventID, messageID, "", false)
+		e.Message = webhook.ImageMessageContent{Id: messageID, ContentProvider: &webhook.ContentProvider{Type: webhook.ContentProviderTYPE_LINE}}
+		return e
+	}
+
+	if err := in.HandleEvent(ctx, image("01EVENT1", "m1")); err != nil {
+		t.Fatal(err)
+	}
+	// ダウンロードに失敗したメッセージは保存せず、再送で再試行できるようにする
+	if err := in.HandleEvent(ctx, image("01EVENT2", "m2")); err == nil {
+		t.Fatal("expected download error")
+	}
+	if ok, _ := s.Claim(ctx, "message:m2", time.Minute); !ok {
+		t.Error("claim for failed download was not released")
+	}
+
+	messages, _ := store.All(ctx, s, "C1")
+	if len(messages) != 1 {
+		t.Fatalf("stored %d messages, want 1", len(messages))
+	}
+	m := messages[0]
+	if m.Type != store.TypeImage || m.Content == nil || m.Content.Key != "C1/m1" || m.Content.ContentType != "image/jpeg" || m.Content.Size != 10 {
+		t.Fatalf("message = %+v content = %+v", m, m.Content)
+	}
+
+	body, _, err := in.Attachments.Get(ctx, m.Content.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if data, _ := io.ReadAll(body); string(data) != "jpeg-bytes" {
+		t.Errorf("stored content = %q", data)
+	}
+}
+
+func TestIngestLocations(t *testing.T) {
+	s := store.NewMemory()
+	in := New(s)
+	ctx := context.Background()
+
+	location := func(eventID, messageID, title string, lat, lng float64) webhook.MessageEvent {
+		e := textEvent(eventID, messageID, "", false)
+		e.Message = webhook.LocationMessageContent{Id: messageID, Title: title, Address: "京都府京都市", Latitude: lat, Longitude: lng}
+		return e
+	}
+	cb := &webhook.CallbackRequest{Events: []webhook.EventInterface{
+		location("01EVENT1", "m1", "清水寺", 34.9949, 135.7850),
+		textEvent("01EVENT2", "m2", "ここ行きたい", false),
+		location("01EVENT3", "m3", "金閣寺", 35.0394, 135.7292),
+		webhook.UnsendEvent{
+			Source:    webhook.GroupSource{GroupId: "C1"},
+			Timestamp: 1700000001000,
+			Unsend:    &webhook.UnsendDetail{MessageId: "m3"},
+		},
+	}}
+	if err := in.HandleCallback(ctx, cb); err != nil {
+		t.Fatal(err)
+	}
+
+	locations, err := store.Locations(ctx, s, []string{"C1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locations) != 1 {
+		t.Fatalf("got %d locations, want 1: %+v", len(locations), locations)
+	}
+	l := locations[0].Location
+	if locations[0].Type != store.TypeLocation || l.Title != "清水寺" || l.Latitude != 34.9949 || l.Longitude != 135.7850 {
+		t.Fatalf("location = %+v", l)
+	}
+}
+
+func TestIngestResolvesDisplayName(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	line.AddMember("C1", linetest.Member{UserID: "Ue41eb551b7025cf6", DisplayName: "たくと", PictureURL: "https://example.com/p.jpg"})
+
+	s := store.NewMemory()
+	in := New(s)
+	in.Profiles = profile.New(s, line.Client())
+	ctx := context.Background()
+
+	if err := in.HandleEvent(ctx, textEvent("01EVENT1", "m1", "清水寺", false)); err != nil {
+		t.Fatal(err)
+	}
+	// 退出済みなどでプロフィールが取れない場合も保存はする
+	unknown := textEvent("01EVENT2", "m2", "金閣寺", false)
+	unknown.Source = webhook.GroupSource{GroupId: "C1", UserId: "Uffffffffffff"}
+	if err := in.HandleEvent(ctx, unknown); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := store.All(ctx, s, "C1")
+	if len(messages) != 2 {
+		t.Fatalf("stored %d messages", len(messages))
+	}
+	if messages[0].UserName != "たくと" || messages[0].PictureURL != "https://example.com/p.jpg" {
+		t.Errorf("first message = %+v", messages[0])
+	}
+	if messages[1].UserName != "User-Ufffffff" {
+		t.Errorf("second message = %+v", messages[1])
+	}
+}
+
+func TestIngestMembership(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	line.AddMember("C1", linetest.Member{UserID: "U1"})
+	line.AddMember("C1", linetest.Member{UserID: "U2"})
+
+	s := store.NewMemory()
+	in := New(s)
+	in.Members = line.Client()
+	ctx := context.Background()
+	source := webhook.GroupSource{GroupId: "C1"}
+
+	cb := &webhook.CallbackRequest{Events: []webhook.EventInterface{
+		webhook.JoinEvent{Source: source},
+		webhook.MemberJoinedEvent{Source: source, Joined: &webhook.JoinedMembers{Members: []webhook.UserSource{{UserId: "U3"}}}},
+		webhook.MemberLeftEvent{Source: source, Left: &webhook.LeftMembers{Members: []webhook.UserSource{{UserId: "U1"}}}},
+	}}
+	if err := in.HandleCallback(ctx, cb); err != nil {
+		t.Fatal(err)
+	}
+
+	// まだ発言していない U3 もグループのメッセージを見られる
+	if groups, _ := s.UserGroups(ctx, "U3"); fmt.Sprint(groups) != "[C1]" {
+		t.Errorf("groups of U3 = %v", groups)
+	}
+	if members, _ := s.Members(ctx, "C1"); fmt.Sprint(members) != "[U2 U3]" {
+		t.Errorf("members = %v", members)
+	}
+
+	if err := in.HandleEvent(ctx, webhook.LeaveEvent{Source: source}); err != nil {
+		t.Fatal(err)
+	}
+	if members, _ := s.Members(ctx, "C1"); len(members) != 0 {
+		t.Errorf("members after leave = %v", members)
+	}
+}
+
+func TestIngestRoomsAndUsers(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	line.AddMember("R1", linetest.Member{UserID: "Uroom", DisplayName: "ルームの人"})
+	line.AddMember("C1", linetest.Member{UserID: "Udm", DisplayName: "DMの人"})
+
+	s := store.NewMemory()
+	in := New(s)
+	in.Profiles = profile.New(s, line.Client())
+	ctx := context.Background()
+
+	room := textEvent("01EVENT1", "m1", "部屋", false)
+	room.Source = webhook.RoomSource{RoomId: "R1", UserId: "Uroom"}
+	dm := textEvent("01EVENT2", "m2", "DM", false)
+	dm.Source = webhook.UserSource{UserId: "Udm"}
+	cb := &webhook.CallbackRequest{Events: []webhook.EventInterface{room, dm, textEvent("01EVENT3", "m3", "グループ", false)}}
+	if err := in.HandleCallback(ctx, cb); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := store.Paginate(ctx, s, store.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]store.Message{}
+	for _, m := range page.Messages {
+		got[m.ConversationType] = m
+	}
+	if m := got[store.ConversationRoom]; m.GroupID != "R1" || m.UserName != "ルームの人" {
+		t.Errorf("room message = %+v", m)
+	}
+	if m := got[store.ConversationUser]; m.GroupID != "Udm" || m.UserName != "DMの人" {
+		t.Errorf("user message = %+v", m)
+	}
+	if m := got[store.ConversationGroup]; m.GroupID != "C1" {
+		t.Errorf("group message = %+v", m)
+	}
+
+	page, err = store.Paginate(ctx, s, store.Query{ConversationType: store.ConversationRoom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].GroupID != "R1" {
+		t.Errorf("room filter = %+v", page.Messages)
+	}
+}
+
+func TestIngestCommands(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	s := store.NewMemory()
+	trips := trip.NewMemory()
+	in := New(s)
+	in.Commands = command.NewRegistry()
+	trip.Register(in.Commands, trips, nil)
+	in.Replier = line.Client()
+	ctx := context.Background()
+
+	add := textEvent("01EVENT1", "m1", "/add 清水寺", false)
+	add.ReplyToken = "reply-1"
+	if err := in.HandleEvent(ctx, add); err != nil {
+		t.Fatal(err)
+	}
+	// 再送では追加も返信もしない
+	add.DeliveryContext.IsRedelivery = true
+	if err := in.HandleEvent(ctx, add); err != nil {
+		t.Fatal(err)
+	}
+	chat := textEvent("01EVENT2", "m2", "楽しみ！", false)
+	chat.ReplyToken = "reply-2"
+	in.HandleEvent(ctx, chat)
+
+	items, _ := trips.Items(ctx, "C1")
+	if len(items) != 1 || items[0].Title != "清水寺" || items[0].SourceMessageID != "m1" {
+		t.Errorf("items = %+v", items)
+	}
+	sent := line.Sent()
+	if len(sent) != 1 || sent[0].ReplyToken != "reply-1" || !strings.Contains(string(sent[0].Messages[0]), "1. 清水寺") {
+		t.Fatalf("sent = %+v", sent)
+	}
+	// コマンドもメッセージとして保存され、ボットの返信も 1 件残る
+	messages, _ := store.All(ctx, s, "C1")
+	if len(messages) != 3 {
+		t.Fatalf("stored %d messages", len(messages))
+	}
+	var replies []store.Message
+	for _, m := range messages {
+		if m.Direction == store.DirectionOutbound {
+			replies = append(replies, m)
+		}
+	}
+	if len(replies) != 1 || !strings.Contains(replies[0].Message, "1. 清水寺") || replies[0].UserID != "" ||
+		replies[0].Delivery == nil || replies[0].Delivery.Method != outbound.MethodReply {
+		t.Errorf("replies = %+v", replies)
+	}
+}
+
+func TestIngestFlexReply(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	trips := trip.NewMemory()
+	in := New(store.NewMemory())
+	in.Commands = command.NewRegistry()
+	trip.Register(in.Commands, trips, nil)
+	in.Replier = line.Client()
+	in.Trips = trips
+	ctx := context.Background()
+
+	create := textEvent("01EVENT1", "m1", "/trip new 京都旅行", false)
+	create.ReplyToken = "reply-1"
+	in.HandleEvent(ctx, create)
+	trips.AddEntry(ctx, trip.Entry{GroupID: "C1", TripID: "C1-1", Date: "2026-11-04", Time: "10:00", Title: "金閣寺", Status: trip.EntryConfirmed})
+	event := textEvent("01EVENT2", "m2", "/itinerary", false)
+	event.ReplyToken = "reply-2"
+	if err := in.HandleEvent(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := line.Sent()
+	if len(sent) != 2 || !strings.Contains(string(sent[1].Messages[0]), `"type":"flex"`) || !strings.Contains(string(sent[1].Messages[0]), "金閣寺") {
+		t.Fatalf("sent = %+v", sent)
+	}
+	// Flex の返信は代替テキストで、作った旅行に記録される
+	messages, _ := store.All(ctx, in.Store, "C1")
+	if last := messages[len(messages)-1]; last.Direction != store.DirectionOutbound || last.Message != "📅 旅程（京都旅行）" || last.TripID != "C1-1" {
+		t.Errorf("recorded reply = %+v", last)
+	}
+}
+
+func TestIngestReplyTokens(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	s := store.NewMemory()
+	in := New(s)
+	in.Commands = command.NewRegistry()
+	trip.Register(in.Commands, trip.NewMemory(), nil)
+	in.Replier = line.Client()
+	ctx := context.Background()
+	now := time.Now().UnixMilli()
+
+	// 期限切れの返信トークンならプッシュで答える
+	add := textEvent("01EVENT1", "m1", "/add 清水寺", false)
+	add.ReplyToken, add.Timestamp = "reply-1", now
+	line.ExpireReplyToken("reply-1")
+	in.HandleEvent(ctx, add)
+
+	// 誰も返信しなかったトークンは iOS からの送信に使う
+	chat := textEvent("01EVENT2", "m2", "楽しみ！", false)
+	chat.ReplyToken, chat.Timestamp = "reply-2", now
+	in.HandleEvent(ctx, chat)
+	old := textEvent("01EVENT3", "m3", "古いメッセージ", false)
+	old.Source = webhook.GroupSource{GroupId: "C2", UserId: "U1"}
+	old.ReplyToken = "reply-3"
+	in.HandleEvent(ctx, old)
+
+	sender := outbound.NewSender(line.Client(), s)
+	message := []messaging_api.MessageInterface{messaging_api.TextMessage{Text: "集合は 10 時"}}
+	for _, to := range []string{"C1", "C1", "C2"} {
+		if _, err := sender.Send(ctx, to, "", message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var kinds []string
+	for _, sent := range line.Sent() {
+		kinds = append(kinds, sent.Kind+":"+sent.ReplyToken+sent.To)
+	}
+	if fmt.Sprint(kinds) != "[push:C1 reply:reply-2 push:C1 push:C2]" {
+		t.Errorf("sent = %v", kinds)
+	}
+	counters, _ := s.Counters(ctx)
+	if counters[outbound.CounterReplied] != 1 || counters[outbound.CounterPushed] != 3 {
+		t.Errorf("counters = %v", counters)
+	}
+}
+
+func TestIngestAttachesActiveTrip(t *testing.T) {
+	s := store.NewMemory()
+	trips := trip.NewMemory()
+	in := New(s)
+	in.Commands = command.NewRegistry()
+	in.Trips = trips
+	trip.Register(in.Commands, trips, nil)
+	ctx := context.Background()
+
+	in.HandleEvent(ctx, textEvent("01EVENT1", "m1", "旅行前", false))
+	in.HandleEvent(ctx, textEvent("01EVENT2", "m2", "/trip new 京都旅行", false))
+	in.HandleEvent(ctx, textEvent("01EVENT3", "m3", "楽しみ！", false))
+
+	messages, _ := store.All(ctx, s, "C1")
+	var ids []string
+	for _, m := range messages {
+		ids = append(ids, m.TripID)
+	}
+	// コマンドのメッセージは旅行ができる前に保存される
+	if fmt.Sprint(ids) != "[  C1-1]" {
+		t.Errorf("trip IDs = %q", ids)
+	}
+	page, err := store.Paginate(ctx, s, store.Query{TripID: "C1-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Message != "楽しみ！" {
+		t.Errorf("messages of trip = %+v", page.Messages)
+	}
+}
+
+func TestIngestProposesItinerary(t *testing.T) {
+	s := store.NewMemory()
+	trips := trip.NewMemory()
+	in := New(s)
+	in.Commands = command.NewRegistry()
+	in.Trips = trips
+	trip.Register(in.Commands, trips, nil)
+	ctx := context.Background()
+
+	in.HandleEvent(ctx, textEvent("01EVENT1", "m1", "/trip new 京都旅行 2023-11-15~2023-11-17", false))
+	in.HandleEvent(ctx, textEvent("01EVENT2", "m2", "2日目の夜 焼肉", false))
+	// 再送では提案しない
+	in.HandleEvent(ctx, textEvent("01EVENT2", "m2", "2日目の夜 焼肉", true))
+
+	entries, _ := trips.Entries(ctx, "C1")
+	if len(entries) != 1 || entries[0].Date != "2023-11-16" || entries[0].Period != "夜" || entries[0].Title != "焼肉" || entries[0].TripID != "C1-1" {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	// 位置は UTF-16 で数える（絵文字は 2）
+	text := "🍜 /pay 1200 @Taro Yamada @All"
+	got := mentions(text, &webhook.Mention{Mentionees: []webhook.MentioneeInterface{
+		webhook.UserMentionee{Index: 13, Length: 12, UserId: "U3"},
+		webhook.AllMentionee{Index: 26, Length: 4},
+	}})
+	if fmt.Sprint(got) != "[{U3 false @Taro Yamada} { true @All}]" {
+		t.Errorf("mentions = %+v", got)
+	}
+}
@@ -0,0 +1,449 @@
+// Package jpdate finds Japanese date and time expressions in chat messages,
+// such as 「3日目の10時に金閣寺」 or 「明日の夜 焼肉」, and resolves them
+// against the time the message was sent and the days of the trip.
+package jpdate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Ref is what relative expressions are resolved against.
+type Ref struct {
+	// Now is when the message was sent, in the conversation's time zone.
+	Now time.Time
+	// TripStart and TripEnd are the first and last day of the trip, for
+	// 「2日目」 and 「最終日」. Zero when unknown.
+	TripStart time.Time
+	TripEnd   time.Time
+}
+
+// Match is a date and time found in a text.
+type Match struct {
+	// Date is midnight of the day in Now's location. HasDate is false when
+	// the text only names a time.
+	Date    time.Time
+	HasDate bool
+	// Hour and Minute are set when HasTime.
+	Hour    int
+	Minute  int
+	HasTime bool
+	// Period is a part of the day (朝, 午前, 昼, 午後, 夕方, 夜, 深夜) given
+	// instead of an exact time.
+	Period string
+	// Expression is the text read as the date and time, and Rest what is
+	// left of the message with joining particles removed: usually the plan.
+	Expression string
+	Rest       string
+}
+
+// periodStart orders entries that only have a part of the day.
+var periodStart = map[string]int{
+	"朝": 8, "午前": 10, "昼": 12, "午後": 14, "夕方": 17, "夜": 19, "深夜": 23,
+}
+
+// Time returns the start of the match: the exact time, the usual start of
+// its Period, or midnight.
+func (m Match) Time() time.Time {
+	switch {
+	case m.HasTime:
+		return m.Date.Add(time.Duration(m.Hour)*time.Hour + time.Duration(m.Minute)*time.Minute)
+	case m.Period != "":
+		return m.Date.Add(time.Duration(periodStart[m.Period]) * time.Hour)
+	default:
+		return m.Date
+	}
+}
+
+const num = `([0-9]{1,4}|[〇一二三四五六七八九十]{1,3})`
+
+var weekdays = map[string]time.Weekday{
+	"日": time.Sunday, "月": time.Monday, "火": time.Tuesday, "水": time.Wednesday,
+	"木": time.Thursday, "金": time.Friday, "土": time.Saturday,
+}
+
+// relativeDays are words naming a day relative to the message, with the
+// part of the day some of them imply.
+var relativeDays = map[string]struct {
+	days   int
+	period string
+}{
+	"今日": {0, ""}, "きょう": {0, ""}, "本日": {0, ""},
+	"今朝": {0, "朝"}, "今夜": {0, "夜"}, "今晩": {0, "夜"},
+	"明日": {1, ""}, "あした": {1, ""}, "あす": {1, ""},
+	"明朝": {1, "朝"}, "明晩": {1, "夜"}, "明夜": {1, "夜"},
+	"明後日": {2, ""}, "あさって": {2, ""},
+	"明々後日": {3, ""}, "明明後日": {3, ""}, "しあさって": {3, ""},
+}
+
+// dateRule reads one form of date. It reports false when the match cannot
+// be resolved, e.g. 「2日目」 without a trip start date.
+type dateRule struct {
+	re      *regexp.Regexp
+	resolve func(g []string, ref Ref) (day time.Time, period string, ok bool)
+}
+
+// Rules are tried in order and the first that resolves wins, so longer
+// forms come before the shorter ones they contain.
+var dateRules = []dateRule{
+	{regexp.MustCompile(`([0-9]{4})[年/.-]([0-9]{1,2})[月/.-]([0-9]{1,2})日?` + weekdaySuffix), func(g []string, ref Ref) (time.Time, string, bool) {
+		return date(ref, atoi(g[1]), atoi(g[2]), atoi(g[3]))
+	}},
+	{regexp.MustCompile(num + `月` + num + `日` + weekdaySuffix + `|([0-9]{1,2})/([0-9]{1,2})` + weekdaySuffix), func(g []string, ref Ref) (time.Time, string, bool) {
+		month, day := parseNum(g[1]), parseNum(g[2])
+		if g[3] != "" {
+			month, day = atoi(g[3]), atoi(g[4])
+		}
+		t, _, ok := date(ref, ref.Now.Year(), month, day)
+		if ok && t.Before(today(ref)) {
+			// 年のない日付は次に来るその日
+			t, _, ok = date(ref, ref.Now.Year()+1, month, day)
+		}
+		return t, "", ok
+	}},
+	{regexp.MustCompile(num + `日目|初日|最終日`), func(g []string, ref Ref) (time.Time, string, bool) {
+		switch {
+		case g[0] == "最終日":
+			return ref.TripEnd, "", !ref.TripEnd.IsZero()
+		case ref.TripStart.IsZero():
+			return time.Time{}, "", false
+		case g[0] == "初日":
+			return ref.TripStart, "", true
+		}
+		n := parseNum(g[1])
+		return ref.TripStart.AddDate(0, 0, n-1), "", n >= 1
+	}},
+	{regexp.MustCompile(`明々後日|明明後日|しあさって|明後日|あさって|明日|あした|あす|明朝|明晩|明夜|今日|きょう|本日|今朝|今夜|今晩`), func(g []string, ref Ref) (time.Time, string, bool) {
+		r := relativeDays[g[0]]
+		return today(ref).AddDate(0, 0, r.days), r.period, true
+	}},
+	{regexp.MustCompile(num + `(日|週間)後`), func(g []string, ref Ref) (time.Time, string, bool) {
+		n := parseNum(g[1])
+		if g[2] == "週間" {
+			n *= 7
+		}
+		return today(ref).AddDate(0, 0, n), "", n > 0
+	}},
+	{regexp.MustCompile(`(今月|来月|再来月)末|月末|年末|年明け|年始`), func(g []string, ref Ref) (time.Time, string, bool) {
+		t := today(ref)
+		switch g[0] {
+		case "年末":
+			return time.Date(t.Year(), time.December, 31, 0, 0, 0, 0, t.Location()), "", true
+		case "年明け", "年始":
+			return time.Date(t.Year()+1, time.January, 1, 0, 0, 0, 0, t.Location()), "", true
+		}
+		months := map[string]int{"": 0, "今月": 0, "来月": 1, "再来月": 2}[g[1]]
+		// 翌月の 0 日はその月の末日
+		return time.Date(t.Year(), t.Month()+time.Month(months)+1, 0, 0, 0, 0, 0, t.Location()), "", true
+	}},
+	{regexp.MustCompile(`(次|今度)の([月火水木金土日])曜日?|(?:(今週|来週|再来週)の?)?([月火水木金土日])曜日?|(今週|来週|再来週|週)末`), func(g []string, ref Ref) (time.Time, string, bool) {
+		if g[2] != "" {
+			// 次の土曜: 今日が土曜なら来週の土曜
+			return next(ref, weekdays[g[2]], 1), "", true
+		}
+		week, day := g[3], weekdays[g[4]]
+		if g[4] == "" {
+			week, day = g[5], time.Saturday
+		}
+		switch week {
+		case "", "週":
+			if g[4] == "" && ref.Now.Weekday() == time.Sunday {
+				// 日曜の「週末」は今日
+				return today(ref), "", true
+			}
+			return next(ref, day, 0), "", true
+		case "今週":
+			if g[4] == "" && ref.Now.Weekday() == time.Sunday {
+				// 日曜の「今週末」も今日
+				return today(ref), "", true
+			}
+			return weekOf(ref, 0, day), "", true
+		case "来週":
+			return weekOf(ref, 1, day), "", true
+		default:
+			return weekOf(ref, 2, day), "", true
+		}
+	}},
+}
+
+// weekdaySuffix swallows the weekday often written after a date: 11/3(火).
+const weekdaySuffix = `(?:\s*\([月火水木金土日](?:曜日?)?\))?`
+
+var (
+	// clockRe reads 10時, 10時半, 10時15分, 午後3時, 夜7時 and 10:30.
+	clockRe = regexp.MustCompile(`(?:(午前|午後|朝|昼|夕方|夜|晩|深夜)の?)?(?:` + num + `時(半|` + num + `分|間)?|([0-9]{1,2}):([0-9]{2}))`)
+	// periodRe reads a part of the day without a time.
+	periodRe = regexp.MustCompile(`正午|午前中|午前|午後|お昼|深夜|夕方|朝|昼|夜|晩`)
+)
+
+var periodNames = map[string]string{
+	"午前中": "午前", "午前": "午前", "午後": "午後", "お昼": "昼", "昼": "昼",
+	"深夜": "深夜", "夕方": "夕方", "朝": "朝", "夜": "夜", "晩": "夜",
+}
+
+// Parse finds the first date and time in text. It reports false when text
+// has neither.
+func Parse(text string, ref Ref) (Match, bool) {
+	text = normalize(text)
+	var m Match
+	var spans [][2]int
+
+	datePeriod := ""
+	for _, rule := range dateRules {
+		found := false
+		for _, loc := range rule.re.FindAllStringSubmatchIndex(text, -1) {
+			day, period, ok := rule.resolve(groups(text, loc), ref)
+			if !ok {
+				continue
+			}
+			m.Date, m.HasDate, datePeriod = day, true, period
+			spans = append(spans, [2]int{loc[0], loc[1]})
+			found = true
+			break
+		}
+		if found {
+			break
+		}
+	}
+
+	// 日付の部分を空白で隠して時刻を探す（バイト位置はそのまま）
+	masked := mask(text, spans)
+	for _, loc := range clockRe.FindAllStringSubmatchIndex(masked, -1) {
+		g := groups(masked, loc)
+		if g[3] == "間" {
+			// 「2時間」は時刻ではない
+			continue
+		}
+		modifier := g[1]
+		if modifier == "" {
+			modifier = datePeriod
+		}
+		hour, minute := parseNum(g[2]), 0
+		switch {
+		case g[5] != "":
+			hour, minute = atoi(g[5]), atoi(g[6])
+			if modifier == "" {
+				// 10:30 のような書き方は 24 時間制
+				modifier = "24h"
+			}
+		case g[3] == "半":
+			minute = 30
+		case g[4] != "":
+			minute = parseNum(g[4])
+		}
+		hour = adjustHour(hour, modifier)
+		if hour < 0 || hour > 24 || minute > 59 {
+			continue
+		}
+		m.Hour, m.Minute, m.HasTime = hour%24, minute, true
+		spans = append(spans, [2]int{loc[0], loc[1]})
+		break
+	}
+	if !m.HasTime {
+		if loc := periodRe.FindStringIndex(masked); loc != nil {
+			word := masked[loc[0]:loc[1]]
+			if word == "正午" {
+				m.Hour, m.HasTime = 12, true
+			} else {
+				m.Period = periodNames[word]
+			}
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		} else {
+			m.Period = datePeriod
+		}
+	}
+
+	if !m.HasDate && !m.HasTime && m.Period == "" {
+		return Match{}, false
+	}
+	m.Expression, m.Rest = split(text, spans)
+	return m, true
+}
+
+// adjustHour reads a 12-hour clock with its part of the day. Without one,
+// 1時 to 6時 are taken as the afternoon, as in 「3時にお茶」.
+func adjustHour(hour int, modifier string) int {
+	switch modifier {
+	case "午後", "夕方":
+		if hour < 12 {
+			return hour + 12
+		}
+	case "夜", "晩":
+		if hour <= 12 {
+			// 夜12時は 0 時
+			return hour + 12
+		}
+	case "昼":
+		if hour <= 5 {
+			return hour + 12
+		}
+	case "":
+		if hour >= 1 && hour <= 6 {
+			return hour + 12
+		}
+	}
+	return hour
+}
+
+func today(ref Ref) time.Time {
+	n := ref.Now
+	return time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, n.Location())
+}
+
+func date(ref Ref, year, month, day int) (time.Time, string, bool) {
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, ref.Now.Location())
+	// 2/30 のような存在しない日付は読まない
+	return t, "", month >= 1 && month <= 12 && t.Day() == day
+}
+
+// next returns the first day on weekday wd at least skip days after today.
+func next(ref Ref, wd time.Weekday, skip int) time.Time {
+	t := today(ref).AddDate(0, 0, skip)
+	for t.Weekday() != wd {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// weekOf returns weekday wd in the week weeks after the current one.
+// Weeks start on Monday.
+func weekOf(ref Ref, weeks int, wd time.Weekday) time.Time {
+	t := today(ref)
+	monday := t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	return monday.AddDate(0, 0, 7*weeks+(int(wd)+6)%7)
+}
+
+// normalize turns full-width digits and symbols into ASCII.
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '０' && r <= '９':
+			return '0' + (r - '０')
+		case r == '：':
+			return ':'
+		case r == '／':
+			return '/'
+		case r == '（':
+			return '('
+		case r == '）':
+			return ')'
+		case r == '　':
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func groups(s string, loc []int) []string {
+	g := make([]string, len(loc)/2)
+	for i := range g {
+		if loc[2*i] >= 0 {
+			g[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return g
+}
+
+func mask(s string, spans [][2]int) string {
+	b := []byte(s)
+	for _, sp := range spans {
+		for i := sp[0]; i < sp[1]; i++ {
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
+
+// leading and trailing are the particles joining an expression to the
+// rest of a sentence, as in 「3日目の10時に」.
+var (
+	leading  = []string{"くらい", "ぐらい", "から", "まで", "ごろ", "頃", "の", "に", "は", "で", "も", "、", ",", "~", "〜", " "}
+	trailing = []string{"から", "まで", "の", "に", "は", "で", "も", "、", ",", " "}
+)
+
+// split returns the text of the spans and the rest of s around them.
+func split(s string, spans [][2]int) (expression, rest string) {
+	sortSpans(spans)
+	var exprs, parts []string
+	pos := 0
+	for i, sp := range spans {
+		seg := s[pos:sp[0]]
+		if i > 0 {
+			seg = trimPrefixes(seg, leading)
+		}
+		parts = append(parts, trimSuffixes(seg, trailing))
+		exprs = append(exprs, s[sp[0]:sp[1]])
+		pos = sp[1]
+	}
+	parts = append(parts, trimPrefixes(s[pos:], leading))
+
+	rest = strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+	rest = strings.TrimRight(rest, "!?！？。、")
+	return strings.Join(exprs, " "), rest
+}
+
+func sortSpans(spans [][2]int) {
+	for i := 1; i < len(spans); i++ {
+		for j := i; j > 0 && spans[j][0] < spans[j-1][0]; j-- {
+			spans[j], spans[j-1] = spans[j-1], spans[j]
+		}
+	}
+}
+
+func trimPrefixes(s string, prefixes []string) string {
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, p := range prefixes {
+			if strings.HasPrefix(s, p) {
+				s, trimmed = s[len(p):], true
+			}
+		}
+	}
+	return s
+}
+
+func trimSuffixes(s string, suffixes []string) string {
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, p := range suffixes {
+			if strings.HasSuffix(s, p) {
+				s, trimmed = s[:len(s)-len(p)], true
+			}
+		}
+	}
+	return s
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// parseNum reads ASCII digits or kanji numerals up to 99 (三, 十五, 二十三).
+func parseNum(s string) int {
+	if s == "" {
+		return 0
+	}
+	if s[0] >= '0' && s[0] <= '9' {
+		return atoi(s)
+	}
+	digits := map[rune]int{'〇': 0, '一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	n, cur := 0, 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		if r == '十' {
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+			continue
+		}
+		cur = cur*10 + digits[r]
+	}
+	return n + cur
+}
@@ -0,0 +1,263 @@
+package jpdate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var jst = time.FixedZone("JST", 9*60*60)
+
+// render shows a match as "date time | rest", with "-" for missing parts
+// and the period in place of a time.
+func render(m Match) string {
+	day, clock := "-", "-"
+	if m.HasDate {
+		day = m.Date.Format("2006-01-02")
+	}
+	if m.HasTime {
+		clock = fmt.Sprintf("%02d:%02d", m.Hour, m.Minute)
+	} else if m.Period != "" {
+		clock = m.Period
+	}
+	return day + " " + clock + " | " + m.Rest
+}
+
+func TestParse(t *testing.T) {
+	// 2026-10-21 は水曜日。旅行は 11/3（火）〜11/5（木）
+	ref := Ref{
+		Now:       time.Date(2026, 10, 21, 12, 0, 0, 0, jst),
+		TripStart: time.Date(2026, 11, 3, 0, 0, 0, 0, jst),
+		TripEnd:   time.Date(2026, 11, 5, 0, 0, 0, 0, jst),
+	}
+	tests := []struct {
+		text string
+		want string // "" は日時なし
+	}{
+		// 旅行の何日目
+		{"3日目の10時に金閣寺", "2026-11-05 10:00 | 金閣寺"},
+		{"１日目　１５時　チェックイン", "2026-11-03 15:00 | チェックイン"},
+		{"2日目は嵐山", "2026-11-04 - | 嵐山"},
+		{"二日目の朝 伏見稲荷", "2026-11-04 朝 | 伏見稲荷"},
+		{"三日目の午後に清水寺", "2026-11-05 午後 | 清水寺"},
+		{"初日の夜は先斗町で飲み", "2026-11-03 夜 | 先斗町で飲み"},
+		{"最終日 11時チェックアウト", "2026-11-05 11:00 | チェックアウト"},
+		{"2日目10時半 嵐山トロッコ", "2026-11-04 10:30 | 嵐山トロッコ"},
+		{"金閣寺は3日目の10時から", "2026-11-05 10:00 | 金閣寺"},
+		{"3日目どうする？", "2026-11-05 - | どうする"},
+		{"1日目の12:30にお昼ごはん", "2026-11-03 12:30 | お昼ごはん"},
+
+		// 相対的な日
+		{"明日の夜 焼肉", "2026-10-22 夜 | 焼肉"},
+		{"明日 焼肉", "2026-10-22 - | 焼肉"},
+		{"あした10時集合", "2026-10-22 10:00 | 集合"},
+		{"あすの朝7時に出発", "2026-10-22 07:00 | 出発"},
+		{"明後日のお昼にラーメン", "2026-10-23 昼 | ラーメン"},
+		{"あさって 夕方から買い物", "2026-10-23 夕方 | 買い物"},
+		{"しあさっての午前中に洗車", "2026-10-24 午前 | 洗車"},
+		{"明々後日 映画", "2026-10-24 - | 映画"},
+		{"今日の19時に予約したよ", "2026-10-21 19:00 | 予約したよ"},
+		{"きょう 夜ごはん 鍋", "2026-10-21 夜 | ごはん 鍋"},
+		{"本日18時半から宴会", "2026-10-21 18:30 | 宴会"},
+		{"今夜 花火大会", "2026-10-21 夜 | 花火大会"},
+		{"今夜9時に電話する", "2026-10-21 21:00 | 電話する"},
+		{"今晩はカレー", "2026-10-21 夜 | カレー"},
+		{"明朝6時に起きる", "2026-10-22 06:00 | 起きる"},
+		{"明晩 送別会", "2026-10-22 夜 | 送別会"},
+		{"3日後に出発", "2026-10-24 - | 出発"},
+		{"2週間後に京都", "2026-11-04 - | 京都"},
+
+		// 曜日
+		{"来週土曜 嵐山", "2026-10-31 - | 嵐山"},
+		{"来週の土曜日は嵐山に行きたい", "2026-10-31 - | 嵐山に行きたい"},
+		{"今週金曜の夜に飲み会", "2026-10-23 夜 | 飲み会"},
+		{"土曜 BBQ", "2026-10-24 - | BBQ"},
+		{"水曜の15時に打ち合わせ", "2026-10-21 15:00 | 打ち合わせ"},
+		{"次の水曜 ランチ", "2026-10-28 - | ランチ"},
+		{"今度の日曜日に温泉", "2026-10-25 - | 温泉"},
+		{"再来週の月曜 有給", "2026-11-02 - | 有給"},
+		{"週末はキャンプ", "2026-10-24 - | キャンプ"},
+		{"来週末 紅葉狩り", "2026-10-31 - | 紅葉狩り"},
+		{"今週末の夜 花火", "2026-10-24 夜 | 花火"},
+		{"来週の金曜 送別会やろう", "2026-10-30 - | 送別会やろう"},
+		{"金曜の夜 飲みに行かない？", "2026-10-23 夜 | 飲みに行かない"},
+		{"再来週の土曜日 BBQ", "2026-11-07 - | BBQ"},
+		{"再来週末は空いてる？", "2026-11-07 - | 空いてる"},
+		{"今度の土曜 10時に駅前", "2026-10-24 10:00 | 駅前"},
+		{"日曜の朝 ゴルフ", "2026-10-25 朝 | ゴルフ"},
+
+		// 月末・年末年始
+		{"月末に精算しよう", "2026-10-31 - | 精算しよう"},
+		{"今月末 家賃", "2026-10-31 - | 家賃"},
+		{"来月末 締め切り", "2026-11-30 - | 締め切り"},
+		{"再来月末に引っ越し", "2026-12-31 - | 引っ越し"},
+		{"年末 帰省する", "2026-12-31 - | 帰省する"},
+		{"年明けに新年会", "2027-01-01 - | 新年会"},
+		{"年始 初詣", "2027-01-01 - | 初詣"},
+
+		// 月日
+		{"11/3 京都駅 集合", "2026-11-03 - | 京都駅 集合"},
+		{"11/3(火) 10時 京都駅", "2026-11-03 10:00 | 京都駅"},
+		{"11/3（火）の朝に出発", "2026-11-03 朝 | 出発"},
+		{"11月4日の夜に鴨川", "2026-11-04 夜 | 鴨川"},
+		{"十一月五日 お土産", "2026-11-05 - | お土産"},
+		{"2026年11月3日 新幹線", "2026-11-03 - | 新幹線"},
+		{"2026/11/04 14:00 錦市場", "2026-11-04 14:00 | 錦市場"},
+		{"1/5に初詣", "2027-01-05 - | 初詣"},
+		{"10/21 飲み", "2026-10-21 - | 飲み"},
+		// 過ぎた日付は来年
+		{"10/20 同窓会", "2027-10-20 - | 同窓会"},
+
+		// 時刻
+		{"10時半に集合", "- 10:30 | 集合"},
+		{"10時15分 バス", "- 10:15 | バス"},
+		{"十時に起きる", "- 10:00 | 起きる"},
+		{"午後3時にお茶", "- 15:00 | お茶"},
+		{"3時にお茶", "- 15:00 | お茶"},
+		{"午前9時 出発", "- 09:00 | 出発"},
+		{"朝8時に朝ごはん", "- 08:00 | 朝ごはん"},
+		{"夜7時 ディナー", "- 19:00 | ディナー"},
+		{"夜の8時から花火", "- 20:00 | 花火"},
+		{"夕方5時に駅", "- 17:00 | 駅"},
+		{"昼1時 ランチ", "- 13:00 | ランチ"},
+		{"深夜1時 ラーメン", "- 01:00 | ラーメン"},
+		{"夜12時 解散", "- 00:00 | 解散"},
+		{"15:30 チェックイン", "- 15:30 | チェックイン"},
+		{"6:30 起床", "- 06:30 | 起床"},
+		{"正午に集合", "- 12:00 | 集合"},
+		{"１０時ごろ ホテル出発", "- 10:00 | ホテル出発"},
+		{"9時くらいに集合", "- 09:00 | 集合"},
+		{"お昼 そば", "- 昼 | そば"},
+		{"夜 焼肉", "- 夜 | 焼肉"},
+
+		// 日時でないもの
+		{"2時間くらいかかる", ""},
+		{"楽しみ！", ""},
+		{"清水寺", ""},
+		{"2/30 集合", ""},
+		{"再来週 京都", ""},
+		{"来週どうする？", ""},
+	}
+	for _, tt := range tests {
+		m, ok := Parse(tt.text, ref)
+		got := ""
+		if ok {
+			got = render(m)
+		}
+		if tt.want == "" && ok && m.HasDate {
+			t.Errorf("Parse(%q) = %q, want no date", tt.text, got)
+			continue
+		}
+		if tt.want != "" && got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestParseAcrossWeek resolves the same phrasings from other days, since
+// weekday rules depend on the day the message was sent.
+func TestParseAcrossWeek(t *testing.T) {
+	tests := []struct {
+		now   string
+		cases map[string]string
+	}{
+		{"2026-10-18", map[string]string{ // 日曜
+			"土曜 BBQ":       "2026-10-24 - | BBQ",
+			"水曜の15時に打ち合わせ": "2026-10-21 15:00 | 打ち合わせ",
+			"日曜 温泉":        "2026-10-18 - | 温泉",
+			"週末はキャンプ":      "2026-10-18 - | キャンプ",
+			"今週末 花火":       "2026-10-18 - | 花火",
+			"来週末 紅葉狩り":     "2026-10-24 - | 紅葉狩り",
+			"来週の金曜 送別会":    "2026-10-23 - | 送別会",
+			"次の日曜 ランチ":     "2026-10-25 - | ランチ",
+			"今度の土曜日に温泉":    "2026-10-24 - | 温泉",
+			"再来週の水曜 有給":    "2026-10-28 - | 有給",
+			"明日 ゴルフ":       "2026-10-19 - | ゴルフ",
+			"月末に飲み会":       "2026-10-31 - | 飲み会",
+		}},
+		{"2026-10-19", map[string]string{ // 月曜
+			"週末はキャンプ":  "2026-10-24 - | キャンプ",
+			"日曜 温泉":    "2026-10-25 - | 温泉",
+			"月曜 定例":    "2026-10-19 - | 定例",
+			"次の月曜 定例":  "2026-10-26 - | 定例",
+			"来週末 紅葉狩り": "2026-10-31 - | 紅葉狩り",
+		}},
+		{"2026-10-24", map[string]string{ // 土曜
+			"週末はキャンプ":  "2026-10-24 - | キャンプ",
+			"日曜 温泉":    "2026-10-25 - | 温泉",
+			"今週の日曜 温泉": "2026-10-25 - | 温泉",
+			"来週の金曜 飲み": "2026-10-30 - | 飲み",
+		}},
+		{"2026-12-31", map[string]string{ // 大晦日（木曜）
+			"明日 初詣":      "2027-01-01 - | 初詣",
+			"1/2に実家":     "2027-01-02 - | 実家",
+			"12/31 紅白":   "2026-12-31 - | 紅白",
+			"年明けに新年会":    "2027-01-01 - | 新年会",
+			"年末の大掃除":     "2026-12-31 - | 大掃除",
+			"月末 カウントダウン": "2026-12-31 - | カウントダウン",
+			"来月末 締め切り":   "2027-01-31 - | 締め切り",
+			"来週の月曜 仕事始め": "2027-01-04 - | 仕事始め",
+			"金曜 新年会":     "2027-01-01 - | 新年会",
+			"3日後に帰る":     "2027-01-03 - | 帰る",
+		}},
+		{"2027-01-31", map[string]string{ // 日曜・月末
+			"月末 支払い":    "2027-01-31 - | 支払い",
+			"来月末 支払い":   "2027-02-28 - | 支払い",
+			"週末 掃除":     "2027-01-31 - | 掃除",
+			"明後日 歯医者":   "2027-02-02 - | 歯医者",
+			"来週の土曜 スキー": "2027-02-06 - | スキー",
+			"2月29日 会議":  "",
+		}},
+		{"2028-02-28", map[string]string{ // うるう年
+			"明日 健康診断": "2028-02-29 - | 健康診断",
+			"月末 精算":   "2028-02-29 - | 精算",
+			"2/29 集合": "2028-02-29 - | 集合",
+		}},
+	}
+	for _, tt := range tests {
+		now, err := time.ParseInLocation("2006-01-02", tt.now, jst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref := Ref{Now: now.Add(12 * time.Hour)}
+		for text, want := range tt.cases {
+			m, ok := Parse(text, ref)
+			got := ""
+			if ok && m.HasDate {
+				got = render(m)
+			}
+			if got != want {
+				t.Errorf("on %s Parse(%q) = %q, want %q", tt.now, text, got, want)
+			}
+		}
+	}
+}
+
+func TestParseWithoutTrip(t *testing.T) {
+	// 旅行の日程がないと「N日目」は読めない
+	ref := Ref{Now: time.Date(2026, 10, 18, 12, 0, 0, 0, jst)}
+	if m, ok := Parse("3日目の10時に金閣寺", ref); !ok || m.HasDate || !m.HasTime {
+		t.Errorf("without trip = %+v, %v", m, ok)
+	}
+	if _, ok := Parse("最終日 お土産", ref); ok {
+		t.Error("最終日 resolved without trip end")
+	}
+	// 日曜の「週末」は今日
+	if m, _ := Parse("週末 掃除", ref); m.Date.Format("2006-01-02") != "2026-10-18" {
+		t.Errorf("weekend on Sunday = %v", m.Date)
+	}
+}
+
+func TestMatchTime(t *testing.T) {
+	ref := Ref{Now: time.Date(2026, 10, 21, 12, 0, 0, 0, jst)}
+	for text, want := range map[string]string{
+		"明日の10時半": "2026-10-22 10:30",
+		"明日の夜":    "2026-10-22 19:00",
+		"明日":      "2026-10-22 00:00",
+	} {
+		m, _ := Parse(text, ref)
+		if got := m.Time().Format("2006-01-02 15:04"); got != want {
+			t.Errorf("Parse(%q).Time() = %s, want %s", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package lineapi builds LINE Messaging API clients from the environment.
+// LINE_API_ENDPOINT and LINE_DATA_API_ENDPOINT override the API hosts, so a
+// local fake (cmd/fakeline) can stand in for LINE during development.
+package lineapi
+
+import (
+	"os"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// New returns a MessagingApiAPI for LINE_CHANNEL_TOKEN.
+func New() (*messaging_api.MessagingApiAPI, error) {
+	var options []messaging_api.MessagingApiAPIOption
+	if endpoint := os.Getenv("LINE_API_ENDPOINT"); endpoint != "" {
+		options = append(options, messaging_api.WithEndpoint(endpoint))
+	}
+	return messaging_api.NewMessagingApiAPI(os.Getenv("LINE_CHANNEL_TOKEN"), options...)
+}
+
+// NewBlob returns a MessagingApiBlobAPI for LINE_CHANNEL_TOKEN. The content
+// host falls back to LINE_API_ENDPOINT, which is what the fake serves.
+func NewBlob() (*messaging_api.MessagingApiBlobAPI, error) {
+	var options []messaging_api.MessagingApiBlobAPIOption
+	endpoint := os.Getenv("LINE_DATA_API_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("LINE_API_ENDPOINT")
+	}
+	if endpoint != "" {
+		options = append(options, messaging_api.WithBlobEndpoint(endpoint))
+	}
+	return messaging_api.NewMessagingApiBlobAPI(os.Getenv("LINE_CHANNEL_TOKEN"), options...)
+}
@@ -0,0 +1,113 @@
+package linetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// Sent is a reply or push the fake accepted.
+type Sent struct {
+	// Kind is "reply" or "push".
+	Kind       string            `json:"kind"`
+	ReplyToken string            `json:"replyToken,omitempty"`
+	To         string            `json:"to,omitempty"`
+	RetryKey   string            `json:"retryKey,omitempty"`
+	Messages   []json.RawMessage `json:"messages"`
+}
+
+// Sent lists the messages replied or pushed so far.
+func (s *Server) Sent() []Sent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Sent(nil), s.sent...)
+}
+
+// ExpireReplyToken makes later replies with token fail, as LINE does once
+// a token was used or is older than its validity window.
+func (s *Server) ExpireReplyToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replyTokens[token] = true
+}
+
+// FailPushes makes the next pushes answer with statuses, one each, without
+// sending anything, e.g. 429 or 500 to exercise retries.
+func (s *Server) FailPushes(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+func replyMessage(s *Server, w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var req Sent
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReplyToken == "" || len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "The request body has 1 error(s)")
+		return
+	}
+	s.mu.Lock()
+	if s.replyTokens[req.ReplyToken] {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "Invalid reply token")
+		return
+	}
+	// 返信トークンは 1 回しか使えない
+	s.replyTokens[req.ReplyToken] = true
+	s.mu.Unlock()
+
+	req.Kind = "reply"
+	s.accept(w, req)
+}
+
+func pushMessage(s *Server, w http.ResponseWriter, r *http.Request, params map[string]string) {
+	var req Sent
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" || len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "The request body has 1 error(s)")
+		return
+	}
+	req.Kind = "push"
+	req.RetryKey = r.Header.Get("X-Line-Retry-Key")
+	if req.RetryKey != "" && !retryKeyPattern.MatchString(req.RetryKey) {
+		writeError(w, http.StatusBadRequest, "The value for the 'X-Line-Retry-Key' header is invalid")
+		return
+	}
+
+	s.mu.Lock()
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		s.mu.Unlock()
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+	// 受付済みの再試行キーは 409 で最初の送信結果を返す
+	if sent, ok := s.retryKeys[req.RetryKey]; ok {
+		s.mu.Unlock()
+		w.Header().Set("X-Line-Accepted-Request-Id", "accepted-"+req.RetryKey)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": "The retry key is already accepted", "sentMessages": sent})
+		return
+	}
+	s.mu.Unlock()
+	s.accept(w, req)
+}
+
+var retryKeyPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// accept records req and answers with sent message IDs.
+func (s *Server) accept(w http.ResponseWriter, req Sent) {
+	s.mu.Lock()
+	s.sent = append(s.sent, req)
+	var sentMessages []map[string]string
+	for range req.Messages {
+		s.sentSeq++
+		id := strconv.Itoa(500000000000000000 + s.sentSeq)
+		sentMessages = append(sentMessages, map[string]string{"id": id, "quoteToken": "q" + id})
+	}
+	if req.RetryKey != "" {
+		s.retryKeys[req.RetryKey] = sentMessages
+	}
+	s.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"sentMessages": sentMessages})
+}
@@ -0,0 +1,207 @@
+// Package linetest provides an in-process fake of the LINE Messaging API for
+// tests and local development. Like upstashtest it implements just the
+// endpoints this project calls, backed by in-memory data.
+package linetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// Token is the channel access token the fake accepts.
+const Token = "test-channel-token"
+
+// Member is a member of a group or room known to the fake.
+type Member struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName"`
+	PictureURL  string `json:"pictureUrl,omitempty"`
+}
+
+// Server is a fake LINE Messaging API endpoint.
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the number of member IDs per page (LINE returns up to 100).
+	PageSize int
+	// DenyMemberIDs makes the member ID list answer 403, as LINE does for
+	// accounts that are not verified or premium.
+	DenyMemberIDs bool
+
+	mu          sync.Mutex
+	members     map[string]map[string]Member
+	requests    []string
+	sent        []Sent
+	replyTokens map[string]bool
+	retryKeys   map[string][]map[string]string
+	failures    []int
+	sentSeq     int
+}
+
+// NewServer starts a fake LINE API server. Close it when done.
+func NewServer() *Server {
+	s := New()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// New returns a fake without starting a listener, for serving it on a
+// fixed address (see cmd/fakeline).
+func New() *Server {
+	return &Server{PageSize: 100, members: make(map[string]map[string]Member), replyTokens: make(map[string]bool), retryKeys: make(map[string][]map[string]string)}
+}
+
+// Client returns a MessagingApiAPI pointed at this server.
+func (s *Server) Client() *messaging_api.MessagingApiAPI {
+	bot, err := messaging_api.NewMessagingApiAPI(Token, messaging_api.WithEndpoint(s.URL))
+	if err != nil {
+		panic(err)
+	}
+	return bot
+}
+
+// AddMember registers a member of the group or room groupID.
+func (s *Server) AddMember(groupID string, m Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members[groupID] == nil {
+		s.members[groupID] = make(map[string]Member)
+	}
+	s.members[groupID][m.UserID] = m
+}
+
+// RemoveMember removes userID from groupID.
+func (s *Server) RemoveMember(groupID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[groupID], userID)
+}
+
+// Requests lists the requests served so far as "METHOD /path".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+type route struct {
+	method  string
+	pattern []string
+	handle  func(s *Server, w http.ResponseWriter, r *http.Request, params map[string]string)
+}
+
+var routes []route
+
+func init() {
+	routes = []route{
+		{"GET", split("/v2/bot/group/{groupId}/member/{userId}"), getGroupMemberProfile},
+		{"GET", split("/v2/bot/group/{groupId}/members/ids"), getGroupMembersIds},
+		{"GET", split("/v2/bot/room/{groupId}/member/{userId}"), getGroupMemberProfile},
+		{"GET", split("/v2/bot/room/{groupId}/members/ids"), getGroupMembersIds},
+		{"GET", split("/v2/bot/profile/{userId}"), getProfile},
+		{"POST", split("/v2/bot/message/reply"), replyMessage},
+		{"POST", split("/v2/bot/message/push"), pushMessage},
+	}
+}
+
+func split(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// match reports whether path fits pattern, collecting {name} segments.
+func match(pattern, path []string) (map[string]string, bool) {
+	if len(pattern) != len(path) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, seg := range pattern {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params[seg[1:len(seg)-1]] = path[i]
+		} else if seg != path[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+Token {
+		writeError(w, http.StatusUnauthorized, "Authentication failed")
+		return
+	}
+
+	path := split(r.URL.Path)
+	for _, rt := range routes {
+		if params, ok := match(rt.pattern, path); ok && rt.method == r.Method {
+			rt.handle(s, w, r, params)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Not found")
+}
+
+// writeError responds in the LINE API error format.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func getGroupMemberProfile(s *Server, w http.ResponseWriter, r *http.Request, params map[string]string) {
+	s.mu.Lock()
+	m, ok := s.members[params["groupId"]][params["userId"]]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	json.NewEncoder(w).Encode(m)
+}
+
+func getGroupMembersIds(s *Server, w http.ResponseWriter, r *http.Request, params map[string]string) {
+	if s.DenyMemberIDs {
+		writeError(w, http.StatusForbidden, "Access to this API is not available for your account")
+		return
+	}
+
+	s.mu.Lock()
+	var ids []string
+	for id := range s.members[params["groupId"]] {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+	sort.Strings(ids)
+
+	// start は次ページの先頭位置（本物は不透明なトークン）
+	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+	end := min(start+s.PageSize, len(ids))
+	res := map[string]interface{}{"memberIds": ids[min(start, end):end]}
+	if end < len(ids) {
+		res["next"] = strconv.Itoa(end)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// getProfile finds the user in any group or room; 1:1 chats need no group.
+func getProfile(s *Server, w http.ResponseWriter, r *http.Request, params map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, members := range s.members {
+		if m, ok := members[params["userId"]]; ok {
+			json.NewEncoder(w).Encode(m)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Not found")
+}
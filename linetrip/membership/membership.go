@@ -0,0 +1,66 @@
+// Package membership seeds the group membership registry from the LINE
+// member ID list. Day-to-day updates come from join and leave webhook events
+// (see package ingest).
+package membership
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Source lists the members of groups and rooms.
+// *messaging_api.MessagingApiAPI implements it.
+type Source interface {
+	GetGroupMembersIds(groupId, start string) (*messaging_api.MembersIdsResponse, error)
+	GetRoomMembersIds(roomId, start string) (*messaging_api.MembersIdsResponse, error)
+}
+
+// Seed records every current member of the group or room groupID and returns how many were
+// listed. Once the full list has been read, registered users missing from it
+// (e.g. members who left while events were missed) are removed. LINE only
+// allows this for verified and premium accounts; other accounts get an error
+// and rely on webhook events alone.
+func Seed(ctx context.Context, s store.MessageStore, src Source, groupID string) (int, error) {
+	listed := map[string]bool{}
+	start := ""
+	for {
+		var res *messaging_api.MembersIdsResponse
+		var err error
+		switch store.ConversationTypeOf(groupID) {
+		case store.ConversationGroup:
+			res, err = src.GetGroupMembersIds(groupID, start)
+		case store.ConversationRoom:
+			res, err = src.GetRoomMembersIds(groupID, start)
+		default:
+			return 0, fmt.Errorf("cannot list members of %s", groupID)
+		}
+		if err != nil {
+			return len(listed), fmt.Errorf("list members of %s: %w", groupID, err)
+		}
+		if err := s.AddMembers(ctx, groupID, res.MemberIds...); err != nil {
+			return len(listed), err
+		}
+		for _, id := range res.MemberIds {
+			listed[id] = true
+		}
+		if res.Next == "" {
+			break
+		}
+		start = res.Next
+	}
+
+	known, err := s.Members(ctx, groupID)
+	if err != nil {
+		return len(listed), err
+	}
+	var gone []string
+	for _, id := range known {
+		if !listed[id] {
+			gone = append(gone, id)
+		}
+	}
+	return len(listed), s.RemoveMembers(ctx, groupID, gone...)
+}
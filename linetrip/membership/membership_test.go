@@ -0,0 +1,61 @@
+package membership
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestSeed(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	line.PageSize = 2
+	for i := 1; i <= 5; i++ {
+		line.AddMember("C1", linetest.Member{UserID: fmt.Sprintf("U%d", i)})
+	}
+
+	s := store.NewMemory()
+	ctx := context.Background()
+	// 抜けたのにイベントを取りこぼしたメンバー
+	s.AddMembers(ctx, "C1", "Ugone")
+
+	n, err := Seed(ctx, s, line.Client(), "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("seeded %d members, want 5", n)
+	}
+	members, _ := s.Members(ctx, "C1")
+	if want := []string{"U1", "U2", "U3", "U4", "U5"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("members = %v, want %v", members, want)
+	}
+	if groups, _ := s.UserGroups(ctx, "U3"); !reflect.DeepEqual(groups, []string{"C1"}) {
+		t.Errorf("user groups of U3 = %v", groups)
+	}
+	if groups, _ := s.UserGroups(ctx, "Ugone"); len(groups) != 0 {
+		t.Errorf("user groups of Ugone = %v", groups)
+	}
+}
+
+func TestSeedNotPermitted(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	line.DenyMemberIDs = true
+
+	s := store.NewMemory()
+	ctx := context.Background()
+	s.AddMembers(ctx, "C1", "U1")
+
+	if _, err := Seed(ctx, s, line.Client(), "C1"); err == nil {
+		t.Fatal("expected error")
+	}
+	// 取得できなかった場合は既存の登録を消さない
+	if members, _ := s.Members(ctx, "C1"); !reflect.DeepEqual(members, []string{"U1"}) {
+		t.Errorf("members = %v", members)
+	}
+}
@@ -0,0 +1,254 @@
+package outbound
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SendHandler serves /api/send: it validates a Request and sends its
+// messages to the conversation in one call, as a reply when someone there
+// just wrote and as a push otherwise. With send_at the messages are added to
+// schedule instead and pushed by a Dispatcher at that time.
+//
+//	POST {"to": "C...", "messages": [{"type": "location", ...}]}
+//	POST {"to": "C...", "messages": [...], "send_at": "2025-05-03T08:00:00+09:00"}
+func SendHandler(sender *Sender, schedule Schedule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		req.Normalize()
+		messages, err := req.Build()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.SendAt != nil {
+			scheduleSend(w, r, schedule, req)
+			return
+		}
+		if sender == nil {
+			writeError(w, http.StatusServiceUnavailable, "LINE_CHANNEL_TOKEN not configured")
+			return
+		}
+
+		delivery, err := sender.Send(r.Context(), req.To, req.RetryKey, messages)
+		if err != nil {
+			log.Printf("❌ Send message error: %v", err)
+			// 同じ retry_key で送り直せば二重に投稿されない
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":     "Failed to send message",
+				"retry_key": delivery.RetryKey,
+				"retryable": Retryable(err),
+			})
+			return
+		}
+		if delivery.AlreadyAccepted {
+			log.Printf("📤 Send %s to %s was already accepted", delivery.RetryKey, req.To)
+		} else {
+			log.Printf("📤 Sent %d messages to %s by %s", len(messages), req.To, delivery.Method)
+		}
+		sender.Record(r.Context(), req.To, req.SentBy, req.Messages, delivery)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":           "success",
+			"to":               req.To,
+			"method":           delivery.Method,
+			"sent_messages":    delivery.SentMessages,
+			"retry_key":        delivery.RetryKey,
+			"already_accepted": delivery.AlreadyAccepted,
+		})
+	}
+}
+
+// scheduleSend adds a validated request with send_at to schedule. The job
+// is identified by the request's retry_key when given, so resubmitting it
+// schedules nothing twice.
+func scheduleSend(w http.ResponseWriter, r *http.Request, schedule Schedule, req Request) {
+	if schedule == nil {
+		writeError(w, http.StatusServiceUnavailable, "scheduled sends are not configured")
+		return
+	}
+	now := time.Now()
+	if err := CheckSendAt(*req.SendAt, now); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := req.RetryKey
+	if id == "" {
+		id = NewRetryKey()
+	}
+	job := Job{
+		ID:        id,
+		To:        req.To,
+		Messages:  req.Messages,
+		SendAt:    *req.SendAt,
+		Status:    StatusPending,
+		SentBy:    req.SentBy,
+		CreatedAt: now.UnixMilli(),
+		UpdatedAt: now.UnixMilli(),
+	}
+	saved, added, err := schedule.Add(r.Context(), job)
+	if err != nil {
+		log.Printf("❌ Schedule message error: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to schedule message")
+		return
+	}
+	if added {
+		log.Printf("📅 Scheduled %d messages to %s at %s", len(job.Messages), job.To, job.SendAt.Format(time.RFC3339))
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            "scheduled",
+		"scheduled":         saved,
+		"retry_key":         saved.ID,
+		"already_scheduled": !added,
+	})
+}
+
+// JobRequest is the body of a scheduled message PATCH. Unset fields are
+// left unchanged.
+type JobRequest struct {
+	To       *string    `json:"to"`
+	Messages []Message  `json:"messages"`
+	SendAt   *time.Time `json:"send_at"`
+}
+
+// ScheduledHandler serves /api/scheduled, the messages waiting for their
+// send_at and those that failed to go out. Only pending messages that are
+// not being sent can be edited.
+//
+//	GET                 list scheduled messages
+//	GET    ?id=...      one scheduled message
+//	PATCH  ?id=...      {to?, messages?, send_at?}
+//	DELETE ?id=...      cancel a pending message or drop a failed one
+func ScheduledHandler(schedule Schedule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if schedule == nil {
+			writeError(w, http.StatusServiceUnavailable, "scheduled sends are not configured")
+			return
+		}
+
+		ctx := r.Context()
+		id := r.URL.Query().Get("id")
+		if id == "" && r.Method != http.MethodGet {
+			writeError(w, http.StatusBadRequest, "id is required")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if id != "" {
+				job, err := schedule.Job(ctx, id)
+				if err != nil {
+					jobError(w, "load scheduled message", err)
+					return
+				}
+				json.NewEncoder(w).Encode(job)
+				return
+			}
+			jobs, err := schedule.Jobs(ctx)
+			if err != nil {
+				jobError(w, "list scheduled messages", err)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"scheduled": jobs,
+				"count":     len(jobs),
+			})
+
+		case http.MethodPatch:
+			job, err := schedule.Job(ctx, id)
+			if err != nil {
+				jobError(w, "load scheduled message", err)
+				return
+			}
+			if job.Status != StatusPending {
+				writeError(w, http.StatusConflict, "only pending messages can be edited")
+				return
+			}
+			var req JobRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+			now := time.Now()
+			if req.To != nil {
+				job.To = *req.To
+			}
+			if req.Messages != nil {
+				job.Messages = req.Messages
+			}
+			if req.SendAt != nil {
+				if err := CheckSendAt(*req.SendAt, now); err != nil {
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				job.SendAt = *req.SendAt
+			}
+			if _, err := (Request{To: job.To, Messages: job.Messages}).Build(); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			job.UpdatedAt = now.UnixMilli()
+			if err := schedule.Update(ctx, job); err != nil {
+				jobError(w, "update scheduled message", err)
+				return
+			}
+			json.NewEncoder(w).Encode(job)
+
+		case http.MethodDelete:
+			if err := schedule.Cancel(ctx, id); err != nil {
+				jobError(w, "cancel scheduled message", err)
+				return
+			}
+			log.Printf("📅 Cancelled scheduled message %s", id)
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "cancelled", "id": id})
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func jobError(w http.ResponseWriter, what string, err error) {
+	switch {
+	case errors.Is(err, ErrNoScheduled):
+		writeError(w, http.StatusNotFound, "Scheduled message not found")
+	case errors.Is(err, ErrNotPending):
+		writeError(w, http.StatusConflict, "Scheduled message is being sent")
+	default:
+		log.Printf("Error: %s: %v", what, err)
+		writeError(w, http.StatusInternalServerError, "Failed to "+what)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
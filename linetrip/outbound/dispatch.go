@@ -0,0 +1,152 @@
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Dispatcher defaults.
+const (
+	DefaultDispatchAttempts = 5
+	DefaultRetryDelay       = time.Minute
+	DefaultDispatchBatch    = 20
+	// DefaultDispatchLease bounds how long a job may be sending before
+	// another dispatcher takes it again.
+	DefaultDispatchLease = 2 * time.Minute
+)
+
+// Dispatcher sends the scheduled messages that are due. It runs from the
+// /api/dispatch cron on Vercel and on a ticker in webhook-server.
+type Dispatcher struct {
+	Schedule Schedule
+	Sender   *Sender
+	// MaxAttempts is how many dispatches a job gets before it is marked
+	// failed. Each dispatch already retries the push Sender.MaxAttempts
+	// times.
+	MaxAttempts int
+	// RetryDelay is the delay before the second dispatch; it doubles each
+	// time.
+	RetryDelay time.Duration
+	// Batch is how many jobs are taken at once.
+	Batch int
+	// Lease is how long a taken job is hidden from other dispatchers.
+	Lease time.Duration
+}
+
+// NewDispatcher returns a dispatcher sending the jobs of schedule with
+// sender.
+func NewDispatcher(schedule Schedule, sender *Sender) *Dispatcher {
+	return &Dispatcher{
+		Schedule:    schedule,
+		Sender:      sender,
+		MaxAttempts: DefaultDispatchAttempts,
+		RetryDelay:  DefaultRetryDelay,
+		Batch:       DefaultDispatchBatch,
+		Lease:       DefaultDispatchLease,
+	}
+}
+
+// DispatchStats summarises a Dispatch.
+type DispatchStats struct {
+	Sent     int `json:"sent"`
+	Retrying int `json:"retrying"`
+	Failed   int `json:"failed"`
+}
+
+// Dispatch sends every job due at now, until none is left or ctx is done.
+// Jobs are pushed with their ID as retry key, so a job whose dispatcher died
+// after LINE accepted it is not posted again.
+func (d *Dispatcher) Dispatch(ctx context.Context, now time.Time) (DispatchStats, error) {
+	var stats DispatchStats
+	for ctx.Err() == nil {
+		jobs, err := d.Schedule.Take(ctx, now, d.Batch, d.Lease)
+		if err != nil {
+			return stats, fmt.Errorf("take scheduled messages: %w", err)
+		}
+		for _, job := range jobs {
+			if ctx.Err() != nil {
+				// 送れなかった分はリース切れ後に再び取り出される
+				break
+			}
+			switch d.send(ctx, job, now) {
+			case StatusPending:
+				stats.Retrying++
+			case StatusFailed:
+				stats.Failed++
+			default:
+				stats.Sent++
+			}
+		}
+		if len(jobs) < d.Batch {
+			break
+		}
+	}
+	return stats, nil
+}
+
+// send delivers one job and stores the outcome. It returns the job's new
+// status, or "" once it is sent.
+func (d *Dispatcher) send(ctx context.Context, job Job, now time.Time) string {
+	req := Request{To: job.To, Messages: job.Messages}
+	messages, err := req.Build()
+	retryable := false
+	if err == nil {
+		var delivery Delivery
+		delivery, err = d.Sender.Send(ctx, job.To, job.ID, messages)
+		if err == nil {
+			log.Printf("📅 Sent scheduled message %s to %s (%d messages)", job.ID, job.To, len(delivery.SentMessages))
+			d.Sender.Record(ctx, job.To, job.SentBy, job.Messages, delivery)
+			if err := d.Schedule.Finish(context.WithoutCancel(ctx), job.ID); err != nil {
+				log.Printf("⚠️ Could not finish scheduled message %s: %v", job.ID, err)
+			}
+			return ""
+		}
+		if ctx.Err() != nil {
+			// 打ち切られた送信は試行回数に数えず、次の dispatch で同じキーで送り直す
+			log.Printf("⚠️ Scheduled message %s interrupted: %v", job.ID, err)
+			if err := d.Schedule.Retry(context.WithoutCancel(ctx), job); err != nil {
+				log.Printf("❌ Could not store scheduled message %s: %v", job.ID, err)
+			}
+			return StatusPending
+		}
+		retryable = Retryable(err)
+	}
+
+	job.Attempts++
+	job.Error = err.Error()
+	job.UpdatedAt = now.UnixMilli()
+	if retryable && job.Attempts < d.MaxAttempts {
+		delay := d.RetryDelay << (job.Attempts - 1)
+		job.SendAt = now.Add(delay)
+		log.Printf("🔁 Scheduled message %s failed (attempt %d/%d), retrying in %v: %v", job.ID, job.Attempts, d.MaxAttempts, delay, err)
+	} else {
+		job.Status = StatusFailed
+		log.Printf("❌ Giving up on scheduled message %s after %d attempts: %v", job.ID, job.Attempts, err)
+	}
+	// ctx が切れていても結果は残す
+	if err := d.Schedule.Retry(context.WithoutCancel(ctx), job); err != nil {
+		log.Printf("❌ Could not store scheduled message %s: %v", job.ID, err)
+	}
+	return job.Status
+}
+
+// Run dispatches due jobs every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats, err := d.Dispatch(ctx, time.Now())
+		if err != nil {
+			log.Printf("❌ Dispatcher error: %v", err)
+		} else if stats.Sent+stats.Retrying+stats.Failed > 0 {
+			log.Printf("📅 Dispatched %d scheduled messages (%d retrying, %d failed)", stats.Sent, stats.Retrying, stats.Failed)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
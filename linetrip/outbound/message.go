@@ -0,0 +1,355 @@
+// Package outbound builds and checks the messages the iOS app sends to a
+// LINE conversation through /api/send. Requests use a typed schema so each
+// message is validated against LINE's limits before anything is pushed:
+// LINE rejects the whole push when one message is malformed.
+package outbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/takuto277/line-trip-list-api/linetrip/flex"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Message types.
+const (
+	TypeText     = "text"
+	TypeImage    = "image"
+	TypeSticker  = "sticker"
+	TypeLocation = "location"
+	TypeFlex     = "flex"
+	TypeTemplate = "template"
+)
+
+// Limits of the Messaging API.
+const (
+	// MaxMessages is the number of messages one push or reply may carry.
+	MaxMessages = 5
+	// MaxText is the longest text message, in characters.
+	MaxText = 5000
+	// MaxLocationText bounds a location's title and address.
+	MaxLocationText = 100
+	// MaxTemplateAltText is the longest notification text of a template.
+	MaxTemplateAltText = 400
+	// MaxQuickReplies is the number of quick reply buttons.
+	MaxQuickReplies = 13
+	// MaxLabel is the longest quick reply or template action label.
+	MaxLabel = 20
+	// MaxData is the longest postback data.
+	MaxData = 300
+)
+
+// Message is one message of a send request. Which fields apply depends on
+// Type:
+//
+//	text      text
+//	image     original_content_url, preview_image_url (HTTPS)
+//	sticker   package_id, sticker_id
+//	location  title, address, latitude, longitude
+//	flex      alt_text, contents (a LINE Flex bubble or carousel as is)
+//	template  alt_text, template (a LINE template object as is)
+//
+// Any message may carry quick reply buttons; LINE shows those of the last
+// message only.
+type Message struct {
+	Type               string           `json:"type"`
+	Text               string           `json:"text,omitempty"`
+	OriginalContentURL string           `json:"original_content_url,omitempty"`
+	PreviewImageURL    string           `json:"preview_image_url,omitempty"`
+	PackageID          string           `json:"package_id,omitempty"`
+	StickerID          string           `json:"sticker_id,omitempty"`
+	Title              string           `json:"title,omitempty"`
+	Address            string           `json:"address,omitempty"`
+	Latitude           float64          `json:"latitude,omitempty"`
+	Longitude          float64          `json:"longitude,omitempty"`
+	AltText            string           `json:"alt_text,omitempty"`
+	Contents           json.RawMessage  `json:"contents,omitempty"`
+	Template           json.RawMessage  `json:"template,omitempty"`
+	QuickReply         []QuickReplyItem `json:"quick_reply,omitempty"`
+}
+
+// Quick reply action types.
+const (
+	ActionMessage    = "message"
+	ActionPostback   = "postback"
+	ActionURI        = "uri"
+	ActionLocation   = "location"
+	ActionCamera     = "camera"
+	ActionCameraRoll = "camera_roll"
+	ActionDatetime   = "datetime"
+)
+
+// QuickReplyItem is a quick reply button:
+//
+//	message      label, text  sends text as the user
+//	postback     label, data, display_text?
+//	uri          label, uri
+//	location     label        opens the location picker
+//	camera       label
+//	camera_roll  label
+//	datetime     label, data, mode (date, time or datetime)
+type QuickReplyItem struct {
+	Type        string `json:"type"`
+	Label       string `json:"label"`
+	Text        string `json:"text,omitempty"`
+	Data        string `json:"data,omitempty"`
+	DisplayText string `json:"display_text,omitempty"`
+	URI         string `json:"uri,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// Request is the body of /api/send. To may be a group, room or user ID;
+// group_id and a lone text message are still accepted for older clients.
+// RetryKey is left empty for a new send and set to the retry_key of an
+// earlier response to resubmit it. SendAt (RFC 3339) schedules the send
+// instead of sending now. SentBy is the LINE user ID of the app user, shown
+// on the sent messages in /api/messages.
+type Request struct {
+	To       string     `json:"to"`
+	GroupID  string     `json:"group_id,omitempty"`
+	Message  string     `json:"message,omitempty"`
+	Messages []Message  `json:"messages,omitempty"`
+	RetryKey string     `json:"retry_key,omitempty"`
+	SendAt   *time.Time `json:"send_at,omitempty"`
+	SentBy   string     `json:"sent_by,omitempty"`
+}
+
+// Normalize folds the fields of older clients into To and Messages.
+func (r *Request) Normalize() {
+	if r.To == "" {
+		r.To = r.GroupID
+	}
+	if len(r.Messages) == 0 && r.Message != "" {
+		r.Messages = []Message{{Type: TypeText, Text: r.Message}}
+	}
+	r.GroupID, r.Message = "", ""
+}
+
+// Validate checks a normalized request.
+func (r Request) Validate() error {
+	if r.To == "" {
+		return fmt.Errorf("to (or group_id) is required")
+	}
+	if store.ConversationTypeOf(r.To) == "" {
+		return fmt.Errorf("to must be a group, room or user ID")
+	}
+	if r.RetryKey != "" && !ValidRetryKey(r.RetryKey) {
+		return fmt.Errorf("retry_key must be a UUID")
+	}
+	if r.SentBy != "" && store.ConversationTypeOf(r.SentBy) != store.ConversationUser {
+		return fmt.Errorf("sent_by must be a user ID")
+	}
+	if len(r.Messages) == 0 {
+		return fmt.Errorf("messages (or message) is required")
+	}
+	if len(r.Messages) > MaxMessages {
+		return fmt.Errorf("%d messages, at most %d can be sent at once", len(r.Messages), MaxMessages)
+	}
+	for i, m := range r.Messages {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("messages[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Build validates the request and converts its messages for the SDK.
+func (r Request) Build() ([]messaging_api.MessageInterface, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	out := make([]messaging_api.MessageInterface, 0, len(r.Messages))
+	for i, m := range r.Messages {
+		message, err := m.Build()
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		out = append(out, message)
+	}
+	return out, nil
+}
+
+func tooLong(field, s string, max int) error {
+	if n := utf8.RuneCountInString(s); n > max {
+		return fmt.Errorf("%s is %d characters, over %d", field, n, max)
+	}
+	return nil
+}
+
+// Validate checks a message against LINE's limits.
+func (m Message) Validate() error {
+	switch m.Type {
+	case TypeText:
+		if m.Text == "" {
+			return fmt.Errorf("text is required")
+		}
+		if err := tooLong("text", m.Text, MaxText); err != nil {
+			return err
+		}
+	case TypeImage:
+		for field, u := range map[string]string{"original_content_url": m.OriginalContentURL, "preview_image_url": m.PreviewImageURL} {
+			if u == "" {
+				return fmt.Errorf("%s is required", field)
+			}
+			if err := flex.CheckURL(u); err != nil {
+				return fmt.Errorf("%s: %v", field, err)
+			}
+		}
+	case TypeSticker:
+		if m.PackageID == "" || m.StickerID == "" {
+			return fmt.Errorf("package_id and sticker_id are required")
+		}
+	case TypeLocation:
+		if m.Title == "" || m.Address == "" {
+			return fmt.Errorf("title and address are required")
+		}
+		if err := tooLong("title", m.Title, MaxLocationText); err != nil {
+			return err
+		}
+		if err := tooLong("address", m.Address, MaxLocationText); err != nil {
+			return err
+		}
+		if m.Latitude < -90 || m.Latitude > 90 || m.Longitude < -180 || m.Longitude > 180 {
+			return fmt.Errorf("latitude or longitude out of range")
+		}
+	case TypeFlex:
+		if err := flex.Check(m.AltText, m.Contents); err != nil {
+			return err
+		}
+	case TypeTemplate:
+		if m.AltText == "" {
+			return fmt.Errorf("alt_text is required")
+		}
+		if err := tooLong("alt_text", m.AltText, MaxTemplateAltText); err != nil {
+			return err
+		}
+		if err := checkTemplate(m.Template); err != nil {
+			return err
+		}
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		return fmt.Errorf("unknown type %q", m.Type)
+	}
+
+	if len(m.QuickReply) > MaxQuickReplies {
+		return fmt.Errorf("%d quick replies, over %d", len(m.QuickReply), MaxQuickReplies)
+	}
+	for i, item := range m.QuickReply {
+		if err := item.Validate(); err != nil {
+			return fmt.Errorf("quick_reply[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a quick reply button.
+func (q QuickReplyItem) Validate() error {
+	if q.Label == "" {
+		return fmt.Errorf("label is required")
+	}
+	if err := tooLong("label", q.Label, MaxLabel); err != nil {
+		return err
+	}
+	if q.ImageURL != "" {
+		if err := flex.CheckURL(q.ImageURL); err != nil {
+			return fmt.Errorf("image_url: %v", err)
+		}
+	}
+	switch q.Type {
+	case ActionMessage:
+		if q.Text == "" {
+			return fmt.Errorf("text is required")
+		}
+		return tooLong("text", q.Text, 300)
+	case ActionPostback:
+		if q.Data == "" {
+			return fmt.Errorf("data is required")
+		}
+		return tooLong("data", q.Data, MaxData)
+	case ActionURI:
+		if q.URI == "" {
+			return fmt.Errorf("uri is required")
+		}
+		return tooLong("uri", q.URI, flex.MaxURL)
+	case ActionDatetime:
+		if q.Data == "" {
+			return fmt.Errorf("data is required")
+		}
+		switch q.Mode {
+		case "date", "time", "datetime":
+			return tooLong("data", q.Data, MaxData)
+		default:
+			return fmt.Errorf("mode must be date, time or datetime")
+		}
+	case ActionLocation, ActionCamera, ActionCameraRoll:
+		return nil
+	default:
+		return fmt.Errorf("unknown action type %q", q.Type)
+	}
+}
+
+// Build converts a valid message for the SDK.
+func (m Message) Build() (messaging_api.MessageInterface, error) {
+	quickReply := m.quickReply()
+	switch m.Type {
+	case TypeText:
+		return &messaging_api.TextMessage{Text: m.Text, QuickReply: quickReply}, nil
+	case TypeImage:
+		return &messaging_api.ImageMessage{OriginalContentUrl: m.OriginalContentURL, PreviewImageUrl: m.PreviewImageURL, QuickReply: quickReply}, nil
+	case TypeSticker:
+		return &messaging_api.StickerMessage{PackageId: m.PackageID, StickerId: m.StickerID, QuickReply: quickReply}, nil
+	case TypeLocation:
+		return &messaging_api.LocationMessage{Title: m.Title, Address: m.Address, Latitude: m.Latitude, Longitude: m.Longitude, QuickReply: quickReply}, nil
+	case TypeFlex:
+		message, err := flex.Message(m.AltText, m.Contents)
+		if err != nil {
+			return nil, err
+		}
+		message.QuickReply = quickReply
+		return message, nil
+	case TypeTemplate:
+		template, err := messaging_api.UnmarshalTemplate(m.Template)
+		if err != nil {
+			return nil, err
+		}
+		return &messaging_api.TemplateMessage{AltText: m.AltText, Template: template, QuickReply: quickReply}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", m.Type)
+	}
+}
+
+func (m Message) quickReply() *messaging_api.QuickReply {
+	if len(m.QuickReply) == 0 {
+		return nil
+	}
+	items := make([]messaging_api.QuickReplyItem, 0, len(m.QuickReply))
+	for _, q := range m.QuickReply {
+		items = append(items, messaging_api.QuickReplyItem{Type: "action", ImageUrl: q.ImageURL, Action: q.action()})
+	}
+	return &messaging_api.QuickReply{Items: items}
+}
+
+func (q QuickReplyItem) action() messaging_api.ActionInterface {
+	switch q.Type {
+	case ActionMessage:
+		return &messaging_api.MessageAction{Label: q.Label, Text: q.Text}
+	case ActionPostback:
+		return &messaging_api.PostbackAction{Label: q.Label, Data: q.Data, DisplayText: q.DisplayText}
+	case ActionURI:
+		return &messaging_api.UriAction{Label: q.Label, Uri: q.URI}
+	case ActionLocation:
+		return &messaging_api.LocationAction{Label: q.Label}
+	case ActionCamera:
+		return &messaging_api.CameraAction{Label: q.Label}
+	case ActionCameraRoll:
+		return &messaging_api.CameraRollAction{Label: q.Label}
+	default:
+		return &messaging_api.DatetimePickerAction{Label: q.Label, Data: q.Data, Mode: messaging_api.DatetimePickerActionMODE(q.Mode)}
+	}
+}
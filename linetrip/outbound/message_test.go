@@ -0,0 +1,123 @@
+package outbound
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+)
+
+func TestValidate(t *testing.T) {
+	long := strings.Repeat("あ", 5001)
+	tests := []struct {
+		name    string
+		message string
+		wantErr string
+	}{
+		{"text", `{"type":"text","text":"こんにちは"}`, ""},
+		{"empty text", `{"type":"text"}`, "text is required"},
+		{"long text", `{"type":"text","text":"` + long + `"}`, "over 5000"},
+		{"image", `{"type":"image","original_content_url":"https://example.com/a.jpg","preview_image_url":"https://example.com/a_s.jpg"}`, ""},
+		{"http image", `{"type":"image","original_content_url":"http://example.com/a.jpg","preview_image_url":"https://example.com/a_s.jpg"}`, "must be https"},
+		{"sticker", `{"type":"sticker","package_id":"446","sticker_id":"1988"}`, ""},
+		{"location", `{"type":"location","title":"清水寺","address":"京都府京都市東山区清水1-294","latitude":34.9949,"longitude":135.785}`, ""},
+		{"bad latitude", `{"type":"location","title":"清水寺","address":"京都","latitude":134.9,"longitude":135.785}`, "out of range"},
+		{"flex", `{"type":"flex","alt_text":"清水寺","contents":{"type":"bubble","body":{"type":"box","layout":"vertical","contents":[{"type":"text","text":"清水寺"}]}}}`, ""},
+		{"flex without alt text", `{"type":"flex","contents":{"type":"bubble"}}`, "altText is required"},
+		{"buttons", `{"type":"template","alt_text":"行き先","template":{"type":"buttons","text":"どこに行く？","actions":[{"type":"message","label":"清水寺","text":"清水寺"}]}}`, ""},
+		{"buttons without actions", `{"type":"template","alt_text":"行き先","template":{"type":"buttons","text":"どこに行く？","actions":[]}}`, "1 to 4 actions"},
+		{"confirm", `{"type":"template","alt_text":"確認","template":{"type":"confirm","text":"行く？","actions":[{"type":"message","label":"はい","text":"はい"},{"type":"message","label":"いいえ","text":"いいえ"}]}}`, ""},
+		{"confirm with one action", `{"type":"template","alt_text":"確認","template":{"type":"confirm","text":"行く？","actions":[{"type":"message","label":"はい","text":"はい"}]}}`, "needs 2 actions"},
+		{"unknown template", `{"type":"template","alt_text":"x","template":{"type":"list"}}`, "template type must be"},
+		{"quick reply", `{"type":"text","text":"いつ？","quick_reply":[{"type":"datetime","label":"日付を選ぶ","data":"date","mode":"date"},{"type":"location","label":"現在地"}]}`, ""},
+		{"quick reply label", `{"type":"text","text":"いつ？","quick_reply":[{"type":"message","label":"` + strings.Repeat("あ", 21) + `","text":"x"}]}`, "quick_reply[0]: label is 21 characters"},
+		{"quick reply mode", `{"type":"text","text":"いつ？","quick_reply":[{"type":"datetime","label":"日付","data":"d","mode":"week"}]}`, "mode must be"},
+		{"unknown type", `{"type":"video"}`, "unknown type"},
+	}
+	for _, tt := range tests {
+		var m Message
+		if err := json.Unmarshal([]byte(tt.message), &m); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err := m.Validate()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+		if err == nil {
+			if _, err := m.Build(); err != nil {
+				t.Errorf("%s: build: %v", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	req := Request{GroupID: "C1", Message: "こんにちは"}
+	req.Normalize()
+	if req.To != "C1" || len(req.Messages) != 1 || req.Messages[0].Text != "こんにちは" {
+		t.Errorf("normalized = %+v", req)
+	}
+
+	for _, bad := range []Request{
+		{To: "X1", Messages: req.Messages},
+		{To: "C1"},
+		{To: "C1", Messages: make([]Message, 6)},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestSendHandler(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	send := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		SendHandler(NewSender(line.Client(), nil), nil).ServeHTTP(w, httptest.NewRequest("POST", "/api/send", strings.NewReader(body)))
+		return w
+	}
+
+	// 場所カード: 地図とボタン付きのメッセージ
+	w := send(`{"to":"C1","messages":[
+		{"type":"location","title":"清水寺","address":"京都府京都市東山区清水1-294","latitude":34.9949,"longitude":135.785},
+		{"type":"text","text":"ここに行きたい","quick_reply":[{"type":"postback","label":"行きたい","data":"vote=1","display_text":"行きたい！"}]}
+	]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	var res struct {
+		Status       string   `json:"status"`
+		SentMessages []string `json:"sent_messages"`
+	}
+	json.NewDecoder(w.Body).Decode(&res)
+	if res.Status != "success" || len(res.SentMessages) != 2 {
+		t.Errorf("response = %+v", res)
+	}
+	sent := line.Sent()
+	if len(sent) != 1 || sent[0].To != "C1" || len(sent[0].Messages) != 2 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	if got := string(sent[0].Messages[1]); !strings.Contains(got, `"quickReply"`) || !strings.Contains(got, `"displayText":"行きたい！"`) {
+		t.Errorf("quick reply = %s", got)
+	}
+
+	// 旧クライアントの形式
+	if w := send(`{"group_id":"C1","message":"こんにちは"}`); w.Code != http.StatusOK {
+		t.Errorf("legacy send: %d %s", w.Code, w.Body)
+	}
+	// 1 件でも不正なら何も送らない
+	w = send(`{"to":"C1","messages":[{"type":"text","text":"ok"},{"type":"image","original_content_url":"http://example.com/a.jpg","preview_image_url":"https://example.com/a.jpg"}]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "messages[1]") {
+		t.Errorf("invalid send: %d %s", w.Code, w.Body)
+	}
+	if len(line.Sent()) != 2 {
+		t.Errorf("sent after invalid request = %d", len(line.Sent()))
+	}
+}
@@ -0,0 +1,256 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Scheduled message statuses.
+const (
+	// StatusPending messages wait for their send time, or are being sent.
+	StatusPending = "pending"
+	// StatusFailed messages gave up after MaxAttempts; they stay listed
+	// until cancelled.
+	StatusFailed = "failed"
+)
+
+// MaxScheduleAhead bounds how far ahead a message may be scheduled.
+const MaxScheduleAhead = 365 * 24 * time.Hour
+
+var (
+	// ErrNoScheduled is returned for unknown scheduled message IDs.
+	ErrNoScheduled = errors.New("scheduled message not found")
+	// ErrNotPending is returned when editing or cancelling a message that
+	// a dispatcher is sending right now.
+	ErrNotPending = errors.New("scheduled message is being sent")
+)
+
+// Job is a message scheduled for later. Its ID doubles as the retry key
+// of its push, so a dispatcher that dies halfway never posts it twice.
+type Job struct {
+	ID       string    `json:"id"`
+	To       string    `json:"to"`
+	Messages []Message `json:"messages"`
+	SendAt   time.Time `json:"send_at"`
+	Status   string    `json:"status"`
+	// SentBy is the app user who scheduled the job.
+	SentBy string `json:"sent_by,omitempty"`
+	// Attempts and Error describe the failed attempts so far.
+	Attempts  int    `json:"attempts,omitempty"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// due is the schedule score of a job waiting for its send time. A job
+// being sent is scored by the end of its lease instead, which is how edits
+// tell the two apart.
+func (j Job) due() int64 {
+	return j.SendAt.UnixMilli()
+}
+
+// CheckSendAt validates the send time of a new or edited job. A minute of
+// clock skew is allowed; such jobs go out on the next dispatch.
+func CheckSendAt(at, now time.Time) error {
+	if at.IsZero() {
+		return fmt.Errorf("send_at is required")
+	}
+	if at.Before(now.Add(-time.Minute)) {
+		return fmt.Errorf("send_at is in the past")
+	}
+	if at.After(now.Add(MaxScheduleAhead)) {
+		return fmt.Errorf("send_at is more than a year ahead")
+	}
+	return nil
+}
+
+// Schedule keeps scheduled messages until a Dispatcher sends them.
+type Schedule interface {
+	// Add stores a new pending job. When a job with the same ID exists it
+	// is returned with added false, so a resubmitted request schedules
+	// nothing twice.
+	Add(ctx context.Context, job Job) (saved Job, added bool, err error)
+	// Jobs lists pending and failed jobs, soonest first.
+	Jobs(ctx context.Context) ([]Job, error)
+	Job(ctx context.Context, id string) (Job, error)
+	// Update replaces a pending job that is not being sent.
+	Update(ctx context.Context, job Job) error
+	// Cancel removes a pending job that is not being sent, or a failed
+	// one.
+	Cancel(ctx context.Context, id string) error
+
+	// Take leases up to limit jobs due at now for lease. Each is handed to
+	// one caller only; a job that is neither finished nor retried before
+	// the lease ends is due again.
+	Take(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Job, error)
+	// Finish removes a taken job once it was sent.
+	Finish(ctx context.Context, id string) error
+	// Retry stores a taken job again: pending jobs wait for their new
+	// SendAt, failed ones are kept for the list.
+	Retry(ctx context.Context, job Job) error
+}
+
+var (
+	memorySchedulesMu sync.Mutex
+	memorySchedules   = make(map[*store.Memory]*MemorySchedule)
+)
+
+// OpenSchedule returns the schedule kept alongside s: a Redis sorted set in
+// the same database, the same bbolt file, or process memory.
+func OpenSchedule(s store.MessageStore) (Schedule, error) {
+	switch s := s.(type) {
+	case *store.Upstash:
+		return NewRedisSchedule(s.Client()), nil
+	case *store.Bolt:
+		return NewBoltSchedule(s.DB())
+	case *store.Memory:
+		// メモリ版はメッセージストアと同じ寿命にする
+		memorySchedulesMu.Lock()
+		defer memorySchedulesMu.Unlock()
+		if m, ok := memorySchedules[s]; ok {
+			return m, nil
+		}
+		m := NewMemorySchedule()
+		memorySchedules[s] = m
+		return m, nil
+	default:
+		return nil, fmt.Errorf("no schedule for %T", s)
+	}
+}
+
+// sortJobs orders jobs by send time.
+func sortJobs(jobs []Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].SendAt.Equal(jobs[j].SendAt) {
+			return jobs[i].SendAt.Before(jobs[j].SendAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
+
+// MemorySchedule keeps scheduled messages in process memory.
+type MemorySchedule struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+	// scores maps the ID of every pending job to the Unix millisecond it
+	// is due, like the Redis sorted set.
+	scores map[string]int64
+}
+
+// NewMemorySchedule returns an empty schedule.
+func NewMemorySchedule() *MemorySchedule {
+	return &MemorySchedule{jobs: make(map[string]Job), scores: make(map[string]int64)}
+}
+
+func (m *MemorySchedule) Add(ctx context.Context, job Job) (Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.jobs[job.ID]; ok {
+		return existing, false, nil
+	}
+	m.jobs[job.ID] = job
+	m.scores[job.ID] = job.due()
+	return job, true, nil
+}
+
+func (m *MemorySchedule) Jobs(ctx context.Context) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+func (m *MemorySchedule) Job(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNoScheduled
+	}
+	return job, nil
+}
+
+func (m *MemorySchedule) Update(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.pending(job.ID); err != nil {
+		return err
+	}
+	m.jobs[job.ID] = job
+	m.scores[job.ID] = job.due()
+	return nil
+}
+
+// pending checks that job id waits for its send time.
+func (m *MemorySchedule) pending(id string) error {
+	job, ok := m.jobs[id]
+	if !ok {
+		return ErrNoScheduled
+	}
+	if score, ok := m.scores[id]; !ok || score != job.due() {
+		return ErrNotPending
+	}
+	return nil
+}
+
+func (m *MemorySchedule) Cancel(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; !ok || job.Status != StatusFailed {
+		if err := m.pending(id); err != nil {
+			return err
+		}
+	}
+	delete(m.jobs, id)
+	delete(m.scores, id)
+	return nil
+}
+
+func (m *MemorySchedule) Take(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []Job
+	for id, score := range m.scores {
+		if score <= now.UnixMilli() {
+			jobs = append(jobs, m.jobs[id])
+		}
+	}
+	sortJobs(jobs)
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	for _, job := range jobs {
+		m.scores[job.ID] = now.Add(lease).UnixMilli()
+	}
+	return jobs, nil
+}
+
+func (m *MemorySchedule) Finish(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	delete(m.scores, id)
+	return nil
+}
+
+func (m *MemorySchedule) Retry(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	if job.Status == StatusPending {
+		m.scores[job.ID] = job.due()
+	} else {
+		delete(m.scores, job.ID)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package outbound
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// scheduledJobsBucket maps a scheduled message ID to its JSON.
+	scheduledJobsBucket = []byte("scheduled_jobs")
+	// scheduledDueBucket maps the ID of every pending job to the Unix
+	// millisecond it is due (big-endian), like the Redis sorted set.
+	scheduledDueBucket = []byte("scheduled_due")
+)
+
+// BoltSchedule keeps scheduled messages in the message store's bbolt file.
+type BoltSchedule struct {
+	db *bolt.DB
+}
+
+// NewBoltSchedule returns a schedule in db, creating its buckets.
+func NewBoltSchedule(db *bolt.DB) (*BoltSchedule, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{scheduledJobsBucket, scheduledDueBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return &BoltSchedule{db: db}, err
+}
+
+func dueKey(ms int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(ms))
+	return key
+}
+
+func getJob(tx *bolt.Tx, id string) (Job, error) {
+	data := tx.Bucket(scheduledJobsBucket).Get([]byte(id))
+	if data == nil {
+		return Job{}, ErrNoScheduled
+	}
+	var job Job
+	err := json.Unmarshal(data, &job)
+	return job, err
+}
+
+func putJob(tx *bolt.Tx, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(scheduledJobsBucket).Put([]byte(job.ID), data)
+}
+
+// boltPending checks that job id waits for its send time.
+func boltPending(tx *bolt.Tx, id string) (Job, error) {
+	job, err := getJob(tx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	v := tx.Bucket(scheduledDueBucket).Get([]byte(id))
+	if v == nil || int64(binary.BigEndian.Uint64(v)) != job.due() {
+		return job, ErrNotPending
+	}
+	return job, nil
+}
+
+func (s *BoltSchedule) Add(ctx context.Context, job Job) (Job, bool, error) {
+	added := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getJob(tx, job.ID)
+		if err == nil {
+			job = existing
+			return nil
+		}
+		if err != ErrNoScheduled {
+			return err
+		}
+		if err := putJob(tx, job); err != nil {
+			return err
+		}
+		added = true
+		return tx.Bucket(scheduledDueBucket).Put([]byte(job.ID), dueKey(job.due()))
+	})
+	return job, added, err
+}
+
+func (s *BoltSchedule) Jobs(ctx context.Context) ([]Job, error) {
+	jobs := []Job{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(scheduledJobsBucket).ForEach(func(_, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	sortJobs(jobs)
+	return jobs, err
+}
+
+func (s *BoltSchedule) Job(ctx context.Context, id string) (Job, error) {
+	var job Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, err = getJob(tx, id)
+		return err
+	})
+	return job, err
+}
+
+func (s *BoltSchedule) Update(ctx context.Context, job Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := boltPending(tx, job.ID); err != nil {
+			return err
+		}
+		if err := putJob(tx, job); err != nil {
+			return err
+		}
+		return tx.Bucket(scheduledDueBucket).Put([]byte(job.ID), dueKey(job.due()))
+	})
+}
+
+func (s *BoltSchedule) Cancel(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		job, err := boltPending(tx, id)
+		if err == ErrNotPending && job.Status == StatusFailed {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(scheduledDueBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(scheduledJobsBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltSchedule) Take(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Job, error) {
+	var jobs []Job
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		due := tx.Bucket(scheduledDueBucket)
+		err := due.ForEach(func(k, v []byte) error {
+			if int64(binary.BigEndian.Uint64(v)) > now.UnixMilli() {
+				return nil
+			}
+			job, err := getJob(tx, string(k))
+			if err == ErrNoScheduled {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sortJobs(jobs)
+		if len(jobs) > limit {
+			jobs = jobs[:limit]
+		}
+		for _, job := range jobs {
+			if err := due.Put([]byte(job.ID), dueKey(now.Add(lease).UnixMilli())); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (s *BoltSchedule) Finish(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(scheduledDueBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(scheduledJobsBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltSchedule) Retry(ctx context.Context, job Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putJob(tx, job); err != nil {
+			return err
+		}
+		due := tx.Bucket(scheduledDueBucket)
+		if job.Status == StatusPending {
+			return due.Put([]byte(job.ID), dueKey(job.due()))
+		}
+		return due.Delete([]byte(job.ID))
+	})
+}
@@ -0,0 +1,210 @@
+package outbound
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// Redis keys: ScheduleKey is a sorted set of job IDs scored by the Unix
+// millisecond they are due, ScheduledJobsKey a hash of job ID to JSON.
+// Failed jobs are only in the hash.
+const (
+	ScheduleKey      = "line_scheduled"
+	ScheduledJobsKey = "line_scheduled_jobs"
+)
+
+// RedisSchedule keeps scheduled messages in Upstash Redis. Several
+// dispatchers may share it: ZREM succeeds for one of them only, which is
+// how a due job is handed out once. Edits and cancellations remove the job
+// the same way, in a transaction with the check that it is not leased.
+type RedisSchedule struct {
+	client *upstash.Client
+}
+
+// NewRedisSchedule returns a schedule using client.
+func NewRedisSchedule(client *upstash.Client) *RedisSchedule {
+	return &RedisSchedule{client: client}
+}
+
+func (r *RedisSchedule) Add(ctx context.Context, job Job) (Job, bool, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return Job{}, false, err
+	}
+	res, err := r.client.Do(ctx, "HSETNX", ScheduledJobsKey, job.ID, string(data))
+	if err != nil {
+		return Job{}, false, err
+	}
+	if upstash.Int(res) == 0 {
+		existing, err := r.Job(ctx, job.ID)
+		return existing, false, err
+	}
+	if _, err := r.client.Do(ctx, "ZADD", ScheduleKey, job.due(), job.ID); err != nil {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+func (r *RedisSchedule) Jobs(ctx context.Context) ([]Job, error) {
+	res, err := r.client.Do(ctx, "HGETALL", ScheduledJobsKey)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []Job{}
+	for _, v := range upstash.Hash(res) {
+		var job Job
+		if err := json.Unmarshal([]byte(upstash.String(v)), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+func (r *RedisSchedule) Job(ctx context.Context, id string) (Job, error) {
+	res, err := r.client.Do(ctx, "HGET", ScheduledJobsKey, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if res == nil {
+		return Job{}, ErrNoScheduled
+	}
+	var job Job
+	err = json.Unmarshal([]byte(upstash.String(res)), &job)
+	return job, err
+}
+
+// takePending reads job id and removes it from ScheduleKey in one
+// transaction, so that either the caller or a dispatcher's Take owns the
+// job, never both. A job leased to a dispatcher is put back and
+// ErrNotPending returned.
+func (r *RedisSchedule) takePending(ctx context.Context, id string) (Job, error) {
+	res, err := r.client.Multi(ctx,
+		[]interface{}{"HGET", ScheduledJobsKey, id},
+		[]interface{}{"ZSCORE", ScheduleKey, id},
+		[]interface{}{"ZREM", ScheduleKey, id},
+	)
+	if err != nil {
+		return Job{}, err
+	}
+	if res[0] == nil {
+		return Job{}, ErrNoScheduled
+	}
+	var job Job
+	if err := json.Unmarshal([]byte(upstash.String(res[0])), &job); err != nil {
+		return Job{}, err
+	}
+	if res[1] == nil {
+		return job, ErrNotPending
+	}
+	if score := upstash.Int(res[1]); score != job.due() {
+		// 送信中のリースを戻す（その間に Finish・Retry されていれば NX で何もしない）
+		if _, err := r.client.Do(context.WithoutCancel(ctx), "ZADD", ScheduleKey, "NX", score, id); err != nil {
+			return job, err
+		}
+		return job, ErrNotPending
+	}
+	return job, nil
+}
+
+func (r *RedisSchedule) Update(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if _, err := r.takePending(ctx, job.ID); err != nil {
+		return err
+	}
+	// ScheduleKey から外している間は Take に取られないので、戻すまでが編集になる
+	_, err = r.client.Multi(context.WithoutCancel(ctx),
+		[]interface{}{"HSET", ScheduledJobsKey, job.ID, string(data)},
+		[]interface{}{"ZADD", ScheduleKey, job.due(), job.ID},
+	)
+	return err
+}
+
+func (r *RedisSchedule) Cancel(ctx context.Context, id string) error {
+	job, err := r.takePending(ctx, id)
+	if err == ErrNotPending && job.Status == StatusFailed {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Do(ctx, "HDEL", ScheduledJobsKey, id)
+	return err
+}
+
+func (r *RedisSchedule) Take(ctx context.Context, now time.Time, limit int, lease time.Duration) ([]Job, error) {
+	res, err := r.client.Do(ctx, "ZRANGE", ScheduleKey, 0, limit-1, "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	items := upstash.Strings(res)
+	var jobs []Job
+	for i := 0; i+1 < len(items); i += 2 {
+		id, score := items[i], upstash.Int(items[i+1])
+		if score > now.UnixMilli() {
+			break
+		}
+		// ZREM に成功した dispatcher だけが送る。ZRANGE の後に編集された予約は
+		// スコアが変わっているので戻す
+		res, err := r.client.Multi(ctx,
+			[]interface{}{"ZSCORE", ScheduleKey, id},
+			[]interface{}{"ZREM", ScheduleKey, id},
+		)
+		if err != nil {
+			return jobs, err
+		}
+		if upstash.Int(res[1]) == 0 {
+			continue
+		}
+		if current := upstash.Int(res[0]); current > now.UnixMilli() {
+			if _, err := r.client.Do(ctx, "ZADD", ScheduleKey, "NX", current, id); err != nil {
+				return jobs, err
+			}
+			continue
+		}
+		if _, err := r.client.Do(ctx, "ZADD", ScheduleKey, "NX", now.Add(lease).UnixMilli(), id); err != nil {
+			return jobs, err
+		}
+		job, err := r.Job(ctx, id)
+		if err == ErrNoScheduled {
+			r.client.Do(ctx, "ZREM", ScheduleKey, id)
+			continue
+		}
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (r *RedisSchedule) Finish(ctx context.Context, id string) error {
+	_, err := r.client.Pipeline(ctx,
+		[]interface{}{"HDEL", ScheduledJobsKey, id},
+		[]interface{}{"ZREM", ScheduleKey, id},
+	)
+	return err
+}
+
+func (r *RedisSchedule) Retry(ctx context.Context, job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if _, err := r.client.Do(ctx, "HSET", ScheduledJobsKey, job.ID, string(data)); err != nil {
+		return err
+	}
+	if job.Status == StatusPending {
+		_, err = r.client.Do(ctx, "ZADD", ScheduleKey, job.due(), job.ID)
+	} else {
+		_, err = r.client.Do(ctx, "ZREM", ScheduleKey, job.ID)
+	}
+	return err
+}
@@ -0,0 +1,292 @@
+package outbound
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash/upstashtest"
+)
+
+// schedules opens a schedule next to each message store backend.
+func schedules(t *testing.T) map[string]func() Schedule {
+	open := func(s store.MessageStore) Schedule {
+		schedule, err := OpenSchedule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return schedule
+	}
+	return map[string]func() Schedule{
+		store.BackendUpstash: func() Schedule {
+			srv := upstashtest.NewServer()
+			t.Cleanup(srv.Close)
+			return open(store.NewUpstash(srv.Client()))
+		},
+		store.BackendMemory: func() Schedule { return open(store.NewMemory()) },
+		store.BackendBolt: func() Schedule {
+			s, err := store.OpenBolt(filepath.Join(t.TempDir(), "messages.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return open(s)
+		},
+	}
+}
+
+func newJob(id string, at time.Time) Job {
+	return Job{ID: id, To: "C1", Messages: []Message{{Type: TypeText, Text: "集合は 10 時"}}, SendAt: at, Status: StatusPending}
+}
+
+func TestSchedule(t *testing.T) {
+	now := time.Date(2025, 5, 3, 8, 0, 0, 0, time.UTC)
+	for name, newSchedule := range schedules(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newSchedule()
+			for _, job := range []Job{newJob("b", now.Add(time.Hour)), newJob("a", now), newJob("c", now.Add(-time.Minute))} {
+				if _, added, err := s.Add(ctx, job); err != nil || !added {
+					t.Fatalf("Add %s = %v, %v", job.ID, added, err)
+				}
+			}
+			// 同じ ID は二重に予約しない
+			if saved, added, err := s.Add(ctx, newJob("a", now.Add(time.Hour))); err != nil || added || !saved.SendAt.Equal(now) {
+				t.Errorf("duplicate Add = %+v, %v, %v", saved, added, err)
+			}
+			jobs, err := s.Jobs(ctx)
+			if err != nil || len(jobs) != 3 || jobs[0].ID != "c" || jobs[2].ID != "b" {
+				t.Fatalf("Jobs = %+v, %v", jobs, err)
+			}
+
+			taken, err := s.Take(ctx, now, 10, time.Minute)
+			if err != nil || len(taken) != 2 || taken[0].ID != "c" || taken[1].ID != "a" {
+				t.Fatalf("Take = %+v, %v", taken, err)
+			}
+			// 送信中の予約は取り出せず、編集も取り消しもできない
+			if again, _ := s.Take(ctx, now, 10, time.Minute); len(again) != 0 {
+				t.Errorf("taken twice: %+v", again)
+			}
+			if err := s.Update(ctx, taken[1]); !errors.Is(err, ErrNotPending) {
+				t.Errorf("Update while sending = %v", err)
+			}
+			if err := s.Cancel(ctx, "a"); !errors.Is(err, ErrNotPending) {
+				t.Errorf("Cancel while sending = %v", err)
+			}
+			// リースが切れると再び取り出される
+			if again, _ := s.Take(ctx, now.Add(2*time.Minute), 10, time.Minute); len(again) != 2 {
+				t.Errorf("after lease: %+v", again)
+			}
+
+			if err := s.Finish(ctx, "c"); err != nil {
+				t.Fatal(err)
+			}
+			failed := taken[1]
+			failed.Status, failed.Attempts, failed.Error = StatusFailed, 5, "unexpected status code: 500"
+			if err := s.Retry(ctx, failed); err != nil {
+				t.Fatal(err)
+			}
+			if due, _ := s.Take(ctx, now.Add(time.Hour), 10, time.Minute); len(due) != 1 || due[0].ID != "b" {
+				t.Errorf("failed job taken: %+v", due)
+			}
+			s.Finish(ctx, "b")
+			if err := s.Cancel(ctx, "a"); err != nil {
+				t.Errorf("Cancel failed = %v", err)
+			}
+			if _, err := s.Job(ctx, "a"); !errors.Is(err, ErrNoScheduled) {
+				t.Errorf("cancelled job = %v", err)
+			}
+
+			retry := newJob("d", now.Add(2*time.Hour))
+			s.Add(ctx, retry)
+			retry.SendAt = now.Add(3 * time.Hour)
+			if err := s.Update(ctx, retry); err != nil {
+				t.Fatal(err)
+			}
+			if due, _ := s.Take(ctx, now.Add(2*time.Hour), 10, time.Minute); len(due) != 0 {
+				t.Errorf("edited job taken early: %+v", due)
+			}
+			if err := s.Cancel(ctx, "d"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Cancel(ctx, "d"); !errors.Is(err, ErrNoScheduled) {
+				t.Errorf("second Cancel = %v", err)
+			}
+		})
+	}
+}
+
+func TestRedisScheduleConcurrentUpdate(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+	s := NewRedisSchedule(srv.Client())
+	ctx := context.Background()
+	now := time.Date(2025, 5, 3, 8, 0, 0, 0, time.UTC)
+	const n = 40
+	for i := 0; i < n; i++ {
+		s.Add(ctx, newJob(fmt.Sprintf("j%02d", i), now))
+	}
+
+	// 送信時刻になった予約を取り出すのと同時に、1 時間後へ編集する
+	var mu sync.Mutex
+	taken := map[string]int{}
+	updated := map[string]bool{}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				jobs, err := s.Take(ctx, now, 3, 3*time.Hour)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(jobs) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, job := range jobs {
+					taken[job.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			job := newJob(id, now.Add(time.Hour))
+			job.Messages[0].Text = "集合は 11 時"
+			err := s.Update(ctx, job)
+			if err != nil && !errors.Is(err, ErrNotPending) {
+				t.Error(err)
+			}
+			mu.Lock()
+			updated[id] = err == nil
+			mu.Unlock()
+		}(fmt.Sprintf("j%02d", i))
+	}
+	wg.Wait()
+
+	// 編集された予約は 1 時間後に、取り出された予約はどれも 1 回だけ
+	later, err := s.Take(ctx, now.Add(time.Hour), n, 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range later {
+		if !updated[job.ID] || job.Messages[0].Text != "集合は 11 時" {
+			t.Errorf("taken later: %+v", job)
+		}
+		taken[job.ID]++
+	}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("j%02d", i)
+		if taken[id] != 1 {
+			t.Errorf("%s taken %d times (updated %v)", id, taken[id], updated[id])
+		}
+		job, _ := s.Job(ctx, id)
+		if updated[id] != job.SendAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("%s updated %v, stored %+v", id, updated[id], job)
+		}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	s := store.NewMemory()
+	sender := NewSender(line.Client(), s)
+	sender.Backoff = time.Millisecond
+	schedule, _ := OpenSchedule(s)
+	d := NewDispatcher(schedule, sender)
+	ctx := context.Background()
+	now := time.Date(2025, 5, 3, 8, 0, 0, 0, time.UTC)
+
+	schedule.Add(ctx, newJob(NewRetryKey(), now))
+	later, _, _ := schedule.Add(ctx, newJob(NewRetryKey(), now.Add(time.Hour)))
+
+	// 送信に失敗した予約は RetryDelay 後に送り直す
+	line.FailPushes(500, 500, 500, 500)
+	stats, err := d.Dispatch(ctx, now)
+	if err != nil || stats.Retrying != 1 || stats.Sent != 0 {
+		t.Fatalf("Dispatch = %+v, %v", stats, err)
+	}
+	jobs, _ := schedule.Jobs(ctx)
+	if jobs[0].Attempts != 1 || !jobs[0].SendAt.Equal(now.Add(DefaultRetryDelay)) || jobs[0].Error == "" {
+		t.Errorf("retried job = %+v", jobs[0])
+	}
+	stats, err = d.Dispatch(ctx, now.Add(DefaultRetryDelay))
+	if err != nil || stats.Sent != 1 {
+		t.Fatalf("second Dispatch = %+v, %v", stats, err)
+	}
+	if sent := line.Sent(); len(sent) != 1 || sent[0].Kind != "push" || sent[0].RetryKey != jobs[0].ID {
+		t.Errorf("sent = %+v", sent)
+	}
+
+	// 送り直しても直らない失敗は failed として一覧に残す
+	line.FailPushes(http.StatusBadRequest)
+	stats, _ = d.Dispatch(ctx, now.Add(time.Hour))
+	if stats.Failed != 1 {
+		t.Errorf("Dispatch = %+v", stats)
+	}
+	if job, err := schedule.Job(ctx, later.ID); err != nil || job.Status != StatusFailed || job.Attempts != 1 {
+		t.Errorf("failed job = %+v, %v", job, err)
+	}
+	if stats, _ := d.Dispatch(ctx, now.Add(2*time.Hour)); stats != (DispatchStats{}) {
+		t.Errorf("failed job dispatched again: %+v", stats)
+	}
+}
+
+func TestScheduledHandler(t *testing.T) {
+	s := store.NewMemory()
+	schedule, _ := OpenSchedule(s)
+	do := func(h http.Handler, method, target, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var res map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&res)
+		return w.Code, res
+	}
+	send := SendHandler(nil, schedule)
+	scheduled := ScheduledHandler(schedule)
+	at := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	// LINE_CHANNEL_TOKEN がなくても予約はできる
+	code, res := do(send, "POST", "/api/send", `{"to":"C1","message":"集合は 10 時","send_at":"`+at+`"}`)
+	if code != http.StatusAccepted || res["status"] != "scheduled" {
+		t.Fatalf("schedule: %d %v", code, res)
+	}
+	id := res["retry_key"].(string)
+	if code, _ := do(send, "POST", "/api/send", `{"to":"C1","message":"x","send_at":"2000-01-01T00:00:00Z"}`); code != http.StatusBadRequest {
+		t.Errorf("past send_at: %d", code)
+	}
+
+	if code, res := do(scheduled, "GET", "/api/scheduled", ""); code != http.StatusOK || res["count"] != 1.0 {
+		t.Errorf("list: %d %v", code, res)
+	}
+	code, res = do(scheduled, "PATCH", "/api/scheduled?id="+id, `{"messages":[{"type":"text","text":"集合は 11 時"}]}`)
+	if code != http.StatusOK || res["messages"].([]interface{})[0].(map[string]interface{})["text"] != "集合は 11 時" {
+		t.Errorf("edit: %d %v", code, res)
+	}
+	if code, _ := do(scheduled, "PATCH", "/api/scheduled?id="+id, `{"to":"nobody"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid edit: %d", code)
+	}
+	if code, _ := do(scheduled, "DELETE", "/api/scheduled?id="+id, ""); code != http.StatusOK {
+		t.Errorf("cancel: %d", code)
+	}
+	if code, _ := do(scheduled, "DELETE", "/api/scheduled?id="+id, ""); code != http.StatusNotFound {
+		t.Errorf("cancel twice: %d", code)
+	}
+}
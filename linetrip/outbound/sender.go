@@ -0,0 +1,300 @@
+package outbound
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// Delivery methods: replies are free, pushes count against the monthly
+// message quota.
+const (
+	MethodReply = "reply"
+	MethodPush  = "push"
+)
+
+// Counter names recorded through Store.IncrCounter, next to the ingest
+// counters shown by /api/health.
+const (
+	CounterReplied = "sent_reply"
+	CounterPushed  = "sent_push"
+)
+
+// Sender defaults.
+const (
+	DefaultMaxAttempts = 4
+	DefaultBackoff     = 500 * time.Millisecond
+	// RetryKeyTTL is how long LINE remembers a retry key.
+	RetryKeyTTL = 24 * time.Hour
+)
+
+// retryKeyPrefix marks the store.MessageStore claims of retry keys.
+const retryKeyPrefix = "retry_key:"
+
+// Pusher sends messages to a conversation.
+// *messaging_api.MessagingApiAPI implements it.
+type Pusher interface {
+	PushMessage(req *messaging_api.PushMessageRequest, xLineRetryKey string) (*messaging_api.PushMessageResponse, error)
+}
+
+// Client sends messages through LINE.
+// *messaging_api.MessagingApiAPI implements it.
+type Client interface {
+	Pusher
+	ReplyMessage(req *messaging_api.ReplyMessageRequest) (*messaging_api.ReplyMessageResponse, error)
+}
+
+// Store keeps the reply tokens of recent webhook events, the retry keys of
+// replies sent or in flight and the delivery counters. store.MessageStore implements
+// it.
+type Store interface {
+	TakeReplyToken(ctx context.Context, conversationID string) (string, error)
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, key string) error
+	IncrCounter(ctx context.Context, name string, delta int64) error
+}
+
+// Delivery reports how messages were sent.
+type Delivery struct {
+	Method       string   `json:"method,omitempty"`
+	SentMessages []string `json:"sent_messages"`
+	// RetryKey identifies the send: resubmitting it with the same key never
+	// posts the messages twice.
+	RetryKey string `json:"retry_key"`
+	// AlreadyAccepted is set when an earlier attempt with the same retry key
+	// was already sent.
+	AlreadyAccepted bool `json:"already_accepted,omitempty"`
+}
+
+// Sender sends messages to a conversation with the Reply API when a fresh
+// reply token is at hand, and pushes them otherwise. Pushes carry a retry
+// key and are retried with exponential backoff while LINE answers 429 or
+// 5xx, or cannot be reached.
+type Sender struct {
+	Client Client
+	// Store, when set, supplies the reply tokens kept by ingest, remembers
+	// the retry keys of replies and records which method each send used. Without it
+	// every send is a push.
+	Store Store
+	// Timeline, when set, receives the sends passed to Record, attached to
+	// the trip ActiveTrip returns when that is set too.
+	Timeline   Timeline
+	ActiveTrip func(ctx context.Context, conversationID string) (string, error)
+	// MaxAttempts is how often a push is tried before it fails.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles each time.
+	Backoff time.Duration
+}
+
+// NewSender returns a Sender for client keeping its state in s, which may
+// be nil.
+func NewSender(client Client, s Store) *Sender {
+	return &Sender{Client: client, Store: s, MaxAttempts: DefaultMaxAttempts, Backoff: DefaultBackoff}
+}
+
+// NewRetryKey returns a random UUID (version 4), the format LINE expects
+// in X-Line-Retry-Key.
+func NewRetryKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ValidRetryKey reports whether key is a UUID.
+func ValidRetryKey(key string) bool {
+	return uuidPattern.MatchString(key)
+}
+
+// Send delivers messages to conversation to. An empty retryKey starts a new
+// send, which replies with the token kept for the conversation if there is
+// one. A retryKey from an earlier Delivery resubmits that send: it is
+// always pushed with the same X-Line-Retry-Key, and LINE answers 409 when
+// it was accepted already. LINE never sees the key of a reply, so a reply
+// claims its key in Store before it is sent, and resubmits of a key that
+// is claimed are not sent again.
+func (s *Sender) Send(ctx context.Context, to, retryKey string, messages []messaging_api.MessageInterface) (Delivery, error) {
+	resubmit := retryKey != ""
+	if !resubmit {
+		retryKey = NewRetryKey()
+	} else if s.replying(ctx, retryKey) {
+		log.Printf("🔁 Send %s was already replied", retryKey)
+		return Delivery{Method: MethodReply, SentMessages: []string{}, RetryKey: retryKey, AlreadyAccepted: true}, nil
+	}
+
+	var token string
+	claimed := false
+	if s.Store != nil && !resubmit {
+		var err error
+		if token, err = s.Store.TakeReplyToken(ctx, to); err != nil {
+			log.Printf("⚠️ Could not load reply token of %s: %v", to, err)
+		}
+		if token != "" {
+			// 返信中・返信済みのキーで送り直されても送らないよう、返信の前に押さえる
+			if claimed, err = s.Store.Claim(ctx, retryKeyPrefix+retryKey, RetryKeyTTL); err != nil {
+				log.Printf("⚠️ Could not claim retry key %s: %v", retryKey, err)
+			}
+		}
+	}
+	return s.deliver(ctx, to, token, retryKey, claimed, messages)
+}
+
+// replying reports whether the send with retryKey was replied or is being
+// replied. Pushes never keep their claim, so a send that died before
+// reaching LINE is pushed again when resubmitted.
+func (s *Sender) replying(ctx context.Context, retryKey string) bool {
+	if s.Store == nil {
+		return false
+	}
+	claimed, err := s.Store.Claim(ctx, retryKeyPrefix+retryKey, RetryKeyTTL)
+	if err != nil {
+		log.Printf("⚠️ Could not look up retry key %s: %v", retryKey, err)
+		return false
+	}
+	if !claimed {
+		return true
+	}
+	s.release(ctx, retryKey)
+	return false
+}
+
+func (s *Sender) release(ctx context.Context, retryKey string) {
+	if err := s.Store.Release(context.WithoutCancel(ctx), retryKeyPrefix+retryKey); err != nil {
+		log.Printf("⚠️ Could not release retry key %s: %v", retryKey, err)
+	}
+}
+
+// Reply answers with replyToken and falls back to a push to conversation
+// to when the token is empty or LINE no longer accepts it.
+func (s *Sender) Reply(ctx context.Context, to, replyToken string, messages []messaging_api.MessageInterface) (Delivery, error) {
+	return s.deliver(ctx, to, replyToken, NewRetryKey(), false, messages)
+}
+
+// deliver replies with replyToken, if any, and pushes otherwise. claimed
+// tells that the retry key is claimed for the reply; the claim is released
+// before falling back to a push.
+func (s *Sender) deliver(ctx context.Context, to, replyToken, retryKey string, claimed bool, messages []messaging_api.MessageInterface) (Delivery, error) {
+	if replyToken != "" {
+		res, err := s.Client.ReplyMessage(&messaging_api.ReplyMessageRequest{ReplyToken: replyToken, Messages: messages})
+		if err == nil {
+			s.count(ctx, CounterReplied)
+			var ids []string
+			if res != nil {
+				ids = messageIDs(res.SentMessages)
+			}
+			return Delivery{Method: MethodReply, SentMessages: ids, RetryKey: retryKey}, nil
+		}
+		log.Printf("⚠️ Reply to %s failed, pushing instead: %v", to, err)
+		if claimed {
+			// Push の送り直しは LINE の 409 に任せる
+			s.release(ctx, retryKey)
+		}
+	}
+
+	delivery, err := s.push(ctx, to, retryKey, messages)
+	if err == nil {
+		s.count(ctx, CounterPushed)
+	}
+	return delivery, err
+}
+
+// push sends messages with retryKey, retrying while the failure may be
+// temporary. LINE answers 409 when an earlier attempt with the key got
+// through, which counts as success.
+func (s *Sender) push(ctx context.Context, to, retryKey string, messages []messaging_api.MessageInterface) (Delivery, error) {
+	delivery := Delivery{Method: MethodPush, SentMessages: []string{}, RetryKey: retryKey}
+	delay := s.Backoff
+	for attempt := 1; ; attempt++ {
+		res, err := s.Client.PushMessage(&messaging_api.PushMessageRequest{To: to, Messages: messages}, retryKey)
+		if err == nil {
+			if res != nil {
+				delivery.SentMessages = messageIDs(res.SentMessages)
+			}
+			return delivery, nil
+		}
+		if status := statusOf(err); status == http.StatusConflict {
+			log.Printf("🔁 Push %s to %s was already accepted", retryKey, to)
+			delivery.SentMessages = acceptedIDs(err)
+			delivery.AlreadyAccepted = true
+			return delivery, nil
+		}
+		if !Retryable(err) || attempt >= s.MaxAttempts || ctx.Err() != nil {
+			return delivery, err
+		}
+
+		log.Printf("🔁 Push %s to %s failed (attempt %d/%d), retrying in %v: %v", retryKey, to, attempt, s.MaxAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return delivery, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// statusOf returns the HTTP status of an SDK error, or 0 when LINE was not
+// reached. line-bot-sdk-go v8 does not expose the status of a failed call,
+// so this reads its "unexpected status code: %d, <body>" message; an SDK
+// upgrade that changes the wording makes every failure look like a network
+// error, which TestStatusOf catches.
+func statusOf(err error) int {
+	var status int
+	if _, scanErr := fmt.Sscanf(err.Error(), "unexpected status code: %d", &status); scanErr != nil {
+		return 0
+	}
+	return status
+}
+
+// Retryable reports whether a failed send may succeed when tried again with
+// the same retry key: LINE was rate limiting, failing or unreachable.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	status := statusOf(err)
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// acceptedIDs reads the sent message IDs LINE repeats in a 409 answer.
+func acceptedIDs(err error) []string {
+	var body struct {
+		SentMessages []messaging_api.SentMessage `json:"sentMessages"`
+	}
+	if i := strings.Index(err.Error(), "{"); i >= 0 {
+		json.Unmarshal([]byte(err.Error()[i:]), &body)
+	}
+	return messageIDs(body.SentMessages)
+}
+
+// count records a delivery method; a failure only loses a statistic.
+func (s *Sender) count(ctx context.Context, name string) {
+	if s.Store == nil {
+		return
+	}
+	if err := s.Store.IncrCounter(ctx, name, 1); err != nil {
+		log.Printf("⚠️ Could not count %s: %v", name, err)
+	}
+}
+
+// messageIDs returns the IDs LINE gave the sent messages.
+func messageIDs(sent []messaging_api.SentMessage) []string {
+	ids := []string{}
+	for _, m := range sent {
+		ids = append(ids, m.Id)
+	}
+	return ids
+}
@@ -0,0 +1,211 @@
+package outbound
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestRetryKey(t *testing.T) {
+	key := NewRetryKey()
+	if !ValidRetryKey(key) || key[14] != '4' || key == NewRetryKey() {
+		t.Errorf("NewRetryKey = %s", key)
+	}
+	if ValidRetryKey("retry-1") {
+		t.Error("non-UUID accepted")
+	}
+}
+
+func TestSenderRetries(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	sender := NewSender(line.Client(), nil)
+	sender.Backoff = time.Millisecond
+	ctx := context.Background()
+	message := []messaging_api.MessageInterface{messaging_api.TextMessage{Text: "集合は 10 時"}}
+
+	// 429 と 500 は同じキーで送り直す
+	line.FailPushes(http.StatusTooManyRequests, http.StatusInternalServerError)
+	delivery, err := sender.Send(ctx, "C1", "", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Method != MethodPush || len(delivery.SentMessages) != 1 || !ValidRetryKey(delivery.RetryKey) {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if requests := line.Requests(); len(requests) != 3 {
+		t.Errorf("requests = %v", requests)
+	}
+	sent := line.Sent()
+	if len(sent) != 1 || sent[0].RetryKey != delivery.RetryKey {
+		t.Fatalf("sent = %+v", sent)
+	}
+
+	// 受付済みのキーで送り直すと 409 になり、成功として扱う
+	again, err := sender.Send(ctx, "C1", delivery.RetryKey, message)
+	if err != nil || !again.AlreadyAccepted || again.SentMessages[0] != delivery.SentMessages[0] {
+		t.Errorf("resubmit = %+v, %v", again, err)
+	}
+	if len(line.Sent()) != 1 {
+		t.Error("resubmitted send was posted twice")
+	}
+
+	// 400 は送り直さない
+	line.FailPushes(http.StatusBadRequest)
+	if _, err := sender.Send(ctx, "C1", "", message); err == nil || Retryable(err) {
+		t.Errorf("400 = %v", err)
+	}
+	line.FailPushes(500, 500, 500, 500)
+	failed, err := sender.Send(ctx, "C1", "", message)
+	if err == nil || !Retryable(err) || failed.RetryKey == "" {
+		t.Errorf("after %d attempts = %+v, %v", DefaultMaxAttempts, failed, err)
+	}
+}
+
+func TestSendResubmit(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	s := store.NewMemory()
+	sender := NewSender(line.Client(), s)
+	sender.Backoff = time.Millisecond
+	send := func(body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		SendHandler(sender, nil).ServeHTTP(w, httptest.NewRequest("POST", "/api/send", strings.NewReader(body)))
+		var res map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&res)
+		return w.Code, res
+	}
+
+	// 送信が失敗してもキーを返すので、同じキーで送り直せる
+	line.FailPushes(500, 500, 500, 500)
+	code, res := send(`{"to":"C1","message":"集合は 10 時"}`)
+	if code != http.StatusBadGateway || res["retryable"] != true {
+		t.Fatalf("failed send: %d %v", code, res)
+	}
+	key := res["retry_key"].(string)
+	body := `{"to":"C1","message":"集合は 10 時","retry_key":"` + key + `"}`
+	if code, res := send(body); code != http.StatusOK || res["retry_key"] != key || res["already_accepted"] != false {
+		t.Fatalf("resubmit: %d %v", code, res)
+	}
+	// 成功した送信をもう一度送っても投稿しない
+	if code, res := send(body); code != http.StatusOK || res["already_accepted"] != true {
+		t.Errorf("second resubmit: %d %v", code, res)
+	}
+	if sent := line.Sent(); len(sent) != 1 || sent[0].RetryKey != key {
+		t.Errorf("sent = %+v", sent)
+	}
+	if code, _ := send(`{"to":"C1","message":"x","retry_key":"retry-1"}`); code != http.StatusBadRequest {
+		t.Errorf("invalid retry key: %d", code)
+	}
+
+	// 前の送信が LINE に届く前に落ちていても、送り直せば投稿される
+	lost := NewRetryKey()
+	if code, res := send(`{"to":"C1","message":"集合は 10 時","retry_key":"` + lost + `"}`); code != http.StatusOK || res["already_accepted"] != false {
+		t.Errorf("lost send: %d %v", code, res)
+	}
+	if sent := line.Sent(); len(sent) != 2 || sent[1].RetryKey != lost {
+		t.Errorf("sent = %+v", sent)
+	}
+}
+
+func TestSendReplyResubmit(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	s := store.NewMemory()
+	ctx := context.Background()
+	s.SaveReplyToken(ctx, "C1", "reply-1", time.Now().Add(time.Minute))
+	sender := NewSender(line.Client(), s)
+	message := []messaging_api.MessageInterface{messaging_api.TextMessage{Text: "集合は 10 時"}}
+
+	delivery, err := sender.Send(ctx, "C1", "", message)
+	if err != nil || delivery.Method != MethodReply {
+		t.Fatalf("delivery = %+v, %v", delivery, err)
+	}
+	// 返信のキーは LINE が知らないので、送り直しはここで止める
+	again, err := sender.Send(ctx, "C1", delivery.RetryKey, message)
+	if err != nil || !again.AlreadyAccepted {
+		t.Errorf("resubmit = %+v, %v", again, err)
+	}
+	if sent := line.Sent(); len(sent) != 1 || sent[0].Kind != "reply" {
+		t.Errorf("sent = %+v", sent)
+	}
+
+	// 返信中のキーも押さえられているので、同時の送り直しは送らない
+	inFlight := NewRetryKey()
+	s.Claim(ctx, retryKeyPrefix+inFlight, RetryKeyTTL)
+	if again, err := sender.Send(ctx, "C1", inFlight, message); err != nil || !again.AlreadyAccepted {
+		t.Errorf("resubmit during reply = %+v, %v", again, err)
+	}
+	if len(line.Sent()) != 1 {
+		t.Error("resubmit during reply was pushed")
+	}
+
+	// 返信できずに Push したキーは押さえたままにしない
+	s.SaveReplyToken(ctx, "C1", "reply-2", time.Now().Add(time.Minute))
+	line.ExpireReplyToken("reply-2")
+	pushed, err := sender.Send(ctx, "C1", "", message)
+	if err != nil || pushed.Method != MethodPush {
+		t.Fatalf("fallback = %+v, %v", pushed, err)
+	}
+	if again, err := sender.Send(ctx, "C1", pushed.RetryKey, message); err != nil || again.Method != MethodPush || !again.AlreadyAccepted {
+		t.Errorf("resubmit after fallback = %+v, %v", again, err)
+	}
+	if sent := line.Sent(); len(sent) != 2 {
+		t.Errorf("sent = %+v", sent)
+	}
+}
+
+// TestStatusOf checks the SDK error format statusOf relies on, through a
+// real client.
+func TestStatusOf(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message":"error"}`))
+	}))
+	client, err := messaging_api.NewMessagingApiAPI("token", messaging_api.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := func() error {
+		_, err := client.PushMessage(&messaging_api.PushMessageRequest{To: "C1", Messages: []messaging_api.MessageInterface{messaging_api.TextMessage{Text: "x"}}}, "")
+		return err
+	}
+
+	for _, tt := range []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusForbidden, false},
+		{http.StatusConflict, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	} {
+		status = tt.status
+		err := push()
+		if err == nil {
+			t.Errorf("%d: no error", tt.status)
+			continue
+		}
+		if statusOf(err) != tt.status || Retryable(err) != tt.retryable {
+			t.Errorf("%d: statusOf = %d, Retryable = %v (%v)", tt.status, statusOf(err), Retryable(err), err)
+		}
+	}
+
+	// 届かなかったときは 0 で、送り直してよい
+	server.Close()
+	if err := push(); err == nil || statusOf(err) != 0 || !Retryable(err) {
+		t.Errorf("unreachable: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package outbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/flex"
+)
+
+// Limits of template messages.
+const (
+	// MaxButtons is the number of actions of a buttons template.
+	MaxButtons = 4
+	// MaxColumns is the number of columns of a carousel template.
+	MaxColumns = 10
+)
+
+// template is the part of a LINE template object that is checked here.
+type template struct {
+	Type              string            `json:"type"`
+	Title             string            `json:"title"`
+	Text              string            `json:"text"`
+	ThumbnailImageURL string            `json:"thumbnailImageUrl"`
+	ImageURL          string            `json:"imageUrl"`
+	Actions           []json.RawMessage `json:"actions"`
+	Action            json.RawMessage   `json:"action"`
+	Columns           []template        `json:"columns"`
+}
+
+// checkTemplate validates a buttons, confirm, carousel or image_carousel
+// template in LINE's own JSON format.
+func checkTemplate(data json.RawMessage) error {
+	if len(data) == 0 {
+		return fmt.Errorf("template is required")
+	}
+	var t template
+	if err := json.Unmarshal(data, &t); err != nil {
+		return fmt.Errorf("invalid template: %v", err)
+	}
+	switch t.Type {
+	case "buttons":
+		return t.checkButtons(1, MaxButtons)
+	case "confirm":
+		if t.Text == "" {
+			return fmt.Errorf("template text is required")
+		}
+		if n := utf8.RuneCountInString(t.Text); n > 240 {
+			return fmt.Errorf("template text is %d characters, over 240", n)
+		}
+		if len(t.Actions) != 2 {
+			return fmt.Errorf("confirm template needs 2 actions, not %d", len(t.Actions))
+		}
+		return checkActions(t.Actions)
+	case "carousel":
+		if err := checkColumns(len(t.Columns)); err != nil {
+			return err
+		}
+		// LINE は全列で同じ数のアクションを求める
+		for i, column := range t.Columns {
+			if len(column.Actions) != len(t.Columns[0].Actions) {
+				return fmt.Errorf("column %d: every column needs the same number of actions", i+1)
+			}
+			if err := column.checkButtons(1, 3); err != nil {
+				return fmt.Errorf("column %d: %w", i+1, err)
+			}
+		}
+		return nil
+	case "image_carousel":
+		if err := checkColumns(len(t.Columns)); err != nil {
+			return err
+		}
+		for i, column := range t.Columns {
+			if err := flex.CheckURL(column.ImageURL); err != nil {
+				return fmt.Errorf("column %d: %v", i+1, err)
+			}
+			if err := checkActions([]json.RawMessage{column.Action}); err != nil {
+				return fmt.Errorf("column %d: %w", i+1, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("template type must be buttons, confirm, carousel or image_carousel, not %q", t.Type)
+	}
+}
+
+// checkButtons checks the text, image and actions of a buttons template or
+// a carousel column. The text is shorter when a title or image takes room.
+func (t template) checkButtons(min, max int) error {
+	if t.Text == "" {
+		return fmt.Errorf("template text is required")
+	}
+	// ボタンは 160 文字、カルーセルの列は 120 文字
+	limit := 160
+	if t.Type == "" {
+		limit = 120
+	}
+	if t.Title != "" || t.ThumbnailImageURL != "" {
+		limit = 60
+	}
+	if n := utf8.RuneCountInString(t.Text); n > limit {
+		return fmt.Errorf("template text is %d characters, over %d", n, limit)
+	}
+	if n := utf8.RuneCountInString(t.Title); n > 40 {
+		return fmt.Errorf("template title is %d characters, over 40", n)
+	}
+	if t.ThumbnailImageURL != "" {
+		if err := flex.CheckURL(t.ThumbnailImageURL); err != nil {
+			return fmt.Errorf("thumbnailImageUrl: %v", err)
+		}
+	}
+	if len(t.Actions) < min || len(t.Actions) > max {
+		return fmt.Errorf("template needs %d to %d actions, not %d", min, max, len(t.Actions))
+	}
+	return checkActions(t.Actions)
+}
+
+func checkColumns(n int) error {
+	if n == 0 {
+		return fmt.Errorf("carousel template has no columns")
+	}
+	if n > MaxColumns {
+		return fmt.Errorf("carousel template has %d columns, over %d", n, MaxColumns)
+	}
+	return nil
+}
+
+// checkActions checks the type and label of template actions; the SDK
+// rejects unknown types when the message is built.
+func checkActions(actions []json.RawMessage) error {
+	for i, data := range actions {
+		var a struct {
+			Type  string `json:"type"`
+			Label string `json:"label"`
+			URI   string `json:"uri"`
+		}
+		if err := json.Unmarshal(data, &a); err != nil {
+			return fmt.Errorf("action %d: invalid action: %v", i+1, err)
+		}
+		switch a.Type {
+		case "message", "postback", "uri", "datetimepicker", "camera", "cameraRoll", "location":
+		default:
+			return fmt.Errorf("action %d: unknown action type %q", i+1, a.Type)
+		}
+		if n := utf8.RuneCountInString(a.Label); n > MaxLabel {
+			return fmt.Errorf("action %d: label is %d characters, over %d", i+1, n, MaxLabel)
+		}
+		if a.Type == "uri" && a.URI == "" {
+			return fmt.Errorf("action %d: uri is required", i+1)
+		}
+	}
+	return nil
+}
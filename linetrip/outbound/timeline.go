@@ -0,0 +1,122 @@
+package outbound
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// timelinePrefix marks the store.MessageStore claims of recorded sends.
+const timelinePrefix = "timeline:"
+
+// Timeline keeps the conversation logs served by /api/messages.
+// store.MessageStore implements it.
+type Timeline interface {
+	Append(ctx context.Context, m store.Message) (store.Message, error)
+	Profile(ctx context.Context, groupID, userID string) (store.Profile, bool, error)
+}
+
+// Record appends a successful send to the timeline of conversation to as
+// one outbound entry, so the app sees its own messages next to the ones it
+// received. sentBy is the LINE user ID of the app user, or "". A resubmitted
+// send is recorded once: the retry key is claimed in Store first.
+func (s *Sender) Record(ctx context.Context, to, sentBy string, messages []Message, delivery Delivery) {
+	if s.Timeline == nil {
+		return
+	}
+	if delivery.AlreadyAccepted && len(delivery.SentMessages) == 0 {
+		// 以前の送信で記録済み
+		return
+	}
+	key := timelinePrefix + delivery.RetryKey
+	if s.Store != nil {
+		claimed, err := s.Store.Claim(ctx, key, RetryKeyTTL)
+		if err != nil {
+			log.Printf("⚠️ Could not claim %s: %v", key, err)
+		} else if !claimed {
+			return
+		}
+	}
+
+	m := timelineEntry(to, sentBy, messages, delivery, time.Now())
+	if sentBy != "" {
+		// 名前が取れなくても記録はする
+		if p, ok, err := s.Timeline.Profile(ctx, to, sentBy); err != nil {
+			log.Printf("⚠️ Profile lookup of %s failed: %v", sentBy, err)
+		} else if ok {
+			m.UserName, m.PictureURL = p.DisplayName, p.PictureURL
+		}
+	}
+	if s.ActiveTrip != nil {
+		if id, err := s.ActiveTrip(ctx, to); err != nil {
+			log.Printf("⚠️ Active trip lookup failed, recording without a trip: %v", err)
+		} else {
+			m.TripID = id
+		}
+	}
+	saved, err := s.Timeline.Append(ctx, m)
+	if err != nil {
+		log.Printf("⚠️ Could not record send %s in %s: %v", delivery.RetryKey, to, err)
+		if s.Store != nil {
+			s.Store.Release(context.WithoutCancel(ctx), key)
+		}
+		return
+	}
+	log.Printf("✅ Outbound message saved with ID %s", saved.ID)
+}
+
+// timelineEntry describes a send as a stored message. A lone image or
+// location keeps its type; anything else is stored as the text of its
+// messages, one per line.
+func timelineEntry(to, sentBy string, messages []Message, delivery Delivery, now time.Time) store.Message {
+	status := store.DeliverySent
+	if delivery.AlreadyAccepted {
+		status = store.DeliveryAccepted
+	}
+	m := store.Message{
+		GroupID:          to,
+		ConversationType: store.ConversationTypeOf(to),
+		Timestamp:        now.UnixMilli(),
+		Type:             store.TypeText,
+		Direction:        store.DirectionOutbound,
+		SentBy:           sentBy,
+		Delivery: &store.Delivery{
+			Method:         delivery.Method,
+			Status:         status,
+			SentMessageIDs: delivery.SentMessages,
+			RetryKey:       delivery.RetryKey,
+		},
+	}
+	if sentBy != "" {
+		m.UserName = store.FallbackName(sentBy)
+	}
+
+	if len(messages) == 1 {
+		switch msg := messages[0]; msg.Type {
+		case TypeImage:
+			m.Type = store.TypeImage
+			m.Content = &store.Content{URL: msg.OriginalContentURL, PreviewURL: msg.PreviewImageURL}
+			return m
+		case TypeLocation:
+			m.Type = store.TypeLocation
+			m.Location = &store.Location{Title: msg.Title, Address: msg.Address, Latitude: msg.Latitude, Longitude: msg.Longitude}
+			return m
+		}
+	}
+	var lines []string
+	for _, msg := range messages {
+		switch msg.Type {
+		case TypeText:
+			lines = append(lines, msg.Text)
+		case TypeLocation:
+			lines = append(lines, msg.Title)
+		case TypeFlex, TypeTemplate:
+			lines = append(lines, msg.AltText)
+		}
+	}
+	m.Message = strings.Join(lines, "\n")
+	return m
+}
@@ -0,0 +1,74 @@
+package outbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestRecord(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	s := store.NewMemory()
+	ctx := context.Background()
+	s.SetProfile(ctx, "C1", store.Profile{UserID: "U1", DisplayName: "たくと"})
+	sender := NewSender(line.Client(), s)
+	sender.Timeline = s
+	sender.ActiveTrip = func(ctx context.Context, conversationID string) (string, error) { return "C1:1", nil }
+	send := func(body string) int {
+		w := httptest.NewRecorder()
+		SendHandler(sender, nil).ServeHTTP(w, httptest.NewRequest("POST", "/api/send", strings.NewReader(body)))
+		return w.Code
+	}
+
+	key := NewRetryKey()
+	body := `{"to":"C1","sent_by":"U1","retry_key":"` + key + `","messages":[{"type":"text","text":"集合は 10 時"},{"type":"text","text":"遅れないでね"}]}`
+	if code := send(body); code != http.StatusOK {
+		t.Fatalf("send: %d", code)
+	}
+	// 送り直しても記録は 1 件
+	send(body)
+	if code := send(`{"to":"C1","sent_by":"C1","message":"x"}`); code != http.StatusBadRequest {
+		t.Errorf("sent_by group: %d", code)
+	}
+
+	messages, err := s.Range(ctx, "C1", "", 0)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("timeline = %+v, %v", messages, err)
+	}
+	m := messages[0]
+	if m.Direction != store.DirectionOutbound || m.SentBy != "U1" || m.UserID != "" || m.UserName != "たくと" || m.TripID != "C1:1" {
+		t.Errorf("entry = %+v", m)
+	}
+	if m.Message != "集合は 10 時\n遅れないでね" || m.Delivery == nil || m.Delivery.Status != store.DeliverySent ||
+		m.Delivery.Method != MethodPush || len(m.Delivery.SentMessageIDs) != 2 || m.Delivery.RetryKey != key {
+		t.Errorf("entry = %+v, delivery %+v", m, m.Delivery)
+	}
+	// 送信者はメンバーとして登録しない
+	if groups, _ := s.UserGroups(ctx, "U1"); len(groups) != 0 {
+		t.Errorf("UserGroups = %v", groups)
+	}
+}
+
+func TestTimelineEntry(t *testing.T) {
+	now := time.Date(2025, 5, 3, 8, 0, 0, 0, time.UTC)
+	delivery := Delivery{Method: MethodPush, SentMessages: []string{"1"}, RetryKey: "k", AlreadyAccepted: true}
+	m := timelineEntry("C1", "", []Message{{Type: TypeLocation, Title: "清水寺", Address: "京都市東山区", Latitude: 34.99, Longitude: 135.78}}, delivery, now)
+	if m.Type != store.TypeLocation || m.Location == nil || m.Location.Title != "清水寺" || m.Delivery.Status != store.DeliveryAccepted || m.Timestamp != now.UnixMilli() || m.UserName != "" {
+		t.Errorf("location entry = %+v", m)
+	}
+	m = timelineEntry("C1", "U1", []Message{{Type: TypeImage, OriginalContentURL: "https://example.com/a.jpg", PreviewImageURL: "https://example.com/p.jpg"}}, delivery, now)
+	if m.Type != store.TypeImage || m.Content.URL != "https://example.com/a.jpg" || m.UserName != store.FallbackName("U1") {
+		t.Errorf("image entry = %+v", m)
+	}
+	m = timelineEntry("C1", "", []Message{{Type: TypeSticker, PackageID: "1", StickerID: "2"}, {Type: TypeFlex, AltText: "旅程"}}, delivery, now)
+	if m.Type != store.TypeText || m.Message != "旅程" {
+		t.Errorf("mixed entry = %+v", m)
+	}
+}
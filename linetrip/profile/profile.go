@@ -0,0 +1,105 @@
+// Package profile resolves group members' LINE display names and pictures.
+// Profiles are fetched on first sight (GetGroupMemberProfile, or the room and
+// 1:1 equivalents) and cached in the message store, where they are refreshed
+// once older than the TTL.
+package profile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// DefaultTTL is how long a cached profile is used before it is fetched
+// again. Display names rarely change during a trip.
+const DefaultTTL = 24 * time.Hour
+
+// Source fetches member profiles. *messaging_api.MessagingApiAPI implements
+// it.
+type Source interface {
+	GetGroupMemberProfile(groupId, userId string) (*messaging_api.GroupUserProfileResponse, error)
+	GetRoomMemberProfile(roomId, userId string) (*messaging_api.RoomUserProfileResponse, error)
+	GetProfile(userId string) (*messaging_api.UserProfileResponse, error)
+}
+
+// Resolver looks up profiles through the store cache.
+type Resolver struct {
+	Store  store.MessageStore
+	Source Source
+	TTL    time.Duration
+	// Now returns the current time; tests override it.
+	Now func() time.Time
+}
+
+// New returns a Resolver with the TTL from PROFILE_TTL (a Go duration such
+// as "12h"), or DefaultTTL.
+func New(s store.MessageStore, source Source) *Resolver {
+	ttl := DefaultTTL
+	if v := os.Getenv("PROFILE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			ttl = d
+		} else {
+			log.Printf("⚠️ Invalid PROFILE_TTL %q, using %s", v, DefaultTTL)
+		}
+	}
+	return &Resolver{Store: s, Source: source, TTL: ttl, Now: time.Now}
+}
+
+// Lookup returns the profile of userID in the conversation groupID. A stale cached profile
+// is returned when refreshing it fails, so a LINE outage does not turn names
+// back into placeholders.
+func (r *Resolver) Lookup(ctx context.Context, groupID, userID string) (store.Profile, error) {
+	now := r.Now()
+	cached, ok, err := r.Store.Profile(ctx, groupID, userID)
+	if err != nil {
+		log.Printf("⚠️ Failed to read cached profile of %s: %v", userID, err)
+	}
+	if ok && now.Sub(time.UnixMilli(cached.FetchedAt)) < r.TTL {
+		return cached, nil
+	}
+
+	fetched, err := r.Fetch(ctx, groupID, userID)
+	if err != nil {
+		if ok {
+			log.Printf("⚠️ Using stale profile of %s: %v", userID, err)
+			return cached, nil
+		}
+		return store.Profile{}, err
+	}
+	return fetched, nil
+}
+
+// Fetch gets the profile from LINE and caches it, ignoring the cache.
+func (r *Resolver) Fetch(ctx context.Context, groupID, userID string) (store.Profile, error) {
+	p := store.Profile{UserID: userID, FetchedAt: r.Now().UnixMilli()}
+	var err error
+	switch store.ConversationTypeOf(groupID) {
+	case store.ConversationRoom:
+		var res *messaging_api.RoomUserProfileResponse
+		if res, err = r.Source.GetRoomMemberProfile(groupID, userID); err == nil {
+			p.DisplayName, p.PictureURL = res.DisplayName, res.PictureUrl
+		}
+	case store.ConversationUser:
+		var res *messaging_api.UserProfileResponse
+		if res, err = r.Source.GetProfile(userID); err == nil {
+			p.DisplayName, p.PictureURL = res.DisplayName, res.PictureUrl
+		}
+	default:
+		var res *messaging_api.GroupUserProfileResponse
+		if res, err = r.Source.GetGroupMemberProfile(groupID, userID); err == nil {
+			p.DisplayName, p.PictureURL = res.DisplayName, res.PictureUrl
+		}
+	}
+	if err != nil {
+		return store.Profile{}, fmt.Errorf("get profile of %s: %w", userID, err)
+	}
+	if err := r.Store.SetProfile(ctx, groupID, p); err != nil {
+		log.Printf("⚠️ Failed to cache profile of %s: %v", userID, err)
+	}
+	return p, nil
+}
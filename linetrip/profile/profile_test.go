@@ -0,0 +1,66 @@
+package profile
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestLookupCachesWithTTL(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	line.AddMember("C1", linetest.Member{UserID: "U1", DisplayName: "たくと", PictureURL: "https://example.com/u1.jpg"})
+
+	s := store.NewMemory()
+	r := New(s, line.Client())
+	r.TTL = time.Hour
+	now := time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC)
+	r.Now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		p, err := r.Lookup(ctx, "C1", "U1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.DisplayName != "たくと" || p.PictureURL != "https://example.com/u1.jpg" {
+			t.Fatalf("profile = %+v", p)
+		}
+	}
+	if n := len(line.Requests()); n != 1 {
+		t.Fatalf("LINE called %d times, want 1", n)
+	}
+
+	// TTL を過ぎたら取り直す
+	line.AddMember("C1", linetest.Member{UserID: "U1", DisplayName: "たくと🧳"})
+	now = now.Add(2 * time.Hour)
+	p, err := r.Lookup(ctx, "C1", "U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.DisplayName != "たくと🧳" || len(line.Requests()) != 2 {
+		t.Fatalf("profile after TTL = %+v (%d requests)", p, len(line.Requests()))
+	}
+}
+
+func TestLookupFallsBackToStaleProfile(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+
+	s := store.NewMemory()
+	ctx := context.Background()
+	s.SetProfile(ctx, "C1", store.Profile{UserID: "U1", DisplayName: "old", FetchedAt: 1})
+	r := New(s, line.Client())
+
+	// 取得に失敗しても古いキャッシュを返す
+	p, err := r.Lookup(ctx, "C1", "U1")
+	if err != nil || p.DisplayName != "old" {
+		t.Fatalf("profile = %+v, err = %v", p, err)
+	}
+	if _, err := r.Lookup(ctx, "C1", "U2"); err == nil {
+		t.Fatal("expected error for unknown member")
+	}
+}
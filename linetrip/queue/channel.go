@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+)
+
+// Channel is an in-process queue for the standalone webhook-server. Jobs
+// waiting in it are lost when the process exits.
+type Channel struct {
+	jobs  chan Job
+	ready chan struct{}
+}
+
+// NewChannel returns a queue buffering up to size jobs.
+func NewChannel(size int) *Channel {
+	return &Channel{jobs: make(chan Job, size), ready: make(chan struct{}, 1)}
+}
+
+func (q *Channel) Enqueue(ctx context.Context, jobs ...Job) error {
+	for _, job := range jobs {
+		select {
+		case q.jobs <- job:
+		default:
+			return fmt.Errorf("queue full (%d jobs)", cap(q.jobs))
+		}
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *Channel) Dequeue(ctx context.Context) (*Job, error) {
+	select {
+	case job := <-q.jobs:
+		return &job, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (q *Channel) Done(ctx context.Context, job *Job) error {
+	return nil
+}
+
+func (q *Channel) Len(ctx context.Context) (int64, error) {
+	return int64(len(q.jobs)), nil
+}
+
+// Wait blocks until jobs are enqueued or ctx is done.
+func (q *Channel) Wait(ctx context.Context) {
+	select {
+	case <-q.ready:
+	case <-ctx.Done():
+	}
+}
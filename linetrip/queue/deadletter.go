@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// DeadLettersKey is the Redis hash of dead letters, keyed by ID.
+const DeadLettersKey = "line_dead_letters"
+
+// ErrNoDeadLetter is returned for unknown dead letter IDs.
+var ErrNoDeadLetter = errors.New("dead letter not found")
+
+// DeadLetter is a job the worker gave up on, kept for inspection and replay.
+type DeadLetter struct {
+	// ID is the webhook event ID, or the failure time when the event had
+	// none.
+	ID       string `json:"id"`
+	Job      Job    `json:"job"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+	FailedAt int64  `json:"failed_at"`
+}
+
+// DeadLetters holds failed jobs until they are replayed or discarded.
+type DeadLetters interface {
+	Add(ctx context.Context, d DeadLetter) error
+	// List returns every dead letter, oldest failure first.
+	List(ctx context.Context) ([]DeadLetter, error)
+	Get(ctx context.Context, id string) (DeadLetter, error)
+	Remove(ctx context.Context, id string) error
+}
+
+// NewDeadLetter describes job failing after attempts tries.
+func NewDeadLetter(job Job, attempts int, err error) DeadLetter {
+	now := time.Now().UnixMilli()
+	job.raw = ""
+	d := DeadLetter{ID: job.ID, Job: job, Error: err.Error(), Attempts: attempts, FailedAt: now}
+	if d.ID == "" {
+		d.ID = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return d
+}
+
+// OpenDeadLetters returns the Redis dead letter store when Upstash is
+// configured, and an in-memory one otherwise.
+func OpenDeadLetters() (DeadLetters, error) {
+	if os.Getenv("KV_REST_API_URL") == "" {
+		return NewMemoryDeadLetters(), nil
+	}
+	client, err := upstash.NewFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisDeadLetters(client), nil
+}
+
+// RedisDeadLetters keeps dead letters in an Upstash Redis hash.
+type RedisDeadLetters struct {
+	client *upstash.Client
+}
+
+// NewRedisDeadLetters returns a dead letter store using client.
+func NewRedisDeadLetters(client *upstash.Client) *RedisDeadLetters {
+	return &RedisDeadLetters{client: client}
+}
+
+func (r *RedisDeadLetters) Add(ctx context.Context, d DeadLetter) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Do(ctx, "HSET", DeadLettersKey, d.ID, string(data))
+	return err
+}
+
+func (r *RedisDeadLetters) List(ctx context.Context) ([]DeadLetter, error) {
+	res, err := r.client.Do(ctx, "HGETALL", DeadLettersKey)
+	if err != nil {
+		return nil, err
+	}
+	out := []DeadLetter{}
+	for _, v := range upstash.Hash(res) {
+		var d DeadLetter
+		if err := json.Unmarshal([]byte(upstash.String(v)), &d); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	sortDeadLetters(out)
+	return out, nil
+}
+
+func (r *RedisDeadLetters) Get(ctx context.Context, id string) (DeadLetter, error) {
+	var d DeadLetter
+	res, err := r.client.Do(ctx, "HGET", DeadLettersKey, id)
+	if err != nil {
+		return d, err
+	}
+	if res == nil {
+		return d, ErrNoDeadLetter
+	}
+	err = json.Unmarshal([]byte(upstash.String(res)), &d)
+	return d, err
+}
+
+func (r *RedisDeadLetters) Remove(ctx context.Context, id string) error {
+	res, err := r.client.Do(ctx, "HDEL", DeadLettersKey, id)
+	if err != nil {
+		return err
+	}
+	if upstash.Int(res) == 0 {
+		return ErrNoDeadLetter
+	}
+	return nil
+}
+
+// MemoryDeadLetters keeps dead letters in process, for the standalone
+// webhook-server without Upstash.
+type MemoryDeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+}
+
+// NewMemoryDeadLetters returns an empty in-memory dead letter store.
+func NewMemoryDeadLetters() *MemoryDeadLetters {
+	return &MemoryDeadLetters{letters: make(map[string]DeadLetter)}
+}
+
+func (m *MemoryDeadLetters) Add(ctx context.Context, d DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.letters[d.ID] = d
+	return nil
+}
+
+func (m *MemoryDeadLetters) List(ctx context.Context) ([]DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]DeadLetter, 0, len(m.letters))
+	for _, d := range m.letters {
+		out = append(out, d)
+	}
+	sortDeadLetters(out)
+	return out, nil
+}
+
+func (m *MemoryDeadLetters) Get(ctx context.Context, id string) (DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.letters[id]
+	if !ok {
+		return d, ErrNoDeadLetter
+	}
+	return d, nil
+}
+
+func (m *MemoryDeadLetters) Remove(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.letters[id]; !ok {
+		return ErrNoDeadLetter
+	}
+	delete(m.letters, id)
+	return nil
+}
+
+func sortDeadLetters(letters []DeadLetter) {
+	sort.Slice(letters, func(i, j int) bool {
+		if letters[i].FailedAt != letters[j].FailedAt {
+			return letters[i].FailedAt < letters[j].FailedAt
+		}
+		return letters[i].ID < letters[j].ID
+	})
+}
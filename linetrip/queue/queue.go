@@ -0,0 +1,125 @@
+// Package queue decouples acknowledging a LINE webhook from processing its
+// events. The webhook verifies the signature and enqueues each raw event;
+// a Worker drains the queue and retries failed events.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// Backend names accepted in WEBHOOK_QUEUE.
+const (
+	BackendRedis  = "redis"
+	BackendInline = "inline"
+)
+
+// Job is one webhook event awaiting processing.
+type Job struct {
+	// ID is the webhook event ID, for logs.
+	ID string `json:"id"`
+	// Event is the event exactly as LINE sent it.
+	Event      json.RawMessage `json:"event"`
+	EnqueuedAt int64           `json:"enqueued_at"`
+
+	// raw is the encoded form the job was dequeued as, so a shared queue
+	// can find it again when acknowledging.
+	raw string
+}
+
+// Queue holds jobs until a worker takes them.
+type Queue interface {
+	Enqueue(ctx context.Context, jobs ...Job) error
+	// Dequeue takes the oldest job, or returns nil when the queue is empty.
+	// The job stays in flight until Done is called.
+	Dequeue(ctx context.Context) (*Job, error)
+	Done(ctx context.Context, job *Job) error
+	// Len reports the number of waiting jobs.
+	Len(ctx context.Context) (int64, error)
+}
+
+// Leaser is implemented by shared queues that allow one active worker.
+type Leaser interface {
+	// Acquire takes the worker lease for ttl and requeues jobs a dead worker
+	// left in flight. It reports false when another worker holds the lease.
+	Acquire(ctx context.Context, ttl time.Duration) (bool, error)
+	Release(ctx context.Context) error
+}
+
+// Waiter is implemented by queues that can block until a job arrives, so
+// Worker.Run need not poll them.
+type Waiter interface {
+	Wait(ctx context.Context)
+}
+
+// Verify reads the callback body of r and checks its X-Line-Signature. It
+// returns webhook.ErrInvalidSignature for forged requests.
+func Verify(channelSecret string, r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !webhook.ValidateSignature(channelSecret, r.Header.Get("X-Line-Signature"), body) {
+		return nil, webhook.ErrInvalidSignature
+	}
+	return body, nil
+}
+
+// Jobs splits a verified callback body into one job per event, keeping each
+// event's JSON untouched.
+func Jobs(body []byte) ([]Job, error) {
+	var cb struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	if err := json.Unmarshal(body, &cb); err != nil {
+		return nil, fmt.Errorf("decode callback: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+	jobs := make([]Job, 0, len(cb.Events))
+	for _, event := range cb.Events {
+		var meta struct {
+			WebhookEventID string `json:"webhookEventId"`
+		}
+		json.Unmarshal(event, &meta)
+		jobs = append(jobs, Job{ID: meta.WebhookEventID, Event: event, EnqueuedAt: now})
+	}
+	return jobs, nil
+}
+
+// Open returns the queue selected by WEBHOOK_QUEUE: inline (the default) or
+// redis. Inline returns a nil Queue, meaning the webhook processes events
+// itself before answering, as it used to.
+//
+// Redis has to be asked for explicitly: queued events wait for the next
+// worker run, which on Vercel is a cron firing at most once a minute (Pro
+// plan). That is longer than store.ReplyTokenTTL, so queued events lose
+// their reply tokens and replies fall back to pushes.
+func Open() (Queue, error) {
+	backend := os.Getenv("WEBHOOK_QUEUE")
+	if backend == "" {
+		backend = BackendInline
+	}
+
+	switch backend {
+	case BackendRedis:
+		client, err := upstash.NewFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewRedis(client), nil
+	case BackendInline:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown WEBHOOK_QUEUE %q", backend)
+	}
+}
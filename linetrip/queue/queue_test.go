@@ -0,0 +1,283 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash/upstashtest"
+)
+
+func textEvent(id string) []byte {
+	return []byte(fmt.Sprintf(`{"type":"message","mode":"active","timestamp":1000,"webhookEventId":%q,`+
+		`"deliveryContext":{"isRedelivery":false},`+
+		`"source":{"type":"group","groupId":"C1","userId":"U1"},`+
+		`"message":{"type":"text","id":"m-%s","quoteToken":"q","text":"hi"}}`, id, id))
+}
+
+func callback(ids ...string) []byte {
+	body := `{"destination":"Ubot","events":[`
+	for i, id := range ids {
+		if i > 0 {
+			body += ","
+		}
+		body += string(textEvent(id))
+	}
+	return []byte(body + "]}")
+}
+
+func backends(t *testing.T) map[string]func() Queue {
+	return map[string]func() Queue{
+		BackendRedis: func() Queue {
+			srv := upstashtest.NewServer()
+			t.Cleanup(srv.Close)
+			return NewRedis(srv.Client())
+		},
+		"channel": func() Queue { return NewChannel(10) },
+	}
+}
+
+func TestJobs(t *testing.T) {
+	jobs, err := Jobs(callback("e1", "e2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "e1" || jobs[1].ID != "e2" {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	if _, err := webhook.UnmarshalEvent(jobs[0].Event); err != nil {
+		t.Errorf("event not kept intact: %v", err)
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	for name, newQueue := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			q := newQueue()
+			ctx := context.Background()
+			jobs, _ := Jobs(callback("e1", "e2", "e3"))
+			if err := q.Enqueue(ctx, jobs...); err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := q.Len(ctx); n != 3 {
+				t.Fatalf("Len = %d", n)
+			}
+
+			var got []string
+			for {
+				job, err := q.Dequeue(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if job == nil {
+					break
+				}
+				got = append(got, job.ID)
+				if err := q.Done(ctx, job); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if fmt.Sprint(got) != "[e1 e2 e3]" {
+				t.Errorf("dequeued %v", got)
+			}
+		})
+	}
+}
+
+func TestWorkerRetries(t *testing.T) {
+	for name, newQueue := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			q := newQueue()
+			ctx := context.Background()
+			jobs, _ := Jobs(callback("flaky", "broken"))
+			q.Enqueue(ctx, jobs...)
+
+			calls := map[string]int{}
+			w := NewWorker(q, func(ctx context.Context, event webhook.EventInterface) error {
+				id := event.(webhook.MessageEvent).WebhookEventId
+				calls[id]++
+				if id == "broken" || calls[id] < 3 {
+					return errors.New("temporary failure")
+				}
+				return nil
+			})
+			w.Backoff = time.Millisecond
+			var gaveUp []string
+			w.OnGiveUp = func(ctx context.Context, job Job, attempts int, err error) {
+				gaveUp = append(gaveUp, fmt.Sprintf("%s/%d", job.ID, attempts))
+			}
+
+			stats, err := w.Drain(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Processed != 1 || stats.Failed != 1 || stats.Remaining != 0 {
+				t.Errorf("stats = %+v", stats)
+			}
+			if calls["flaky"] != 3 || calls["broken"] != DefaultMaxAttempts {
+				t.Errorf("calls = %v", calls)
+			}
+			if fmt.Sprint(gaveUp) != fmt.Sprintf("[broken/%d]", DefaultMaxAttempts) {
+				t.Errorf("gave up on %v", gaveUp)
+			}
+		})
+	}
+}
+
+func TestRedisRecoversAbandonedJobs(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+	q := NewRedis(srv.Client())
+	ctx := context.Background()
+
+	jobs, _ := Jobs(callback("e1"))
+	q.Enqueue(ctx, jobs...)
+	// 取り出したまま落ちたワーカー
+	if job, err := q.Dequeue(ctx); err != nil || job == nil {
+		t.Fatalf("Dequeue = %v, %v", job, err)
+	}
+	if n, _ := q.Len(ctx); n != 0 {
+		t.Fatalf("Len = %d", n)
+	}
+
+	handled := 0
+	w := NewWorker(q, func(context.Context, webhook.EventInterface) error {
+		handled++
+		return nil
+	})
+	stats, err := w.Drain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled != 1 || stats.Processed != 1 {
+		t.Errorf("handled %d, stats %+v", handled, stats)
+	}
+
+	// 他のワーカーがリースを持っている間は何もしない
+	q.Acquire(ctx, time.Minute)
+	q.Enqueue(ctx, jobs...)
+	if stats, _ := w.Drain(ctx); !stats.Busy || handled != 1 {
+		t.Errorf("drained while leased: %+v", stats)
+	}
+}
+
+func TestChannelWait(t *testing.T) {
+	q := NewChannel(1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.Wait(ctx)
+		close(done)
+	}()
+	jobs, _ := Jobs(callback("e1"))
+	q.Enqueue(ctx, jobs...)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("Wait did not return after Enqueue")
+	}
+
+	if err := q.Enqueue(ctx, jobs...); err == nil {
+		t.Error("Enqueue into a full queue succeeded")
+	}
+}
+
+func TestWorkerDeadLetters(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+	letters := map[string]DeadLetters{
+		BackendRedis: NewRedisDeadLetters(srv.Client()),
+		"memory":     NewMemoryDeadLetters(),
+	}
+	for name, dl := range letters {
+		t.Run(name, func(t *testing.T) {
+			q := NewChannel(10)
+			ctx := context.Background()
+			jobs, _ := Jobs(callback("ok", "down"))
+			q.Enqueue(ctx, jobs...)
+			q.Enqueue(ctx, Job{ID: "garbage", Event: []byte(`[1]`)})
+
+			w := NewWorker(q, func(ctx context.Context, event webhook.EventInterface) error {
+				if event.(webhook.MessageEvent).WebhookEventId == "down" {
+					return errors.New("upstash unavailable")
+				}
+				return nil
+			})
+			w.Backoff = time.Millisecond
+			w.DeadLetters = dl
+			if _, err := w.Drain(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := dl.List(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 2 {
+				t.Fatalf("dead letters = %+v", list)
+			}
+			d, err := dl.Get(ctx, "down")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Attempts != DefaultMaxAttempts || d.Error != "upstash unavailable" || string(d.Job.Event) != string(jobs[1].Event) {
+				t.Errorf("dead letter = %+v", d)
+			}
+			if d, _ := dl.Get(ctx, "garbage"); d.Attempts != 0 {
+				t.Errorf("undecodable event retried: %+v", d)
+			}
+
+			if err := dl.Remove(ctx, "down"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dl.Get(ctx, "down"); !errors.Is(err, ErrNoDeadLetter) {
+				t.Errorf("Get after Remove = %v", err)
+			}
+			if err := dl.Remove(ctx, "down"); !errors.Is(err, ErrNoDeadLetter) {
+				t.Errorf("second Remove = %v", err)
+			}
+		})
+	}
+}
+
+func TestDrainStopsBeforeLeaseExpires(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+	q := NewRedis(srv.Client())
+	ctx := context.Background()
+
+	jobs, _ := Jobs(callback("e1", "e2", "e3", "e4", "e5"))
+	q.Enqueue(ctx, jobs...)
+
+	w := NewWorker(q, func(ctx context.Context, _ webhook.EventInterface) error {
+		select {
+		case <-time.After(250 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	w.Lease = time.Second
+	start := time.Now()
+	stats, err := w.Drain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= w.Lease {
+		t.Errorf("drained for %v, past the %v lease", elapsed, w.Lease)
+	}
+	if stats.Processed != 3 || stats.Failed != 0 || stats.Remaining != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// 途中で止めたジョブは次のワーカーが処理する
+	w.Handle = func(context.Context, webhook.EventInterface) error { return nil }
+	if stats, err := w.Drain(ctx); err != nil || stats.Processed != 2 {
+		t.Errorf("next drain = %+v, %v", stats, err)
+	}
+}
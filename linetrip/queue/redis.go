@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// Redis keys. Jobs are pushed on the left of QueueKey and moved from its
+// right into ProcessingKey while a worker handles them.
+const (
+	QueueKey      = "line_queue"
+	ProcessingKey = "line_queue:processing"
+	LockKey       = "line_queue:lock"
+)
+
+// Redis is a queue in an Upstash Redis list, shared by every webhook and
+// worker invocation.
+type Redis struct {
+	client *upstash.Client
+}
+
+// NewRedis returns a queue using client.
+func NewRedis(client *upstash.Client) *Redis {
+	return &Redis{client: client}
+}
+
+func (q *Redis) Enqueue(ctx context.Context, jobs ...Job) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	cmd := []interface{}{"LPUSH", QueueKey}
+	for _, job := range jobs {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		cmd = append(cmd, string(data))
+	}
+	_, err := q.client.Do(ctx, cmd...)
+	return err
+}
+
+func (q *Redis) Dequeue(ctx context.Context) (*Job, error) {
+	res, err := q.client.Do(ctx, "LMOVE", QueueKey, ProcessingKey, "RIGHT", "LEFT")
+	if err != nil || res == nil {
+		return nil, err
+	}
+	raw := upstash.String(res)
+	var job Job
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		// 壊れたジョブは処理中リストに残さない
+		q.client.Do(ctx, "LREM", ProcessingKey, 1, raw)
+		return nil, err
+	}
+	job.raw = raw
+	return &job, nil
+}
+
+func (q *Redis) Done(ctx context.Context, job *Job) error {
+	_, err := q.client.Do(ctx, "LREM", ProcessingKey, 1, job.raw)
+	return err
+}
+
+func (q *Redis) Len(ctx context.Context) (int64, error) {
+	res, err := q.client.Do(ctx, "LLEN", QueueKey)
+	return upstash.Int(res), err
+}
+
+func (q *Redis) Acquire(ctx context.Context, ttl time.Duration) (bool, error) {
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	res, err := q.client.Do(ctx, "SET", LockKey, time.Now().UnixMilli(), "NX", "EX", seconds)
+	if err != nil || res == nil {
+		return false, err
+	}
+
+	// リースを取れた = 他のワーカーはいないので、処理中のまま残ったジョブは戻す
+	for {
+		res, err := q.client.Do(ctx, "LMOVE", ProcessingKey, QueueKey, "LEFT", "RIGHT")
+		if err != nil {
+			return true, err
+		}
+		if res == nil {
+			return true, nil
+		}
+	}
+}
+
+func (q *Redis) Release(ctx context.Context) error {
+	_, err := q.client.Do(ctx, "DEL", LockKey)
+	return err
+}
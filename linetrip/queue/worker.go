@@ -0,0 +1,184 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// Worker defaults.
+const (
+	DefaultMaxAttempts  = 4
+	DefaultBackoff      = 250 * time.Millisecond
+	DefaultPollInterval = time.Second
+	DefaultLease        = 5 * time.Minute
+)
+
+// Worker takes jobs off a Queue and hands their events to Handle, retrying
+// failures with exponential backoff.
+type Worker struct {
+	Queue  Queue
+	Handle func(context.Context, webhook.EventInterface) error
+	// MaxAttempts is how often an event is tried before it is given up.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles each time.
+	Backoff time.Duration
+	// PollInterval is how long Run sleeps on an empty queue that cannot
+	// signal new jobs.
+	PollInterval time.Duration
+	// Lease bounds how long a worker holds a shared queue's lease; Drain
+	// stops before it runs out.
+	Lease time.Duration
+	// DeadLetters, when set, keeps jobs that failed MaxAttempts times or
+	// could not be decoded, so they can be replayed later.
+	DeadLetters DeadLetters
+	// OnGiveUp, when set, is called with every job given up on.
+	OnGiveUp func(ctx context.Context, job Job, attempts int, err error)
+}
+
+// NewWorker returns a worker feeding the events in q to handle.
+func NewWorker(q Queue, handle func(context.Context, webhook.EventInterface) error) *Worker {
+	return &Worker{
+		Queue:        q,
+		Handle:       handle,
+		MaxAttempts:  DefaultMaxAttempts,
+		Backoff:      DefaultBackoff,
+		PollInterval: DefaultPollInterval,
+		Lease:        DefaultLease,
+	}
+}
+
+// Stats summarises a Drain.
+type Stats struct {
+	Processed int   `json:"processed"`
+	Failed    int   `json:"failed"`
+	Remaining int64 `json:"remaining"`
+	// Busy is set when another worker held the queue's lease.
+	Busy bool `json:"busy,omitempty"`
+}
+
+// Drain processes jobs until the queue is empty or ctx is done. On a shared
+// queue it also stops shortly before its lease expires, since the lease is
+// not renewed. A job interrupted by ctx stays in flight and is retried by the
+// next drain.
+func (w *Worker) Drain(ctx context.Context) (Stats, error) {
+	var stats Stats
+	if leaser, ok := w.Queue.(Leaser); ok {
+		acquired, err := leaser.Acquire(ctx, w.Lease)
+		if err != nil {
+			return stats, fmt.Errorf("acquire lease: %w", err)
+		}
+		if !acquired {
+			stats.Busy = true
+			return stats, nil
+		}
+		defer leaser.Release(context.Background())
+
+		// リースが切れた後も処理を続けると、次のワーカーが処理中のジョブを
+		// キューに戻して二重に処理するので、切れる前に止める
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Lease-w.Lease/10)
+		defer cancel()
+	}
+
+	for ctx.Err() == nil {
+		job, err := w.Queue.Dequeue(ctx)
+		if err != nil {
+			return stats, fmt.Errorf("dequeue: %w", err)
+		}
+		if job == nil {
+			break
+		}
+		if err := w.process(ctx, job); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			stats.Failed++
+		} else {
+			stats.Processed++
+		}
+		if err := w.Queue.Done(ctx, job); err != nil {
+			return stats, fmt.Errorf("ack %s: %w", job.ID, err)
+		}
+	}
+
+	stats.Remaining, _ = w.Queue.Len(context.WithoutCancel(ctx))
+	return stats, nil
+}
+
+// Run drains the queue until ctx is done, waiting for new jobs in between.
+func (w *Worker) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		stats, err := w.Drain(ctx)
+		if err != nil {
+			log.Printf("❌ Worker error: %v", err)
+		} else if stats.Processed+stats.Failed > 0 {
+			log.Printf("✅ Worker processed %d events (%d failed, %d waiting)", stats.Processed, stats.Failed, stats.Remaining)
+		}
+		if stats.Remaining > 0 && err == nil && !stats.Busy {
+			continue
+		}
+
+		if waiter, ok := w.Queue.(Waiter); ok && err == nil {
+			waiter.Wait(ctx)
+			continue
+		}
+		select {
+		case <-time.After(w.PollInterval):
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// process handles one job, retrying until it succeeds, MaxAttempts is
+// reached or ctx is done.
+func (w *Worker) process(ctx context.Context, job *Job) error {
+	event, err := webhook.UnmarshalEvent(job.Event)
+	if err != nil {
+		err = fmt.Errorf("decode event: %w", err)
+		w.giveUp(ctx, job, 0, err)
+		return err
+	}
+
+	delay := w.Backoff
+	for attempt := 1; ; attempt++ {
+		err = w.Handle(ctx, event)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if attempt >= w.MaxAttempts {
+			w.giveUp(ctx, job, attempt, err)
+			return err
+		}
+
+		log.Printf("🔁 Event %s failed (attempt %d/%d), retrying in %v: %v", job.ID, attempt, w.MaxAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (w *Worker) giveUp(ctx context.Context, job *Job, attempts int, err error) {
+	log.Printf("❌ Giving up on event %s after %d attempts: %v", job.ID, attempts, err)
+	if w.DeadLetters != nil {
+		// ctx が切れていても記録だけは残す
+		if err := w.DeadLetters.Add(context.WithoutCancel(ctx), NewDeadLetter(*job, attempts, err)); err != nil {
+			log.Printf("❌ Could not dead-letter event %s, it is lost: %v", job.ID, err)
+		} else {
+			log.Printf("🪦 Event %s moved to dead letters", job.ID)
+		}
+	}
+	if w.OnGiveUp != nil {
+		w.OnGiveUp(ctx, *job, attempts, err)
+	}
+}
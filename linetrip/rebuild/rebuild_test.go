@@ -0,0 +1,127 @@
+package rebuild
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/archive"
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
+)
+
+func textEvent(eventID, messageID, text string) string {
+	return fmt.Sprintf(`{"type":"message","mode":"active","timestamp":1700000000000,"webhookEventId":%q,`+
+		`"deliveryContext":{"isRedelivery":false},"source":{"type":"group","groupId":"C1","userId":"U1"},`+
+		`"message":{"type":"text","id":%q,"quoteToken":"q","text":%q}}`, eventID, messageID, text)
+}
+
+func memberJoined(eventID, userID string) string {
+	return fmt.Sprintf(`{"type":"memberJoined","mode":"active","timestamp":1700000000000,"webhookEventId":%q,`+
+		`"deliveryContext":{"isRedelivery":false},"source":{"type":"group","groupId":"C1"},`+
+		`"joined":{"members":[{"type":"user","userId":%q}]}}`, eventID, userID)
+}
+
+func callback(events ...string) []byte {
+	body := `{"destination":"Ubot","events":[`
+	for i, e := range events {
+		if i > 0 {
+			body += ","
+		}
+		body += e
+	}
+	return []byte(body + "]}")
+}
+
+func TestRebuildIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	a := archive.NewFS(t.TempDir())
+	day := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	a.Append(ctx, day, callback(textEvent("e1", "m1", "清水寺"), memberJoined("e2", "U2")))
+	a.Append(ctx, day.Add(time.Hour), callback(textEvent("e3", "m2", "金閣寺")))
+	// 再送で同じ内容が 2 回保存されている
+	a.Append(ctx, day.Add(time.Hour), callback(textEvent("e3", "m2", "金閣寺")))
+	a.Append(ctx, day.Add(24*time.Hour), callback(textEvent("e4", "m3", "伏見稲荷")))
+
+	s := store.NewMemory()
+	// m1 はライブの Webhook で保存済み
+	s.Append(ctx, store.Message{GroupID: "C1", MessageID: "m1", WebhookEventID: "e1", Message: "清水寺"})
+
+	var reports []Progress
+	rb := &Rebuilder{Archive: a, Store: s, Until: day.Add(2 * time.Hour), OnProgress: func(p Progress) {
+		reports = append(reports, p)
+	}}
+	p, err := rb.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Buckets != 2 || p.Callbacks != 3 || p.Events != 4 || p.Messages != 1 || p.Skipped != 2 || p.Memberships != 1 || p.Failed != 0 {
+		t.Errorf("progress = %+v", p)
+	}
+	if len(reports) != 2 || reports[0].Bucket != "2026-10-18T09" || reports[1].BucketsDone != 2 {
+		t.Errorf("reports = %+v", reports)
+	}
+
+	messages, _ := store.All(ctx, s, "C1")
+	if len(messages) != 2 || messages[1].Message != "金閣寺" {
+		t.Fatalf("messages after rebuild = %+v", messages)
+	}
+	if members, _ := s.Members(ctx, "C1"); fmt.Sprint(members) != "[U1 U2]" {
+		t.Errorf("members = %v", members)
+	}
+	// 再構築はヘルスチェックの件数に数えない
+	if counters, _ := s.Counters(ctx); len(counters) != 0 {
+		t.Errorf("live counters touched: %v", counters)
+	}
+
+	// もう一度流しても何も増えない
+	rb.Until = time.Time{}
+	p, err = rb.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Messages != 1 || p.Skipped != 3 {
+		t.Errorf("second run = %+v", p)
+	}
+	if messages, _ := store.All(ctx, s, "C1"); len(messages) != 3 {
+		t.Errorf("messages after second rebuild = %d", len(messages))
+	}
+}
+
+func TestRebuildTripItems(t *testing.T) {
+	ctx := context.Background()
+	a := archive.NewFS(t.TempDir())
+	day := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	a.Append(ctx, day, callback(textEvent("e1", "m1", "/add 清水寺"), textEvent("e2", "m2", "/add 金閣寺")))
+	a.Append(ctx, day.Add(time.Hour), callback(textEvent("e3", "m3", "/done 2"), textEvent("e4", "m4", "/list")))
+
+	// メッセージは保存済みだが、リストはまだない（機能追加前の履歴）
+	s := store.NewMemory()
+	for i, text := range []string{"/add 清水寺", "/add 金閣寺", "/done 2", "/list"} {
+		s.Append(ctx, store.Message{GroupID: "C1", MessageID: fmt.Sprintf("m%d", i+1), WebhookEventID: fmt.Sprintf("e%d", i+1), Message: text})
+	}
+	trips := trip.NewMemory()
+	rb := &Rebuilder{Archive: a, Store: s, NewIngester: func(s store.MessageStore) *ingest.Ingester {
+		in := ingest.New(s)
+		in.Commands = command.NewRegistry()
+		trip.Register(in.Commands, trips, nil)
+		return in
+	}}
+
+	for run := 1; run <= 2; run++ {
+		p, err := rb.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Messages != 0 || p.Skipped != 4 || p.TripItems != 3 {
+			t.Errorf("run %d: %+v", run, p)
+		}
+		items, _ := trips.Items(ctx, "C1")
+		if fmt.Sprintf("%d %s %v", len(items), items[0].Title, items[1].Done) != "2 清水寺 true" {
+			t.Errorf("run %d: items = %+v", run, items)
+		}
+	}
+}
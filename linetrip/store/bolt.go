@@ -0,0 +1,484 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// messagesBucket holds one nested bucket per group.
+	messagesBucket = []byte("messages")
+	// groupsBucket maps group ID to its JSON-encoded Group index entry.
+	groupsBucket = []byte("groups")
+	// userGroupsBucket holds one nested bucket per user listing group IDs;
+	// membersBucket holds the reverse, one nested bucket per group.
+	userGroupsBucket = []byte("user_groups")
+	membersBucket    = []byte("members")
+	// claimsBucket maps a claimed key to its expiry; claimExpiryBucket
+	// orders the same keys by expiry so expired ones can be pruned cheaply.
+	claimsBucket      = []byte("claims")
+	claimExpiryBucket = []byte("claim_expiry")
+	countersBucket    = []byte("counters")
+	// tombstonesBucket holds one nested bucket per group mapping LINE
+	// message ID to the unsend timestamp.
+	tombstonesBucket = []byte("tombstones")
+	// profilesBucket holds one nested bucket per group mapping user ID to a
+	// JSON-encoded Profile.
+	profilesBucket = []byte("profiles")
+	// replyTokensBucket maps a conversation ID to the expiry of its reply
+	// token followed by the token.
+	replyTokensBucket = []byte("reply_tokens")
+)
+
+// Bolt stores messages in an embedded bbolt database file. Keys are the
+// bucket sequence encoded big-endian, so cursor order is append order.
+type Bolt struct {
+	Retention int
+
+	db *bolt.DB
+}
+
+// OpenBolt opens (or creates) the database at path.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{messagesBucket, groupsBucket, userGroupsBucket, claimsBucket, claimExpiryBucket, countersBucket, tombstonesBucket, profilesBucket, membersBucket, replyTokensBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{Retention: DefaultRetention, db: db}, nil
+}
+
+func boltKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+func (s *Bolt) Append(ctx context.Context, m Message) (Message, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(messagesBucket).CreateBucketIfNotExists([]byte(m.GroupID))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		m.ID = ""
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(boltKey(seq), data); err != nil {
+			return err
+		}
+		m.ID = strconv.FormatUint(seq, 10)
+
+		if s.Retention > 0 && seq > uint64(s.Retention) {
+			if err := trimBefore(b, seq-uint64(s.Retention)+1); err != nil {
+				return err
+			}
+		}
+
+		if err := touchGroup(tx.Bucket(groupsBucket), m); err != nil {
+			return err
+		}
+		if m.UserID != "" {
+			return addMember(tx, m.GroupID, m.UserID)
+		}
+		return nil
+	})
+	return m, err
+}
+
+// addMember records membership in both directions.
+func addMember(tx *bolt.Tx, groupID, userID string) error {
+	ub, err := tx.Bucket(userGroupsBucket).CreateBucketIfNotExists([]byte(userID))
+	if err != nil {
+		return err
+	}
+	if err := ub.Put([]byte(groupID), nil); err != nil {
+		return err
+	}
+	mb, err := tx.Bucket(membersBucket).CreateBucketIfNotExists([]byte(groupID))
+	if err != nil {
+		return err
+	}
+	return mb.Put([]byte(userID), nil)
+}
+
+func touchGroup(b *bolt.Bucket, m Message) error {
+	g := Group{ID: m.GroupID}
+	if v := b.Get([]byte(m.GroupID)); v != nil {
+		if err := json.Unmarshal(v, &g); err != nil {
+			return err
+		}
+	}
+	g.touch(m)
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(m.GroupID), data)
+}
+
+// trimBefore deletes every key below the given sequence number.
+func trimBefore(b *bolt.Bucket, seq uint64) error {
+	limit := boltKey(seq)
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Bolt) Range(ctx context.Context, groupID, after string, count int) ([]Message, error) {
+	var start uint64 = 1
+	if after != "" {
+		n, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		start = n + 1
+	}
+
+	var out []Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(boltKey(start)); k != nil; k, v = c.Next() {
+			if count > 0 && len(out) >= count {
+				break
+			}
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			m.ID = strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+			out = append(out, m)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *Bolt) RangeBefore(ctx context.Context, groupID, before string, count int) ([]Message, error) {
+	var out []Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		var k, v []byte
+		if before == "" {
+			k, v = c.Last()
+		} else {
+			n, err := strconv.ParseUint(before, 10, 64)
+			if err != nil {
+				return err
+			}
+			// Seek は before 以上の最初のキーに移動するので、その1つ前から読む
+			if k, _ = c.Seek(boltKey(n)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil; k, v = c.Prev() {
+			if count > 0 && len(out) >= count {
+				break
+			}
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			m.ID = strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+			out = append(out, m)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *Bolt) Tombstone(ctx context.Context, t Tombstone) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(tombstonesBucket).CreateBucketIfNotExists([]byte(t.GroupID))
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(t.MessageID)) != nil {
+			return nil
+		}
+		return b.Put([]byte(t.MessageID), boltKey(uint64(t.DeletedAt)))
+	})
+}
+
+func (s *Bolt) Tombstones(ctx context.Context, groupID string) ([]Tombstone, error) {
+	var out []Tombstone
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tombstonesBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			out = append(out, Tombstone{GroupID: groupID, MessageID: string(k), DeletedAt: int64(binary.BigEndian.Uint64(v))})
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Bolt) Profile(ctx context.Context, groupID, userID string) (Profile, bool, error) {
+	var p Profile
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(profilesBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(userID))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &p)
+	})
+	return p, ok, err
+}
+
+func (s *Bolt) SetProfile(ctx context.Context, groupID string, p Profile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(profilesBucket).CreateBucketIfNotExists([]byte(groupID))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(p.UserID), data)
+	})
+}
+
+func (s *Bolt) Profiles(ctx context.Context, groupID string) ([]Profile, error) {
+	var out []Profile
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(profilesBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var p Profile
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			out = append(out, p)
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Bolt) Groups(ctx context.Context) ([]Group, error) {
+	var groups []Group
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(groupsBucket).ForEach(func(k, v []byte) error {
+			var g Group
+			if err := json.Unmarshal(v, &g); err != nil {
+				return err
+			}
+			groups = append(groups, g)
+			return nil
+		})
+	})
+	sortGroups(groups)
+	return groups, err
+}
+
+func (s *Bolt) UserGroups(ctx context.Context, userID string) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(userGroupsBucket).Bucket([]byte(userID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	sort.Strings(ids)
+	return ids, err
+}
+
+func (s *Bolt) AddMembers(ctx context.Context, groupID string, userIDs ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range userIDs {
+			if err := addMember(tx, groupID, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Bolt) RemoveMembers(ctx context.Context, groupID string, userIDs ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		mb := tx.Bucket(membersBucket).Bucket([]byte(groupID))
+		for _, id := range userIDs {
+			if ub := tx.Bucket(userGroupsBucket).Bucket([]byte(id)); ub != nil {
+				if err := ub.Delete([]byte(groupID)); err != nil {
+					return err
+				}
+			}
+			if mb != nil {
+				if err := mb.Delete([]byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Bolt) Members(ctx context.Context, groupID string) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(membersBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	sort.Strings(ids)
+	return ids, err
+}
+
+func (s *Bolt) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	claimed := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		claims := tx.Bucket(claimsBucket)
+		expiry := tx.Bucket(claimExpiryBucket)
+		now := time.Now()
+
+		// 期限切れのキーを先頭から削除
+		c := expiry.Cursor()
+		limit := boltKey(uint64(now.UnixNano()))
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:8], limit) < 0; k, _ = c.First() {
+			if err := claims.Delete(k[8:]); err != nil {
+				return err
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+
+		if claims.Get([]byte(key)) != nil {
+			return nil
+		}
+		exp := boltKey(uint64(now.Add(ttl).UnixNano()))
+		if err := claims.Put([]byte(key), exp); err != nil {
+			return err
+		}
+		claimed = true
+		return expiry.Put(append(exp, key...), nil)
+	})
+	return claimed, err
+}
+
+func (s *Bolt) Release(ctx context.Context, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		claims := tx.Bucket(claimsBucket)
+		exp := claims.Get([]byte(key))
+		if exp == nil {
+			return nil
+		}
+		if err := tx.Bucket(claimExpiryBucket).Delete(append(append([]byte(nil), exp...), key...)); err != nil {
+			return err
+		}
+		return claims.Delete([]byte(key))
+	})
+}
+
+func (s *Bolt) IncrCounter(ctx context.Context, name string, delta int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(countersBucket)
+		var n int64
+		if v := b.Get([]byte(name)); v != nil {
+			n = int64(binary.BigEndian.Uint64(v))
+		}
+		return b.Put([]byte(name), boltKey(uint64(n+delta)))
+	})
+}
+
+func (s *Bolt) Counters(ctx context.Context) (map[string]int64, error) {
+	out := make(map[string]int64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(countersBucket).ForEach(func(k, v []byte) error {
+			out[string(k)] = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	return out, err
+}
+
+// DB returns the underlying database, for packages keeping their own
+// buckets in the same file. bbolt locks the file, so it cannot be opened
+// twice.
+func (s *Bolt) SaveReplyToken(ctx context.Context, conversationID, token string, expires time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		v := append(boltKey(uint64(expires.UnixNano())), token...)
+		return tx.Bucket(replyTokensBucket).Put([]byte(conversationID), v)
+	})
+}
+
+func (s *Bolt) TakeReplyToken(ctx context.Context, conversationID string) (string, error) {
+	var token string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(replyTokensBucket)
+		v := b.Get([]byte(conversationID))
+		if len(v) < 8 {
+			return nil
+		}
+		if time.Now().UnixNano() < int64(binary.BigEndian.Uint64(v[:8])) {
+			token = string(v[8:])
+		}
+		return b.Delete([]byte(conversationID))
+	})
+	return token, err
+}
+
+func (s *Bolt) DB() *bolt.DB {
+	return s.db
+}
+
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,37 @@
+package store
+
+import "strings"
+
+// Conversation types. Messages and groups are keyed by conversation ID: a
+// LINE group ID (C...), room ID (R...) or, for 1:1 chats with the bot, the
+// user ID (U...). The GroupID fields keep their name for API compatibility.
+const (
+	ConversationGroup = "group"
+	ConversationRoom  = "room"
+	ConversationUser  = "user"
+)
+
+// ConversationTypeOf derives the conversation type from a LINE ID prefix.
+// It returns "" for IDs it does not recognise.
+func ConversationTypeOf(id string) string {
+	switch {
+	case strings.HasPrefix(id, "C"):
+		return ConversationGroup
+	case strings.HasPrefix(id, "R"):
+		return ConversationRoom
+	case strings.HasPrefix(id, "U"):
+		return ConversationUser
+	default:
+		return ""
+	}
+}
+
+// fillConversationTypes sets ConversationType on messages stored before the
+// field existed.
+func fillConversationTypes(messages []Message) {
+	for i := range messages {
+		if messages[i].ConversationType == "" {
+			messages[i].ConversationType = ConversationTypeOf(messages[i].GroupID)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory keeps messages in process memory. Data is lost on restart, so it is
+// meant for local development and tests.
+type Memory struct {
+	Retention int
+
+	mu         sync.Mutex
+	seq        uint64
+	messages   map[string][]Message
+	groups     map[string]*Group
+	userGroups map[string]map[string]struct{}
+	members    map[string]map[string]struct{}
+	tombstones map[string][]Tombstone
+	profiles   map[string]map[string]Profile
+	claims     map[string]time.Time
+	counters   map[string]int64
+	tokens     map[string]replyToken
+}
+
+// replyToken is a reply token kept by Memory.
+type replyToken struct {
+	token   string
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		Retention:  DefaultRetention,
+		messages:   make(map[string][]Message),
+		groups:     make(map[string]*Group),
+		userGroups: make(map[string]map[string]struct{}),
+		members:    make(map[string]map[string]struct{}),
+		tombstones: make(map[string][]Tombstone),
+		profiles:   make(map[string]map[string]Profile),
+		claims:     make(map[string]time.Time),
+		counters:   make(map[string]int64),
+		tokens:     make(map[string]replyToken),
+	}
+}
+
+func (s *Memory) Append(ctx context.Context, m Message) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	m.ID = strconv.FormatUint(s.seq, 10)
+	log := append(s.messages[m.GroupID], m)
+	if s.Retention > 0 && len(log) > s.Retention {
+		log = append([]Message(nil), log[len(log)-s.Retention:]...)
+	}
+	s.messages[m.GroupID] = log
+
+	g, ok := s.groups[m.GroupID]
+	if !ok {
+		g = &Group{ID: m.GroupID}
+		s.groups[m.GroupID] = g
+	}
+	g.touch(m)
+
+	if m.UserID != "" {
+		s.addMember(m.GroupID, m.UserID)
+	}
+	return m, nil
+}
+
+// addMember records membership in both directions; s.mu must be held.
+func (s *Memory) addMember(groupID, userID string) {
+	if s.userGroups[userID] == nil {
+		s.userGroups[userID] = make(map[string]struct{})
+	}
+	s.userGroups[userID][groupID] = struct{}{}
+	if s.members[groupID] == nil {
+		s.members[groupID] = make(map[string]struct{})
+	}
+	s.members[groupID][userID] = struct{}{}
+}
+
+func (s *Memory) Range(ctx context.Context, groupID, after string, count int) ([]Message, error) {
+	var afterSeq uint64
+	if after != "" {
+		n, err := strconv.ParseUint(after, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		afterSeq = n
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []Message
+	for _, m := range s.messages[groupID] {
+		if count > 0 && len(out) >= count {
+			break
+		}
+		if seq, _ := strconv.ParseUint(m.ID, 10, 64); seq > afterSeq {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func (s *Memory) RangeBefore(ctx context.Context, groupID, before string, count int) ([]Message, error) {
+	beforeSeq := ^uint64(0)
+	if before != "" {
+		n, err := strconv.ParseUint(before, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		beforeSeq = n
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []Message
+	log := s.messages[groupID]
+	for i := len(log) - 1; i >= 0; i-- {
+		if count > 0 && len(out) >= count {
+			break
+		}
+		if seq, _ := strconv.ParseUint(log[i].ID, 10, 64); seq < beforeSeq {
+			out = append(out, log[i])
+		}
+	}
+	return out, nil
+}
+
+func (s *Memory) Tombstone(ctx context.Context, t Tombstone) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.tombstones[t.GroupID] {
+		if existing.MessageID == t.MessageID {
+			return nil
+		}
+	}
+	s.tombstones[t.GroupID] = append(s.tombstones[t.GroupID], t)
+	return nil
+}
+
+func (s *Memory) Tombstones(ctx context.Context, groupID string) ([]Tombstone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Tombstone(nil), s.tombstones[groupID]...), nil
+}
+
+func (s *Memory) Profile(ctx context.Context, groupID, userID string) (Profile, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.profiles[groupID][userID]
+	return p, ok, nil
+}
+
+func (s *Memory) SetProfile(ctx context.Context, groupID string, p Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.profiles[groupID] == nil {
+		s.profiles[groupID] = make(map[string]Profile)
+	}
+	s.profiles[groupID][p.UserID] = p
+	return nil
+}
+
+func (s *Memory) Profiles(ctx context.Context, groupID string) ([]Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Profile, 0, len(s.profiles[groupID]))
+	for _, p := range s.profiles[groupID] {
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (s *Memory) Groups(ctx context.Context) ([]Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := make([]Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, *g)
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+func (s *Memory) UserGroups(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id := range s.userGroups[userID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *Memory) AddMembers(ctx context.Context, groupID string, userIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range userIDs {
+		s.addMember(groupID, id)
+	}
+	return nil
+}
+
+func (s *Memory) RemoveMembers(ctx context.Context, groupID string, userIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range userIDs {
+		delete(s.userGroups[id], groupID)
+		delete(s.members[groupID], id)
+	}
+	return nil
+}
+
+func (s *Memory) Members(ctx context.Context, groupID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id := range s.members[groupID] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *Memory) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, exp := range s.claims {
+		if !now.Before(exp) {
+			delete(s.claims, k)
+		}
+	}
+	if _, ok := s.claims[key]; ok {
+		return false, nil
+	}
+	s.claims[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *Memory) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, key)
+	return nil
+}
+
+func (s *Memory) IncrCounter(ctx context.Context, name string, delta int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[name] += delta
+	return nil
+}
+
+func (s *Memory) Counters(ctx context.Context) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int64, len(s.counters))
+	for k, v := range s.counters {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (s *Memory) SaveReplyToken(ctx context.Context, conversationID, token string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[conversationID] = replyToken{token: token, expires: expires}
+	return nil
+}
+
+func (s *Memory) TakeReplyToken(ctx context.Context, conversationID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[conversationID]
+	delete(s.tokens, conversationID)
+	if !ok || !time.Now().Before(t.expires) {
+		return "", nil
+	}
+	return t.token, nil
+}
+
+func (s *Memory) Close() error {
+	return nil
+}
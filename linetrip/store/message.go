@@ -0,0 +1,144 @@
+// Package store persists the LINE messages received through the webhook.
+package store
+
+// Message types. Entries stored before media support have no type and are
+// text messages.
+const (
+	TypeText     = "text"
+	TypeImage    = "image"
+	TypeVideo    = "video"
+	TypeAudio    = "audio"
+	TypeFile     = "file"
+	TypeLocation = "location"
+)
+
+// Directions of a timeline entry.
+const (
+	// DirectionInbound entries came in through the webhook. Entries stored
+	// before outbound messages were kept have no direction and are inbound.
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+)
+
+// Delivery statuses of outbound entries.
+const (
+	// DeliverySent messages were accepted by LINE when they were sent.
+	DeliverySent = "sent"
+	// DeliveryAccepted messages were resubmitted with the retry key of an
+	// earlier attempt LINE had already accepted.
+	DeliveryAccepted = "accepted"
+)
+
+// Message represents a stored LINE message.
+type Message struct {
+	// ID is assigned by the store when the message is appended and doubles as
+	// a position in the log.
+	ID string `json:"id,omitempty"`
+	// MessageID and WebhookEventID come from LINE and are used to drop
+	// redelivered webhooks.
+	MessageID      string `json:"message_id,omitempty"`
+	WebhookEventID string `json:"webhook_event_id,omitempty"`
+	// GroupID is the conversation ID: a group, room or user ID.
+	GroupID          string `json:"group_id"`
+	ConversationType string `json:"conversation_type"`
+	UserID           string `json:"user_id"`
+	Message          string `json:"message"`
+	// UserName is the sender's LINE display name, or FallbackName when the
+	// profile could not be fetched.
+	UserName   string `json:"user_name"`
+	PictureURL string `json:"picture_url,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+	Type       string `json:"type,omitempty"`
+	// TripID is the trip that was active in the conversation when the
+	// message arrived.
+	TripID string `json:"trip_id,omitempty"`
+	// Mentions are the users mentioned in a text message.
+	Mentions []Mention `json:"mentions,omitempty"`
+	// Content describes the binary content of image, video, audio and file
+	// messages.
+	Content *Content `json:"content,omitempty"`
+	// Location is set for location messages.
+	Location *Location `json:"location,omitempty"`
+	// Deleted is set when the sender unsent the message in LINE. The text is
+	// cleared and DeletedAt holds the unsend event timestamp.
+	Deleted   bool  `json:"deleted,omitempty"`
+	DeletedAt int64 `json:"deleted_at,omitempty"`
+	// Direction is DirectionOutbound for messages the app sent through the
+	// bot, which have no UserID.
+	Direction string `json:"direction,omitempty"`
+	// SentBy is the LINE user ID of the app user who sent an outbound
+	// message.
+	SentBy string `json:"sent_by,omitempty"`
+	// Delivery describes how an outbound message was sent.
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// Delivery is the outcome of sending an outbound message.
+type Delivery struct {
+	// Method is "reply" or "push".
+	Method string `json:"method"`
+	Status string `json:"status"`
+	// SentMessageIDs are the IDs LINE gave the sent messages, in order.
+	SentMessageIDs []string `json:"sent_message_ids"`
+	RetryKey       string   `json:"retry_key,omitempty"`
+}
+
+// Mention is an @mention in a text message.
+type Mention struct {
+	// UserID is empty for @All and for users who have not let the bot see
+	// their profile.
+	UserID string `json:"user_id,omitempty"`
+	All    bool   `json:"all,omitempty"`
+	// Text is the mention as written, e.g. "@たくと".
+	Text string `json:"text"`
+}
+
+// Content is the binary part of a media message. Key locates it in the
+// attachment store; URL is filled in when serving, or holds the original
+// URL for content LINE only references (contentProvider "external").
+type Content struct {
+	Key         string `json:"key,omitempty"`
+	URL         string `json:"url,omitempty"`
+	PreviewURL  string `json:"preview_url,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	FileName    string `json:"file_name,omitempty"`
+	// Duration is the length of video and audio in milliseconds.
+	Duration int64 `json:"duration,omitempty"`
+}
+
+// Location is a place shared as a LINE location message.
+type Location struct {
+	Title     string  `json:"title,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Tombstone records that a message was unsent. Logs are append-only, so the
+// original entry stays in place and is redacted when read.
+type Tombstone struct {
+	GroupID   string `json:"group_id"`
+	MessageID string `json:"message_id"`
+	DeletedAt int64  `json:"deleted_at"`
+}
+
+// redact applies tombstones to messages in place.
+func redact(messages []Message, tombstones []Tombstone) {
+	if len(tombstones) == 0 {
+		return
+	}
+	deleted := make(map[string]int64, len(tombstones))
+	for _, t := range tombstones {
+		deleted[t.MessageID] = t.DeletedAt
+	}
+	for i := range messages {
+		if at, ok := deleted[messages[i].MessageID]; ok && messages[i].MessageID != "" {
+			messages[i].Message = ""
+			messages[i].Content = nil
+			messages[i].Location = nil
+			messages[i].Deleted = true
+			messages[i].DeletedAt = at
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Query selects a page of messages across one or more groups.
+//
+// Without Before or After and with Limit set, the newest Limit messages are
+// returned. After pages forward (newer), Before pages backward (older). With
+// neither a cursor nor a limit the whole history is returned, which is what
+// /api/messages did before pagination existed.
+type Query struct {
+	// GroupIDs restricts the query; nil means every group in the index.
+	GroupIDs []string
+	// ConversationType, when set, keeps only conversations of that type
+	// (group, room or user).
+	ConversationType string
+	Limit            int
+	Before           string
+	After            string
+	// Since and Until bound Message.Timestamp (milliseconds, inclusive).
+	// Zero means unbounded.
+	Since int64
+	Until int64
+	// TripID, when set, keeps only messages attached to that trip.
+	TripID string
+	// UserID, when set and GroupIDs is nil, restricts the query to the
+	// conversations the user is a member of, including those they never
+	// spoke in.
+	UserID string
+}
+
+// Page is one page of a Query result. Messages are always oldest first.
+type Page struct {
+	Messages []Message `json:"messages"`
+	// NextCursor continues in the direction of the query: pass it as
+	// Before when paging backward, or as After when paging forward. It is
+	// empty once backward paging reaches the beginning.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// AfterCursor points just past the newest message seen, for polling
+	// newer messages later. It is not set when paging backward with Before.
+	AfterCursor string `json:"after_cursor,omitempty"`
+	HasMore     bool   `json:"has_more"`
+	// Deleted lists messages unsent since the After cursor was issued, so a
+	// polling client can drop messages it already holds. Messages in the
+	// page itself are redacted instead.
+	Deleted []Tombstone `json:"deleted"`
+}
+
+// cursor is a position in each group's log. Group IDs missing from the map
+// are read from the start (forward) or the end (backward).
+type cursor map[string]string
+
+// deletedKey holds the newest tombstone timestamp a cursor has reported.
+// Group IDs never start with "~", so it cannot clash with a group position.
+const deletedKey = "~deleted"
+
+func (c cursor) deletedSince() int64 {
+	n, _ := strconv.ParseInt(c[deletedKey], 10, 64)
+	return n
+}
+
+func decodeCursor(s string) (cursor, error) {
+	c := cursor{}
+	if s == "" {
+		return c, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return c, nil
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (c cursor) clone() cursor {
+	out := make(cursor, len(c))
+	for k, v := range c {
+		out[k] = v
+	}
+	return out
+}
+
+func (q Query) backward() bool {
+	return q.Before != "" || (q.After == "" && q.Limit > 0)
+}
+
+func (q Query) matches(m Message) bool {
+	return (q.Since == 0 || m.Timestamp >= q.Since) && (q.Until == 0 || m.Timestamp <= q.Until) &&
+		(q.TripID == "" || m.TripID == q.TripID)
+}
+
+// Paginate runs q against s.
+func Paginate(ctx context.Context, s MessageStore, q Query) (*Page, error) {
+	if q.Before != "" && q.After != "" {
+		return nil, fmt.Errorf("before and after cannot be combined")
+	}
+	backward := q.backward()
+	token := q.After
+	if backward {
+		token = q.Before
+	}
+	positions, err := decodeCursor(token)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs := q.GroupIDs
+	if groupIDs == nil && q.UserID != "" {
+		ids, err := s.UserGroups(ctx, q.UserID)
+		if err != nil {
+			return nil, err
+		}
+		// 所属がなければ空のページ
+		groupIDs = append([]string{}, ids...)
+	}
+	if groupIDs == nil {
+		groups, err := s.Groups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			groupIDs = append(groupIDs, g.ID)
+		}
+	}
+	if q.ConversationType != "" {
+		var filtered []string
+		for _, id := range groupIDs {
+			if ConversationTypeOf(id) == q.ConversationType {
+				filtered = append(filtered, id)
+			}
+		}
+		groupIDs = filtered
+	}
+
+	// 各グループから最大 Limit+1 件ずつ集め、時刻順にマージしてから切り詰める
+	want := 0
+	if q.Limit > 0 {
+		want = q.Limit + 1
+	}
+	var candidates []Message
+	var tombstones []Tombstone
+	newest := cursor{}
+	for _, id := range groupIDs {
+		msgs, err := collect(ctx, s, q, id, positions[id], backward, want)
+		if err != nil {
+			return nil, err
+		}
+		if backward && len(msgs) > 0 {
+			newest[id] = msgs[0].ID
+		}
+
+		ts, err := s.Tombstones(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		tombstones = append(tombstones, ts...)
+
+		profiles, err := s.Profiles(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		applyProfiles(msgs, profiles)
+		candidates = append(candidates, msgs...)
+	}
+	redact(candidates, tombstones)
+	fillConversationTypes(candidates)
+
+	// 取消はログの後ろに追記されないので、カーソルに最後に報告した時刻を持たせる
+	since := positions.deletedSince()
+	watermark := since
+	deleted := []Tombstone{}
+	for _, t := range tombstones {
+		if t.DeletedAt > since && q.After != "" {
+			deleted = append(deleted, t)
+		}
+		if t.DeletedAt > watermark {
+			watermark = t.DeletedAt
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].DeletedAt < deleted[j].DeletedAt })
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Timestamp != b.Timestamp {
+			return (a.Timestamp < b.Timestamp) != backward
+		}
+		return (a.GroupID < b.GroupID) != backward
+	})
+
+	page := &Page{Deleted: deleted}
+	if q.Limit > 0 && len(candidates) > q.Limit {
+		page.HasMore = true
+		candidates = candidates[:q.Limit]
+	}
+
+	// 並び順に最後まで辿ると、各グループの「最も進んだ位置」が残る
+	next := positions.clone()
+	for _, m := range candidates {
+		next[m.GroupID] = m.ID
+	}
+	if watermark > 0 {
+		next[deletedKey] = strconv.FormatInt(watermark, 10)
+		newest[deletedKey] = next[deletedKey]
+	}
+
+	if backward {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+		if page.HasMore {
+			page.NextCursor = next.encode()
+		}
+		if q.Before == "" {
+			page.AfterCursor = newest.encode()
+		}
+	} else {
+		page.NextCursor = next.encode()
+		page.AfterCursor = page.NextCursor
+	}
+
+	page.Messages = candidates
+	if page.Messages == nil {
+		page.Messages = []Message{}
+	}
+	return page, nil
+}
+
+// collect reads up to want messages of one group that match q, starting at
+// pos. A want of zero reads to the end of the log. Logs are in arrival order,
+// which follows event time closely, so reading stops once the Since/Until
+// bound in the reading direction is passed.
+func collect(ctx context.Context, s MessageStore, q Query, groupID, pos string, backward bool, want int) ([]Message, error) {
+	chunk := rangeChunk
+	if want > 0 && want < chunk && q.Since == 0 && q.Until == 0 && q.TripID == "" {
+		chunk = want
+	}
+
+	var out []Message
+	for {
+		var msgs []Message
+		var err error
+		if backward {
+			msgs, err = s.RangeBefore(ctx, groupID, pos, chunk)
+		} else {
+			msgs, err = s.Range(ctx, groupID, pos, chunk)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range msgs {
+			if backward && q.Since != 0 && m.Timestamp < q.Since {
+				return out, nil
+			}
+			if !backward && q.Until != 0 && m.Timestamp > q.Until {
+				return out, nil
+			}
+			if q.matches(m) {
+				out = append(out, m)
+				if want > 0 && len(out) >= want {
+					return out, nil
+				}
+			}
+		}
+		if len(msgs) < chunk {
+			return out, nil
+		}
+		pos = msgs[len(msgs)-1].ID
+	}
+}
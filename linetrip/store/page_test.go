@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func texts(messages []Message) string {
+	var out []string
+	for _, m := range messages {
+		out = append(out, m.Message)
+	}
+	return fmt.Sprint(out)
+}
+
+func TestPaginate(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+			for i := 0; i < 7; i++ {
+				group := []string{"C1", "C2"}[i%2]
+				if _, err := s.Append(ctx, Message{GroupID: group, Message: fmt.Sprint(i), Timestamp: int64(1000 * (i + 1))}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// 最新3件
+			page, err := Paginate(ctx, s, Query{Limit: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if texts(page.Messages) != "[4 5 6]" || !page.HasMore || page.NextCursor == "" {
+				t.Fatalf("latest page = %s has_more=%v", texts(page.Messages), page.HasMore)
+			}
+			after := page.AfterCursor
+
+			// 古い方へ辿る
+			page, err = Paginate(ctx, s, Query{Limit: 3, Before: page.NextCursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if texts(page.Messages) != "[1 2 3]" || !page.HasMore {
+				t.Fatalf("second page = %s", texts(page.Messages))
+			}
+			page, err = Paginate(ctx, s, Query{Limit: 3, Before: page.NextCursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if texts(page.Messages) != "[0]" || page.HasMore || page.NextCursor != "" {
+				t.Fatalf("last page = %s has_more=%v", texts(page.Messages), page.HasMore)
+			}
+
+			// 新着のポーリング
+			page, err = Paginate(ctx, s, Query{After: after})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Messages) != 0 || page.NextCursor == "" {
+				t.Fatalf("poll with no news = %s", texts(page.Messages))
+			}
+			for _, m := range []Message{{GroupID: "C2", Message: "7", Timestamp: 8000}, {GroupID: "C3", Message: "8", Timestamp: 9000}} {
+				if _, err := s.Append(ctx, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, err = Paginate(ctx, s, Query{After: page.NextCursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if texts(page.Messages) != "[7 8]" {
+				t.Fatalf("poll = %s", texts(page.Messages))
+			}
+
+			// 時刻範囲とグループ指定
+			page, err = Paginate(ctx, s, Query{GroupIDs: []string{"C1"}, Since: 2000, Until: 5000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if texts(page.Messages) != "[2 4]" {
+				t.Fatalf("since/until = %s", texts(page.Messages))
+			}
+		})
+	}
+}
+
+func TestPaginateRejectsBadCursor(t *testing.T) {
+	s := NewMemory()
+	if _, err := Paginate(context.Background(), s, Query{After: "not a cursor"}); err == nil {
+		t.Error("expected error for invalid cursor")
+	}
+	if _, err := Paginate(context.Background(), s, Query{After: "e30", Before: "e30"}); err == nil {
+		t.Error("expected error for before+after")
+	}
+}
+
+func TestPaginateReportsUnsent(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+			for i, id := range []string{"m1", "m2", "m3"} {
+				if _, err := s.Append(ctx, Message{GroupID: "C1", MessageID: id, Message: id, Timestamp: int64(1000 * (i + 1))}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			page, err := Paginate(ctx, s, Query{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			after := page.AfterCursor
+
+			// 同じ取消が再送されても一度だけ記録される
+			for i := 0; i < 2; i++ {
+				if err := s.Tombstone(ctx, Tombstone{GroupID: "C1", MessageID: "m2", DeletedAt: 5000}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			page, err = Paginate(ctx, s, Query{After: after})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Deleted) != 1 || page.Deleted[0].MessageID != "m2" || page.Deleted[0].DeletedAt != 5000 {
+				t.Fatalf("deleted = %+v", page.Deleted)
+			}
+
+			// 一度報告した取消は次のポーリングでは返らない
+			page, err = Paginate(ctx, s, Query{After: page.AfterCursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Deleted) != 0 {
+				t.Fatalf("deleted reported twice: %+v", page.Deleted)
+			}
+
+			page, err = Paginate(ctx, s, Query{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := page.Messages[1]
+			if !m.Deleted || m.Message != "" || m.DeletedAt != 5000 || page.Messages[0].Deleted {
+				t.Fatalf("messages = %+v", page.Messages)
+			}
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	parse := func(raw string) (Query, error) {
+		params, _ := url.ParseQuery(raw)
+		return ParseQuery(params)
+	}
+	q, err := parse("group_id=C1&line_id=U1&limit=50&after=abc&since=2025-05-03T00:00:00Z&until=1746300000000&conversation_type=group&trip_id=C1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(q.GroupIDs) != "[C1]" || q.UserID != "" || q.Limit != 50 || q.After != "abc" || q.Since != 1746230400000 || q.Until != 1746300000000 || q.ConversationType != ConversationGroup || q.TripID != "C1-1" {
+		t.Errorf("query = %+v", q)
+	}
+	if q, _ := parse("line_id=U1"); q.GroupIDs != nil || q.UserID != "U1" {
+		t.Errorf("line_id query = %+v", q)
+	}
+	for _, raw := range []string{"limit=0", "limit=1001", "limit=x", "before=a&after=b", "conversation_type=dm", "since=yesterday"} {
+		if _, err := parse(raw); err == nil {
+			t.Errorf("%s accepted", raw)
+		}
+	}
+}
+
+func TestPaginateMember(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	s.Append(ctx, Message{GroupID: "C1", UserID: "U1", Message: "a", Timestamp: 1})
+	s.Append(ctx, Message{GroupID: "C2", UserID: "U2", Message: "b", Timestamp: 2})
+	s.AddMembers(ctx, "C3", "U1")
+	s.Append(ctx, Message{GroupID: "C3", UserID: "U3", Message: "c", Timestamp: 3})
+
+	// 発言していないグループもメンバー登録から含める
+	page, err := Paginate(ctx, s, Query{UserID: "U1"})
+	if err != nil || texts(page.Messages) != "[a c]" {
+		t.Errorf("member page = %v, %v", page, err)
+	}
+	page, err = Paginate(ctx, s, Query{UserID: "U9"})
+	if err != nil || len(page.Messages) != 0 {
+		t.Errorf("non-member page = %v, %v", page, err)
+	}
+	if body := page.Response(); body["count"] != 0 || body["has_more"] != false {
+		t.Errorf("response = %v", body)
+	}
+}
@@ -0,0 +1,46 @@
+package store
+
+import "fmt"
+
+// Profile is a group member's LINE profile as returned by
+// GetGroupMemberProfile. FetchedAt (milliseconds) lets callers decide when
+// to refresh it.
+type Profile struct {
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	PictureURL  string `json:"picture_url,omitempty"`
+	FetchedAt   int64  `json:"fetched_at"`
+}
+
+// FallbackName is the name stored for a sender whose profile is unknown.
+func FallbackName(userID string) string {
+	if userID == "" {
+		return "Unknown User"
+	}
+	if len(userID) > 8 {
+		return fmt.Sprintf("User-%s", userID[:8])
+	}
+	return fmt.Sprintf("User-%s", userID)
+}
+
+// applyProfiles fills in the display name and picture of messages stored
+// before their sender's profile was known. Messages stored with a real name
+// keep it, so history shows the name used at the time.
+func applyProfiles(messages []Message, profiles []Profile) {
+	if len(profiles) == 0 {
+		return
+	}
+	byUser := make(map[string]Profile, len(profiles))
+	for _, p := range profiles {
+		byUser[p.UserID] = p
+	}
+	for i := range messages {
+		m := &messages[i]
+		p, ok := byUser[m.UserID]
+		if !ok || m.UserID == "" || m.UserName != FallbackName(m.UserID) {
+			continue
+		}
+		m.UserName = p.DisplayName
+		m.PictureURL = p.PictureURL
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxLimit is the largest page a /messages request may ask for.
+const MaxLimit = 1000
+
+// ParseQuery reads the parameters of a /messages request: group_id or
+// line_id, conversation_type, trip_id, limit, before/after cursors and
+// since/until bounds. Timestamps may be epoch milliseconds or RFC3339.
+func ParseQuery(params url.Values) (Query, error) {
+	query := Query{
+		Before: params.Get("before"),
+		After:  params.Get("after"),
+		TripID: params.Get("trip_id"),
+	}
+	if groupID := params.Get("group_id"); groupID != "" {
+		query.GroupIDs = []string{groupID}
+	} else {
+		query.UserID = params.Get("line_id")
+	}
+	if query.Before != "" && query.After != "" {
+		return query, fmt.Errorf("before and after cannot be combined")
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		query.Limit = n
+	}
+
+	switch t := params.Get("conversation_type"); t {
+	case "", ConversationGroup, ConversationRoom, ConversationUser:
+		query.ConversationType = t
+	default:
+		return query, fmt.Errorf("conversation_type must be group, room or user")
+	}
+
+	var err error
+	if query.Since, err = parseTimestamp(params.Get("since")); err != nil {
+		return query, fmt.Errorf("invalid since: %v", err)
+	}
+	if query.Until, err = parseTimestamp(params.Get("until")); err != nil {
+		return query, fmt.Errorf("invalid until: %v", err)
+	}
+	return query, nil
+}
+
+func parseTimestamp(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
+
+// Response is the JSON body /messages returns for p. Cursors are null
+// rather than absent when there is none.
+func (p *Page) Response() map[string]interface{} {
+	return map[string]interface{}{
+		"messages":     p.Messages,
+		"count":        len(p.Messages),
+		"has_more":     p.HasMore,
+		"next_cursor":  nullable(p.NextCursor),
+		"after_cursor": nullable(p.AfterCursor),
+		"deleted":      p.Deleted,
+	}
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,293 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// MessageStore is an append-only log of messages partitioned by group.
+// Implementations must be safe for concurrent use; IDs are opaque to callers
+// but increase with every append to a group so they can be used as positions
+// for Range.
+type MessageStore interface {
+	// Append adds m to the end of its group's log, updates the group index
+	// and returns m with its ID set.
+	Append(ctx context.Context, m Message) (Message, error)
+	// Range returns up to count messages of groupID stored after the given
+	// ID, oldest first, as appended: tombstones are not applied. An empty
+	// after starts from the beginning of the log and a count of zero means
+	// no limit.
+	Range(ctx context.Context, groupID, after string, count int) ([]Message, error)
+	// RangeBefore returns up to count messages of groupID stored before the
+	// given ID, newest first. An empty before starts from the end of the log.
+	RangeBefore(ctx context.Context, groupID, before string, count int) ([]Message, error)
+	// Tombstone records that a message was unsent.
+	Tombstone(ctx context.Context, t Tombstone) error
+	// Tombstones lists the tombstones recorded for groupID.
+	Tombstones(ctx context.Context, groupID string) ([]Tombstone, error)
+	// Profile returns the cached profile of userID in groupID.
+	Profile(ctx context.Context, groupID, userID string) (Profile, bool, error)
+	// SetProfile caches a member profile of groupID.
+	SetProfile(ctx context.Context, groupID string, p Profile) error
+	// Profiles lists the cached profiles of groupID.
+	Profiles(ctx context.Context, groupID string) ([]Profile, error)
+	// Groups lists every known group, most recently active first.
+	Groups(ctx context.Context) ([]Group, error)
+	// UserGroups returns the IDs of the groups userID is a member of.
+	// Posting in a group counts as membership, on top of AddMembers.
+	UserGroups(ctx context.Context, userID string) ([]string, error)
+	// AddMembers records userIDs as members of groupID.
+	AddMembers(ctx context.Context, groupID string, userIDs ...string) error
+	// RemoveMembers drops userIDs from the members of groupID.
+	RemoveMembers(ctx context.Context, groupID string, userIDs ...string) error
+	// Members lists the known members of groupID.
+	Members(ctx context.Context, groupID string) ([]string, error)
+	// Claim marks key as seen for ttl and reports whether this call was the
+	// first to do so. It is used to drop duplicate webhook deliveries.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Release forgets a claimed key so a later delivery can be processed,
+	// e.g. after storing the message failed.
+	Release(ctx context.Context, key string) error
+	// IncrCounter adds delta to the named ingest counter.
+	IncrCounter(ctx context.Context, name string, delta int64) error
+	// Counters returns every ingest counter.
+	Counters(ctx context.Context) (map[string]int64, error)
+	// SaveReplyToken keeps the latest reply token of conversationID until
+	// expires, replacing the one kept before.
+	SaveReplyToken(ctx context.Context, conversationID, token string, expires time.Time) error
+	// TakeReplyToken returns the reply token of conversationID and forgets
+	// it, since LINE accepts each token once. It returns "" when there is
+	// none or it has expired.
+	TakeReplyToken(ctx context.Context, conversationID string) (string, error)
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// Group is an entry of the group index.
+type Group struct {
+	ID               string `json:"group_id"`
+	ConversationType string `json:"conversation_type"`
+	// FirstActivity and LastActivity are LINE event timestamps in
+	// milliseconds.
+	FirstActivity int64 `json:"first_activity"`
+	LastActivity  int64 `json:"last_activity"`
+	// MessageCount counts every message received, including ones already
+	// trimmed by retention.
+	MessageCount int64 `json:"message_count"`
+}
+
+// touch records m in the group index entry g.
+func (g *Group) touch(m Message) {
+	if g.MessageCount == 0 || m.Timestamp < g.FirstActivity {
+		g.FirstActivity = m.Timestamp
+	}
+	if m.Timestamp > g.LastActivity {
+		g.LastActivity = m.Timestamp
+	}
+	g.MessageCount++
+}
+
+// sortGroups orders groups by recent activity and fills in their
+// conversation type.
+func sortGroups(groups []Group) {
+	for i := range groups {
+		groups[i].ConversationType = ConversationTypeOf(groups[i].ID)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].LastActivity != groups[j].LastActivity {
+			return groups[i].LastActivity > groups[j].LastActivity
+		}
+		return groups[i].ID < groups[j].ID
+	})
+}
+
+// Backend names accepted by MESSAGE_STORE.
+const (
+	BackendUpstash = "upstash"
+	BackendMemory  = "memory"
+	BackendBolt    = "bolt"
+)
+
+// ReplyTokenTTL is how long a reply token is used after its event. LINE
+// accepts a token for about a minute; the margin covers the time the reply
+// itself takes.
+const ReplyTokenTTL = 50 * time.Second
+
+// DefaultRetention is the approximate number of messages each backend keeps
+// per group; older entries are trimmed on append.
+const DefaultRetention = 10000
+
+const rangeChunk = 500
+
+// All reads the whole log of groupID from s in chunks, with unsent messages
+// redacted.
+func All(ctx context.Context, s MessageStore, groupID string) ([]Message, error) {
+	var messages []Message
+	after := ""
+	for {
+		chunk, err := s.Range(ctx, groupID, after, rangeChunk)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, chunk...)
+		if len(chunk) < rangeChunk {
+			break
+		}
+		after = chunk[len(chunk)-1].ID
+	}
+
+	fillConversationTypes(messages)
+	if err := decorate(ctx, s, groupID, messages); err != nil {
+		return messages, err
+	}
+	return messages, nil
+}
+
+// decorate applies the tombstones and profiles of groupID to messages read
+// from its log.
+func decorate(ctx context.Context, s MessageStore, groupID string, messages []Message) error {
+	tombstones, err := s.Tombstones(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	redact(messages, tombstones)
+
+	profiles, err := s.Profiles(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	applyProfiles(messages, profiles)
+	return nil
+}
+
+// AllGroups reads the logs of the given groups and merges them by
+// timestamp. A nil groupIDs reads every group in the index.
+func AllGroups(ctx context.Context, s MessageStore, groupIDs []string) ([]Message, error) {
+	if groupIDs == nil {
+		groups, err := s.Groups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			groupIDs = append(groupIDs, g.ID)
+		}
+	}
+
+	var messages []Message
+	for _, id := range groupIDs {
+		chunk, err := All(ctx, s, id)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, chunk...)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp < messages[j].Timestamp
+	})
+	return messages, nil
+}
+
+var (
+	openMu sync.Mutex
+	opened = make(map[string]MessageStore)
+)
+
+// Open returns the store selected by the environment:
+//
+//	MESSAGE_STORE       upstash | memory | bolt (default: upstash when
+//	                    KV_REST_API_URL is set, memory otherwise)
+//	MESSAGE_STORE_PATH  database file for bolt (default: messages.db)
+//	MESSAGE_RETENTION   number of messages to keep (default: 10000)
+//
+// Stores are opened once per process and shared, so handlers running in the
+// same process see the same in-memory or bolt data.
+func Open() (MessageStore, error) {
+	backend := os.Getenv("MESSAGE_STORE")
+	if backend == "" {
+		backend = BackendMemory
+		if os.Getenv("KV_REST_API_URL") != "" {
+			backend = BackendUpstash
+		}
+	}
+
+	retention := DefaultRetention
+	if v := os.Getenv("MESSAGE_RETENTION"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MESSAGE_RETENTION %q: %w", v, err)
+		}
+		retention = n
+	}
+
+	path := os.Getenv("MESSAGE_STORE_PATH")
+	if path == "" {
+		path = "messages.db"
+	}
+
+	openMu.Lock()
+	defer openMu.Unlock()
+
+	cacheKey := backend
+	if backend == BackendBolt {
+		cacheKey += ":" + path
+	}
+	if s, ok := opened[cacheKey]; ok {
+		return s, nil
+	}
+
+	var s MessageStore
+	switch backend {
+	case BackendUpstash:
+		client, err := upstash.NewFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		u := NewUpstash(client)
+		u.Retention = retention
+		if _, err := u.MigrateLegacy(context.Background()); err != nil {
+			log.Printf("⚠️ Legacy message migration failed: %v", err)
+		}
+		s = u
+	case BackendMemory:
+		m := NewMemory()
+		m.Retention = retention
+		s = m
+	case BackendBolt:
+		b, err := OpenBolt(path)
+		if err != nil {
+			return nil, err
+		}
+		b.Retention = retention
+		s = b
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_STORE %q", backend)
+	}
+
+	opened[cacheKey] = s
+	log.Printf("🗄️ Using %s message store", backend)
+	return s, nil
+}
+
+// Locations reads the location messages of the given groups (nil means
+// every group), oldest first. Unsent locations are left out.
+func Locations(ctx context.Context, s MessageStore, groupIDs []string) ([]Message, error) {
+	messages, err := AllGroups(ctx, s, groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	locations := []Message{}
+	for _, m := range messages {
+		if m.Type == TypeLocation && m.Location != nil && !m.Deleted {
+			locations = append(locations, m)
+		}
+	}
+	return locations, nil
+}
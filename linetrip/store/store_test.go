@@ -0,0 +1,395 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash/upstashtest"
+)
+
+// backends lists a constructor for every MessageStore implementation so the
+// same behaviour is checked against each of them.
+func backends(t *testing.T) map[string]func(retention int) MessageStore {
+	return map[string]func(retention int) MessageStore{
+		BackendUpstash: func(retention int) MessageStore {
+			srv := upstashtest.NewServer()
+			t.Cleanup(srv.Close)
+			s := NewUpstash(srv.Client())
+			s.Retention = retention
+			return s
+		},
+		BackendMemory: func(retention int) MessageStore {
+			s := NewMemory()
+			s.Retention = retention
+			return s
+		},
+		BackendBolt: func(retention int) MessageStore {
+			s, err := OpenBolt(filepath.Join(t.TempDir(), "messages.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			s.Retention = retention
+			return s
+		},
+	}
+}
+
+func TestConcurrentAppend(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+
+			const n = 100
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, err := s.Append(ctx, Message{
+						GroupID:   "C1",
+						UserID:    "U1",
+						Message:   fmt.Sprintf("msg-%d", i),
+						Timestamp: int64(i),
+					})
+					if err != nil {
+						errs <- err
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatalf("append: %v", err)
+			}
+
+			messages, err := All(ctx, s, "C1")
+			if err != nil {
+				t.Fatalf("all: %v", err)
+			}
+			if len(messages) != n {
+				t.Fatalf("got %d messages, want %d", len(messages), n)
+			}
+			seen := make(map[string]bool)
+			for _, m := range messages {
+				if m.ID == "" {
+					t.Errorf("message %q has no ID", m.Message)
+				}
+				seen[m.Message] = true
+			}
+			for i := 0; i < n; i++ {
+				if !seen[fmt.Sprintf("msg-%d", i)] {
+					t.Errorf("msg-%d was lost", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+			for i := 0; i < rangeChunk+7; i++ {
+				if _, err := s.Append(ctx, Message{GroupID: "C1", Message: fmt.Sprint(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.Append(ctx, Message{GroupID: "C2", Message: "other"}); err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := s.Range(ctx, "C1", "", 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(first) != 5 || first[0].Message != "0" {
+				t.Fatalf("unexpected first page: %+v", first)
+			}
+			next, err := s.Range(ctx, "C1", first[4].ID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(next) != 1 || next[0].Message != "5" {
+				t.Fatalf("range after %s = %+v, want message 5", first[4].ID, next)
+			}
+
+			all, err := All(ctx, s, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != rangeChunk+7 {
+				t.Fatalf("got %d messages, want %d", len(all), rangeChunk+7)
+			}
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(3)
+			ctx := context.Background()
+			for i := 0; i < 10; i++ {
+				if _, err := s.Append(ctx, Message{GroupID: "C1", Message: fmt.Sprint(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			all, err := All(ctx, s, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != 3 || all[0].Message != "7" {
+				t.Fatalf("retention kept %+v", all)
+			}
+		})
+	}
+}
+
+func TestGroupIndex(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(2)
+			ctx := context.Background()
+			appends := []Message{
+				{GroupID: "C1", UserID: "U1", Message: "a", Timestamp: 1000},
+				{GroupID: "C2", UserID: "U2", Message: "b", Timestamp: 1500},
+				{GroupID: "C1", UserID: "U2", Message: "c", Timestamp: 2000},
+				{GroupID: "C1", UserID: "U1", Message: "d", Timestamp: 3000},
+			}
+			for _, m := range appends {
+				if _, err := s.Append(ctx, m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			groups, err := s.Groups(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []Group{
+				{ID: "C1", ConversationType: ConversationGroup, FirstActivity: 1000, LastActivity: 3000, MessageCount: 3},
+				{ID: "C2", ConversationType: ConversationGroup, FirstActivity: 1500, LastActivity: 1500, MessageCount: 1},
+			}
+			if fmt.Sprint(groups) != fmt.Sprint(want) {
+				t.Errorf("Groups() = %+v, want %+v", groups, want)
+			}
+
+			ids, err := s.UserGroups(ctx, "U2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(ids) != "[C1 C2]" {
+				t.Errorf("UserGroups(U2) = %v", ids)
+			}
+
+			merged, err := AllGroups(ctx, s, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts []string
+			for _, m := range merged {
+				texts = append(texts, m.Message)
+			}
+			// C1 は retention 2 なので "a" は消えている
+			if fmt.Sprint(texts) != "[b c d]" {
+				t.Errorf("AllGroups() = %v", texts)
+			}
+		})
+	}
+}
+
+func TestBoltPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	ctx := context.Background()
+
+	s, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Append(ctx, Message{GroupID: "C1", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	all, err := All(ctx, s, "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Message != "hello" {
+		t.Fatalf("reopened store has %+v", all)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+
+			if ok, err := s.Claim(ctx, "event:1", time.Hour); err != nil || !ok {
+				t.Fatalf("first claim = %v, %v", ok, err)
+			}
+			if ok, err := s.Claim(ctx, "event:1", time.Hour); err != nil || ok {
+				t.Fatalf("second claim = %v, %v", ok, err)
+			}
+			if err := s.Release(ctx, "event:1"); err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := s.Claim(ctx, "event:1", time.Hour); err != nil || !ok {
+				t.Fatalf("claim after release = %v, %v", ok, err)
+			}
+
+			if err := s.IncrCounter(ctx, "duplicates", 2); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.IncrCounter(ctx, "duplicates", 1); err != nil {
+				t.Fatal(err)
+			}
+			counters, err := s.Counters(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if counters["duplicates"] != 3 {
+				t.Errorf("counters = %v", counters)
+			}
+		})
+	}
+}
+
+func TestClaimExpires(t *testing.T) {
+	for _, name := range []string{BackendMemory, BackendBolt} {
+		t.Run(name, func(t *testing.T) {
+			s := backends(t)[name](DefaultRetention)
+			ctx := context.Background()
+			if ok, _ := s.Claim(ctx, "k", time.Millisecond); !ok {
+				t.Fatal("first claim failed")
+			}
+			time.Sleep(5 * time.Millisecond)
+			if ok, err := s.Claim(ctx, "k", time.Hour); err != nil || !ok {
+				t.Fatalf("claim after expiry = %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestReplyTokens(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+
+			if token, err := s.TakeReplyToken(ctx, "C1"); err != nil || token != "" {
+				t.Fatalf("take without token = %q, %v", token, err)
+			}
+			s.SaveReplyToken(ctx, "C1", "r1", time.Now().Add(time.Minute))
+			s.SaveReplyToken(ctx, "C1", "r2", time.Now().Add(time.Minute))
+			s.SaveReplyToken(ctx, "C2", "r3", time.Now().Add(20*time.Millisecond))
+			if token, err := s.TakeReplyToken(ctx, "C1"); err != nil || token != "r2" {
+				t.Fatalf("take = %q, %v", token, err)
+			}
+			if token, _ := s.TakeReplyToken(ctx, "C1"); token != "" {
+				t.Errorf("token used twice: %q", token)
+			}
+			time.Sleep(30 * time.Millisecond)
+			if token, _ := s.TakeReplyToken(ctx, "C2"); token != "" {
+				t.Errorf("expired token = %q", token)
+			}
+		})
+	}
+}
+
+func TestProfilesApplyToPlaceholderNames(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+			s.Append(ctx, Message{GroupID: "C1", UserID: "Ue41eb551b7025cf6", UserName: FallbackName("Ue41eb551b7025cf6"), Message: "古い", Timestamp: 1000})
+			s.Append(ctx, Message{GroupID: "C1", UserID: "Ue41eb551b7025cf6", UserName: "旧姓", Message: "名前付き", Timestamp: 2000})
+
+			if _, ok, _ := s.Profile(ctx, "C1", "Ue41eb551b7025cf6"); ok {
+				t.Fatal("profile found before SetProfile")
+			}
+			p := Profile{UserID: "Ue41eb551b7025cf6", DisplayName: "たくと", PictureURL: "https://example.com/p.jpg", FetchedAt: 1000}
+			if err := s.SetProfile(ctx, "C1", p); err != nil {
+				t.Fatal(err)
+			}
+			if got, ok, err := s.Profile(ctx, "C1", p.UserID); err != nil || !ok || got != p {
+				t.Fatalf("Profile = %+v, %v, %v", got, ok, err)
+			}
+
+			messages, err := All(ctx, s, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if messages[0].UserName != "たくと" || messages[0].PictureURL != p.PictureURL {
+				t.Errorf("placeholder not replaced: %+v", messages[0])
+			}
+			// 保存時の名前はそのまま残す
+			if messages[1].UserName != "旧姓" {
+				t.Errorf("stored name overwritten: %+v", messages[1])
+			}
+
+			page, err := Paginate(ctx, s, Query{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Messages[0].UserName != "たくと" {
+				t.Errorf("paginated placeholder not replaced: %+v", page.Messages[0])
+			}
+		})
+	}
+}
+
+func TestMembers(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(DefaultRetention)
+			ctx := context.Background()
+
+			if err := s.AddMembers(ctx, "C1", "U1", "U2"); err != nil {
+				t.Fatal(err)
+			}
+			// 発言もメンバーとして数える
+			s.Append(ctx, Message{GroupID: "C1", UserID: "U3", Message: "hi"})
+			s.AddMembers(ctx, "C2", "U1")
+
+			members, err := s.Members(ctx, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(members) != "[U1 U2 U3]" {
+				t.Errorf("members = %v", members)
+			}
+			if groups, _ := s.UserGroups(ctx, "U1"); fmt.Sprint(groups) != "[C1 C2]" {
+				t.Errorf("groups of U1 = %v", groups)
+			}
+
+			if err := s.RemoveMembers(ctx, "C1", "U1", "U3"); err != nil {
+				t.Fatal(err)
+			}
+			members, _ = s.Members(ctx, "C1")
+			if fmt.Sprint(members) != "[U2]" {
+				t.Errorf("members after removal = %v", members)
+			}
+			if groups, _ := s.UserGroups(ctx, "U1"); fmt.Sprint(groups) != "[C2]" {
+				t.Errorf("groups of U1 after removal = %v", groups)
+			}
+			if groups, _ := s.UserGroups(ctx, "U3"); len(groups) != 0 {
+				t.Errorf("groups of U3 after removal = %v", groups)
+			}
+		})
+	}
+}
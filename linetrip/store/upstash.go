@@ -0,0 +1,488 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// Redis keys. Each group has its own stream and index hash; GroupsKey is a
+// sorted set of group IDs scored by last activity.
+const (
+	messagesKeyPrefix   = "line_messages:"
+	GroupsKey           = "line_groups"
+	groupKeyPrefix      = "line_groups:"
+	userGroupsKeyPrefix = "line_user_groups:"
+	membersKeyPrefix    = "line_members:"
+	tombstonesKeyPrefix = "line_tombstones:"
+	profilesKeyPrefix   = "line_profiles:"
+	claimKeyPrefix      = "line_dedupe:"
+	replyTokenKeyPrefix = "line_reply_tokens:"
+	CountersKey         = "line_ingest_counters"
+
+	// LegacyKey held the whole history as one JSON array. It is migrated
+	// into the per-group streams when the store is opened.
+	LegacyKey = "line_messages"
+)
+
+// MessagesKey returns the stream key of groupID, e.g. line_messages:C1234.
+func MessagesKey(groupID string) string {
+	return messagesKeyPrefix + groupID
+}
+
+// Upstash stores messages in per-group Redis streams through the Upstash
+// REST API. XADD is atomic on the server, so concurrent webhooks never
+// overwrite each other the way the old GET/append/SET cycle did.
+type Upstash struct {
+	Retention int
+
+	client *upstash.Client
+}
+
+// NewUpstash returns a stream-backed store using client.
+func NewUpstash(client *upstash.Client) *Upstash {
+	return &Upstash{client: client, Retention: DefaultRetention}
+}
+
+// Client returns the underlying client, for packages keeping their own data
+// next to the messages.
+func (s *Upstash) Client() *upstash.Client {
+	return s.client
+}
+
+func (s *Upstash) Append(ctx context.Context, m Message) (Message, error) {
+	m.ID = ""
+	data, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+
+	xadd := []interface{}{"XADD", MessagesKey(m.GroupID)}
+	if s.Retention > 0 {
+		xadd = append(xadd, "MAXLEN", "~", s.Retention)
+	}
+	xadd = append(xadd, "*", "data", string(data))
+
+	// インデックス更新も同じラウンドトリップで送る
+	groupKey := groupKeyPrefix + m.GroupID
+	cmds := [][]interface{}{
+		xadd,
+		{"ZADD", GroupsKey, "GT", m.Timestamp, m.GroupID},
+		{"HSETNX", groupKey, "first_activity", m.Timestamp},
+		{"HINCRBY", groupKey, "message_count", 1},
+	}
+	if m.UserID != "" {
+		cmds = append(cmds,
+			[]interface{}{"SADD", userGroupsKeyPrefix + m.UserID, m.GroupID},
+			[]interface{}{"SADD", membersKeyPrefix + m.GroupID, m.UserID})
+	}
+
+	res, err := s.client.Pipeline(ctx, cmds...)
+	if len(res) > 0 && res[0] != nil {
+		m.ID = upstash.String(res[0])
+	}
+	if err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func (s *Upstash) Range(ctx context.Context, groupID, after string, count int) ([]Message, error) {
+	start := "-"
+	if after != "" {
+		start = "(" + after
+	}
+	args := []interface{}{"XRANGE", MessagesKey(groupID), start, "+"}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	res, err := s.client.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntries(res)
+}
+
+func (s *Upstash) RangeBefore(ctx context.Context, groupID, before string, count int) ([]Message, error) {
+	end := "+"
+	if before != "" {
+		end = "(" + before
+	}
+	args := []interface{}{"XREVRANGE", MessagesKey(groupID), end, "-"}
+	if count > 0 {
+		args = append(args, "COUNT", count)
+	}
+	res, err := s.client.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntries(res)
+}
+
+func (s *Upstash) Tombstone(ctx context.Context, t Tombstone) error {
+	_, err := s.client.Do(ctx, "HSETNX", tombstonesKeyPrefix+t.GroupID, t.MessageID, t.DeletedAt)
+	return err
+}
+
+func (s *Upstash) Tombstones(ctx context.Context, groupID string) ([]Tombstone, error) {
+	res, err := s.client.Do(ctx, "HGETALL", tombstonesKeyPrefix+groupID)
+	if err != nil {
+		return nil, err
+	}
+	var out []Tombstone
+	for id, at := range upstash.Hash(res) {
+		out = append(out, Tombstone{GroupID: groupID, MessageID: id, DeletedAt: upstash.Int(at)})
+	}
+	return out, nil
+}
+
+func (s *Upstash) Profile(ctx context.Context, groupID, userID string) (Profile, bool, error) {
+	var p Profile
+	res, err := s.client.Do(ctx, "HGET", profilesKeyPrefix+groupID, userID)
+	if err != nil || res == nil {
+		return p, false, err
+	}
+	if err := json.Unmarshal([]byte(upstash.String(res)), &p); err != nil {
+		return p, false, err
+	}
+	return p, true, nil
+}
+
+func (s *Upstash) SetProfile(ctx context.Context, groupID string, p Profile) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "HSET", profilesKeyPrefix+groupID, p.UserID, string(data))
+	return err
+}
+
+func (s *Upstash) Profiles(ctx context.Context, groupID string) ([]Profile, error) {
+	res, err := s.client.Do(ctx, "HGETALL", profilesKeyPrefix+groupID)
+	if err != nil {
+		return nil, err
+	}
+	var out []Profile
+	for _, v := range upstash.Hash(res) {
+		var p Profile
+		if err := json.Unmarshal([]byte(upstash.String(v)), &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (s *Upstash) Groups(ctx context.Context) ([]Group, error) {
+	res, err := s.client.Do(ctx, "ZREVRANGE", GroupsKey, 0, -1, "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	pairs, _ := res.([]interface{})
+
+	var groups []Group
+	var cmds [][]interface{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		id := upstash.String(pairs[i])
+		groups = append(groups, Group{ID: id, LastActivity: upstash.Int(pairs[i+1])})
+		cmds = append(cmds, []interface{}{"HGETALL", groupKeyPrefix + id})
+	}
+	if len(cmds) == 0 {
+		return groups, nil
+	}
+
+	hashes, err := s.client.Pipeline(ctx, cmds...)
+	if err != nil {
+		return nil, err
+	}
+	for i, h := range hashes {
+		fields := upstash.Hash(h)
+		groups[i].FirstActivity = upstash.Int(fields["first_activity"])
+		groups[i].MessageCount = upstash.Int(fields["message_count"])
+	}
+	sortGroups(groups)
+	return groups, nil
+}
+
+func (s *Upstash) UserGroups(ctx context.Context, userID string) ([]string, error) {
+	res, err := s.client.Do(ctx, "SMEMBERS", userGroupsKeyPrefix+userID)
+	if err != nil {
+		return nil, err
+	}
+	return upstash.Strings(res), nil
+}
+
+func (s *Upstash) AddMembers(ctx context.Context, groupID string, userIDs ...string) error {
+	return s.updateMembers(ctx, "SADD", groupID, userIDs)
+}
+
+func (s *Upstash) RemoveMembers(ctx context.Context, groupID string, userIDs ...string) error {
+	return s.updateMembers(ctx, "SREM", groupID, userIDs)
+}
+
+// updateMembers applies op (SADD or SREM) to both membership directions in
+// one round-trip.
+func (s *Upstash) updateMembers(ctx context.Context, op, groupID string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	members := []interface{}{op, membersKeyPrefix + groupID}
+	cmds := make([][]interface{}, 0, len(userIDs)+1)
+	for _, id := range userIDs {
+		members = append(members, id)
+		cmds = append(cmds, []interface{}{op, userGroupsKeyPrefix + id, groupID})
+	}
+	cmds = append(cmds, members)
+	_, err := s.client.Pipeline(ctx, cmds...)
+	return err
+}
+
+func (s *Upstash) Members(ctx context.Context, groupID string) ([]string, error) {
+	res, err := s.client.Do(ctx, "SMEMBERS", membersKeyPrefix+groupID)
+	if err != nil {
+		return nil, err
+	}
+	ids := upstash.Strings(res)
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *Upstash) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	res, err := s.client.Do(ctx, "SET", claimKeyPrefix+key, 1, "NX", "EX", seconds)
+	if err != nil {
+		return false, err
+	}
+	// NX で既に存在した場合は nil が返る
+	return res != nil, nil
+}
+
+func (s *Upstash) Release(ctx context.Context, key string) error {
+	_, err := s.client.Do(ctx, "DEL", claimKeyPrefix+key)
+	return err
+}
+
+func (s *Upstash) IncrCounter(ctx context.Context, name string, delta int64) error {
+	_, err := s.client.Do(ctx, "HINCRBY", CountersKey, name, delta)
+	return err
+}
+
+func (s *Upstash) Counters(ctx context.Context) (map[string]int64, error) {
+	res, err := s.client.Do(ctx, "HGETALL", CountersKey)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int64)
+	for k, v := range upstash.Hash(res) {
+		out[k] = upstash.Int(v)
+	}
+	return out, nil
+}
+
+func (s *Upstash) SaveReplyToken(ctx context.Context, conversationID, token string, expires time.Time) error {
+	ms := time.Until(expires).Milliseconds()
+	if ms < 1 {
+		return nil
+	}
+	_, err := s.client.Do(ctx, "SET", replyTokenKeyPrefix+conversationID, token, "PX", ms)
+	return err
+}
+
+func (s *Upstash) TakeReplyToken(ctx context.Context, conversationID string) (string, error) {
+	// GETDEL で取り出すので、同じトークンを 2 つのリクエストが使うことはない
+	res, err := s.client.Do(ctx, "GETDEL", replyTokenKeyPrefix+conversationID)
+	if err != nil {
+		return "", err
+	}
+	token, _ := res.(string)
+	return token, nil
+}
+
+func (s *Upstash) Close() error {
+	return nil
+}
+
+// MigrateLegacy moves messages written by earlier versions into the
+// per-group streams. The legacy key is first renamed to <key>:migrating and
+// copied under a lease, so only one concurrent caller performs the copy; the
+// lease is renewed with every message and the copy stops if it was lost. The
+// copy records its progress in <key>:migrating:cursor: a copy that failed
+// partway is resumed by the next call, without appending the messages it
+// already copied again.
+func (s *Upstash) MigrateLegacy(ctx context.Context) (int, error) {
+	migrating := LegacyKey + ":migrating"
+	cursor := migrating + ":cursor"
+	res, err := s.client.Pipeline(ctx, []interface{}{"TYPE", LegacyKey}, []interface{}{"TYPE", migrating})
+	if err != nil {
+		return 0, err
+	}
+	pending, resume := upstash.String(res[0]) == "string", upstash.String(res[1]) == "string"
+	if !pending && !resume {
+		return 0, nil
+	}
+
+	lease := &migrationLease{client: s.client, key: claimKeyPrefix + migrating, token: strconv.FormatInt(time.Now().UnixNano(), 36)}
+	claimed, err := lease.acquire(ctx)
+	if err != nil || !claimed {
+		// 他のリクエストが移行中
+		return 0, err
+	}
+	defer lease.release(context.WithoutCancel(ctx))
+	if !resume {
+		if _, err := s.client.Do(ctx, "RENAME", LegacyKey, migrating); err != nil {
+			var redisErr *upstash.Error
+			if errors.As(err, &redisErr) {
+				// 他のリクエストが先に移行を終えた
+				return 0, nil
+			}
+			return 0, err
+		}
+	}
+
+	blob, err := s.client.Do(ctx, "GET", migrating)
+	if err != nil {
+		return 0, err
+	}
+	var legacy []Message
+	if err := json.Unmarshal([]byte(upstash.String(blob)), &legacy); err != nil {
+		return 0, fmt.Errorf("decode legacy messages: %w", err)
+	}
+	done, err := s.client.Do(ctx, "GET", cursor)
+	if err != nil {
+		return 0, err
+	}
+	start := int(upstash.Int(done))
+	if start > 0 {
+		log.Printf("🔁 Resuming migration of %s at %d/%d", LegacyKey, start, len(legacy))
+		// カーソルを進める前に落ちた 1 件は追加済みかもしれない
+		if start < len(legacy) {
+			copied, err := s.copied(ctx, legacy[start])
+			if err != nil {
+				return 0, err
+			}
+			if copied {
+				start++
+			}
+		}
+	}
+	n := 0
+	for i := start; i < len(legacy); i++ {
+		// 1 件ごとにリースを延ばし、切れて他のリクエストに移っていたらやめる
+		if err := lease.renew(ctx); err != nil {
+			return n, err
+		}
+		if _, err := s.Append(ctx, legacy[i]); err != nil {
+			return n, err
+		}
+		n++
+		if _, err := s.client.Do(ctx, "SET", cursor, i+1); err != nil {
+			return n, err
+		}
+	}
+	if _, err := s.client.Do(ctx, "DEL", migrating, cursor); err != nil {
+		return n, err
+	}
+	log.Printf("✅ Migrated %d legacy messages from %s", len(legacy), LegacyKey)
+	return n, nil
+}
+
+// migrationLeaseTTL bounds how long a legacy copy may stall before another
+// caller takes it over.
+const migrationLeaseTTL = 5 * time.Minute
+
+// errMigrationLeaseLost stops a copy whose lease expired and was taken over.
+var errMigrationLeaseLost = errors.New("legacy migration lease lost")
+
+// migrationLease is the lease of one MigrateLegacy call, told apart from
+// other callers' by its token.
+type migrationLease struct {
+	client *upstash.Client
+	key    string
+	token  string
+	lost   bool
+}
+
+func (l *migrationLease) acquire(ctx context.Context) (bool, error) {
+	res, err := l.client.Do(ctx, "SET", l.key, l.token, "NX", "EX", int64(migrationLeaseTTL/time.Second))
+	return res != nil, err
+}
+
+// renew extends the lease if it is still held by this call.
+func (l *migrationLease) renew(ctx context.Context) error {
+	res, err := l.client.Multi(ctx,
+		[]interface{}{"GET", l.key},
+		[]interface{}{"EXPIRE", l.key, int64(migrationLeaseTTL / time.Second)},
+	)
+	if err != nil {
+		return err
+	}
+	if upstash.String(res[0]) != l.token {
+		// 延ばしたのは引き継いだリクエストのリースなので、そのまま任せる
+		l.lost = true
+		return errMigrationLeaseLost
+	}
+	return nil
+}
+
+func (l *migrationLease) release(ctx context.Context) {
+	if !l.lost {
+		l.client.Do(ctx, "DEL", l.key)
+	}
+}
+
+// copied reports whether m is the last message of its group's stream.
+func (s *Upstash) copied(ctx context.Context, m Message) (bool, error) {
+	last, err := s.RangeBefore(ctx, m.GroupID, "", 1)
+	if err != nil || len(last) == 0 {
+		return false, err
+	}
+	last[0].ID, m.ID = "", ""
+	a, err := json.Marshal(last[0])
+	if err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(m)
+	return string(a) == string(b), err
+}
+
+// decodeEntries converts an XRANGE reply ([[id, [field, value, ...]], ...])
+// into messages.
+func decodeEntries(res interface{}) ([]Message, error) {
+	entries, ok := res.([]interface{})
+	if !ok {
+		if res == nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unexpected XRANGE reply: %T", res)
+	}
+
+	messages := make([]Message, 0, len(entries))
+	for _, e := range entries {
+		pair, ok := e.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("unexpected stream entry: %v", e)
+		}
+		fields := upstash.Hash(pair[1])
+		data, ok := fields["data"]
+		if !ok {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal([]byte(upstash.String(data)), &m); err != nil {
+			return nil, fmt.Errorf("decode stream entry %v: %w", pair[0], err)
+		}
+		m.ID = upstash.String(pair[0])
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
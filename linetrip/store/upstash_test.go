@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash/upstashtest"
+)
+
+func TestUpstashMigrateLegacy(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+
+	legacy, _ := json.Marshal([]Message{
+		{GroupID: "C1", Message: "old-1", Timestamp: 1},
+		{GroupID: "C2", Message: "old-2", Timestamp: 2},
+	})
+	if _, err := srv.Exec("SET", LegacyKey, string(legacy)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewUpstash(srv.Client())
+	ctx := context.Background()
+	if n, err := s.MigrateLegacy(ctx); err != nil || n != 2 {
+		t.Fatalf("migration = %d, %v", n, err)
+	}
+	all, err := AllGroups(ctx, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Message != "old-1" || all[1].GroupID != "C2" {
+		t.Fatalf("migrated %+v", all)
+	}
+	if n, _ := srv.Exec("EXISTS", LegacyKey, LegacyKey+":migrating", LegacyKey+":migrating:cursor"); n != float64(0) {
+		t.Errorf("legacy keys left behind")
+	}
+
+	// 2回目は何もしない
+	if n, err := s.MigrateLegacy(ctx); err != nil || n != 0 {
+		t.Errorf("second migration = %d, %v", n, err)
+	}
+}
+
+func TestUpstashMigrateResume(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+	s := NewUpstash(srv.Client())
+	ctx := context.Background()
+
+	// 3 件中 2 件目を追加したところで落ち、カーソルは 1 のまま残った
+	legacy := []Message{
+		{GroupID: "C1", MessageID: "m1", Message: "old-1", Timestamp: 1},
+		{GroupID: "C1", MessageID: "m2", Message: "old-2", Timestamp: 2},
+		{GroupID: "C1", MessageID: "m3", Message: "old-3", Timestamp: 3},
+	}
+	blob, _ := json.Marshal(legacy)
+	srv.Exec("SET", LegacyKey+":migrating", string(blob))
+	srv.Exec("SET", LegacyKey+":migrating:cursor", "1")
+	s.Append(ctx, legacy[0])
+	s.Append(ctx, legacy[1])
+
+	if n, err := s.MigrateLegacy(ctx); err != nil || n != 1 {
+		t.Fatalf("resumed migration = %d, %v", n, err)
+	}
+	all, err := s.Range(ctx, "C1", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range all {
+		got = append(got, m.MessageID)
+	}
+	if fmt.Sprint(got) != "[m1 m2 m3]" {
+		t.Errorf("migrated %v", got)
+	}
+	if n, _ := srv.Exec("EXISTS", LegacyKey+":migrating", LegacyKey+":migrating:cursor"); n != float64(0) {
+		t.Errorf("migration state left behind")
+	}
+
+	// 移行中の別のリクエストは待たずに戻る
+	srv.Exec("SET", LegacyKey, string(blob))
+	s.Claim(ctx, LegacyKey+":migrating", time.Minute)
+	if n, err := s.MigrateLegacy(ctx); err != nil || n != 0 {
+		t.Errorf("concurrent migration = %d, %v", n, err)
+	}
+	if n, _ := srv.Exec("EXISTS", LegacyKey); n != float64(1) {
+		t.Errorf("legacy key taken during another migration")
+	}
+}
+
+func TestMigrationLeaseLost(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	lease := &migrationLease{client: srv.Client(), key: "lease", token: "a"}
+	if ok, err := lease.acquire(ctx); err != nil || !ok {
+		t.Fatalf("acquire = %v, %v", ok, err)
+	}
+	if err := lease.renew(ctx); err != nil {
+		t.Fatalf("renew = %v", err)
+	}
+
+	// リースが切れて別のリクエストに移った
+	srv.Exec("SET", "lease", "b")
+	if err := lease.renew(ctx); !errors.Is(err, errMigrationLeaseLost) {
+		t.Errorf("renew after takeover = %v", err)
+	}
+	lease.release(ctx)
+	if v, _ := srv.Exec("GET", "lease"); v != "b" {
+		t.Errorf("released the other lease: %v", v)
+	}
+}
@@ -0,0 +1,716 @@
+package trip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/takuto277/line-trip-list-api/linetrip/flex"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+)
+
+// ItemRequest is the body of POST and PATCH requests. Unset fields are
+// left unchanged by PATCH.
+type ItemRequest struct {
+	GroupID string  `json:"group_id"`
+	TripID  string  `json:"trip_id"`
+	Title   *string `json:"title"`
+	Done    *bool   `json:"done"`
+}
+
+// ItemsHandler serves trip list CRUD for the iOS app. Items belong to the
+// active trip unless trip_id names another one:
+//
+//	GET    ?group_id=C...[&trip_id=C...-2]  list items
+//	POST   {group_id, title, trip_id?}      add an item
+//	PATCH  ?group_id=C...&id=3     {title?, done?}
+//	DELETE ?group_id=C...&id=3     remove an item
+func ItemsHandler(s Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		ctx := r.Context()
+		groupID := r.URL.Query().Get("group_id")
+		switch r.Method {
+		case http.MethodGet:
+			if groupID == "" {
+				writeError(w, http.StatusBadRequest, "group_id is required")
+				return
+			}
+			tripID := r.URL.Query().Get("trip_id")
+			if tripID == "" {
+				id, err := ActiveID(ctx, s, groupID)
+				if err != nil {
+					serverError(w, "list trip items", err)
+					return
+				}
+				tripID = id
+			}
+			items, err := s.Items(ctx, groupID)
+			if err != nil {
+				serverError(w, "list trip items", err)
+				return
+			}
+			items = ItemsOf(items, tripID)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"group_id": groupID,
+				"trip_id":  tripID,
+				"items":    items,
+				"count":    len(items),
+			})
+
+		case http.MethodPost:
+			var req ItemRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			if req.GroupID == "" {
+				req.GroupID = groupID
+			}
+			title := ""
+			if req.Title != nil {
+				title = cleanTitle(*req.Title)
+			}
+			if req.GroupID == "" || title == "" {
+				writeError(w, http.StatusBadRequest, "group_id and title are required")
+				return
+			}
+			if req.TripID == "" {
+				id, err := ActiveID(ctx, s, req.GroupID)
+				if err != nil {
+					serverError(w, "add trip item", err)
+					return
+				}
+				req.TripID = id
+			} else if g, _, ok := ParseTripID(req.TripID); !ok || g != req.GroupID {
+				writeError(w, http.StatusBadRequest, "trip_id is not a trip of group_id")
+				return
+			}
+			now := time.Now().UnixMilli()
+			item := Item{GroupID: req.GroupID, TripID: req.TripID, Title: title, CreatedAt: now, UpdatedAt: now}
+			if req.Done != nil && *req.Done {
+				item.Done, item.DoneAt = true, now
+			}
+			saved, _, err := s.AddItem(ctx, item)
+			if err != nil {
+				serverError(w, "add trip item", err)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(saved)
+
+		case http.MethodPatch:
+			item, ok := lookup(w, r, s)
+			if !ok {
+				return
+			}
+			var req ItemRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			now := time.Now().UnixMilli()
+			if req.Title != nil {
+				if item.Title = cleanTitle(*req.Title); item.Title == "" {
+					writeError(w, http.StatusBadRequest, "title cannot be empty")
+					return
+				}
+			}
+			if req.Done != nil && *req.Done != item.Done {
+				item.Done, item.DoneAt = *req.Done, 0
+				if item.Done {
+					item.DoneAt = now
+				}
+			}
+			item.UpdatedAt = now
+			if err := s.UpdateItem(ctx, item); err != nil {
+				itemError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(item)
+
+		case http.MethodDelete:
+			item, ok := lookup(w, r, s)
+			if !ok {
+				return
+			}
+			if err := s.RemoveItem(ctx, item.GroupID, item.ID); err != nil {
+				itemError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "deleted", "id": item.ID})
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// lookup finds the item named by the group_id and id query parameters.
+func lookup(w http.ResponseWriter, r *http.Request, s Store) (Item, bool) {
+	groupID := r.URL.Query().Get("group_id")
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if groupID == "" || err != nil {
+		writeError(w, http.StatusBadRequest, "group_id and a numeric id are required")
+		return Item{}, false
+	}
+	item, err := s.Item(r.Context(), groupID, id)
+	if err != nil {
+		itemError(w, err)
+		return Item{}, false
+	}
+	return item, true
+}
+
+func itemError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "Trip item not found")
+		return
+	}
+	serverError(w, "update trip item", err)
+}
+
+// TripRequest is the body of trip POST and PATCH requests. Unset fields are
+// left unchanged by PATCH.
+type TripRequest struct {
+	GroupID     string  `json:"group_id"`
+	Title       *string `json:"title"`
+	Destination *string `json:"destination"`
+	StartDate   *string `json:"start_date"`
+	EndDate     *string `json:"end_date"`
+	Status      *string `json:"status"`
+	// Rates replaces the trip's snapshot rate of the given currencies.
+	Rates Rates `json:"rates"`
+}
+
+func (req TripRequest) apply(t *Trip) {
+	if req.Title != nil {
+		t.Title = cleanTitle(*req.Title)
+	}
+	if req.Destination != nil {
+		t.Destination = cleanTitle(*req.Destination)
+	}
+	if req.StartDate != nil {
+		t.StartDate = *req.StartDate
+	}
+	if req.EndDate != nil {
+		t.EndDate = *req.EndDate
+	}
+	for code, rate := range req.Rates {
+		if t.Rates == nil {
+			t.Rates = Rates{}
+		}
+		t.Rates[code] = rate
+	}
+}
+
+// TripsHandler serves the trips of each conversation. Creating a trip makes
+// it the active one; archived trips stay browsable with status=archived.
+//
+//	GET   ?group_id=C...[&status=archived]  list trips
+//	GET   ?id=C...-2                        one trip with its items
+//	POST  {group_id, title, destination?, start_date?, end_date?}
+//	PATCH ?id=C...-2  {title?, destination?, start_date?, end_date?, status?, rates?}
+func TripsHandler(s Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		ctx := r.Context()
+		query := r.URL.Query()
+		switch {
+		case r.Method == http.MethodGet && query.Get("id") != "":
+			t, ok := lookupTrip(w, r, s)
+			if !ok {
+				return
+			}
+			items, err := s.Items(ctx, t.GroupID)
+			if err != nil {
+				serverError(w, "get trip", err)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"trip":  t,
+				"items": ItemsOf(items, t.ID),
+			})
+
+		case r.Method == http.MethodGet:
+			groupID := query.Get("group_id")
+			if groupID == "" {
+				writeError(w, http.StatusBadRequest, "group_id is required")
+				return
+			}
+			trips, err := s.Trips(ctx, groupID)
+			if err != nil {
+				serverError(w, "list trips", err)
+				return
+			}
+			active := ""
+			filtered := []Trip{}
+			for _, t := range trips {
+				if t.Status == StatusActive {
+					active = t.ID
+				}
+				if status := query.Get("status"); status == "" || status == t.Status {
+					filtered = append(filtered, t)
+				}
+			}
+			loc, err := Location(ctx, s, groupID)
+			if err != nil {
+				serverError(w, "list trips", err)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"group_id":       groupID,
+				"time_zone":      loc.String(),
+				"active_trip_id": active,
+				"trips":          filtered,
+				"count":          len(filtered),
+			})
+
+		case r.Method == http.MethodPost:
+			var req TripRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			if req.GroupID == "" {
+				req.GroupID = query.Get("group_id")
+			}
+			if req.GroupID == "" {
+				writeError(w, http.StatusBadRequest, "group_id is required")
+				return
+			}
+			t := Trip{GroupID: req.GroupID, Status: StatusPlanning}
+			req.apply(&t)
+			if err := t.Validate(); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			saved, _, err := Create(ctx, s, t, time.Now().UnixMilli())
+			if err != nil {
+				serverError(w, "create trip", err)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(saved)
+
+		case r.Method == http.MethodPatch:
+			t, ok := lookupTrip(w, r, s)
+			if !ok {
+				return
+			}
+			var req TripRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			previous := t.Status
+			req.apply(&t)
+			if req.Status != nil {
+				t.Status = *req.Status
+			}
+			if err := t.Validate(); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			// 状態の変更は進行中の旅行の付け替えを伴う
+			now := time.Now().UnixMilli()
+			status := t.Status
+			t.Status = previous
+			t.UpdatedAt = now
+			err := s.UpdateTrip(ctx, t)
+			if err == nil && status != t.Status {
+				switch status {
+				case StatusActive:
+					t, err = Activate(ctx, s, t.ID, now)
+				case StatusArchived:
+					t, err = Archive(ctx, s, t.ID, now)
+				default:
+					t.Status, t.ArchivedAt = status, 0
+					err = s.UpdateTrip(ctx, t)
+				}
+			}
+			if err != nil {
+				tripError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(t)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+// lookupTrip finds the trip named by the id query parameter.
+func lookupTrip(w http.ResponseWriter, r *http.Request, s Store) (Trip, bool) {
+	t, err := s.Trip(r.Context(), r.URL.Query().Get("id"))
+	if err != nil {
+		tripError(w, err)
+		return Trip{}, false
+	}
+	return t, true
+}
+
+func tripError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTripNotFound) {
+		writeError(w, http.StatusNotFound, "Trip not found")
+		return
+	}
+	serverError(w, "update trip", err)
+}
+
+func serverError(w http.ResponseWriter, what string, err error) {
+	log.Printf("Error: %s: %v", what, err)
+	writeError(w, http.StatusInternalServerError, "Failed to "+what)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// EntryRequest is the body of itinerary POST and PATCH requests. Unset
+// fields are left unchanged by PATCH.
+type EntryRequest struct {
+	Date     *string `json:"date"`
+	Time     *string `json:"time"`
+	Period   *string `json:"period"`
+	Title    *string `json:"title"`
+	Status   *string `json:"status"`
+	Place    *string `json:"place"`
+	ImageURL *string `json:"image_url"`
+}
+
+func (req EntryRequest) apply(e *Entry) {
+	if req.Date != nil {
+		e.Date = *req.Date
+	}
+	if req.Time != nil {
+		e.Time = *req.Time
+	}
+	if req.Period != nil {
+		e.Period = *req.Period
+	}
+	if req.Title != nil {
+		e.Title = cleanTitle(*req.Title)
+	}
+	if req.Status != nil {
+		e.Status = *req.Status
+	}
+	if req.Place != nil {
+		e.Place = cleanTitle(*req.Place)
+	}
+	if req.ImageURL != nil {
+		e.ImageURL = strings.TrimSpace(*req.ImageURL)
+	}
+}
+
+// ItineraryHandler serves the itinerary of a trip. Entries proposed from
+// chat messages are confirmed or rejected with PATCH:
+//
+//	GET   ?trip_id=C...-2[&status=proposed]  list entries in date order
+//	POST  ?trip_id=C...-2  {date, time?, period?, title, place?, image_url?}  add a confirmed entry
+//	PATCH ?trip_id=C...-2&id=3  {status?, date?, time?, period?, title?, place?, image_url?}
+func ItineraryHandler(s Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		ctx := r.Context()
+		query := r.URL.Query()
+		t, err := s.Trip(ctx, query.Get("trip_id"))
+		if err != nil {
+			tripError(w, err)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			entries, err := s.Entries(ctx, t.GroupID)
+			if err != nil {
+				serverError(w, "list itinerary", err)
+				return
+			}
+			filtered := []Entry{}
+			for _, e := range EntriesOf(entries, t.ID) {
+				if status := query.Get("status"); status == "" || status == e.Status {
+					filtered = append(filtered, e)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"trip_id": t.ID,
+				"entries": filtered,
+				"count":   len(filtered),
+			})
+
+		case http.MethodPost:
+			var req EntryRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			now := time.Now().UnixMilli()
+			e := Entry{GroupID: t.GroupID, TripID: t.ID, Status: EntryConfirmed, CreatedAt: now, UpdatedAt: now}
+			req.apply(&e)
+			if err := e.Validate(); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			saved, _, err := s.AddEntry(ctx, e)
+			if err != nil {
+				serverError(w, "add itinerary entry", err)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(saved)
+
+		case http.MethodPatch:
+			id, err := strconv.Atoi(query.Get("id"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "a numeric id is required")
+				return
+			}
+			e, err := s.Entry(ctx, t.GroupID, id)
+			if err == nil && e.TripID != t.ID {
+				err = ErrEntryNotFound
+			}
+			if err != nil {
+				entryError(w, err)
+				return
+			}
+			var req EntryRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			req.apply(&e)
+			if err := e.Validate(); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			e.UpdatedAt = time.Now().UnixMilli()
+			if err := s.UpdateEntry(ctx, e); err != nil {
+				entryError(w, err)
+				return
+			}
+			json.NewEncoder(w).Encode(e)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}
+}
+
+func entryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrEntryNotFound) {
+		writeError(w, http.StatusNotFound, "Itinerary entry not found")
+		return
+	}
+	serverError(w, "update itinerary entry", err)
+}
+
+// Subresources routes "{prefix}{tripId}/{name}" to handlers[name] with the
+// trip ID as the trip_id query parameter, the same shape the Vercel rewrites
+// give /api/trips/{id}/itinerary and friends.
+func Subresources(prefix string, handlers map[string]http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		h := handlers[name]
+		if !ok || id == "" || h == nil {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		query := r.URL.Query()
+		query.Set("trip_id", id)
+		r.URL.RawQuery = query.Encode()
+		h.ServeHTTP(w, r)
+	}
+}
+
+// SettlementHandler serves the settlement of a trip's expenses:
+//
+//	GET ?trip_id=C...-2  balances and the fewest transfers that even them out
+func SettlementHandler(s Store, people People) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		t, err := s.Trip(r.Context(), r.URL.Query().Get("trip_id"))
+		if err != nil {
+			tripError(w, err)
+			return
+		}
+		result, err := Settle(r.Context(), s, people, t.GroupID, t.ID)
+		if err != nil {
+			ledgerError(w, "settle expenses", err)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// BudgetHandler serves a trip's spending summary in JPY:
+//
+//	GET ?trip_id=C...-2  totals by category, member and currency
+func BudgetHandler(s Store, people People) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		t, err := s.Trip(r.Context(), r.URL.Query().Get("trip_id"))
+		if err != nil {
+			tripError(w, err)
+			return
+		}
+		result, err := Summarize(r.Context(), s, people, t.GroupID, t.ID)
+		if err != nil {
+			ledgerError(w, "summarize expenses", err)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// ledgerError reports an expense in a currency the trip has no rate for as
+// a conflict the client can fix by PATCHing the trip's rates.
+func ledgerError(w http.ResponseWriter, what string, err error) {
+	if errors.Is(err, ErrNoRate) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	serverError(w, what, err)
+}
+
+// ShareHandler renders a trip's itinerary as a Flex carousel, one bubble
+// per day, and pushes it to the trip's conversation:
+//
+//	GET  ?trip_id=C...-2  preview {alt_text, text, contents}
+//	POST ?trip_id=C...-2  send it to the group (a reply when a fresh
+//	                      reply token is kept, otherwise a push)
+//
+// A sent itinerary is recorded in sender's Timeline under the shared trip.
+func ShareHandler(s Store, sender *outbound.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		ctx := r.Context()
+		t, err := s.Trip(ctx, r.URL.Query().Get("trip_id"))
+		if err != nil {
+			tripError(w, err)
+			return
+		}
+		entries, err := s.Entries(ctx, t.GroupID)
+		if err != nil {
+			serverError(w, "list itinerary", err)
+			return
+		}
+		if !Planned(t, entries) {
+			writeError(w, http.StatusConflict, "the trip has no itinerary entries")
+			return
+		}
+		reply, err := ItineraryReply(t, entries)
+		if err != nil {
+			serverError(w, "render itinerary", err)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"trip_id":  t.ID,
+				"alt_text": reply.AltText,
+				"text":     reply.Text,
+				"contents": reply.Flex,
+			})
+			return
+		}
+
+		if sender == nil {
+			writeError(w, http.StatusServiceUnavailable, "LINE_CHANNEL_TOKEN not configured")
+			return
+		}
+		message, err := flex.Message(reply.AltText, reply.Flex)
+		if err != nil {
+			serverError(w, "render itinerary", err)
+			return
+		}
+		delivery, err := sender.Send(ctx, t.GroupID, "", []messaging_api.MessageInterface{message})
+		if err != nil {
+			log.Printf("❌ Sharing itinerary of %s failed: %v", t.ID, err)
+			writeError(w, http.StatusBadGateway, "Failed to send itinerary")
+			return
+		}
+		log.Printf("📅 Shared itinerary of %s to %s by %s", t.ID, t.GroupID, delivery.Method)
+		// 共有した旅程はアクティブな旅行ではなくその旅行に記録する
+		recorder := *sender
+		recorder.ActiveTrip = func(context.Context, string) (string, error) { return t.ID, nil }
+		recorder.Record(ctx, t.GroupID, "", []outbound.Message{{Type: outbound.TypeFlex, AltText: reply.AltText}}, delivery)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "sent",
+			"trip_id":   t.ID,
+			"to":        t.GroupID,
+			"method":    delivery.Method,
+			"retry_key": delivery.RetryKey,
+		})
+	}
+}
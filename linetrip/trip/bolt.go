@@ -0,0 +1,497 @@
+package trip
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// tripItemsBucket holds one nested bucket per conversation mapping the
+	// item number (big-endian) to JSON; its sequence numbers the items.
+	tripItemsBucket = []byte("trip_items")
+	// tripSourcesBucket holds one nested bucket per conversation mapping
+	// the source LINE message ID to the item number.
+	tripSourcesBucket = []byte("trip_sources")
+	// tripsBucket holds one nested bucket per conversation mapping the trip
+	// number to JSON. Trip source messages share tripSourcesBucket with a
+	// "trip:" prefix.
+	tripsBucket = []byte("trips")
+	// tripItineraryBucket holds one nested bucket per conversation mapping
+	// the entry number to JSON, with "entry:" source keys.
+	tripItineraryBucket = []byte("trip_itinerary")
+	// tripExpensesBucket holds one nested bucket per conversation mapping
+	// the expense number to JSON, with "expense:" source keys.
+	tripExpensesBucket = []byte("trip_expenses")
+	// tripActiveBucket maps a conversation ID to the ID of its active trip.
+	tripActiveBucket = []byte("trip_active")
+	// tripTimeZonesBucket maps a conversation ID to its time zone.
+	tripTimeZonesBucket = []byte("trip_time_zones")
+	// tripRatesBucket maps a currency code to its JPY rate.
+	tripRatesBucket = []byte("trip_rates")
+)
+
+// Bolt keeps trips and their items in the message store's bbolt file.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// NewBolt returns a trip store in db, creating its buckets.
+func NewBolt(db *bolt.DB) (*Bolt, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{tripItemsBucket, tripSourcesBucket, tripsBucket, tripItineraryBucket, tripExpensesBucket, tripActiveBucket, tripTimeZonesBucket, tripRatesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return &Bolt{db: db}, err
+}
+
+func itemKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func (s *Bolt) AddItem(ctx context.Context, item Item) (Item, bool, error) {
+	added := true
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		items, err := tx.Bucket(tripItemsBucket).CreateBucketIfNotExists([]byte(item.GroupID))
+		if err != nil {
+			return err
+		}
+		sources, err := tx.Bucket(tripSourcesBucket).CreateBucketIfNotExists([]byte(item.GroupID))
+		if err != nil {
+			return err
+		}
+		if item.SourceMessageID != "" {
+			if v := sources.Get([]byte(item.SourceMessageID)); v != nil {
+				added = false
+				id := int(binary.BigEndian.Uint64(v))
+				item = Item{ID: id, GroupID: item.GroupID}
+				if data := items.Get(v); data != nil {
+					return json.Unmarshal(data, &item)
+				}
+				return nil
+			}
+		}
+
+		seq, err := items.NextSequence()
+		if err != nil {
+			return err
+		}
+		item.ID = int(seq)
+		if item.SourceMessageID != "" {
+			if err := sources.Put([]byte(item.SourceMessageID), itemKey(item.ID)); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return items.Put(itemKey(item.ID), data)
+	})
+	return item, added, err
+}
+
+func (s *Bolt) Items(ctx context.Context, groupID string) ([]Item, error) {
+	out := []Item{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripItemsBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var item Item
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			out = append(out, item)
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Bolt) Item(ctx context.Context, groupID string, id int) (Item, error) {
+	var item Item
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripItemsBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return ErrNotFound
+		}
+		data := b.Get(itemKey(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &item)
+	})
+	return item, err
+}
+
+func (s *Bolt) UpdateItem(ctx context.Context, item Item) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripItemsBucket).Bucket([]byte(item.GroupID))
+		if b == nil || b.Get(itemKey(item.ID)) == nil {
+			return ErrNotFound
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return b.Put(itemKey(item.ID), data)
+	})
+}
+
+func (s *Bolt) RemoveItem(ctx context.Context, groupID string, id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripItemsBucket).Bucket([]byte(groupID))
+		if b == nil || b.Get(itemKey(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete(itemKey(id))
+	})
+}
+
+func (s *Bolt) CreateTrip(ctx context.Context, t Trip) (Trip, bool, error) {
+	created := true
+	source := []byte("trip:" + t.SourceMessageID)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		trips, err := tx.Bucket(tripsBucket).CreateBucketIfNotExists([]byte(t.GroupID))
+		if err != nil {
+			return err
+		}
+		sources, err := tx.Bucket(tripSourcesBucket).CreateBucketIfNotExists([]byte(t.GroupID))
+		if err != nil {
+			return err
+		}
+		if t.SourceMessageID != "" {
+			if v := sources.Get(source); v != nil {
+				created = false
+				return json.Unmarshal(trips.Get(v), &t)
+			}
+		}
+
+		seq, err := trips.NextSequence()
+		if err != nil {
+			return err
+		}
+		t.Number = int(seq)
+		t.ID = TripID(t.GroupID, t.Number)
+		if t.SourceMessageID != "" {
+			if err := sources.Put(source, itemKey(t.Number)); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return trips.Put(itemKey(t.Number), data)
+	})
+	return t, created, err
+}
+
+func (s *Bolt) Trips(ctx context.Context, groupID string) ([]Trip, error) {
+	out := []Trip{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripsBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		active := string(tx.Bucket(tripActiveBucket).Get([]byte(groupID)))
+		return b.ForEach(func(k, v []byte) error {
+			var t Trip
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			activeStatus(&t, active)
+			out = append(out, t)
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Bolt) Trip(ctx context.Context, id string) (Trip, error) {
+	groupID, n, ok := ParseTripID(id)
+	if !ok {
+		return Trip{}, ErrTripNotFound
+	}
+	var t Trip
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripsBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return ErrTripNotFound
+		}
+		data := b.Get(itemKey(n))
+		if data == nil {
+			return ErrTripNotFound
+		}
+		if err := json.Unmarshal(data, &t); err != nil {
+			return err
+		}
+		activeStatus(&t, string(tx.Bucket(tripActiveBucket).Get([]byte(groupID))))
+		return nil
+	})
+	return t, err
+}
+
+func (s *Bolt) UpdateTrip(ctx context.Context, t Trip) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripsBucket).Bucket([]byte(t.GroupID))
+		if b == nil {
+			return ErrTripNotFound
+		}
+		data := b.Get(itemKey(t.Number))
+		if data == nil {
+			return ErrTripNotFound
+		}
+		var stored Trip
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		t.Rates = mergeRates(stored.Rates, t.Rates)
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return b.Put(itemKey(t.Number), data)
+	})
+}
+
+func (s *Bolt) SetActiveTrip(ctx context.Context, groupID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tripActiveBucket).Put([]byte(groupID), []byte(id))
+	})
+}
+
+func (s *Bolt) SnapshotTripRate(ctx context.Context, id, code string, rate float64) (float64, error) {
+	groupID, n, ok := ParseTripID(id)
+	if !ok {
+		return 0, ErrTripNotFound
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripsBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return ErrTripNotFound
+		}
+		data := b.Get(itemKey(n))
+		if data == nil {
+			return ErrTripNotFound
+		}
+		var t Trip
+		if err := json.Unmarshal(data, &t); err != nil {
+			return err
+		}
+		if existing, ok := t.Rates[code]; ok {
+			rate = existing
+			return nil
+		}
+		t.Rates = mergeRates(t.Rates, Rates{code: rate})
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return b.Put(itemKey(n), data)
+	})
+	return rate, err
+}
+
+func (s *Bolt) AddEntry(ctx context.Context, e Entry) (Entry, bool, error) {
+	added := true
+	source := []byte("entry:" + e.SourceMessageID)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		entries, err := tx.Bucket(tripItineraryBucket).CreateBucketIfNotExists([]byte(e.GroupID))
+		if err != nil {
+			return err
+		}
+		sources, err := tx.Bucket(tripSourcesBucket).CreateBucketIfNotExists([]byte(e.GroupID))
+		if err != nil {
+			return err
+		}
+		if e.SourceMessageID != "" {
+			if v := sources.Get(source); v != nil {
+				added = false
+				return json.Unmarshal(entries.Get(v), &e)
+			}
+		}
+
+		seq, err := entries.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = int(seq)
+		if e.SourceMessageID != "" {
+			if err := sources.Put(source, itemKey(e.ID)); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return entries.Put(itemKey(e.ID), data)
+	})
+	return e, added, err
+}
+
+func (s *Bolt) Entries(ctx context.Context, groupID string) ([]Entry, error) {
+	out := []Entry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripItineraryBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			out = append(out, e)
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Bolt) Entry(ctx context.Context, groupID string, id int) (Entry, error) {
+	var e Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripItineraryBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return ErrEntryNotFound
+		}
+		data := b.Get(itemKey(id))
+		if data == nil {
+			return ErrEntryNotFound
+		}
+		return json.Unmarshal(data, &e)
+	})
+	return e, err
+}
+
+func (s *Bolt) UpdateEntry(ctx context.Context, e Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripItineraryBucket).Bucket([]byte(e.GroupID))
+		if b == nil || b.Get(itemKey(e.ID)) == nil {
+			return ErrEntryNotFound
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(itemKey(e.ID), data)
+	})
+}
+
+func (s *Bolt) AddExpense(ctx context.Context, e Expense) (Expense, bool, error) {
+	added := true
+	source := []byte("expense:" + e.SourceMessageID)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		expenses, err := tx.Bucket(tripExpensesBucket).CreateBucketIfNotExists([]byte(e.GroupID))
+		if err != nil {
+			return err
+		}
+		sources, err := tx.Bucket(tripSourcesBucket).CreateBucketIfNotExists([]byte(e.GroupID))
+		if err != nil {
+			return err
+		}
+		if e.SourceMessageID != "" {
+			if v := sources.Get(source); v != nil {
+				added = false
+				return json.Unmarshal(expenses.Get(v), &e)
+			}
+		}
+
+		seq, err := expenses.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = int(seq)
+		if e.SourceMessageID != "" {
+			if err := sources.Put(source, itemKey(e.ID)); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return expenses.Put(itemKey(e.ID), data)
+	})
+	return e, added, err
+}
+
+func (s *Bolt) Expenses(ctx context.Context, groupID string) ([]Expense, error) {
+	out := []Expense{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripExpensesBucket).Bucket([]byte(groupID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var e Expense
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			out = append(out, e)
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Bolt) TimeZone(ctx context.Context, groupID string) (string, error) {
+	var name string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		name = string(tx.Bucket(tripTimeZonesBucket).Get([]byte(groupID)))
+		return nil
+	})
+	return name, err
+}
+
+func (s *Bolt) SetTimeZone(ctx context.Context, groupID, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tripTimeZonesBucket).Put([]byte(groupID), []byte(name))
+	})
+}
+
+func (s *Bolt) Rates(ctx context.Context) (Rates, error) {
+	out := Rates{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tripRatesBucket).ForEach(func(k, v []byte) error {
+			rate, err := strconv.ParseFloat(string(v), 64)
+			if err != nil {
+				return err
+			}
+			out[string(k)] = rate
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *Bolt) SetRates(ctx context.Context, rates Rates) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripRatesBucket)
+		for code, rate := range rates {
+			if err := b.Put([]byte(code), []byte(strconv.FormatFloat(rate, 'g', -1, 64))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Bolt) RemoveRate(ctx context.Context, code string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tripRatesBucket).Delete([]byte(code))
+	})
+}
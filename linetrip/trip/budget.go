@@ -0,0 +1,172 @@
+package trip
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Expense categories. /pay takes one from a "#カテゴリ" word or guesses it
+// from the description.
+const (
+	CategoryFood      = "食事"
+	CategoryTransport = "交通"
+	CategoryLodging   = "宿泊"
+	CategorySights    = "観光"
+	CategoryShopping  = "買い物"
+	CategoryOther     = "その他"
+)
+
+var categoryWords = []struct {
+	category string
+	words    []string
+}{
+	{CategoryFood, []string{"ランチ", "ディナー", "朝食", "昼食", "夕食", "朝ごはん", "昼ごはん", "夜ごはん", "ご飯", "ごはん", "飯", "食", "カフェ", "コーヒー", "居酒屋", "飲み", "酒", "ビール", "焼肉", "寿司", "ラーメン", "弁当", "おやつ"}},
+	{CategoryTransport, []string{"タクシー", "電車", "バス", "新幹線", "飛行機", "航空", "フェリー", "レンタカー", "ガソリン", "駐車", "高速", "地下鉄", "切符", "交通"}},
+	{CategoryLodging, []string{"ホテル", "旅館", "宿", "民泊", "ゲストハウス", "泊"}},
+	{CategorySights, []string{"入場", "チケット", "拝観", "ツアー", "美術館", "博物館", "水族館", "温泉", "体験", "観光"}},
+	{CategoryShopping, []string{"お土産", "土産", "買い物", "ショッピング", "コンビニ"}},
+}
+
+// Categorize guesses the category of an expense from its description.
+func Categorize(description string) string {
+	for _, c := range categoryWords {
+		for _, w := range c.words {
+			if strings.Contains(description, w) {
+				return c.category
+			}
+		}
+	}
+	return CategoryOther
+}
+
+// CategorySpend is the spending in one category.
+type CategorySpend struct {
+	Category string `json:"category"`
+	Total    int64  `json:"total"`
+	Expenses int    `json:"expenses"`
+}
+
+// MemberSpend is what one member paid and their share of the spending.
+type MemberSpend struct {
+	UserID   string `json:"user_id"`
+	Name     string `json:"name"`
+	Paid     int64  `json:"paid"`
+	Share    int64  `json:"share"`
+	Expenses int    `json:"expenses"`
+}
+
+// CurrencySpend is the spending in one currency, in its minor unit and in
+// JPY.
+type CurrencySpend struct {
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+	Total    int64  `json:"total"`
+}
+
+// Budget summarizes a trip's spending in JPY.
+type Budget struct {
+	GroupID    string          `json:"group_id"`
+	TripID     string          `json:"trip_id"`
+	Currency   string          `json:"currency"`
+	Total      int64           `json:"total"`
+	Expenses   int             `json:"expenses"`
+	Categories []CategorySpend `json:"categories"`
+	Members    []MemberSpend   `json:"members"`
+	Currencies []CurrencySpend `json:"currencies"`
+	Rates      Rates           `json:"rates,omitempty"`
+}
+
+// Summarize totals the expenses of trip tripID by category, member and
+// currency. Categories and currencies are listed by total, largest first.
+func Summarize(ctx context.Context, s Store, people People, groupID, tripID string) (Budget, error) {
+	all, err := s.Expenses(ctx, groupID)
+	if err != nil {
+		return Budget{}, err
+	}
+	original := map[int]Expense{}
+	for _, e := range all {
+		original[e.ID] = e
+	}
+	expenses, rates, err := ledger(ctx, s, groupID, tripID)
+	if err != nil {
+		return Budget{}, err
+	}
+
+	result := Budget{GroupID: groupID, TripID: tripID, Currency: Currency, Expenses: len(expenses), Categories: []CategorySpend{}, Members: []MemberSpend{}, Currencies: []CurrencySpend{}, Rates: rates}
+	categories := map[string]*CategorySpend{}
+	members := map[string]*MemberSpend{}
+	currencies := map[string]*CurrencySpend{}
+	member := func(id string) *MemberSpend {
+		if m, ok := members[id]; ok {
+			return m
+		}
+		m := &MemberSpend{UserID: id}
+		members[id] = m
+		return m
+	}
+	for _, e := range expenses {
+		result.Total += e.Amount
+
+		category := e.Category
+		if category == "" {
+			category = CategoryOther
+		}
+		c, ok := categories[category]
+		if !ok {
+			c = &CategorySpend{Category: category}
+			categories[category] = c
+		}
+		c.Total += e.Amount
+		c.Expenses++
+
+		payer := member(e.PaidBy)
+		payer.Paid += e.Amount
+		payer.Expenses++
+		for id, share := range e.Shares() {
+			member(id).Share += share
+		}
+
+		code := original[e.ID].Code()
+		cur, ok := currencies[code]
+		if !ok {
+			cur = &CurrencySpend{Currency: code}
+			currencies[code] = cur
+		}
+		cur.Amount += original[e.ID].Amount
+		cur.Total += e.Amount
+	}
+
+	for _, c := range categories {
+		result.Categories = append(result.Categories, *c)
+	}
+	sort.Slice(result.Categories, func(i, j int) bool {
+		a, b := result.Categories[i], result.Categories[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Category < b.Category
+	})
+	for id, m := range members {
+		if people == nil {
+			m.Name = store.FallbackName(id)
+		} else {
+			m.Name = people.Name(ctx, groupID, id)
+		}
+		result.Members = append(result.Members, *m)
+	}
+	sort.Slice(result.Members, func(i, j int) bool { return result.Members[i].UserID < result.Members[j].UserID })
+	for _, c := range currencies {
+		result.Currencies = append(result.Currencies, *c)
+	}
+	sort.Slice(result.Currencies, func(i, j int) bool {
+		a, b := result.Currencies[i], result.Currencies[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Currency < b.Currency
+	})
+	return result, nil
+}
@@ -0,0 +1,575 @@
+package trip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Register adds the trip commands to r. people, when set, provides the
+// members an expense is split between by default and their names.
+func Register(r *command.Registry, s Store, people People) {
+	c := commands{store: s, people: people, now: time.Now}
+	r.Register(command.Command{Name: "add", Usage: "/add 清水寺", Summary: "リストに追加（改行で複数）", Run: c.add})
+	r.Register(command.Command{Name: "list", Usage: "/list", Summary: "リストを表示", ReadOnly: true, Run: c.list})
+	r.Register(command.Command{Name: "done", Usage: "/done 3", Summary: "番号の項目を完了にする", Run: c.done})
+	r.Register(command.Command{Name: "remove", Usage: "/remove 3", Summary: "番号の項目を削除する", Run: c.remove})
+	r.Register(command.Command{Name: "pay", Usage: "/pay 3000 ランチ", Summary: "立て替えを記録（@メンバーで割り勘の相手を指定）", Run: c.pay})
+	r.Register(command.Command{Name: "settle", Usage: "/settle", Summary: "精算（誰が誰にいくら払うか）", ReadOnly: true, Run: c.settle})
+	r.Register(command.Command{Name: "itinerary", Usage: "/itinerary", Summary: "旅程を日ごとのカードで表示", ReadOnly: true, Rich: c.itinerary})
+	r.Register(command.Command{Name: "timezone", Usage: "/timezone Asia/Tokyo", Summary: "日付を読むタイムゾーン（旅先に合わせる）", Run: c.timezone})
+	r.Register(command.Command{Name: "trip", Usage: "/trip new 京都旅行 11/3-11/5 京都", Summary: "旅行の作成・一覧（/trip）・切替（switch 2）・アーカイブ（archive）", Run: c.trip})
+}
+
+type commands struct {
+	store  Store
+	people People
+	now    func() time.Time
+}
+
+func (c commands) add(ctx context.Context, req command.Request) (string, error) {
+	var titles []string
+	for _, line := range strings.Split(req.Args, "\n") {
+		if title := cleanTitle(line); title != "" {
+			titles = append(titles, title)
+		}
+	}
+	if len(titles) == 0 {
+		return "追加する場所を書いてください（例: /add 清水寺）", nil
+	}
+
+	tripID, err := ActiveID(ctx, c.store, req.Message.GroupID)
+	if err != nil {
+		return "", err
+	}
+	now := c.at(req)
+	var lines []string
+	for i, title := range titles {
+		item := Item{
+			GroupID:     req.Message.GroupID,
+			TripID:      tripID,
+			Title:       title,
+			AddedBy:     req.Message.UserID,
+			AddedByName: req.Message.UserName,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if req.Message.MessageID != "" {
+			item.SourceMessageID = req.Message.MessageID
+			if len(titles) > 1 {
+				item.SourceMessageID += "#" + strconv.Itoa(i)
+			}
+		}
+		saved, _, err := c.store.AddItem(ctx, item)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", saved.ID, saved.Title))
+	}
+	return "✅ 追加しました\n" + strings.Join(lines, "\n"), nil
+}
+
+func (c commands) list(ctx context.Context, req command.Request) (string, error) {
+	active, _, err := Active(ctx, c.store, req.Message.GroupID)
+	if err != nil {
+		return "", err
+	}
+	items, err := c.store.Items(ctx, req.Message.GroupID)
+	if err != nil {
+		return "", err
+	}
+	items = ItemsOf(items, active.ID)
+	if len(items) == 0 {
+		return "リストはまだ空です。/add 清水寺 のように追加できます", nil
+	}
+	header := "📝 やりたいことリスト"
+	if active.ID != "" {
+		header += "（" + active.Title + "）"
+	}
+	return header + "\n" + Format(items), nil
+}
+
+func (c commands) done(ctx context.Context, req command.Request) (string, error) {
+	return c.each(ctx, req, "完了にする番号を書いてください（例: /done 3）", func(item Item) (string, error) {
+		if !item.Done {
+			now := c.at(req)
+			item.Done = true
+			item.DoneAt = now
+			item.UpdatedAt = now
+			if err := c.store.UpdateItem(ctx, item); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("🎉 %d. %s を完了にしました", item.ID, item.Title), nil
+	})
+}
+
+func (c commands) remove(ctx context.Context, req command.Request) (string, error) {
+	return c.each(ctx, req, "削除する番号を書いてください（例: /remove 3）", func(item Item) (string, error) {
+		if err := c.store.RemoveItem(ctx, item.GroupID, item.ID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("🗑️ %d. %s を削除しました", item.ID, item.Title), nil
+	})
+}
+
+// at is when a command happened: the message time, so replaying the
+// archive reproduces the original timestamps.
+func (c commands) at(req command.Request) int64 {
+	if req.Message.Timestamp > 0 {
+		return req.Message.Timestamp
+	}
+	return c.now().UnixMilli()
+}
+
+// each applies fn to every item number in the arguments.
+func (c commands) each(ctx context.Context, req command.Request, usage string, fn func(Item) (string, error)) (string, error) {
+	ids, ok := parseNumbers(req.Args)
+	if !ok {
+		return usage, nil
+	}
+	var lines []string
+	for _, id := range ids {
+		item, err := c.store.Item(ctx, req.Message.GroupID, id)
+		if errors.Is(err, ErrNotFound) {
+			lines = append(lines, fmt.Sprintf("%d 番の項目はありません", id))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		line, err := fn(item)
+		if errors.Is(err, ErrNotFound) {
+			// 同時に削除された
+			lines = append(lines, fmt.Sprintf("%d 番の項目はありません", id))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// parseNumbers reads item numbers such as "3", "#3", "３" or "1 2 5".
+func parseNumbers(args string) ([]int, bool) {
+	args = strings.Map(func(r rune) rune {
+		switch {
+		case r >= '０' && r <= '９':
+			return '0' + (r - '０')
+		case r == '#' || r == '＃' || r == ',' || r == '、':
+			return ' '
+		}
+		return r
+	}, args)
+
+	var ids []int
+	for _, field := range strings.Fields(args) {
+		id, err := strconv.Atoi(field)
+		if err != nil || id <= 0 {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, len(ids) > 0
+}
+
+// Format renders items as numbered lines with a check mark for done ones.
+func Format(items []Item) string {
+	var b strings.Builder
+	for i, item := range items {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		mark := "⬜"
+		if item.Done {
+			mark = "✅"
+		}
+		fmt.Fprintf(&b, "%s %d. %s", mark, item.ID, item.Title)
+	}
+	return b.String()
+}
+
+// trip runs the /trip subcommands: new, switch, archive and the list shown
+// without one.
+func (c commands) trip(ctx context.Context, req command.Request) (string, error) {
+	sub, rest, _ := strings.Cut(strings.TrimSpace(req.Args), " ")
+	rest = strings.TrimSpace(rest)
+	switch strings.ToLower(sub) {
+	case "", "list", "一覧":
+		return c.trips(ctx, req)
+	case "new", "作成":
+		return c.newTrip(ctx, req, rest)
+	case "switch", "切替", "切り替え":
+		n, ok := parseNumbers(rest)
+		if !ok || len(n) != 1 {
+			return "切り替える旅行の番号を書いてください（例: /trip switch 2）", nil
+		}
+		t, err := Activate(ctx, c.store, TripID(req.Message.GroupID, n[0]), c.at(req))
+		if errors.Is(err, ErrTripNotFound) {
+			return fmt.Sprintf("%d 番の旅行はありません", n[0]), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("🔁 %d. %s に切り替えました", t.Number, t.Title), nil
+	case "archive", "終了":
+		id, err := ActiveID(ctx, c.store, req.Message.GroupID)
+		if err != nil {
+			return "", err
+		}
+		if rest != "" {
+			n, ok := parseNumbers(rest)
+			if !ok || len(n) != 1 {
+				return "アーカイブする旅行の番号を書いてください（例: /trip archive 2）", nil
+			}
+			id = TripID(req.Message.GroupID, n[0])
+		}
+		if id == "" {
+			return "進行中の旅行はありません", nil
+		}
+		t, err := Archive(ctx, c.store, id, c.at(req))
+		if errors.Is(err, ErrTripNotFound) {
+			return "その番号の旅行はありません", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("🗄️ %d. %s をアーカイブしました", t.Number, t.Title), nil
+	default:
+		return "使い方: /trip new 京都旅行 11/3-11/5 京都、/trip switch 2、/trip archive", nil
+	}
+}
+
+func (c commands) trips(ctx context.Context, req command.Request) (string, error) {
+	trips, err := c.store.Trips(ctx, req.Message.GroupID)
+	if err != nil {
+		return "", err
+	}
+	if len(trips) == 0 {
+		return "旅行はまだありません。/trip new 京都旅行 11/3-11/5 京都 のように作成できます", nil
+	}
+	var b strings.Builder
+	b.WriteString("🧳 旅行一覧")
+	for _, t := range trips {
+		mark := "・"
+		switch t.Status {
+		case StatusActive:
+			mark = "▶️"
+		case StatusArchived:
+			mark = "🗄️"
+		}
+		fmt.Fprintf(&b, "\n%s %d. %s", mark, t.Number, t.Title)
+		if dates := formatDates(t); dates != "" {
+			fmt.Fprintf(&b, "（%s）", dates)
+		}
+	}
+	return b.String(), nil
+}
+
+func (c commands) newTrip(ctx context.Context, req command.Request, args string) (string, error) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return "旅行の名前を書いてください（例: /trip new 京都旅行 11/3-11/5 京都）", nil
+	}
+	t := Trip{
+		GroupID:         req.Message.GroupID,
+		Title:           fields[0],
+		CreatedBy:       req.Message.UserID,
+		SourceMessageID: req.Message.MessageID,
+	}
+	loc, err := Location(ctx, c.store, req.Message.GroupID)
+	if err != nil {
+		return "", err
+	}
+	rest := fields[1:]
+	if len(rest) > 0 {
+		if start, end, ok := ParseDateRange(rest[0], time.UnixMilli(c.at(req)).In(loc)); ok {
+			t.StartDate, t.EndDate = start, end
+			rest = rest[1:]
+		}
+	}
+	t.Destination = strings.Join(rest, " ")
+
+	saved, _, err := Create(ctx, c.store, t, c.at(req))
+	if err != nil {
+		return "", err
+	}
+	reply := fmt.Sprintf("🧳 %d. %s を作成しました", saved.Number, saved.Title)
+	if dates := formatDates(saved); dates != "" {
+		reply += "\n📅 " + dates
+	}
+	if saved.Destination != "" {
+		reply += "\n📍 " + saved.Destination
+	}
+	return reply + "\nこれからの /add とメッセージはこの旅行に記録されます", nil
+}
+
+// formatDates renders a trip's dates as "11/3〜11/5".
+func formatDates(t Trip) string {
+	short := func(d string) string {
+		day, err := time.Parse(DateLayout, d)
+		if err != nil {
+			return d
+		}
+		return fmt.Sprintf("%d/%d", day.Month(), day.Day())
+	}
+	switch {
+	case t.StartDate == "":
+		return ""
+	case t.EndDate == "" || t.EndDate == t.StartDate:
+		return short(t.StartDate)
+	default:
+		return short(t.StartDate) + "〜" + short(t.EndDate)
+	}
+}
+
+func (c commands) timezone(ctx context.Context, req command.Request) (string, error) {
+	name := strings.TrimSpace(req.Args)
+	if name == "" {
+		loc, err := Location(ctx, c.store, req.Message.GroupID)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("🕘 タイムゾーンは %s です（変更: /timezone America/New_York）", loc), nil
+	}
+	if _, err := time.LoadLocation(name); err != nil || name == "Local" {
+		return fmt.Sprintf("%s は知らないタイムゾーンです（例: Asia/Tokyo）", name), nil
+	}
+	if err := c.store.SetTimeZone(ctx, req.Message.GroupID, name); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("🕘 タイムゾーンを %s にしました", name), nil
+}
+
+func (c commands) pay(ctx context.Context, req command.Request) (string, error) {
+	const usage = "金額を書いてください（例: /pay 3000 ランチ、/pay 1200 タクシー @たくと、/pay 30000ウォン 夕食）"
+	m := req.Message
+	args := req.Args
+	active, _, err := Active(ctx, c.store, m.GroupID)
+	if err != nil {
+		return "", err
+	}
+	table, err := c.store.Rates(ctx)
+	if err != nil {
+		return "", err
+	}
+	participants := map[string]bool{}
+	all := false
+	// LINE のメンションは表示名に空白があっても正確に分かる
+	for _, mention := range m.Mentions {
+		if mention.Text == "" || !strings.Contains(args, mention.Text) {
+			continue
+		}
+		args = strings.Replace(args, mention.Text, " ", 1)
+		switch {
+		case mention.All:
+			all = true
+		case mention.UserID != "":
+			participants[mention.UserID] = true
+		}
+	}
+
+	var amount int64
+	var code, category string
+	var words, names []string
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if name, ok := strings.CutPrefix(strings.Replace(field, "＠", "@", 1), "@"); ok {
+			names = append(names, name)
+			continue
+		}
+		if tag, ok := strings.CutPrefix(strings.Replace(field, "＃", "#", 1), "#"); ok && tag != "" {
+			category = tag
+			continue
+		}
+		if amount == 0 {
+			// 「30000 KRW」のように通貨が次の単語のこともある。知らない英字
+			// 3 文字（BBQ など）は説明として扱う
+			if i+1 < len(fields) {
+				next, ok := currencyOf(fields[i+1])
+				if ok && ValidCurrency(fields[i+1]) && next != Currency && table[next] == 0 && active.Rates[next] == 0 {
+					ok = false
+				}
+				if n, cur, parsed := parseMoney(field + next); ok && parsed {
+					amount, code = n, cur
+					i++
+					continue
+				}
+			}
+			if n, cur, ok := parseMoney(field); ok {
+				amount, code = n, cur
+				continue
+			}
+		}
+		words = append(words, field)
+	}
+	if amount == 0 {
+		return usage, nil
+	}
+	if code == Currency {
+		code = ""
+	}
+	rate := 1.0
+	if code != "" {
+		if active.ID == "" {
+			return "外貨の支払いは旅行ごとに記録します。先に /trip new で旅行を作ってください", nil
+		}
+		rate, err = SnapshotRate(ctx, c.store, &active, code)
+		if errors.Is(err, ErrNoRate) {
+			return fmt.Sprintf("%s の為替レートが登録されていません（管理者が /api/rates で登録できます）", code), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	members, err := c.members(ctx, m.GroupID)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		id, ok := c.findMember(ctx, m.GroupID, members, name)
+		if !ok {
+			return fmt.Sprintf("@%s が見つかりません（LINE のメンションで指定してください）", name), nil
+		}
+		participants[id] = true
+	}
+
+	var split []string
+	if len(participants) == 0 || all {
+		split = members
+	} else {
+		for id := range participants {
+			split = append(split, id)
+		}
+	}
+	if !contains(split, m.UserID) {
+		split = append(split, m.UserID)
+	}
+	sort.Strings(split)
+
+	description := strings.Join(words, " ")
+	if category == "" {
+		category = Categorize(description)
+	}
+	e, _, err := c.store.AddExpense(ctx, Expense{
+		GroupID:         m.GroupID,
+		TripID:          active.ID,
+		PaidBy:          m.UserID,
+		Amount:          amount,
+		Currency:        code,
+		Description:     description,
+		Category:        category,
+		Participants:    split,
+		SourceMessageID: m.MessageID,
+		CreatedAt:       c.at(req),
+	})
+	if err != nil {
+		return "", err
+	}
+	total, err := Convert(e.Amount, e.Code(), active.Rates)
+	if err != nil {
+		return "", err
+	}
+
+	reply := fmt.Sprintf("💰 %s さんが %s", c.name(ctx, m.GroupID, e.PaidBy), money(e.Amount, e.Code()))
+	if e.Description != "" {
+		reply += "（" + e.Description + "）"
+	}
+	reply += "を支払いました"
+	if e.Code() != Currency {
+		reply += fmt.Sprintf("\n💱 %s（1 %s = %s円）", yen(total), e.Code(), strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+	if n := int64(len(e.Participants)); n > 1 {
+		reply += fmt.Sprintf("\n👥 %d 人で割り勘: 1 人 %s", n, yen(total/n))
+	}
+	return reply, nil
+}
+
+func (c commands) settle(ctx context.Context, req command.Request) (string, error) {
+	active, _, err := Active(ctx, c.store, req.Message.GroupID)
+	if err != nil {
+		return "", err
+	}
+	result, err := Settle(ctx, c.store, c.people, req.Message.GroupID, active.ID)
+	if err != nil {
+		return "", err
+	}
+	if result.Expenses == 0 {
+		return "支払いの記録がありません。/pay 3000 ランチ のように記録できます", nil
+	}
+
+	var b strings.Builder
+	b.WriteString("🧾 精算")
+	if active.ID != "" {
+		b.WriteString("（" + active.Title + "）")
+	}
+	fmt.Fprintf(&b, "\n合計 %s・%d 件", yen(result.Total), result.Expenses)
+	if len(result.Transfers) == 0 {
+		b.WriteString("\n精算は必要ありません")
+	}
+	for _, t := range result.Transfers {
+		fmt.Fprintf(&b, "\n%s → %s %s", t.FromName, t.ToName, yen(t.Amount))
+	}
+	return b.String(), nil
+}
+
+// members lists the conversation members an expense is split between by
+// default.
+func (c commands) members(ctx context.Context, groupID string) ([]string, error) {
+	if c.people == nil {
+		return nil, nil
+	}
+	return c.people.Members(ctx, groupID)
+}
+
+func (c commands) name(ctx context.Context, groupID, userID string) string {
+	if c.people == nil {
+		return store.FallbackName(userID)
+	}
+	return c.people.Name(ctx, groupID, userID)
+}
+
+// findMember finds a member by a display name typed after "@".
+func (c commands) findMember(ctx context.Context, groupID string, members []string, name string) (string, bool) {
+	for _, id := range members {
+		if strings.EqualFold(strings.ReplaceAll(c.name(ctx, groupID, id), " ", ""), name) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c commands) itinerary(ctx context.Context, req command.Request) (command.Reply, error) {
+	active, ok, err := Active(ctx, c.store, req.Message.GroupID)
+	if err != nil || !ok {
+		return command.Reply{Text: "進行中の旅行はありません。/trip new 京都旅行 11/3-11/5 で作成できます"}, err
+	}
+	entries, err := c.store.Entries(ctx, req.Message.GroupID)
+	if err != nil {
+		return command.Reply{}, err
+	}
+	if !Planned(active, entries) {
+		return command.Reply{Text: "旅程はまだありません。「3日目の10時に金閣寺」のように日時を書くと候補になります"}, nil
+	}
+	return ItineraryReply(active, entries)
+}
@@ -0,0 +1,205 @@
+package trip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rates maps an ISO 4217 currency code to its price in JPY for one unit of
+// the currency (1 KRW = 0.11).
+type Rates map[string]float64
+
+// ErrNoRate is returned for a currency with no exchange rate.
+var ErrNoRate = errors.New("no exchange rate")
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidCurrency reports whether code looks like an ISO 4217 code.
+func ValidCurrency(code string) bool {
+	return currencyCode.MatchString(code)
+}
+
+// Validate checks that every code is a currency code and every rate is
+// positive.
+func (r Rates) Validate() error {
+	for code, rate := range r {
+		if !ValidCurrency(code) {
+			return fmt.Errorf("%q is not a currency code", code)
+		}
+		if !(rate > 0) || math.IsInf(rate, 0) {
+			return fmt.Errorf("rate of %s must be positive", code)
+		}
+	}
+	return nil
+}
+
+// zeroDecimal lists currencies without a minor unit; other currencies are
+// counted in hundredths (cents).
+var zeroDecimal = map[string]bool{"JPY": true, "KRW": true, "VND": true, "CLP": true, "ISK": true}
+
+func digits(code string) int {
+	if zeroDecimal[code] {
+		return 0
+	}
+	return 2
+}
+
+// Convert turns an amount in the minor unit of code into JPY at rates,
+// rounding to the yen.
+func Convert(amount int64, code string, rates Rates) (int64, error) {
+	if code == "" || code == Currency {
+		return amount, nil
+	}
+	rate, ok := rates[code]
+	if !ok {
+		return 0, fmt.Errorf("%w for %s", ErrNoRate, code)
+	}
+	return int64(math.Round(float64(amount) / math.Pow10(digits(code)) * rate)), nil
+}
+
+// SnapshotRate makes sure trip t has a rate for code, copying it from the
+// rate table the first time the currency is used on the trip. Later changes
+// to the table leave the trip's settlement as it was. Only the rate is
+// written, and t.Rates is updated with the rate the trip ended up with.
+func SnapshotRate(ctx context.Context, s Store, t *Trip, code string) (float64, error) {
+	if code == "" || code == Currency {
+		return 1, nil
+	}
+	if rate, ok := t.Rates[code]; ok {
+		return rate, nil
+	}
+	table, err := s.Rates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rate, ok := table[code]
+	if !ok {
+		return 0, fmt.Errorf("%w for %s", ErrNoRate, code)
+	}
+
+	// 同時に別の支払いが記録していれば、そちらのレートが返る
+	rate, err = s.SnapshotTripRate(ctx, t.ID, code, rate)
+	if err != nil {
+		return 0, err
+	}
+	t.Rates = mergeRates(t.Rates, Rates{code: rate})
+	return rate, nil
+}
+
+// mergeRates returns rates with update added, without modifying rates,
+// which may be shared with a stored trip.
+func mergeRates(rates, update Rates) Rates {
+	if len(update) == 0 {
+		return rates
+	}
+	out := make(Rates, len(rates)+len(update))
+	for code, rate := range rates {
+		out[code] = rate
+	}
+	for code, rate := range update {
+		out[code] = rate
+	}
+	return out
+}
+
+// currencyWords are the ways chat messages write a currency, longest first
+// so that "台湾ドル" wins over "ドル".
+var currencyWords = []struct {
+	word string
+	code string
+}{
+	{"台湾ドル", "TWD"}, {"NT$", "TWD"}, {"US$", "USD"}, {"ウォン", "KRW"},
+	{"ドル", "USD"}, {"ユーロ", "EUR"}, {"円", "JPY"},
+	{"₩", "KRW"}, {"￦", "KRW"}, {"$", "USD"}, {"＄", "USD"}, {"€", "EUR"},
+	{"¥", "JPY"}, {"￥", "JPY"},
+}
+
+// currencyOf reads a word that only names a currency ("KRW", "ウォン").
+func currencyOf(word string) (string, bool) {
+	if ValidCurrency(word) {
+		return word, true
+	}
+	for _, c := range currencyWords {
+		if word == c.word {
+			return c.code, true
+		}
+	}
+	return "", false
+}
+
+// parseMoney reads an amount with an optional currency: "3000", "3,000円",
+// "₩30000", "30000ウォン", "$12.50", "12.50USD" or "500台湾ドル". The amount
+// is in the minor unit of the currency, and the code is "" when no currency
+// is written (JPY).
+func parseMoney(s string) (int64, string, bool) {
+	code := ""
+	for _, c := range currencyWords {
+		if rest, ok := strings.CutPrefix(s, c.word); ok {
+			s, code = rest, c.code
+			break
+		}
+		if rest, ok := strings.CutSuffix(s, c.word); ok {
+			s, code = rest, c.code
+			break
+		}
+	}
+	if code == "" && len(s) > 3 {
+		if c := s[len(s)-3:]; ValidCurrency(c) {
+			s, code = s[:len(s)-3], c
+		} else if c := s[:3]; ValidCurrency(c) {
+			s, code = s[3:], c
+		}
+	}
+	if code == "" || code == Currency {
+		n, ok := parseAmount(s)
+		return n, code, ok
+	}
+	n, ok := parseDecimal(s, digits(code))
+	return n, code, ok
+}
+
+// parseDecimal reads "12.50" or "30,000" as an integer with the given
+// number of decimal digits.
+func parseDecimal(s string, places int) (int64, bool) {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= '０' && r <= '９':
+			return '0' + (r - '０')
+		case r == '．':
+			return '.'
+		case r == ',' || r == '，':
+			return -1
+		}
+		return r
+	}, s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > places {
+		return 0, false
+	}
+	frac += strings.Repeat("0", places-len(frac))
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || n <= 0 || n > 100_000_000*int64(math.Pow10(places)) {
+		return 0, false
+	}
+	return n, true
+}
+
+// money formats an amount in the minor unit of code, as "3,000円" or
+// "12.50 USD".
+func money(amount int64, code string) string {
+	if code == "" || code == Currency {
+		return yen(amount)
+	}
+	d := digits(code)
+	unit := int64(math.Pow10(d))
+	s := strings.TrimSuffix(yen(amount/unit), "円")
+	if d > 0 {
+		s += fmt.Sprintf(".%0*d", d, amount%unit)
+	}
+	return s + " " + code
+}
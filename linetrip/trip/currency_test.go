@@ -0,0 +1,234 @@
+package trip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"3000", "3000 "},
+		{"３,０００円", "3000 JPY"},
+		{"￥3000", "3000 JPY"},
+		{"₩30000", "30000 KRW"},
+		{"30,000ウォン", "30000 KRW"},
+		{"$12.5", "1250 USD"},
+		{"US$12.50", "1250 USD"},
+		{"12.50USD", "1250 USD"},
+		{"NT$500", "50000 TWD"},
+		{"500台湾ドル", "50000 TWD"},
+		{"€9.99", "999 EUR"},
+		{"KRW15000", "15000 KRW"},
+		{"$12.505", "invalid"},
+		{"12.5円", "invalid"},
+		{"ランチ", "invalid"},
+		{"₩0", "invalid"},
+	}
+	for _, tt := range tests {
+		got := "invalid"
+		if n, code, ok := parseMoney(tt.in); ok {
+			got = fmt.Sprintf("%d %s", n, code)
+		}
+		if got != tt.want {
+			t.Errorf("parseMoney(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	rates := Rates{"KRW": 0.11, "USD": 150.25}
+	tests := []struct {
+		amount int64
+		code   string
+		want   int64
+	}{
+		{3000, "", 3000},
+		{3000, "JPY", 3000},
+		{30000, "KRW", 3300},
+		{1250, "USD", 1878}, // 12.50 × 150.25 = 1878.125
+	}
+	for _, tt := range tests {
+		if got, err := Convert(tt.amount, tt.code, rates); err != nil || got != tt.want {
+			t.Errorf("Convert(%d, %s) = %d, %v, want %d", tt.amount, tt.code, got, err, tt.want)
+		}
+	}
+	if _, err := Convert(100, "EUR", rates); !errors.Is(err, ErrNoRate) {
+		t.Errorf("Convert without rate: %v", err)
+	}
+	if got := money(1250, "USD") + " / " + money(30000, "KRW") + " / " + money(3000, ""); got != "12.50 USD / 30,000 KRW / 3,000円" {
+		t.Errorf("money = %s", got)
+	}
+	if err := (Rates{"usd": 150}).Validate(); err == nil {
+		t.Error("lowercase code accepted")
+	}
+	if err := (Rates{"USD": 0}).Validate(); err == nil {
+		t.Error("zero rate accepted")
+	}
+}
+
+func TestRates(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			ctx := context.Background()
+
+			if rates, err := s.Rates(ctx); err != nil || len(rates) != 0 {
+				t.Fatalf("empty Rates = %v, %v", rates, err)
+			}
+			if err := s.SetRates(ctx, Rates{"KRW": 0.11, "USD": 150.25}); err != nil {
+				t.Fatal(err)
+			}
+			s.SetRates(ctx, Rates{"USD": 149.5})
+			if err := s.RemoveRate(ctx, "KRW"); err != nil {
+				t.Fatal(err)
+			}
+			rates, err := s.Rates(ctx)
+			if err != nil || fmt.Sprint(rates) != "map[USD:149.5]" {
+				t.Errorf("Rates = %v, %v", rates, err)
+			}
+		})
+	}
+}
+
+func TestSnapshotRate(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			ctx := context.Background()
+			s.SetRates(ctx, Rates{"KRW": 0.11, "USD": 150})
+			created, _, _ := Create(ctx, s, Trip{GroupID: "C1", Title: "ソウル旅行"}, 1)
+
+			// 読んだ後に旅行が編集されても、レート以外は上書きしない
+			stale := created
+			edited := created
+			edited.Title, edited.Destination = "ソウル・釜山旅行", "釜山"
+			s.UpdateTrip(ctx, edited)
+			if rate, err := SnapshotRate(ctx, s, &stale, "KRW"); err != nil || rate != 0.11 {
+				t.Fatalf("SnapshotRate = %v, %v", rate, err)
+			}
+			saved, _ := s.Trip(ctx, created.ID)
+			if saved.Title != "ソウル・釜山旅行" || saved.Destination != "釜山" || fmt.Sprint(saved.Rates) != "map[KRW:0.11]" {
+				t.Errorf("saved = %+v", saved)
+			}
+			if fmt.Sprint(stale.Rates) != "map[KRW:0.11]" {
+				t.Errorf("rates not updated: %+v", stale.Rates)
+			}
+
+			// 別の支払いが先に記録したレートを使う
+			other := created
+			s.SetRates(ctx, Rates{"KRW": 0.2})
+			if rate, _ := SnapshotRate(ctx, s, &other, "KRW"); rate != 0.11 {
+				t.Errorf("concurrent snapshot = %v", rate)
+			}
+
+			// レートを持たない古い読み取りで更新しても、記録したレートは残る
+			edited.Title = "韓国旅行"
+			if err := s.UpdateTrip(ctx, edited); err != nil {
+				t.Fatal(err)
+			}
+			saved, _ = s.Trip(ctx, created.ID)
+			if saved.Title != "韓国旅行" || fmt.Sprint(saved.Rates) != "map[KRW:0.11]" {
+				t.Errorf("after stale update = %+v", saved)
+			}
+			if trips, _ := s.Trips(ctx, "C1"); len(trips) != 1 || fmt.Sprint(trips[0].Rates) != "map[KRW:0.11]" {
+				t.Errorf("Trips = %+v", trips)
+			}
+
+			// 旅行のレートは直接直せる
+			saved.Rates = Rates{"KRW": 0.105}
+			s.UpdateTrip(ctx, saved)
+			if saved, _ := s.Trip(ctx, created.ID); fmt.Sprint(saved.Rates) != "map[KRW:0.105]" {
+				t.Errorf("rates after edit = %v", saved.Rates)
+			}
+		})
+	}
+}
+
+func TestForeignExpenses(t *testing.T) {
+	r := command.NewRegistry()
+	s := NewMemory()
+	Register(r, s, people{"U1": "たくと", "U2": "はなこ"})
+	ctx := context.Background()
+	s.SetRates(ctx, Rates{"KRW": 0.11, "USD": 150})
+
+	start := time.Date(2026, 11, 3, 12, 0, 0, 0, Tokyo).UnixMilli()
+	seq := 0
+	send := func(userID, text string) string {
+		seq++
+		m := store.Message{GroupID: "C1", UserID: userID, MessageID: fmt.Sprintf("m%d", seq), Message: text, Timestamp: start + int64(seq)}
+		reply, ok, err := r.Dispatch(ctx, m, false)
+		if !ok || err != nil {
+			t.Fatalf("%s: ok=%v err=%v", text, ok, err)
+		}
+		return reply
+	}
+
+	if reply := send("U1", "/pay 30000ウォン 夕食"); !strings.Contains(reply, "/trip new") {
+		t.Errorf("foreign pay without trip = %q", reply)
+	}
+	send("U1", "/trip new ソウル旅行")
+	if reply := send("U1", "/pay 30000ウォン 夕食"); reply != "💰 たくと さんが 30,000 KRW（夕食）を支払いました\n💱 3,300円（1 KRW = 0.11円）\n👥 2 人で割り勘: 1 人 1,650円" {
+		t.Errorf("pay in KRW = %q", reply)
+	}
+	// 旅行で使い始めた後の表の変更は反映しない
+	s.SetRates(ctx, Rates{"KRW": 0.2})
+	if reply := send("U2", "/pay 10000 KRW タクシー"); !strings.Contains(reply, "1,100円") {
+		t.Errorf("pay with code after table change = %q", reply)
+	}
+	if reply := send("U2", "/pay 2000 BBQ"); !strings.HasPrefix(reply, "💰 はなこ さんが 2,000円（BBQ）") {
+		t.Errorf("unknown code kept as description = %q", reply)
+	}
+	if reply := send("U1", "/pay €20 お土産"); !strings.Contains(reply, "EUR の為替レートが登録されていません") {
+		t.Errorf("pay without rate = %q", reply)
+	}
+	send("U1", "/pay $20 #ショー 公演")
+
+	trip, _, _ := Active(ctx, s, "C1")
+	if fmt.Sprint(trip.Rates) != "map[KRW:0.11 USD:150]" {
+		t.Errorf("snapshot = %v", trip.Rates)
+	}
+	expenses, _ := s.Expenses(ctx, "C1")
+	var categories []string
+	for _, e := range expenses {
+		categories = append(categories, e.Category)
+	}
+	if fmt.Sprint(categories) != "[食事 交通 その他 ショー]" {
+		t.Errorf("categories = %v", categories)
+	}
+
+	// たくと: 3300+3000 払って 1650+550+1000+1500 負担 → +1600
+	result, err := Settle(ctx, s, people{}, "C1", trip.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 9400 || len(result.Transfers) != 1 || result.Transfers[0].Amount != 1600 {
+		t.Errorf("settlement = %+v", result)
+	}
+
+	budget, err := Summarize(ctx, s, people{"U1": "たくと"}, "C1", trip.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.Total != 9400 || budget.Expenses != 4 {
+		t.Errorf("budget = %+v", budget)
+	}
+	if fmt.Sprint(budget.Categories) != "[{食事 3300 1} {ショー 3000 1} {その他 2000 1} {交通 1100 1}]" {
+		t.Errorf("categories = %v", budget.Categories)
+	}
+	if fmt.Sprint(budget.Members) != "[{U1 たくと 6300 4700 2} {U2 User-U2 3100 4700 2}]" {
+		t.Errorf("members = %v", budget.Members)
+	}
+	if fmt.Sprint(budget.Currencies) != "[{KRW 40000 4400} {USD 2000 3000} {JPY 2000 2000}]" {
+		t.Errorf("currencies = %v", budget.Currencies)
+	}
+}
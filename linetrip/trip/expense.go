@@ -0,0 +1,372 @@
+package trip
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/profile"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Currency is the currency ledgers are settled in.
+const Currency = "JPY"
+
+// Expense is one payment recorded with /pay, split evenly between its
+// participants.
+type Expense struct {
+	// ID is the expense number within the conversation.
+	ID      int    `json:"id"`
+	GroupID string `json:"group_id"`
+	TripID  string `json:"trip_id,omitempty"`
+	PaidBy  string `json:"paid_by"`
+	// Amount is in the minor unit of Currency (cents for USD), which is
+	// JPY when empty. Other currencies are converted at the trip's rates.
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency,omitempty"`
+	Description string `json:"description,omitempty"`
+	Category    string `json:"category,omitempty"`
+	// Participants share the amount. The payer's share absorbs the
+	// remainder of an uneven split.
+	Participants    []string `json:"participants"`
+	SourceMessageID string   `json:"source_message_id,omitempty"`
+	CreatedAt       int64    `json:"created_at"`
+}
+
+// Shares splits the amount between the participants.
+func (e Expense) Shares() map[string]int64 {
+	shares := make(map[string]int64, len(e.Participants))
+	if len(e.Participants) == 0 {
+		return shares
+	}
+	n := int64(len(e.Participants))
+	each, rest := e.Amount/n, e.Amount%n
+	for _, id := range e.Participants {
+		shares[id] = each
+	}
+	if _, ok := shares[e.PaidBy]; ok {
+		shares[e.PaidBy] += rest
+		return shares
+	}
+	// 支払った人が含まれないときは先頭から 1 円ずつ
+	for _, id := range e.Participants[:rest] {
+		shares[id]++
+	}
+	return shares
+}
+
+// Code returns the expense's currency code.
+func (e Expense) Code() string {
+	if e.Currency == "" {
+		return Currency
+	}
+	return e.Currency
+}
+
+// ExpensesOf filters expenses to those of trip id.
+func ExpensesOf(expenses []Expense, id string) []Expense {
+	out := []Expense{}
+	for _, e := range expenses {
+		if e.TripID == id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// People answers who is in a conversation and what they are called.
+type People interface {
+	Members(ctx context.Context, groupID string) ([]string, error)
+	Name(ctx context.Context, groupID, userID string) string
+}
+
+// Directory implements People with the message store's membership registry
+// and profile cache. Profiles, when set, fetches profiles missing from the
+// cache from LINE.
+type Directory struct {
+	Store    store.MessageStore
+	Profiles *profile.Resolver
+}
+
+func (d Directory) Members(ctx context.Context, groupID string) ([]string, error) {
+	return d.Store.Members(ctx, groupID)
+}
+
+func (d Directory) Name(ctx context.Context, groupID, userID string) string {
+	if d.Profiles != nil {
+		if p, err := d.Profiles.Lookup(ctx, groupID, userID); err == nil && p.DisplayName != "" {
+			return p.DisplayName
+		}
+	} else if p, ok, err := d.Store.Profile(ctx, groupID, userID); err == nil && ok && p.DisplayName != "" {
+		return p.DisplayName
+	}
+	return store.FallbackName(userID)
+}
+
+// Balance is what one member paid and owes over a ledger. A positive
+// Balance is money to receive.
+type Balance struct {
+	UserID  string `json:"user_id"`
+	Name    string `json:"name"`
+	Paid    int64  `json:"paid"`
+	Owed    int64  `json:"owed"`
+	Balance int64  `json:"balance"`
+}
+
+// Transfer is one payment that settles a ledger.
+type Transfer struct {
+	From     string `json:"from"`
+	FromName string `json:"from_name"`
+	To       string `json:"to"`
+	ToName   string `json:"to_name"`
+	Amount   int64  `json:"amount"`
+}
+
+// Settlement is how to even out a trip's expenses.
+type Settlement struct {
+	GroupID   string     `json:"group_id"`
+	TripID    string     `json:"trip_id"`
+	Currency  string     `json:"currency"`
+	Total     int64      `json:"total"`
+	Expenses  int        `json:"expenses"`
+	Balances  []Balance  `json:"balances"`
+	Transfers []Transfer `json:"transfers"`
+	// Rates are the trip's exchange rates the expenses were converted at.
+	Rates Rates `json:"rates,omitempty"`
+}
+
+// ledger lists the expenses of trip tripID of a conversation ("" for those
+// recorded outside any trip) with their amounts converted to JPY, along
+// with the rates used.
+func ledger(ctx context.Context, s Store, groupID, tripID string) ([]Expense, Rates, error) {
+	all, err := s.Expenses(ctx, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	expenses := ExpensesOf(all, tripID)
+
+	var rates Rates
+	if tripID != "" {
+		t, err := s.Trip(ctx, tripID)
+		if err != nil {
+			return nil, nil, err
+		}
+		rates = t.Rates
+	}
+	for i, e := range expenses {
+		amount, err := Convert(e.Amount, e.Code(), rates)
+		if err != nil {
+			return nil, nil, err
+		}
+		expenses[i].Amount, expenses[i].Currency = amount, ""
+	}
+	return expenses, rates, nil
+}
+
+// Settle computes the settlement of the expenses recorded for trip tripID
+// of a conversation ("" for those recorded outside any trip), in JPY.
+func Settle(ctx context.Context, s Store, people People, groupID, tripID string) (Settlement, error) {
+	expenses, rates, err := ledger(ctx, s, groupID, tripID)
+	if err != nil {
+		return Settlement{}, err
+	}
+
+	result := Settlement{GroupID: groupID, TripID: tripID, Currency: Currency, Expenses: len(expenses), Balances: []Balance{}, Transfers: []Transfer{}, Rates: rates}
+	byUser := map[string]*Balance{}
+	get := func(id string) *Balance {
+		if b, ok := byUser[id]; ok {
+			return b
+		}
+		b := &Balance{UserID: id}
+		byUser[id] = b
+		return b
+	}
+	for _, e := range expenses {
+		result.Total += e.Amount
+		get(e.PaidBy).Paid += e.Amount
+		for id, share := range e.Shares() {
+			get(id).Owed += share
+		}
+	}
+
+	name := func(id string) string {
+		if people == nil {
+			return store.FallbackName(id)
+		}
+		return people.Name(ctx, groupID, id)
+	}
+	balances := map[string]int64{}
+	for id, b := range byUser {
+		b.Balance = b.Paid - b.Owed
+		b.Name = name(id)
+		balances[id] = b.Balance
+		result.Balances = append(result.Balances, *b)
+	}
+	sort.Slice(result.Balances, func(i, j int) bool { return result.Balances[i].UserID < result.Balances[j].UserID })
+
+	for _, t := range Transfers(balances) {
+		t.FromName, t.ToName = byUser[t.From].Name, byUser[t.To].Name
+		result.Transfers = append(result.Transfers, t)
+	}
+	return result, nil
+}
+
+// exactLimit bounds the members for which Transfers searches the fewest
+// transfers; larger ledgers are settled greedily.
+const exactLimit = 16
+
+// Transfers returns the fewest payments that bring every balance to zero.
+// Balances must sum to zero.
+//
+// A ledger of n non-zero balances can always be settled with n-1 transfers,
+// and with one fewer for every group of members whose balances already sum
+// to zero among themselves. The search finds the largest number of such
+// groups, then settles each group greedily.
+func Transfers(balances map[string]int64) []Transfer {
+	var ids []string
+	for id, b := range balances {
+		if b != 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(ids) > exactLimit {
+		return settleGroup(ids, balances)
+	}
+
+	n := len(ids)
+	full := 1<<n - 1
+	sum := make([]int64, full+1)
+	groups := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		low := mask & -mask
+		i := bitIndex(low)
+		sum[mask] = sum[mask^low] + balances[ids[i]]
+		best := 0
+		for rest := mask; rest != 0; rest &= rest - 1 {
+			if g := groups[mask^(rest&-rest)]; g > best {
+				best = g
+			}
+		}
+		groups[mask] = best
+		if sum[mask] == 0 {
+			groups[mask]++
+		}
+	}
+
+	// 取り除く順に辿り、合計が 0 になるところでグループを区切る
+	var out []Transfer
+	var group []string
+	for mask := full; mask != 0; {
+		next := 0
+		for rest := mask; rest != 0; rest &= rest - 1 {
+			bit := rest & -rest
+			want := groups[mask]
+			if sum[mask] == 0 {
+				want--
+			}
+			if groups[mask^bit] == want {
+				next = bit
+				break
+			}
+		}
+		group = append(group, ids[bitIndex(next)])
+		mask ^= next
+		if sum[mask] == 0 {
+			out = append(out, settleGroup(group, balances)...)
+			group = nil
+		}
+	}
+	return out
+}
+
+// settleGroup pays the largest debt to the largest credit until the
+// members' balances are even.
+func settleGroup(ids []string, balances map[string]int64) []Transfer {
+	type entry struct {
+		id     string
+		amount int64
+	}
+	var debtors, creditors []entry
+	for _, id := range ids {
+		if b := balances[id]; b < 0 {
+			debtors = append(debtors, entry{id, -b})
+		} else if b > 0 {
+			creditors = append(creditors, entry{id, b})
+		}
+	}
+	byAmount := func(es []entry) func(i, j int) bool {
+		return func(i, j int) bool {
+			if es[i].amount != es[j].amount {
+				return es[i].amount > es[j].amount
+			}
+			return es[i].id < es[j].id
+		}
+	}
+	sort.Slice(debtors, byAmount(debtors))
+	sort.Slice(creditors, byAmount(creditors))
+
+	var out []Transfer
+	for i, j := 0, 0; i < len(debtors) && j < len(creditors); {
+		amount := min(debtors[i].amount, creditors[j].amount)
+		out = append(out, Transfer{From: debtors[i].id, To: creditors[j].id, Amount: amount})
+		debtors[i].amount -= amount
+		creditors[j].amount -= amount
+		if debtors[i].amount == 0 {
+			i++
+		}
+		if creditors[j].amount == 0 {
+			j++
+		}
+	}
+	return out
+}
+
+func bitIndex(bit int) int {
+	i := 0
+	for bit > 1 {
+		bit >>= 1
+		i++
+	}
+	return i
+}
+
+// parseAmount reads "3000", "3,000", "３０００円" or "¥3000".
+func parseAmount(s string) (int64, bool) {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= '０' && r <= '９':
+			return '0' + (r - '０')
+		case r == ',' || r == '，' || r == '円' || r == '¥' || r == '￥':
+			return -1
+		}
+		return r
+	}, s)
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || n > 100_000_000 {
+		return 0, false
+	}
+	return n, true
+}
+
+// yen formats an amount as "3,000円".
+func yen(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if neg {
+		return "-" + b.String() + "円"
+	}
+	return b.String() + "円"
+}
@@ -0,0 +1,171 @@
+package trip
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// people is a fixed member list for the ledger commands.
+type people map[string]string
+
+func (p people) Members(ctx context.Context, groupID string) ([]string, error) {
+	var ids []string
+	for id := range p {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (p people) Name(ctx context.Context, groupID, userID string) string {
+	if name, ok := p[userID]; ok {
+		return name
+	}
+	return store.FallbackName(userID)
+}
+
+func TestTransfers(t *testing.T) {
+	tests := []struct {
+		balances map[string]int64
+		want     int
+	}{
+		{map[string]int64{}, 0},
+		{map[string]int64{"a": 100, "b": -100}, 1},
+		{map[string]int64{"a": 200, "b": -100, "c": -100}, 2},
+		{map[string]int64{"a": 0, "b": 50, "c": -50}, 1},
+		// 貪欲法だと 5 回になるが {8,-6,-2} と {3,1,-4} に分ければ 4 回
+		{map[string]int64{"a": 8, "b": 3, "c": 1, "d": -6, "e": -4, "f": -2}, 4},
+		{map[string]int64{"a": 5, "b": 5, "c": -5, "d": -5}, 2},
+	}
+	for _, tt := range tests {
+		transfers := Transfers(tt.balances)
+		if len(transfers) != tt.want {
+			t.Errorf("Transfers(%v) = %+v, want %d transfers", tt.balances, transfers, tt.want)
+		}
+		left := map[string]int64{}
+		for id, b := range tt.balances {
+			left[id] = b
+		}
+		for _, tr := range transfers {
+			if tr.Amount <= 0 {
+				t.Errorf("transfer of %d", tr.Amount)
+			}
+			left[tr.From] += tr.Amount
+			left[tr.To] -= tr.Amount
+		}
+		for id, b := range left {
+			if b != 0 {
+				t.Errorf("Transfers(%v) leaves %s at %d", tt.balances, id, b)
+			}
+		}
+	}
+
+	// 人数が多いときは貪欲法でも精算はできる
+	many := map[string]int64{}
+	for i := 0; i < 20; i++ {
+		many[fmt.Sprintf("u%02d", i)] = int64(i - 10)
+	}
+	many["u20"] = 10
+	if transfers := Transfers(many); len(transfers) == 0 || len(transfers) > 20 {
+		t.Errorf("Transfers of 21 members = %d transfers", len(transfers))
+	}
+}
+
+func TestShares(t *testing.T) {
+	e := Expense{PaidBy: "a", Amount: 1000, Participants: []string{"a", "b", "c"}}
+	if got := fmt.Sprint(e.Shares()); got != "map[a:334 b:333 c:333]" {
+		t.Errorf("shares = %s", got)
+	}
+	e = Expense{PaidBy: "x", Amount: 1000, Participants: []string{"a", "b", "c"}}
+	if got := fmt.Sprint(e.Shares()); got != "map[a:334 b:333 c:333]" {
+		t.Errorf("shares without payer = %s", got)
+	}
+}
+
+func TestExpenses(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			ctx := context.Background()
+
+			a, added, err := s.AddExpense(ctx, Expense{GroupID: "C1", TripID: "C1-1", PaidBy: "U1", Amount: 3000, Participants: []string{"U1", "U2"}, SourceMessageID: "m1"})
+			if err != nil || !added || a.ID != 1 {
+				t.Fatalf("AddExpense = %+v, %v, %v", a, added, err)
+			}
+			if again, added, err := s.AddExpense(ctx, Expense{GroupID: "C1", PaidBy: "U1", Amount: 3000, SourceMessageID: "m1"}); err != nil || added || again.TripID != "C1-1" {
+				t.Errorf("duplicate AddExpense = %+v, %v, %v", again, added, err)
+			}
+			s.AddExpense(ctx, Expense{GroupID: "C1", PaidBy: "U2", Amount: 500, Participants: []string{"U1", "U2"}})
+
+			expenses, err := s.Expenses(ctx, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(expenses) != 2 || expenses[1].ID != 2 || len(ExpensesOf(expenses, "C1-1")) != 1 {
+				t.Errorf("expenses = %+v", expenses)
+			}
+		})
+	}
+}
+
+func TestLedgerCommands(t *testing.T) {
+	r := command.NewRegistry()
+	s := NewMemory()
+	Register(r, s, people{"U1": "たくと", "U2": "はなこ", "U3": "Taro Yamada"})
+	ctx := context.Background()
+
+	start := time.Date(2026, 11, 3, 12, 0, 0, 0, Tokyo).UnixMilli()
+	seq := 0
+	send := func(userID, text string, mentions ...store.Mention) string {
+		seq++
+		m := store.Message{GroupID: "C1", UserID: userID, MessageID: fmt.Sprintf("m%d", seq), Message: text, Mentions: mentions, Timestamp: start + int64(seq)}
+		reply, ok, err := r.Dispatch(ctx, m, false)
+		if !ok || err != nil {
+			t.Fatalf("%s: ok=%v err=%v", text, ok, err)
+		}
+		return reply
+	}
+
+	if reply := send("U1", "/settle"); !strings.Contains(reply, "ありません") {
+		t.Errorf("settle without expenses = %q", reply)
+	}
+	send("U1", "/trip new 京都旅行")
+	if reply := send("U1", "/pay ３,０００円 ランチ"); reply != "💰 たくと さんが 3,000円（ランチ）を支払いました\n👥 3 人で割り勘: 1 人 1,000円" {
+		t.Errorf("pay = %q", reply)
+	}
+	// 表示名に空白があってもメンションなら分かる
+	if reply := send("U2", "/pay 1200 タクシー @Taro Yamada", store.Mention{UserID: "U3", Text: "@Taro Yamada"}); reply != "💰 はなこ さんが 1,200円（タクシー）を支払いました\n👥 2 人で割り勘: 1 人 600円" {
+		t.Errorf("pay with mention = %q", reply)
+	}
+	if reply := send("U3", "/pay 600 お茶 @たくと"); !strings.Contains(reply, "2 人で割り勘") {
+		t.Errorf("pay with typed name = %q", reply)
+	}
+	if reply := send("U1", "/pay 500 @だれか"); !strings.Contains(reply, "見つかりません") {
+		t.Errorf("pay with unknown name = %q", reply)
+	}
+	if reply := send("U1", "/pay ランチ"); !strings.Contains(reply, "例") {
+		t.Errorf("pay without amount = %q", reply)
+	}
+
+	// たくと: 3000 払って 1000+300 負担 → +1700
+	// はなこ: 1200 払って 1000+600 負担 → -400
+	// Taro:   600 払って 1000+600+300 負担 → -1300
+	if reply := send("U2", "/settle"); reply != "🧾 精算（京都旅行）\n合計 4,800円・3 件\nTaro Yamada → たくと 1,300円\nはなこ → たくと 400円" {
+		t.Errorf("settle = %q", reply)
+	}
+
+	result, err := Settle(ctx, s, people{}, "C1", "C1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 4800 || len(result.Balances) != 3 || result.Balances[0].Balance != 1700 || result.Currency != "JPY" {
+		t.Errorf("settlement = %+v", result)
+	}
+}
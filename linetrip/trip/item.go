@@ -0,0 +1,126 @@
+// Package trip keeps the trips planned in each LINE conversation and their
+// lists of places and things to do, edited from the chat with commands like
+// "/add 清水寺" or from the iOS app through /api/trips.
+package trip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// ErrNotFound is returned for unknown items.
+var ErrNotFound = errors.New("trip item not found")
+
+// Item is one entry of a conversation's trip list.
+type Item struct {
+	// ID is the item number within the conversation, used in commands
+	// ("/done 3"). Numbers are never reused.
+	ID      int    `json:"id"`
+	GroupID string `json:"group_id"`
+	// TripID is the trip that was active when the item was added.
+	TripID string `json:"trip_id,omitempty"`
+	Title  string `json:"title"`
+	Done   bool   `json:"done"`
+	// AddedBy is the LINE user ID of whoever added the item from the chat.
+	AddedBy     string `json:"added_by,omitempty"`
+	AddedByName string `json:"added_by_name,omitempty"`
+	// SourceMessageID is the LINE message ID of the /add command, so a
+	// redelivered or replayed command adds nothing.
+	SourceMessageID string `json:"source_message_id,omitempty"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+	DoneAt          int64  `json:"done_at,omitempty"`
+}
+
+// Store persists trip items.
+type Store interface {
+	// AddItem stores item under the next number of its conversation. If
+	// an item was already added from the same SourceMessageID, that item
+	// is returned with added false.
+	AddItem(ctx context.Context, item Item) (saved Item, added bool, err error)
+	// Items lists a conversation's items by number.
+	Items(ctx context.Context, groupID string) ([]Item, error)
+	Item(ctx context.Context, groupID string, id int) (Item, error)
+	UpdateItem(ctx context.Context, item Item) error
+	RemoveItem(ctx context.Context, groupID string, id int) error
+
+	// CreateTrip stores t under the next trip number of its conversation,
+	// deduplicated by SourceMessageID like AddItem.
+	CreateTrip(ctx context.Context, t Trip) (saved Trip, created bool, err error)
+	// Trips lists a conversation's trips by number.
+	Trips(ctx context.Context, groupID string) ([]Trip, error)
+	Trip(ctx context.Context, id string) (Trip, error)
+	// UpdateTrip replaces a stored trip. Its Rates are added to the trip's
+	// snapshot rates; rates missing from t are kept.
+	UpdateTrip(ctx context.Context, t Trip) error
+	// SetActiveTrip records trip id as the active trip of groupID in a
+	// single write. Trips and Trip report only that trip as active.
+	SetActiveTrip(ctx context.Context, groupID, id string) error
+	// SnapshotTripRate stores rate as the rate of code on trip id unless the
+	// trip already has one, and returns the trip's rate. Nothing else of the
+	// trip is written, so the first snapshot of a currency wins.
+	SnapshotTripRate(ctx context.Context, id, code string, rate float64) (float64, error)
+
+	// AddEntry stores an itinerary entry under the next entry number of its
+	// conversation, deduplicated by SourceMessageID like AddItem.
+	AddEntry(ctx context.Context, e Entry) (saved Entry, added bool, err error)
+	// Entries lists a conversation's itinerary entries by number.
+	Entries(ctx context.Context, groupID string) ([]Entry, error)
+	Entry(ctx context.Context, groupID string, id int) (Entry, error)
+	UpdateEntry(ctx context.Context, e Entry) error
+
+	// AddExpense stores an expense under the next expense number of its
+	// conversation, deduplicated by SourceMessageID like AddItem.
+	AddExpense(ctx context.Context, e Expense) (saved Expense, added bool, err error)
+	// Expenses lists a conversation's expenses by number.
+	Expenses(ctx context.Context, groupID string) ([]Expense, error)
+
+	// Rates returns the exchange rate table admins maintain; trips snapshot
+	// it with SnapshotRate.
+	Rates(ctx context.Context) (Rates, error)
+	// SetRates adds or replaces rates in the table.
+	SetRates(ctx context.Context, rates Rates) error
+	RemoveRate(ctx context.Context, code string) error
+
+	// TimeZone returns the IANA time zone set for a conversation, or "".
+	TimeZone(ctx context.Context, groupID string) (string, error)
+	SetTimeZone(ctx context.Context, groupID, name string) error
+}
+
+var (
+	memoryMu     sync.Mutex
+	memoryStores = make(map[*store.Memory]*Memory)
+)
+
+// Open returns the trip store kept alongside s: the same Redis database,
+// the same bbolt file, or process memory.
+func Open(s store.MessageStore) (Store, error) {
+	switch s := s.(type) {
+	case *store.Upstash:
+		return NewUpstash(s.Client()), nil
+	case *store.Bolt:
+		return NewBolt(s.DB())
+	case *store.Memory:
+		// メモリ版はメッセージストアと同じ寿命にする
+		memoryMu.Lock()
+		defer memoryMu.Unlock()
+		if m, ok := memoryStores[s]; ok {
+			return m, nil
+		}
+		m := NewMemory()
+		memoryStores[s] = m
+		return m, nil
+	default:
+		return nil, fmt.Errorf("no trip store for %T", s)
+	}
+}
+
+// cleanTitle trims a title and collapses it to one line.
+func cleanTitle(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
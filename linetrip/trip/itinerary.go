@@ -0,0 +1,175 @@
+package trip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/flex"
+	"github.com/takuto277/line-trip-list-api/linetrip/jpdate"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+// Itinerary entry statuses. Entries read from chat messages start as
+// proposed until someone confirms or rejects them in the iOS app.
+const (
+	EntryProposed  = "proposed"
+	EntryConfirmed = "confirmed"
+	EntryRejected  = "rejected"
+)
+
+// TimeLayout is the format of Entry.Time.
+const TimeLayout = "15:04"
+
+// ErrEntryNotFound is returned for unknown itinerary entries.
+var ErrEntryNotFound = errors.New("itinerary entry not found")
+
+// Entry is one plan on a day of a trip.
+type Entry struct {
+	// ID is the entry number within the conversation.
+	ID      int    `json:"id"`
+	GroupID string `json:"group_id"`
+	TripID  string `json:"trip_id"`
+	// Date is YYYY-MM-DD. Time is HH:MM when an exact time is known;
+	// otherwise Period may name a part of the day such as 夜.
+	Date   string `json:"date"`
+	Time   string `json:"time,omitempty"`
+	Period string `json:"period,omitempty"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	// Place is where it happens and ImageURL an HTTPS thumbnail of it,
+	// both set from the iOS app and shown by /itinerary.
+	Place    string `json:"place,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	// Text is the message the entry was proposed from, and Expression the
+	// part of it read as the date and time.
+	Text            string `json:"text,omitempty"`
+	Expression      string `json:"expression,omitempty"`
+	ProposedBy      string `json:"proposed_by,omitempty"`
+	ProposedByName  string `json:"proposed_by_name,omitempty"`
+	SourceMessageID string `json:"source_message_id,omitempty"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+}
+
+// Validate checks the fields a client may set.
+func (e Entry) Validate() error {
+	if e.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	if _, err := time.Parse(DateLayout, e.Date); err != nil {
+		return fmt.Errorf("invalid date %q, use YYYY-MM-DD", e.Date)
+	}
+	if e.Time != "" {
+		if _, err := time.Parse(TimeLayout, e.Time); err != nil {
+			return fmt.Errorf("invalid time %q, use HH:MM", e.Time)
+		}
+	}
+	if e.ImageURL != "" {
+		if err := flex.CheckURL(e.ImageURL); err != nil {
+			return fmt.Errorf("invalid image_url: %v", err)
+		}
+	}
+	switch e.Status {
+	case EntryProposed, EntryConfirmed, EntryRejected:
+		return nil
+	default:
+		return fmt.Errorf("invalid status %q", e.Status)
+	}
+}
+
+// EntriesOf filters entries to those of trip id, in the order they happen.
+func EntriesOf(entries []Entry, id string) []Entry {
+	out := []Entry{}
+	for _, e := range entries {
+		if e.TripID == id {
+			out = append(out, e)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].sortKey() < out[j].sortKey() })
+	return out
+}
+
+// periodOrder places entries with only a part of the day among timed ones.
+var periodOrder = map[string]string{
+	"朝": "08:00", "午前": "10:00", "昼": "12:00", "午後": "14:00", "夕方": "17:00", "夜": "19:00", "深夜": "23:00",
+}
+
+func (e Entry) sortKey() string {
+	clock := e.Time
+	if clock == "" {
+		clock = periodOrder[e.Period]
+	}
+	return e.Date + " " + clock
+}
+
+// Location returns the time zone of a conversation: the one set with
+// /timezone, or Tokyo.
+func Location(ctx context.Context, s Store, groupID string) (*time.Location, error) {
+	name, err := s.TimeZone(ctx, groupID)
+	if err != nil || name == "" {
+		return Tokyo, err
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return Tokyo, nil
+	}
+	return loc, nil
+}
+
+// Propose reads a date and time from a text message of a trip, such as
+// 「3日目の10時に金閣寺」, and adds it to the trip's itinerary as a proposed
+// entry. Messages without a day or without anything left to name the plan
+// are ignored, as are messages already proposed from.
+func Propose(ctx context.Context, s Store, m store.Message) (Entry, bool, error) {
+	if m.TripID == "" || m.Deleted || (m.Type != "" && m.Type != store.TypeText) {
+		return Entry{}, false, nil
+	}
+	t, err := s.Trip(ctx, m.TripID)
+	if errors.Is(err, ErrTripNotFound) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	loc, err := Location(ctx, s, m.GroupID)
+	if err != nil {
+		return Entry{}, false, err
+	}
+
+	ref := jpdate.Ref{Now: time.UnixMilli(m.Timestamp).In(loc)}
+	if t.StartDate != "" {
+		ref.TripStart, _ = time.ParseInLocation(DateLayout, t.StartDate, loc)
+	}
+	if t.EndDate != "" {
+		ref.TripEnd, _ = time.ParseInLocation(DateLayout, t.EndDate, loc)
+	}
+	match, ok := jpdate.Parse(m.Message, ref)
+	title := cleanTitle(match.Rest)
+	if !ok || !match.HasDate || utf8.RuneCountInString(title) < 2 {
+		return Entry{}, false, nil
+	}
+
+	e := Entry{
+		GroupID:         m.GroupID,
+		TripID:          t.ID,
+		Date:            match.Date.Format(DateLayout),
+		Period:          match.Period,
+		Title:           title,
+		Status:          EntryProposed,
+		Text:            m.Message,
+		Expression:      match.Expression,
+		ProposedBy:      m.UserID,
+		ProposedByName:  m.UserName,
+		SourceMessageID: m.MessageID,
+		CreatedAt:       m.Timestamp,
+		UpdatedAt:       m.Timestamp,
+	}
+	if match.HasTime {
+		e.Time = fmt.Sprintf("%02d:%02d", match.Hour, match.Minute)
+	}
+	return s.AddEntry(ctx, e)
+}
@@ -0,0 +1,304 @@
+package trip
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Memory keeps trips and their items in process memory.
+type Memory struct {
+	mu       sync.Mutex
+	seq      map[string]int
+	items    map[string]map[int]Item
+	sources  map[string]int
+	trips    map[string]map[int]Trip
+	active   map[string]string
+	entries  map[string]map[int]Entry
+	expenses map[string][]Expense
+	zones    map[string]string
+	rates    Rates
+}
+
+// NewMemory returns an empty in-memory trip store.
+func NewMemory() *Memory {
+	return &Memory{
+		seq:      make(map[string]int),
+		items:    make(map[string]map[int]Item),
+		sources:  make(map[string]int),
+		trips:    make(map[string]map[int]Trip),
+		active:   make(map[string]string),
+		entries:  make(map[string]map[int]Entry),
+		expenses: make(map[string][]Expense),
+		zones:    make(map[string]string),
+		rates:    make(Rates),
+	}
+}
+
+func (m *Memory) AddItem(ctx context.Context, item Item) (Item, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source := item.GroupID + "/" + item.SourceMessageID
+	if item.SourceMessageID != "" {
+		if id, ok := m.sources[source]; ok {
+			if existing, ok := m.items[item.GroupID][id]; ok {
+				return existing, false, nil
+			}
+			return Item{ID: id, GroupID: item.GroupID}, false, nil
+		}
+	}
+
+	m.seq[item.GroupID]++
+	item.ID = m.seq[item.GroupID]
+	if m.items[item.GroupID] == nil {
+		m.items[item.GroupID] = make(map[int]Item)
+	}
+	m.items[item.GroupID][item.ID] = item
+	if item.SourceMessageID != "" {
+		m.sources[source] = item.ID
+	}
+	return item, true, nil
+}
+
+func (m *Memory) Items(ctx context.Context, groupID string) ([]Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Item, 0, len(m.items[groupID]))
+	for _, item := range m.items[groupID] {
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (m *Memory) Item(ctx context.Context, groupID string, id int) (Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[groupID][id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	return item, nil
+}
+
+func (m *Memory) UpdateItem(ctx context.Context, item Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.items[item.GroupID][item.ID]; !ok {
+		return ErrNotFound
+	}
+	m.items[item.GroupID][item.ID] = item
+	return nil
+}
+
+func (m *Memory) RemoveItem(ctx context.Context, groupID string, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.items[groupID][id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.items[groupID], id)
+	return nil
+}
+
+func (m *Memory) CreateTrip(ctx context.Context, t Trip) (Trip, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source := "trip:" + t.GroupID + "/" + t.SourceMessageID
+	if t.SourceMessageID != "" {
+		if n, ok := m.sources[source]; ok {
+			return m.trips[t.GroupID][n], false, nil
+		}
+	}
+
+	if m.trips[t.GroupID] == nil {
+		m.trips[t.GroupID] = make(map[int]Trip)
+	}
+	t.Number = len(m.trips[t.GroupID]) + 1
+	t.ID = TripID(t.GroupID, t.Number)
+	m.trips[t.GroupID][t.Number] = t
+	if t.SourceMessageID != "" {
+		m.sources[source] = t.Number
+	}
+	return t, true, nil
+}
+
+func (m *Memory) Trips(ctx context.Context, groupID string) ([]Trip, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Trip, 0, len(m.trips[groupID]))
+	for _, t := range m.trips[groupID] {
+		activeStatus(&t, m.active[groupID])
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+	return out, nil
+}
+
+func (m *Memory) Trip(ctx context.Context, id string) (Trip, error) {
+	groupID, n, ok := ParseTripID(id)
+	if !ok {
+		return Trip{}, ErrTripNotFound
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.trips[groupID][n]
+	if !ok {
+		return Trip{}, ErrTripNotFound
+	}
+	activeStatus(&t, m.active[groupID])
+	return t, nil
+}
+
+func (m *Memory) UpdateTrip(ctx context.Context, t Trip) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.trips[t.GroupID][t.Number]
+	if !ok {
+		return ErrTripNotFound
+	}
+	t.Rates = mergeRates(stored.Rates, t.Rates)
+	m.trips[t.GroupID][t.Number] = t
+	return nil
+}
+
+func (m *Memory) SetActiveTrip(ctx context.Context, groupID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active[groupID] = id
+	return nil
+}
+
+func (m *Memory) SnapshotTripRate(ctx context.Context, id, code string, rate float64) (float64, error) {
+	groupID, n, ok := ParseTripID(id)
+	if !ok {
+		return 0, ErrTripNotFound
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.trips[groupID][n]
+	if !ok {
+		return 0, ErrTripNotFound
+	}
+	if existing, ok := t.Rates[code]; ok {
+		return existing, nil
+	}
+	t.Rates = mergeRates(t.Rates, Rates{code: rate})
+	m.trips[groupID][n] = t
+	return rate, nil
+}
+
+func (m *Memory) AddEntry(ctx context.Context, e Entry) (Entry, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source := "entry:" + e.GroupID + "/" + e.SourceMessageID
+	if e.SourceMessageID != "" {
+		if id, ok := m.sources[source]; ok {
+			return m.entries[e.GroupID][id], false, nil
+		}
+	}
+
+	if m.entries[e.GroupID] == nil {
+		m.entries[e.GroupID] = make(map[int]Entry)
+	}
+	e.ID = len(m.entries[e.GroupID]) + 1
+	m.entries[e.GroupID][e.ID] = e
+	if e.SourceMessageID != "" {
+		m.sources[source] = e.ID
+	}
+	return e, true, nil
+}
+
+func (m *Memory) Entries(ctx context.Context, groupID string) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Entry, 0, len(m.entries[groupID]))
+	for _, e := range m.entries[groupID] {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (m *Memory) Entry(ctx context.Context, groupID string, id int) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[groupID][id]
+	if !ok {
+		return Entry{}, ErrEntryNotFound
+	}
+	return e, nil
+}
+
+func (m *Memory) UpdateEntry(ctx context.Context, e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[e.GroupID][e.ID]; !ok {
+		return ErrEntryNotFound
+	}
+	m.entries[e.GroupID][e.ID] = e
+	return nil
+}
+
+func (m *Memory) AddExpense(ctx context.Context, e Expense) (Expense, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	source := "expense:" + e.GroupID + "/" + e.SourceMessageID
+	if e.SourceMessageID != "" {
+		if id, ok := m.sources[source]; ok {
+			return m.expenses[e.GroupID][id-1], false, nil
+		}
+	}
+
+	e.ID = len(m.expenses[e.GroupID]) + 1
+	m.expenses[e.GroupID] = append(m.expenses[e.GroupID], e)
+	if e.SourceMessageID != "" {
+		m.sources[source] = e.ID
+	}
+	return e, true, nil
+}
+
+func (m *Memory) Expenses(ctx context.Context, groupID string) ([]Expense, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Expense{}, m.expenses[groupID]...), nil
+}
+
+func (m *Memory) TimeZone(ctx context.Context, groupID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.zones[groupID], nil
+}
+
+func (m *Memory) SetTimeZone(ctx context.Context, groupID, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.zones[groupID] = name
+	return nil
+}
+
+func (m *Memory) Rates(ctx context.Context) (Rates, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(Rates, len(m.rates))
+	for code, rate := range m.rates {
+		out[code] = rate
+	}
+	return out, nil
+}
+
+func (m *Memory) SetRates(ctx context.Context, rates Rates) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for code, rate := range rates {
+		m.rates[code] = rate
+	}
+	return nil
+}
+
+func (m *Memory) RemoveRate(ctx context.Context, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.rates, code)
+	return nil
+}
@@ -0,0 +1,217 @@
+package trip
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/flex"
+)
+
+// maxRows is the number of entries a day's bubble lists before folding the
+// rest into "ほか N 件".
+const maxRows = 15
+
+// object is a JSON object of the Flex Message format. The SDK's structs
+// always write "flex": 0, which changes the layout, so bubbles are built
+// as plain maps.
+type object = map[string]interface{}
+
+var weekdays = []string{"日", "月", "火", "水", "木", "金", "土"}
+
+// day is the entries of one date of a trip.
+type day struct {
+	date    string
+	number  int // 1日目, or 0 outside the trip's dates
+	entries []Entry
+}
+
+// days groups the entries that are not rejected by date. Every date of the
+// trip gets a day when they fit in one carousel, so gaps in the plan show.
+func days(t Trip, entries []Entry) []day {
+	byDate := map[string][]Entry{}
+	for _, e := range EntriesOf(entries, t.ID) {
+		if e.Status != EntryRejected {
+			byDate[e.Date] = append(byDate[e.Date], e)
+		}
+	}
+	dates := map[string]bool{}
+	for d := range byDate {
+		dates[d] = true
+	}
+	start, err1 := time.Parse(DateLayout, t.StartDate)
+	end, err2 := time.Parse(DateLayout, t.EndDate)
+	if err1 == nil && err2 == nil && !end.Before(start) && int(end.Sub(start).Hours()/24) < flex.MaxBubbles {
+		for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+			dates[d.Format(DateLayout)] = true
+		}
+	}
+
+	var out []day
+	for d := range dates {
+		n := 0
+		if date, err := time.Parse(DateLayout, d); err == nil && err1 == nil && !date.Before(start) && (err2 != nil || !date.After(end)) {
+			n = int(date.Sub(start).Hours()/24) + 1
+		}
+		out = append(out, day{date: d, number: n, entries: byDate[d]})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].date < out[j].date })
+	return out
+}
+
+// Planned reports whether trip t has entries that are not rejected.
+func Planned(t Trip, entries []Entry) bool {
+	for _, e := range entries {
+		if e.TripID == t.ID && e.Status != EntryRejected {
+			return true
+		}
+	}
+	return false
+}
+
+// ItineraryReply renders the itinerary of trip t as a Flex carousel with one
+// bubble per day, plus the same plan as text. The carousel is trimmed to
+// LINE's limits: days beyond the twelfth, or beyond the size limit, are
+// left to the iOS app and counted on the last bubble.
+func ItineraryReply(t Trip, entries []Entry) (command.Reply, error) {
+	plan := days(t, entries)
+	title := "📅 旅程（" + t.Title + "）"
+	if dates := formatDates(t); dates != "" {
+		title += dates
+	}
+
+	var text strings.Builder
+	text.WriteString(title)
+	for _, d := range plan {
+		text.WriteString("\n" + d.label())
+		if len(d.entries) == 0 {
+			text.WriteString("\n  予定なし")
+		}
+		for _, e := range d.entries {
+			text.WriteString("\n  " + e.line())
+		}
+	}
+	reply := command.Reply{Text: text.String(), AltText: title}
+
+	bubbles := make([]object, len(plan))
+	for i, d := range plan {
+		bubbles[i] = d.bubble(t)
+	}
+	for len(bubbles) > 0 {
+		shown := bubbles
+		if len(shown) > flex.MaxBubbles {
+			shown = shown[:flex.MaxBubbles]
+		}
+		if rest := len(plan) - len(shown); rest > 0 {
+			last := plan[len(shown)-1].bubble(t)
+			last["footer"] = object{"type": "box", "layout": "vertical", "contents": []interface{}{
+				object{"type": "text", "text": fmt.Sprintf("ほか %d 日分は iOS アプリで確認できます", rest), "size": "xs", "color": "#999999", "wrap": true},
+			}}
+			shown = append(append([]object(nil), shown[:len(shown)-1]...), last)
+		}
+		contents, err := json.Marshal(object{"type": "carousel", "contents": shown})
+		if err != nil {
+			return command.Reply{}, err
+		}
+		if len(contents) <= flex.MaxCarouselSize || len(shown) == 1 {
+			reply.Flex = contents
+			return reply, flex.Check(reply.AltText, reply.Flex)
+		}
+		bubbles = shown[:len(shown)-1]
+	}
+	return reply, nil
+}
+
+func (d day) label() string {
+	date, err := time.Parse(DateLayout, d.date)
+	if err != nil {
+		return d.date
+	}
+	label := fmt.Sprintf("%d/%d（%s）", date.Month(), date.Day(), weekdays[date.Weekday()])
+	if d.number > 0 {
+		label += fmt.Sprintf(" %d日目", d.number)
+	}
+	return label
+}
+
+// line is an entry as one line of text.
+func (e Entry) line() string {
+	s := e.Title
+	if when := e.when(); when != "" {
+		s = when + " " + s
+	}
+	if e.Place != "" {
+		s += "＠" + e.Place
+	}
+	if e.Status == EntryProposed {
+		s += "（候補）"
+	}
+	return s
+}
+
+func (e Entry) when() string {
+	if e.Time != "" {
+		return e.Time
+	}
+	return e.Period
+}
+
+// bubble lays out a day: the first thumbnail as the hero image, then one
+// row per entry with its time, title, place and thumbnail.
+func (d day) bubble(t Trip) object {
+	heading := []interface{}{
+		object{"type": "text", "text": d.label(), "weight": "bold", "size": "lg"},
+		object{"type": "text", "text": t.Title, "size": "xs", "color": "#999999"},
+		object{"type": "separator", "margin": "md"},
+	}
+	rows := heading
+	if len(d.entries) == 0 {
+		rows = append(rows, object{"type": "text", "text": "予定なし", "size": "sm", "color": "#999999", "margin": "md"})
+	}
+	for i, e := range d.entries {
+		if i == maxRows {
+			rows = append(rows, object{"type": "text", "text": fmt.Sprintf("ほか %d 件", len(d.entries)-maxRows), "size": "xs", "color": "#999999", "margin": "md"})
+			break
+		}
+		rows = append(rows, e.row())
+	}
+	bubble := object{"type": "bubble", "body": object{"type": "box", "layout": "vertical", "spacing": "sm", "contents": rows}}
+	for _, e := range d.entries {
+		if e.ImageURL != "" {
+			bubble["hero"] = object{"type": "image", "url": e.ImageURL, "size": "full", "aspectRatio": "20:13", "aspectMode": "cover"}
+			break
+		}
+	}
+	return bubble
+}
+
+func (e Entry) row() object {
+	color := "#111111"
+	if e.Status == EntryProposed {
+		color = "#888888"
+	}
+	title := e.Title
+	if e.Status == EntryProposed {
+		title += "（候補）"
+	}
+	detail := []interface{}{object{"type": "text", "text": title, "size": "sm", "color": color, "wrap": true}}
+	if e.Place != "" {
+		detail = append(detail, object{"type": "text", "text": "📍 " + e.Place, "size": "xs", "color": "#999999", "wrap": true})
+	}
+
+	when := e.when()
+	if when == "" {
+		when = "-"
+	}
+	row := []interface{}{
+		object{"type": "text", "text": when, "size": "sm", "color": "#1DB446", "flex": 0, "gravity": "top"},
+		object{"type": "box", "layout": "vertical", "flex": 1, "margin": "md", "contents": detail},
+	}
+	if e.ImageURL != "" {
+		row = append(row, object{"type": "image", "url": e.ImageURL, "size": "xxs", "aspectMode": "cover", "flex": 0})
+	}
+	return object{"type": "box", "layout": "horizontal", "margin": "md", "contents": row}
+}
@@ -0,0 +1,160 @@
+package trip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/flex"
+	"github.com/takuto277/line-trip-list-api/linetrip/linetest"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+)
+
+func TestItineraryReply(t *testing.T) {
+	kyoto := Trip{ID: "C1-1", GroupID: "C1", Title: "京都旅行", StartDate: "2026-11-03", EndDate: "2026-11-05"}
+	entries := []Entry{
+		{TripID: "C1-1", Date: "2026-11-05", Time: "10:00", Title: "金閣寺", Status: EntryConfirmed, ImageURL: "https://example.com/kinkaku.jpg"},
+		{TripID: "C1-1", Date: "2026-11-03", Period: "夜", Title: "焼肉", Status: EntryProposed, Place: "祇園"},
+		{TripID: "C1-1", Date: "2026-11-03", Time: "13:00", Title: "清水寺", Status: EntryConfirmed},
+		{TripID: "C1-1", Date: "2026-11-04", Time: "09:00", Title: "没案", Status: EntryRejected},
+		{TripID: "C1-2", Date: "2026-11-04", Time: "09:00", Title: "別の旅行", Status: EntryConfirmed},
+	}
+	reply, err := ItineraryReply(kyoto, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `📅 旅程（京都旅行）11/3〜11/5
+11/3（火） 1日目
+  13:00 清水寺
+  夜 焼肉＠祇園（候補）
+11/4（水） 2日目
+  予定なし
+11/5（木） 3日目
+  10:00 金閣寺`
+	if reply.Text != want {
+		t.Errorf("text = %q", reply.Text)
+	}
+	if reply.AltText != "📅 旅程（京都旅行）11/3〜11/5" {
+		t.Errorf("altText = %q", reply.AltText)
+	}
+
+	var carousel struct {
+		Contents []struct {
+			Hero *struct {
+				URL string `json:"url"`
+			} `json:"hero"`
+		} `json:"contents"`
+	}
+	if err := json.Unmarshal(reply.Flex, &carousel); err != nil {
+		t.Fatal(err)
+	}
+	if len(carousel.Contents) != 3 || carousel.Contents[0].Hero != nil || carousel.Contents[2].Hero.URL != "https://example.com/kinkaku.jpg" {
+		t.Errorf("carousel = %s", reply.Flex)
+	}
+	if strings.Contains(string(reply.Flex), "没案") {
+		t.Errorf("carousel = %s", reply.Flex)
+	}
+}
+
+func TestItineraryReplyLimits(t *testing.T) {
+	// 日程のない長旅は予定のある日だけ、12 日を超える分は最後のカードで案内する
+	long := Trip{ID: "C1-1", GroupID: "C1", Title: "世界一周"}
+	var entries []Entry
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 20; j++ {
+			entries = append(entries, Entry{TripID: "C1-1", Date: fmt.Sprintf("2026-12-%02d", i+1), Time: fmt.Sprintf("%02d:00", j), Title: strings.Repeat("観光", 10), Status: EntryConfirmed})
+		}
+	}
+	reply, err := ItineraryReply(long, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flex.Check(reply.AltText, reply.Flex); err != nil {
+		t.Fatal(err)
+	}
+	var carousel struct {
+		Contents []json.RawMessage `json:"contents"`
+	}
+	json.Unmarshal(reply.Flex, &carousel)
+	last := string(carousel.Contents[len(carousel.Contents)-1])
+	if len(carousel.Contents) > flex.MaxBubbles || !strings.Contains(last, fmt.Sprintf("ほか %d 日分", 20-len(carousel.Contents))) {
+		t.Errorf("%d bubbles, last = %s", len(carousel.Contents), last)
+	}
+	if !strings.Contains(string(carousel.Contents[0]), "ほか 5 件") {
+		t.Errorf("rows not folded: %s", carousel.Contents[0])
+	}
+}
+
+func TestItineraryCommand(t *testing.T) {
+	r := command.NewRegistry()
+	s := NewMemory()
+	Register(r, s, nil)
+	ctx := context.Background()
+	run := func(text string) command.Reply {
+		m := store.Message{GroupID: "C1", UserID: "U1", Message: text, Timestamp: 1}
+		reply, ok, err := r.DispatchReply(ctx, m, false)
+		if !ok || err != nil {
+			t.Fatalf("%s: ok=%v err=%v", text, ok, err)
+		}
+		return reply
+	}
+
+	if reply := run("/itinerary"); reply.Flex != nil || !strings.Contains(reply.Text, "/trip new") {
+		t.Errorf("without trip = %+v", reply)
+	}
+	run("/trip new 京都旅行 2026-11-03〜2026-11-05")
+	if reply := run("/itinerary"); reply.Flex != nil || !strings.Contains(reply.Text, "まだありません") {
+		t.Errorf("without entries = %+v", reply)
+	}
+	s.AddEntry(ctx, Entry{GroupID: "C1", TripID: "C1-1", Date: "2026-11-04", Time: "10:00", Title: "金閣寺", Status: EntryConfirmed})
+	if reply := run("/itinerary"); reply.Flex == nil || !strings.Contains(reply.Text, "10:00 金閣寺") {
+		t.Errorf("itinerary = %+v", reply)
+	}
+}
+
+func TestShareHandler(t *testing.T) {
+	line := linetest.NewServer()
+	defer line.Close()
+	s := NewMemory()
+	ctx := context.Background()
+	Create(ctx, s, Trip{GroupID: "C1", Title: "京都旅行", Status: StatusPlanning}, 1)
+	messages := store.NewMemory()
+	sender := outbound.NewSender(line.Client(), messages)
+	sender.Timeline = messages
+	h := ShareHandler(s, sender)
+
+	share := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/?trip_id=C1-1", nil))
+		return w
+	}
+	if w := share("POST"); w.Code != http.StatusConflict {
+		t.Errorf("share without entries: %d", w.Code)
+	}
+	s.AddEntry(ctx, Entry{GroupID: "C1", TripID: "C1-1", Date: "2026-11-04", Time: "10:00", Title: "金閣寺", Status: EntryConfirmed})
+
+	if w := share("GET"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"alt_text":"📅 旅程（京都旅行）"`) {
+		t.Errorf("preview: %d %s", w.Code, w.Body)
+	}
+	if len(line.Sent()) != 0 {
+		t.Fatal("preview pushed a message")
+	}
+	if w := share("POST"); w.Code != http.StatusOK {
+		t.Fatalf("share: %d %s", w.Code, w.Body)
+	}
+	sent := line.Sent()
+	if len(sent) != 1 || sent[0].To != "C1" || !strings.Contains(string(sent[0].Messages[0]), `"type":"flex"`) {
+		t.Errorf("sent = %+v", sent)
+	}
+	// 送った旅程は会話にも残る
+	recorded, _ := store.All(ctx, messages, "C1")
+	if len(recorded) != 1 || recorded[0].Direction != store.DirectionOutbound || recorded[0].Message != "📅 旅程（京都旅行）" || recorded[0].TripID != "C1-1" {
+		t.Errorf("recorded = %+v", recorded)
+	}
+}
@@ -0,0 +1,247 @@
+package trip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trip statuses. Each conversation has at most one active trip, which new
+// items and messages attach to.
+const (
+	StatusPlanning = "planning"
+	StatusActive   = "active"
+	StatusArchived = "archived"
+)
+
+// DateLayout is the format of Trip.StartDate and EndDate.
+const DateLayout = "2006-01-02"
+
+// ErrTripNotFound is returned for unknown trips.
+var ErrTripNotFound = errors.New("trip not found")
+
+// Trip is one journey planned in a conversation.
+type Trip struct {
+	// ID is "{groupId}-{number}", unique across conversations.
+	ID string `json:"id"`
+	// Number is the trip number within the conversation, used in commands
+	// ("/trip switch 2").
+	Number      int    `json:"number"`
+	GroupID     string `json:"group_id"`
+	Title       string `json:"title"`
+	Destination string `json:"destination,omitempty"`
+	// StartDate and EndDate are calendar dates (YYYY-MM-DD) in the
+	// conversation's time zone.
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+	Status    string `json:"status"`
+	CreatedBy string `json:"created_by,omitempty"`
+	// SourceMessageID is the LINE message ID of the /trip new command.
+	SourceMessageID string `json:"source_message_id,omitempty"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+	ArchivedAt      int64  `json:"archived_at,omitempty"`
+	// Rates snapshots the exchange rate of each foreign currency spent on
+	// the trip, taken from the rate table when the currency is first used.
+	Rates Rates `json:"rates,omitempty"`
+}
+
+// TripID returns the ID of trip number n of a conversation.
+func TripID(groupID string, n int) string {
+	return groupID + "-" + strconv.Itoa(n)
+}
+
+// ParseTripID splits a trip ID into its conversation and number.
+func ParseTripID(id string) (groupID string, n int, ok bool) {
+	i := strings.LastIndexByte(id, '-')
+	if i <= 0 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(id[i+1:])
+	if err != nil || n <= 0 {
+		return "", 0, false
+	}
+	return id[:i], n, true
+}
+
+// Validate checks the fields a client may set.
+func (t Trip) Validate() error {
+	if t.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	for _, d := range []string{t.StartDate, t.EndDate} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse(DateLayout, d); err != nil {
+			return fmt.Errorf("invalid date %q, use YYYY-MM-DD", d)
+		}
+	}
+	if t.StartDate != "" && t.EndDate != "" && t.EndDate < t.StartDate {
+		return fmt.Errorf("end_date is before start_date")
+	}
+	if err := t.Rates.Validate(); err != nil {
+		return err
+	}
+	switch t.Status {
+	case StatusPlanning, StatusActive, StatusArchived:
+		return nil
+	default:
+		return fmt.Errorf("invalid status %q", t.Status)
+	}
+}
+
+// Active returns the active trip of a conversation, if any.
+func Active(ctx context.Context, s Store, groupID string) (Trip, bool, error) {
+	trips, err := s.Trips(ctx, groupID)
+	if err != nil {
+		return Trip{}, false, err
+	}
+	for _, t := range trips {
+		if t.Status == StatusActive {
+			return t, true, nil
+		}
+	}
+	return Trip{}, false, nil
+}
+
+// ActiveID returns the ID of the active trip, or "" when there is none.
+func ActiveID(ctx context.Context, s Store, groupID string) (string, error) {
+	t, _, err := Active(ctx, s, groupID)
+	return t.ID, err
+}
+
+// Create stores a new trip and makes it the active one. The first trip of
+// a conversation adopts the items added before trips existed.
+func Create(ctx context.Context, s Store, t Trip, at int64) (Trip, bool, error) {
+	t.Status = StatusPlanning
+	t.CreatedAt, t.UpdatedAt = at, at
+	saved, created, err := s.CreateTrip(ctx, t)
+	if err != nil || !created {
+		return saved, created, err
+	}
+	if saved.Number == 1 {
+		items, err := s.Items(ctx, saved.GroupID)
+		if err != nil {
+			return saved, true, err
+		}
+		for _, item := range ItemsOf(items, "") {
+			item.TripID = saved.ID
+			if err := s.UpdateItem(ctx, item); err != nil && !errors.Is(err, ErrNotFound) {
+				return saved, true, err
+			}
+		}
+	}
+	saved, err = Activate(ctx, s, saved.ID, at)
+	return saved, true, err
+}
+
+// Activate makes trip id the active trip of its conversation. The trip
+// active before goes back to planning: the store records one active trip
+// per conversation, so concurrent switches cannot leave two active.
+func Activate(ctx context.Context, s Store, id string, at int64) (Trip, error) {
+	t, err := s.Trip(ctx, id)
+	if err != nil {
+		return Trip{}, err
+	}
+	t.Status = StatusActive
+	t.ArchivedAt = 0
+	t.UpdatedAt = at
+	if err := s.UpdateTrip(ctx, t); err != nil {
+		return Trip{}, err
+	}
+	return t, s.SetActiveTrip(ctx, t.GroupID, t.ID)
+}
+
+// activeStatus derives the status of t from activeID, the active trip its
+// store recorded for the conversation. A trip saved as active is read as
+// planning once another trip is recorded; before any trip was recorded the
+// saved status stands.
+func activeStatus(t *Trip, activeID string) {
+	if t.Status == StatusActive && activeID != "" && activeID != t.ID {
+		t.Status = StatusPlanning
+	}
+}
+
+// Archive moves a trip to the past trips. Archiving the active trip leaves
+// the conversation without one.
+func Archive(ctx context.Context, s Store, id string, at int64) (Trip, error) {
+	t, err := s.Trip(ctx, id)
+	if err != nil {
+		return Trip{}, err
+	}
+	if t.Status != StatusArchived {
+		t.Status = StatusArchived
+		t.ArchivedAt = at
+		t.UpdatedAt = at
+	}
+	return t, s.UpdateTrip(ctx, t)
+}
+
+// ItemsOf filters items to those of trip id. An empty id selects items
+// added while no trip was active.
+func ItemsOf(items []Item, id string) []Item {
+	out := []Item{}
+	for _, item := range items {
+		if item.TripID == id {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Tokyo is the time zone trip dates are read in.
+var Tokyo = func() *time.Location {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return time.FixedZone("JST", 9*60*60)
+	}
+	return loc
+}()
+
+// ParseDateRange reads "2026-11-03", "11/3", "11月3日" or a range of them
+// joined by "-", "〜" or "~" into YYYY-MM-DD dates. Dates without a year
+// are the next occurrence on or after ref; an end date before its start
+// rolls into the following year.
+func ParseDateRange(s string, ref time.Time) (start, end string, ok bool) {
+	s = strings.NewReplacer("〜", "~", "～", "~", "－", "~", "ー", "~").Replace(s)
+	from, to, isRange := strings.Cut(s, "~")
+	if !isRange && strings.Count(s, "-") != 2 {
+		// 2026-11-03 以外のハイフンは範囲の区切り
+		from, to, isRange = strings.Cut(s, "-")
+	}
+	a, ok := parseDate(from, ref)
+	if !ok {
+		return "", "", false
+	}
+	if !isRange {
+		return a.Format(DateLayout), a.Format(DateLayout), true
+	}
+	b, ok := parseDate(to, a)
+	if !ok {
+		return "", "", false
+	}
+	return a.Format(DateLayout), b.Format(DateLayout), true
+}
+
+func parseDate(s string, ref time.Time) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{DateLayout, "2006/1/2"} {
+		if t, err := time.ParseInLocation(layout, s, ref.Location()); err == nil {
+			return t, true
+		}
+	}
+	s = strings.NewReplacer("月", "/", "日", "").Replace(s)
+	t, err := time.ParseInLocation("1/2", s, ref.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	day := time.Date(ref.Year(), t.Month(), t.Day(), 0, 0, 0, 0, ref.Location())
+	if day.Before(time.Date(ref.Year(), ref.Month(), ref.Day(), 0, 0, 0, 0, ref.Location())) {
+		day = day.AddDate(1, 0, 0)
+	}
+	return day, true
+}
@@ -0,0 +1,477 @@
+package trip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash/upstashtest"
+)
+
+// backends opens a trip store next to each message store backend.
+func backends(t *testing.T) map[string]func() Store {
+	open := func(s store.MessageStore) Store {
+		ts, err := Open(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	return map[string]func() Store{
+		store.BackendUpstash: func() Store {
+			srv := upstashtest.NewServer()
+			t.Cleanup(srv.Close)
+			return open(store.NewUpstash(srv.Client()))
+		},
+		store.BackendMemory: func() Store { return open(store.NewMemory()) },
+		store.BackendBolt: func() Store {
+			s, err := store.OpenBolt(filepath.Join(t.TempDir(), "messages.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return open(s)
+		},
+	}
+}
+
+func TestStore(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			ctx := context.Background()
+
+			a, added, err := s.AddItem(ctx, Item{GroupID: "C1", Title: "清水寺", SourceMessageID: "m1"})
+			if err != nil || !added || a.ID != 1 {
+				t.Fatalf("AddItem = %+v, %v, %v", a, added, err)
+			}
+			b, _, _ := s.AddItem(ctx, Item{GroupID: "C1", Title: "金閣寺"})
+			other, _, _ := s.AddItem(ctx, Item{GroupID: "C2", Title: "大阪城"})
+			if b.ID != 2 || other.ID != 1 {
+				t.Errorf("numbers = %d, %d", b.ID, other.ID)
+			}
+			// 同じメッセージからの追加は無視される
+			if again, added, err := s.AddItem(ctx, Item{GroupID: "C1", Title: "清水寺", SourceMessageID: "m1"}); err != nil || added || again.ID != 1 {
+				t.Errorf("duplicate AddItem = %+v, %v, %v", again, added, err)
+			}
+
+			b.Done = true
+			if err := s.UpdateItem(ctx, b); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.Item(ctx, "C1", 2); err != nil || !got.Done {
+				t.Errorf("Item(2) = %+v, %v", got, err)
+			}
+			if err := s.RemoveItem(ctx, "C1", 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.Item(ctx, "C1", 1); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Item after remove = %v", err)
+			}
+			if err := s.RemoveItem(ctx, "C1", 1); !errors.Is(err, ErrNotFound) {
+				t.Errorf("second remove = %v", err)
+			}
+			// 削除した項目のメッセージが再送されても追加し直さない
+			if again, added, err := s.AddItem(ctx, Item{GroupID: "C1", Title: "清水寺", SourceMessageID: "m1"}); err != nil || added || again.ID != 1 {
+				t.Errorf("AddItem after remove = %+v, %v, %v", again, added, err)
+			}
+			if err := s.UpdateItem(ctx, Item{GroupID: "C1", ID: 9}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("update of unknown item = %v", err)
+			}
+
+			// 番号は再利用しない
+			c, _, _ := s.AddItem(ctx, Item{GroupID: "C1", Title: "伏見稲荷"})
+			items, err := s.Items(ctx, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.ID != 3 || len(items) != 2 || items[0].ID != 2 || items[1].Title != "伏見稲荷" {
+				t.Errorf("items = %+v", items)
+			}
+		})
+	}
+}
+
+func TestUpstashSourceNotStored(t *testing.T) {
+	srv := upstashtest.NewServer()
+	defer srv.Close()
+	s := NewUpstash(srv.Client())
+	ctx := context.Background()
+
+	// 元のメッセージは記録済みだが、項目などが保存されていない
+	srv.Exec("HSET", sourcesKeyPrefix+"C1", "m1", 1)
+	srv.Exec("HSET", sourcesKeyPrefix+"C1", "trip:m1", 1)
+	srv.Exec("HSET", sourcesKeyPrefix+"C1", "entry:m1", 1)
+	srv.Exec("HSET", sourcesKeyPrefix+"C1", "expense:m1", 1)
+
+	if item, added, err := s.AddItem(ctx, Item{GroupID: "C1", Title: "清水寺", SourceMessageID: "m1"}); !errors.Is(err, errSourceNotStored) || added {
+		t.Errorf("AddItem = %+v, %v, %v", item, added, err)
+	}
+	if trip, created, err := s.CreateTrip(ctx, Trip{GroupID: "C1", Title: "京都", SourceMessageID: "m1"}); !errors.Is(err, errSourceNotStored) || created {
+		t.Errorf("CreateTrip = %+v, %v, %v", trip, created, err)
+	}
+	if e, added, err := s.AddEntry(ctx, Entry{GroupID: "C1", Title: "金閣寺", SourceMessageID: "m1"}); !errors.Is(err, errSourceNotStored) || added {
+		t.Errorf("AddEntry = %+v, %v, %v", e, added, err)
+	}
+	if e, added, err := s.AddExpense(ctx, Expense{GroupID: "C1", Amount: 1000, SourceMessageID: "m1"}); !errors.Is(err, errSourceNotStored) || added {
+		t.Errorf("AddExpense = %+v, %v, %v", e, added, err)
+	}
+	if items, _ := s.Items(ctx, "C1"); len(items) != 0 {
+		t.Errorf("items = %+v", items)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	r := command.NewRegistry()
+	s := NewMemory()
+	Register(r, s, nil)
+	ctx := context.Background()
+
+	seq := 0
+	send := func(text string) string {
+		seq++
+		m := store.Message{GroupID: "C1", UserID: "U1", UserName: "たくと", MessageID: fmt.Sprintf("m%d", seq), Message: text, Timestamp: int64(seq) * 1000}
+		reply, ok, err := r.Dispatch(ctx, m, false)
+		if !ok || err != nil {
+			t.Fatalf("%s: ok=%v err=%v", text, ok, err)
+		}
+		return reply
+	}
+
+	if reply := send("/list"); !strings.Contains(reply, "空") {
+		t.Errorf("empty list = %q", reply)
+	}
+	if reply := send("/add 清水寺"); reply != "✅ 追加しました\n1. 清水寺" {
+		t.Errorf("add = %q", reply)
+	}
+	if reply := send("/add 金閣寺\n 伏見稲荷 \n"); reply != "✅ 追加しました\n2. 金閣寺\n3. 伏見稲荷" {
+		t.Errorf("add several = %q", reply)
+	}
+	if reply := send("/add"); !strings.Contains(reply, "例") {
+		t.Errorf("add without title = %q", reply)
+	}
+	if reply := send("/done ３"); reply != "🎉 3. 伏見稲荷 を完了にしました" {
+		t.Errorf("done = %q", reply)
+	}
+	if reply := send("/remove #1 9"); reply != "🗑️ 1. 清水寺 を削除しました\n9 番の項目はありません" {
+		t.Errorf("remove = %q", reply)
+	}
+	if reply := send("/done abc"); !strings.Contains(reply, "例") {
+		t.Errorf("done without number = %q", reply)
+	}
+	if reply := send("/list"); reply != "📝 やりたいことリスト\n⬜ 2. 金閣寺\n✅ 3. 伏見稲荷" {
+		t.Errorf("list = %q", reply)
+	}
+
+	item, _ := s.Item(ctx, "C1", 3)
+	if item.AddedBy != "U1" || item.AddedByName != "たくと" || item.CreatedAt != 3000 || item.DoneAt != 5000 {
+		t.Errorf("item = %+v", item)
+	}
+}
+
+func TestTrips(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			ctx := context.Background()
+
+			s.AddItem(ctx, Item{GroupID: "C1", Title: "清水寺"})
+			kyoto, created, err := Create(ctx, s, Trip{GroupID: "C1", Title: "京都", SourceMessageID: "m1"}, 1000)
+			if err != nil || !created || kyoto.ID != "C1-1" || kyoto.Status != StatusActive {
+				t.Fatalf("Create = %+v, %v, %v", kyoto, created, err)
+			}
+			if again, created, err := Create(ctx, s, Trip{GroupID: "C1", Title: "京都", SourceMessageID: "m1"}, 2000); err != nil || created || again.ID != kyoto.ID {
+				t.Errorf("duplicate Create = %+v, %v, %v", again, created, err)
+			}
+			// 旅行ができる前の項目は最初の旅行に入る
+			if item, _ := s.Item(ctx, "C1", 1); item.TripID != kyoto.ID {
+				t.Errorf("item before trips = %+v", item)
+			}
+
+			okinawa, _, err := Create(ctx, s, Trip{GroupID: "C1", Title: "沖縄"}, 3000)
+			if err != nil || okinawa.Number != 2 {
+				t.Fatalf("second trip = %+v, %v", okinawa, err)
+			}
+			if active, _, _ := Active(ctx, s, "C1"); active.ID != okinawa.ID {
+				t.Errorf("active = %+v", active)
+			}
+			if got, _ := s.Trip(ctx, kyoto.ID); got.Status != StatusPlanning {
+				t.Errorf("previous trip = %+v", got)
+			}
+
+			if _, err := Activate(ctx, s, kyoto.ID, 4000); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Archive(ctx, s, kyoto.ID, 5000); err != nil {
+				t.Fatal(err)
+			}
+			if id, _ := ActiveID(ctx, s, "C1"); id != "" {
+				t.Errorf("active after archive = %q", id)
+			}
+			trips, err := s.Trips(ctx, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(trips) != 2 || trips[0].Status != StatusArchived || trips[0].ArchivedAt != 5000 || trips[1].Status != StatusPlanning {
+				t.Errorf("trips = %+v", trips)
+			}
+
+			if _, err := s.Trip(ctx, "C1-9"); !errors.Is(err, ErrTripNotFound) {
+				t.Errorf("unknown trip = %v", err)
+			}
+			if _, err := s.Trip(ctx, "C1"); !errors.Is(err, ErrTripNotFound) {
+				t.Errorf("malformed id = %v", err)
+			}
+			if trips, _ := s.Trips(ctx, "C2"); len(trips) != 0 {
+				t.Errorf("trips of C2 = %+v", trips)
+			}
+		})
+	}
+}
+
+func TestActivateConcurrent(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			ctx := context.Background()
+			var ids []string
+			for i := 0; i < 5; i++ {
+				created, _, err := Create(ctx, s, Trip{GroupID: "C1", Title: fmt.Sprintf("旅行 %d", i)}, 1000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, created.ID)
+			}
+
+			// 同時に切り替えても進行中の旅行は 1 つだけ
+			var wg sync.WaitGroup
+			for _, id := range ids {
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+					if _, err := Activate(ctx, s, id, 2000); err != nil {
+						t.Error(err)
+					}
+				}(id)
+			}
+			wg.Wait()
+
+			trips, err := s.Trips(ctx, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var active []string
+			for _, trip := range trips {
+				if trip.Status == StatusActive {
+					active = append(active, trip.ID)
+				}
+			}
+			if len(active) != 1 {
+				t.Fatalf("active trips = %v", active)
+			}
+			if got, _ := s.Trip(ctx, active[0]); got.Status != StatusActive {
+				t.Errorf("Trip(%s) = %+v", active[0], got)
+			}
+			if id, _ := ActiveID(ctx, s, "C1"); id != active[0] {
+				t.Errorf("ActiveID = %q, want %q", id, active[0])
+			}
+		})
+	}
+}
+
+func TestTripCommands(t *testing.T) {
+	r := command.NewRegistry()
+	s := NewMemory()
+	Register(r, s, nil)
+	ctx := context.Background()
+
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, Tokyo).UnixMilli()
+	seq := 0
+	send := func(text string) string {
+		seq++
+		m := store.Message{GroupID: "C1", UserID: "U1", MessageID: fmt.Sprintf("m%d", seq), Message: text, Timestamp: start + int64(seq)}
+		reply, ok, err := r.Dispatch(ctx, m, false)
+		if !ok || err != nil {
+			t.Fatalf("%s: ok=%v err=%v", text, ok, err)
+		}
+		return reply
+	}
+
+	if reply := send("/trip"); !strings.Contains(reply, "まだありません") {
+		t.Errorf("empty trips = %q", reply)
+	}
+	if reply := send("/trip new 京都旅行 11/3-11/5 京都 嵐山"); reply != "🧳 1. 京都旅行 を作成しました\n📅 11/3〜11/5\n📍 京都 嵐山\nこれからの /add とメッセージはこの旅行に記録されます" {
+		t.Errorf("new = %q", reply)
+	}
+	kyoto, _ := s.Trip(ctx, "C1-1")
+	if kyoto.StartDate != "2026-11-03" || kyoto.EndDate != "2026-11-05" || kyoto.CreatedBy != "U1" {
+		t.Errorf("trip = %+v", kyoto)
+	}
+	send("/add 清水寺")
+	send("/trip new 沖縄")
+	send("/add 美ら海水族館")
+	if reply := send("/list"); reply != "📝 やりたいことリスト（沖縄）\n⬜ 2. 美ら海水族館" {
+		t.Errorf("list of second trip = %q", reply)
+	}
+	if reply := send("/trip switch 1"); reply != "🔁 1. 京都旅行 に切り替えました" {
+		t.Errorf("switch = %q", reply)
+	}
+	if reply := send("/list"); reply != "📝 やりたいことリスト（京都旅行）\n⬜ 1. 清水寺" {
+		t.Errorf("list after switch = %q", reply)
+	}
+	if reply := send("/trip switch 5"); reply != "5 番の旅行はありません" {
+		t.Errorf("switch to unknown = %q", reply)
+	}
+	if reply := send("/trip archive"); reply != "🗄️ 1. 京都旅行 をアーカイブしました" {
+		t.Errorf("archive = %q", reply)
+	}
+	if reply := send("/trip archive"); reply != "進行中の旅行はありません" {
+		t.Errorf("archive without active = %q", reply)
+	}
+	if reply := send("/trip"); reply != "🧳 旅行一覧\n🗄️ 1. 京都旅行（11/3〜11/5）\n・ 2. 沖縄" {
+		t.Errorf("trips = %q", reply)
+	}
+
+	if reply := send("/timezone"); reply != "🕘 タイムゾーンは Asia/Tokyo です（変更: /timezone America/New_York）" {
+		t.Errorf("timezone = %q", reply)
+	}
+	if reply := send("/timezone Mars/Olympus"); !strings.Contains(reply, "知らない") {
+		t.Errorf("unknown timezone = %q", reply)
+	}
+	if reply := send("/timezone Pacific/Honolulu"); reply != "🕘 タイムゾーンを Pacific/Honolulu にしました" {
+		t.Errorf("set timezone = %q", reply)
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	ref := time.Date(2026, 10, 18, 9, 0, 0, 0, Tokyo)
+	tests := []struct {
+		in         string
+		start, end string
+		ok         bool
+	}{
+		{"2026-11-03", "2026-11-03", "2026-11-03", true},
+		{"11/3", "2026-11-03", "2026-11-03", true},
+		{"11月3日", "2026-11-03", "2026-11-03", true},
+		{"11/3-11/5", "2026-11-03", "2026-11-05", true},
+		{"11/3〜5", "", "", false},
+		{"11/3〜11/5", "2026-11-03", "2026-11-05", true},
+		{"12/30~1/2", "2026-12-30", "2027-01-02", true},
+		{"2027/2/1-2027/2/3", "2027-02-01", "2027-02-03", true},
+		{"10/18", "2026-10-18", "2026-10-18", true},
+		// 過ぎた日付は来年
+		{"3/1", "2027-03-01", "2027-03-01", true},
+		{"京都", "", "", false},
+	}
+	for _, tt := range tests {
+		start, end, ok := ParseDateRange(tt.in, ref)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("ParseDateRange(%q) = %q, %q, %v; want %q, %q, %v", tt.in, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestItinerary(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			ctx := context.Background()
+
+			a, added, err := s.AddEntry(ctx, Entry{GroupID: "C1", TripID: "C1-1", Date: "2026-11-05", Time: "10:00", Title: "金閣寺", SourceMessageID: "m1"})
+			if err != nil || !added || a.ID != 1 {
+				t.Fatalf("AddEntry = %+v, %v, %v", a, added, err)
+			}
+			if again, added, err := s.AddEntry(ctx, Entry{GroupID: "C1", TripID: "C1-1", Title: "金閣寺", SourceMessageID: "m1"}); err != nil || added || again.Date != "2026-11-05" {
+				t.Errorf("duplicate AddEntry = %+v, %v, %v", again, added, err)
+			}
+			// 項目と同じメッセージ ID でも別に数える
+			if _, added, _ := s.AddItem(ctx, Item{GroupID: "C1", Title: "x", SourceMessageID: "m1"}); !added {
+				t.Error("item with the entry's source was not added")
+			}
+			s.AddEntry(ctx, Entry{GroupID: "C1", TripID: "C1-1", Date: "2026-11-04", Period: "夜", Title: "先斗町"})
+			s.AddEntry(ctx, Entry{GroupID: "C1", TripID: "C1-2", Date: "2026-12-01", Title: "別の旅行"})
+
+			a.Status = EntryConfirmed
+			if err := s.UpdateEntry(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := s.Entry(ctx, "C1", 1); err != nil || got.Status != EntryConfirmed {
+				t.Errorf("Entry(1) = %+v, %v", got, err)
+			}
+			if err := s.UpdateEntry(ctx, Entry{GroupID: "C1", ID: 9}); !errors.Is(err, ErrEntryNotFound) {
+				t.Errorf("update of unknown entry = %v", err)
+			}
+
+			entries, err := s.Entries(ctx, "C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var titles []string
+			for _, e := range EntriesOf(entries, "C1-1") {
+				titles = append(titles, e.Title)
+			}
+			if fmt.Sprint(titles) != "[先斗町 金閣寺]" {
+				t.Errorf("itinerary = %v", titles)
+			}
+
+			if name, err := s.TimeZone(ctx, "C1"); err != nil || name != "" {
+				t.Errorf("TimeZone before set = %q, %v", name, err)
+			}
+			if err := s.SetTimeZone(ctx, "C1", "Pacific/Honolulu"); err != nil {
+				t.Fatal(err)
+			}
+			if loc, err := Location(ctx, s, "C1"); err != nil || loc.String() != "Pacific/Honolulu" {
+				t.Errorf("Location = %v, %v", loc, err)
+			}
+		})
+	}
+}
+
+func TestPropose(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+	kyoto, _, _ := Create(ctx, s, Trip{GroupID: "C1", Title: "京都", StartDate: "2026-11-03", EndDate: "2026-11-05"}, 1000)
+
+	sent := time.Date(2026, 10, 21, 12, 0, 0, 0, Tokyo).UnixMilli()
+	propose := func(id, text string) (Entry, bool) {
+		e, added, err := Propose(ctx, s, store.Message{GroupID: "C1", TripID: kyoto.ID, UserID: "U1", UserName: "たくと", MessageID: id, Message: text, Timestamp: sent})
+		if err != nil {
+			t.Fatalf("Propose(%q): %v", text, err)
+		}
+		return e, added
+	}
+
+	e, added := propose("m1", "3日目の10時に金閣寺")
+	if !added || e.Date != "2026-11-05" || e.Time != "10:00" || e.Title != "金閣寺" || e.Status != EntryProposed || e.ProposedByName != "たくと" || e.Expression != "3日目 10時" {
+		t.Errorf("proposal = %+v", e)
+	}
+	if _, added := propose("m1", "3日目の10時に金閣寺"); added {
+		t.Error("same message proposed twice")
+	}
+	if e, _ := propose("m2", "明日の夜 焼肉"); e.Date != "2026-10-22" || e.Period != "夜" || e.Title != "焼肉" {
+		t.Errorf("proposal = %+v", e)
+	}
+	for _, text := range []string{"楽しみ！", "10時に集合", "明日ね"} {
+		if e, added := propose("m-"+text, text); added {
+			t.Errorf("%q proposed %+v", text, e)
+		}
+	}
+
+	// 旅行の外のメッセージは提案しない
+	if _, added, _ := Propose(ctx, s, store.Message{GroupID: "C1", MessageID: "m9", Message: "明日の夜 焼肉", Timestamp: sent}); added {
+		t.Error("message without trip proposed")
+	}
+
+	// タイムゾーンで「明日」が変わる
+	s.SetTimeZone(ctx, "C1", "Pacific/Honolulu")
+	if e, _ := propose("m10", "明日 ハイキング"); e.Date != "2026-10-21" {
+		t.Errorf("proposal in Honolulu = %+v", e)
+	}
+}
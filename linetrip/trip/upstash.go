@@ -0,0 +1,555 @@
+package trip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+// Redis keys: line_trip_items:{groupId} maps item number to JSON,
+// line_trip_sources:{groupId} maps the source LINE message ID to the item
+// number, and line_trip_seq holds the last number of each conversation.
+// Trips live in line_trips:{groupId} by trip number, numbered from the
+// "trips:{groupId}" field of line_trip_seq; their source messages are kept
+// in the items' sources hash with a "trip:" prefix. Itinerary entries work
+// the same way under line_trip_itinerary:{groupId}, "itinerary:{groupId}"
+// and "entry:", and expenses under line_trip_expenses:{groupId},
+// "expenses:{groupId}" and "expense:". A record and its source are written
+// in one transaction; line_trip_removed:{groupId} keeps the numbers of
+// removed items so their sources can tell removal from a missing record.
+// line_trip_snapshot_rates:{tripId} holds the rates snapshotted for a trip,
+// one field per currency. line_trip_active maps a conversation to the ID of
+// its active trip, line_trip_time_zones to its time zone, and
+// line_trip_rates maps a currency code to its JPY rate.
+const (
+	itemsKeyPrefix     = "line_trip_items:"
+	sourcesKeyPrefix   = "line_trip_sources:"
+	seqKey             = "line_trip_seq"
+	tripsKeyPrefix     = "line_trips:"
+	itineraryKeyPrefix = "line_trip_itinerary:"
+	expensesKeyPrefix  = "line_trip_expenses:"
+	removedKeyPrefix   = "line_trip_removed:"
+	tripRatesKeyPrefix = "line_trip_snapshot_rates:"
+	activeKey          = "line_trip_active"
+	timeZonesKey       = "line_trip_time_zones"
+	ratesKey           = "line_trip_rates"
+)
+
+// Upstash keeps trips and their items in Redis hashes.
+type Upstash struct {
+	client *upstash.Client
+}
+
+// NewUpstash returns a trip store using client.
+func NewUpstash(client *upstash.Client) *Upstash {
+	return &Upstash{client: client}
+}
+
+// errSourceNotStored is returned when a source message is claimed but its
+// record is missing. Claims are written together with their records, so the
+// lookup is worth retrying rather than treating the record as removed.
+var errSourceNotStored = errors.New("trip: record of source message not stored")
+
+func (s *Upstash) AddItem(ctx context.Context, item Item) (Item, bool, error) {
+	if item.SourceMessageID != "" {
+		if existing, ok, err := s.bySource(ctx, item); err != nil || ok {
+			return existing, false, err
+		}
+	}
+
+	res, err := s.client.Do(ctx, "HINCRBY", seqKey, item.GroupID, 1)
+	if err != nil {
+		return Item{}, false, err
+	}
+	item.ID = int(upstash.Int(res))
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return Item{}, false, err
+	}
+	added, err := s.create(ctx, item.GroupID, item.SourceMessageID, itemsKeyPrefix, item.ID, data)
+	if err != nil {
+		return Item{}, false, err
+	}
+	if !added {
+		existing, _, err := s.bySource(ctx, item)
+		return existing, false, err
+	}
+	return item, true, nil
+}
+
+// create writes data under number n of the hash keyPrefix+groupID and, when
+// source is set, claims source for it in the same transaction. It reports
+// false when an earlier delivery of the same message claimed source first.
+func (s *Upstash) create(ctx context.Context, groupID, source, keyPrefix string, n int, data []byte) (bool, error) {
+	cmds := [][]interface{}{{"HSET", keyPrefix + groupID, n, string(data)}}
+	if source != "" {
+		cmds = append(cmds, []interface{}{"HSETNX", sourcesKeyPrefix + groupID, source, n})
+	}
+	res, err := s.client.Multi(ctx, cmds...)
+	if err != nil {
+		return false, err
+	}
+	if source == "" || upstash.Int(res[1]) == 1 {
+		return true, nil
+	}
+	// 同時に届いた再送に先を越された（書いたものは消し、番号は欠番になる）
+	_, err = s.client.Do(context.WithoutCancel(ctx), "HDEL", keyPrefix+groupID, n)
+	return false, err
+}
+
+// source looks up the record claimed by source in the hash
+// keyPrefix+groupID. It returns the record's number and JSON, with an empty
+// JSON when the record was removed (only items are, see RemoveItem).
+func (s *Upstash) source(ctx context.Context, groupID, source, keyPrefix string) (int, string, bool, error) {
+	res, err := s.client.Do(ctx, "HGET", sourcesKeyPrefix+groupID, source)
+	if err != nil || res == nil {
+		return 0, "", false, err
+	}
+	n, _ := strconv.Atoi(upstash.String(res))
+	cmds := [][]interface{}{{"HGET", keyPrefix + groupID, n}}
+	if keyPrefix == itemsKeyPrefix {
+		cmds = append(cmds, []interface{}{"SISMEMBER", removedKeyPrefix + groupID, n})
+	}
+	res2, err := s.client.Multi(ctx, cmds...)
+	if err != nil {
+		return 0, "", false, err
+	}
+	if res2[0] != nil {
+		return n, upstash.String(res2[0]), true, nil
+	}
+	if len(res2) > 1 && upstash.Int(res2[1]) == 1 {
+		return n, "", true, nil
+	}
+	return 0, "", false, fmt.Errorf("%w: %s%s/%s", errSourceNotStored, keyPrefix, groupID, source)
+}
+
+// bySource finds the item added from item.SourceMessageID, if any.
+func (s *Upstash) bySource(ctx context.Context, item Item) (Item, bool, error) {
+	id, data, ok, err := s.source(ctx, item.GroupID, item.SourceMessageID, itemsKeyPrefix)
+	if err != nil || !ok {
+		return Item{}, false, err
+	}
+	existing := Item{ID: id, GroupID: item.GroupID}
+	if data == "" {
+		// 追加後に削除されている
+		return existing, true, nil
+	}
+	return existing, true, json.Unmarshal([]byte(data), &existing)
+}
+
+func (s *Upstash) put(ctx context.Context, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "HSET", itemsKeyPrefix+item.GroupID, item.ID, string(data))
+	return err
+}
+
+func (s *Upstash) Items(ctx context.Context, groupID string) ([]Item, error) {
+	res, err := s.client.Do(ctx, "HGETALL", itemsKeyPrefix+groupID)
+	if err != nil {
+		return nil, err
+	}
+	out := []Item{}
+	for _, v := range upstash.Hash(res) {
+		var item Item
+		if err := json.Unmarshal([]byte(upstash.String(v)), &item); err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (s *Upstash) Item(ctx context.Context, groupID string, id int) (Item, error) {
+	res, err := s.client.Do(ctx, "HGET", itemsKeyPrefix+groupID, id)
+	if err != nil {
+		return Item{}, err
+	}
+	if res == nil {
+		return Item{}, ErrNotFound
+	}
+	var item Item
+	err = json.Unmarshal([]byte(upstash.String(res)), &item)
+	return item, err
+}
+
+func (s *Upstash) UpdateItem(ctx context.Context, item Item) error {
+	if _, err := s.Item(ctx, item.GroupID, item.ID); err != nil {
+		return err
+	}
+	return s.put(ctx, item)
+}
+
+func (s *Upstash) RemoveItem(ctx context.Context, groupID string, id int) error {
+	// 削除済みを覚えておき、元のメッセージの再送で「保存前」と区別する
+	res, err := s.client.Multi(ctx,
+		[]interface{}{"HDEL", itemsKeyPrefix + groupID, id},
+		[]interface{}{"SADD", removedKeyPrefix + groupID, id},
+	)
+	if err != nil {
+		return err
+	}
+	if upstash.Int(res[0]) == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Upstash) CreateTrip(ctx context.Context, t Trip) (Trip, bool, error) {
+	source := ""
+	if t.SourceMessageID != "" {
+		source = "trip:" + t.SourceMessageID
+		if existing, ok, err := s.tripBySource(ctx, t.GroupID, source); err != nil || ok {
+			return existing, false, err
+		}
+	}
+
+	res, err := s.client.Do(ctx, "HINCRBY", seqKey, "trips:"+t.GroupID, 1)
+	if err != nil {
+		return Trip{}, false, err
+	}
+	t.Number = int(upstash.Int(res))
+	t.ID = TripID(t.GroupID, t.Number)
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return Trip{}, false, err
+	}
+	created, err := s.create(ctx, t.GroupID, source, tripsKeyPrefix, t.Number, data)
+	if err != nil {
+		return Trip{}, false, err
+	}
+	if !created {
+		existing, _, err := s.tripBySource(ctx, t.GroupID, source)
+		return existing, false, err
+	}
+	return t, true, nil
+}
+
+func (s *Upstash) tripBySource(ctx context.Context, groupID, source string) (Trip, bool, error) {
+	_, data, ok, err := s.source(ctx, groupID, source, tripsKeyPrefix)
+	if err != nil || !ok {
+		return Trip{}, false, err
+	}
+	var t Trip
+	return t, true, json.Unmarshal([]byte(data), &t)
+}
+
+func (s *Upstash) Trips(ctx context.Context, groupID string) ([]Trip, error) {
+	res, err := s.client.Pipeline(ctx,
+		[]interface{}{"HGETALL", tripsKeyPrefix + groupID},
+		[]interface{}{"HGET", activeKey, groupID},
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := []Trip{}
+	for _, v := range upstash.Hash(res[0]) {
+		var t Trip
+		if err := json.Unmarshal([]byte(upstash.String(v)), &t); err != nil {
+			return nil, err
+		}
+		activeStatus(&t, upstash.String(res[1]))
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Number < out[j].Number })
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	cmds := make([][]interface{}, len(out))
+	for i, t := range out {
+		cmds[i] = []interface{}{"HGETALL", tripRatesKeyPrefix + t.ID}
+	}
+	rates, err := s.client.Pipeline(ctx, cmds...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		if err := addTripRates(&out[i], rates[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (s *Upstash) Trip(ctx context.Context, id string) (Trip, error) {
+	groupID, n, ok := ParseTripID(id)
+	if !ok {
+		return Trip{}, ErrTripNotFound
+	}
+	res, err := s.client.Pipeline(ctx,
+		[]interface{}{"HGET", tripsKeyPrefix + groupID, n},
+		[]interface{}{"HGETALL", tripRatesKeyPrefix + id},
+		[]interface{}{"HGET", activeKey, groupID},
+	)
+	if err != nil {
+		return Trip{}, err
+	}
+	if res[0] == nil {
+		return Trip{}, ErrTripNotFound
+	}
+	var t Trip
+	if err := json.Unmarshal([]byte(upstash.String(res[0])), &t); err != nil {
+		return Trip{}, err
+	}
+	activeStatus(&t, upstash.String(res[2]))
+	return t, addTripRates(&t, res[1])
+}
+
+// addTripRates adds the snapshot rates of an HGETALL reply to t, over the
+// rates trips kept in their JSON before.
+func addTripRates(t *Trip, res interface{}) error {
+	snapshots := Rates{}
+	for code, v := range upstash.Hash(res) {
+		rate, err := strconv.ParseFloat(upstash.String(v), 64)
+		if err != nil {
+			return err
+		}
+		snapshots[code] = rate
+	}
+	t.Rates = mergeRates(t.Rates, snapshots)
+	return nil
+}
+
+func (s *Upstash) UpdateTrip(ctx context.Context, t Trip) error {
+	if _, err := s.Trip(ctx, t.ID); err != nil {
+		return err
+	}
+	// レートは通貨ごとに別に持ち、古い読み取りで上書きしない
+	rates := t.Rates
+	t.Rates = nil
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	cmds := [][]interface{}{{"HSET", tripsKeyPrefix + t.GroupID, t.Number, string(data)}}
+	if len(rates) > 0 {
+		hset := []interface{}{"HSET", tripRatesKeyPrefix + t.ID}
+		for code, rate := range rates {
+			hset = append(hset, code, strconv.FormatFloat(rate, 'g', -1, 64))
+		}
+		cmds = append(cmds, hset)
+	}
+	_, err = s.client.Multi(ctx, cmds...)
+	return err
+}
+
+func (s *Upstash) SetActiveTrip(ctx context.Context, groupID, id string) error {
+	_, err := s.client.Do(ctx, "HSET", activeKey, groupID, id)
+	return err
+}
+
+func (s *Upstash) SnapshotTripRate(ctx context.Context, id, code string, rate float64) (float64, error) {
+	t, err := s.Trip(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if existing, ok := t.Rates[code]; ok {
+		return existing, nil
+	}
+	res, err := s.client.Multi(ctx,
+		[]interface{}{"HSETNX", tripRatesKeyPrefix + id, code, strconv.FormatFloat(rate, 'g', -1, 64)},
+		[]interface{}{"HGET", tripRatesKeyPrefix + id, code},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(upstash.String(res[1]), 64)
+}
+
+func (s *Upstash) AddEntry(ctx context.Context, e Entry) (Entry, bool, error) {
+	source := ""
+	if e.SourceMessageID != "" {
+		source = "entry:" + e.SourceMessageID
+		if existing, ok, err := s.entryBySource(ctx, e.GroupID, source); err != nil || ok {
+			return existing, false, err
+		}
+	}
+
+	res, err := s.client.Do(ctx, "HINCRBY", seqKey, "itinerary:"+e.GroupID, 1)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	e.ID = int(upstash.Int(res))
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	added, err := s.create(ctx, e.GroupID, source, itineraryKeyPrefix, e.ID, data)
+	if err != nil {
+		return Entry{}, false, err
+	}
+	if !added {
+		existing, _, err := s.entryBySource(ctx, e.GroupID, source)
+		return existing, false, err
+	}
+	return e, true, nil
+}
+
+func (s *Upstash) entryBySource(ctx context.Context, groupID, source string) (Entry, bool, error) {
+	_, data, ok, err := s.source(ctx, groupID, source, itineraryKeyPrefix)
+	if err != nil || !ok {
+		return Entry{}, false, err
+	}
+	var e Entry
+	return e, true, json.Unmarshal([]byte(data), &e)
+}
+
+func (s *Upstash) putEntry(ctx context.Context, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(ctx, "HSET", itineraryKeyPrefix+e.GroupID, e.ID, string(data))
+	return err
+}
+
+func (s *Upstash) Entries(ctx context.Context, groupID string) ([]Entry, error) {
+	res, err := s.client.Do(ctx, "HGETALL", itineraryKeyPrefix+groupID)
+	if err != nil {
+		return nil, err
+	}
+	out := []Entry{}
+	for _, v := range upstash.Hash(res) {
+		var e Entry
+		if err := json.Unmarshal([]byte(upstash.String(v)), &e); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (s *Upstash) Entry(ctx context.Context, groupID string, id int) (Entry, error) {
+	res, err := s.client.Do(ctx, "HGET", itineraryKeyPrefix+groupID, id)
+	if err != nil {
+		return Entry{}, err
+	}
+	if res == nil {
+		return Entry{}, ErrEntryNotFound
+	}
+	var e Entry
+	err = json.Unmarshal([]byte(upstash.String(res)), &e)
+	return e, err
+}
+
+func (s *Upstash) UpdateEntry(ctx context.Context, e Entry) error {
+	if _, err := s.Entry(ctx, e.GroupID, e.ID); err != nil {
+		return err
+	}
+	return s.putEntry(ctx, e)
+}
+
+func (s *Upstash) AddExpense(ctx context.Context, e Expense) (Expense, bool, error) {
+	source := ""
+	if e.SourceMessageID != "" {
+		source = "expense:" + e.SourceMessageID
+		if existing, ok, err := s.expenseBySource(ctx, e.GroupID, source); err != nil || ok {
+			return existing, false, err
+		}
+	}
+
+	res, err := s.client.Do(ctx, "HINCRBY", seqKey, "expenses:"+e.GroupID, 1)
+	if err != nil {
+		return Expense{}, false, err
+	}
+	e.ID = int(upstash.Int(res))
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return Expense{}, false, err
+	}
+	added, err := s.create(ctx, e.GroupID, source, expensesKeyPrefix, e.ID, data)
+	if err != nil {
+		return Expense{}, false, err
+	}
+	if !added {
+		existing, _, err := s.expenseBySource(ctx, e.GroupID, source)
+		return existing, false, err
+	}
+	return e, true, nil
+}
+
+func (s *Upstash) expenseBySource(ctx context.Context, groupID, source string) (Expense, bool, error) {
+	_, data, ok, err := s.source(ctx, groupID, source, expensesKeyPrefix)
+	if err != nil || !ok {
+		return Expense{}, false, err
+	}
+	var e Expense
+	return e, true, json.Unmarshal([]byte(data), &e)
+}
+
+func (s *Upstash) Expenses(ctx context.Context, groupID string) ([]Expense, error) {
+	res, err := s.client.Do(ctx, "HGETALL", expensesKeyPrefix+groupID)
+	if err != nil {
+		return nil, err
+	}
+	out := []Expense{}
+	for _, v := range upstash.Hash(res) {
+		var e Expense
+		if err := json.Unmarshal([]byte(upstash.String(v)), &e); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (s *Upstash) TimeZone(ctx context.Context, groupID string) (string, error) {
+	res, err := s.client.Do(ctx, "HGET", timeZonesKey, groupID)
+	if err != nil || res == nil {
+		return "", err
+	}
+	return upstash.String(res), nil
+}
+
+func (s *Upstash) SetTimeZone(ctx context.Context, groupID, name string) error {
+	_, err := s.client.Do(ctx, "HSET", timeZonesKey, groupID, name)
+	return err
+}
+
+func (s *Upstash) Rates(ctx context.Context) (Rates, error) {
+	res, err := s.client.Do(ctx, "HGETALL", ratesKey)
+	if err != nil {
+		return nil, err
+	}
+	out := Rates{}
+	for code, v := range upstash.Hash(res) {
+		rate, err := strconv.ParseFloat(upstash.String(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		out[code] = rate
+	}
+	return out, nil
+}
+
+func (s *Upstash) SetRates(ctx context.Context, rates Rates) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	args := []interface{}{"HSET", ratesKey}
+	for code, rate := range rates {
+		args = append(args, code, strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+	_, err := s.client.Do(ctx, args...)
+	return err
+}
+
+func (s *Upstash) RemoveRate(ctx context.Context, code string) error {
+	_, err := s.client.Do(ctx, "HDEL", ratesKey, code)
+	return err
+}
@@ -0,0 +1,191 @@
+// Package upstash is a small client for the Upstash Redis REST API.
+//
+// Every handler used to carry its own copy of redisGet/redisSet; this package
+// replaces those helpers with a single client that also supports pipelines.
+package upstash
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Client talks to Upstash Redis over its REST interface.
+type Client struct {
+	URL        string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Error is an error reported by Redis itself (e.g. WRONGTYPE), as opposed to
+// a transport failure.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "upstash: " + e.Message
+}
+
+// NewFromEnv builds a client from KV_REST_API_URL and KV_REST_API_TOKEN.
+func NewFromEnv() (*Client, error) {
+	url := os.Getenv("KV_REST_API_URL")
+	token := os.Getenv("KV_REST_API_TOKEN")
+	if url == "" || token == "" {
+		return nil, fmt.Errorf("redis credentials not set")
+	}
+	return New(url, token), nil
+}
+
+// New returns a client for the given REST endpoint and token.
+func New(url, token string) *Client {
+	return &Client{
+		URL:        strings.TrimRight(url, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type response struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error"`
+}
+
+// Do runs a single command, e.g. Do(ctx, "GET", "key").
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	var res response
+	if err := c.post(ctx, c.URL, args, &res); err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, &Error{Message: res.Error}
+	}
+	return res.Result, nil
+}
+
+// Pipeline sends several commands in one round-trip. Commands are not
+// atomic as a group; the returned slice holds one result per command and the
+// first Redis error encountered is returned alongside it.
+func (c *Client) Pipeline(ctx context.Context, cmds ...[]interface{}) ([]interface{}, error) {
+	return c.batch(ctx, "/pipeline", cmds)
+}
+
+// Multi runs several commands as one transaction (MULTI/EXEC): no other
+// command runs between them. Results are returned as by Pipeline; a
+// command that fails does not undo the others.
+func (c *Client) Multi(ctx context.Context, cmds ...[]interface{}) ([]interface{}, error) {
+	return c.batch(ctx, "/multi-exec", cmds)
+}
+
+func (c *Client) batch(ctx context.Context, path string, cmds [][]interface{}) ([]interface{}, error) {
+	var res []response
+	if err := c.post(ctx, c.URL+path, cmds, &res); err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(res))
+	var firstErr error
+	for i, r := range res {
+		results[i] = r.Result
+		if r.Error != "" && firstErr == nil {
+			firstErr = &Error{Message: r.Error}
+		}
+	}
+	return results, firstErr
+}
+
+func (c *Client) post(ctx context.Context, url string, payload interface{}, out interface{}) error {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Upstash は Redis エラーを 400 + {"error": "..."} で返すので、
+	// JSON として読めるならステータスに関係なくデコードする
+	if err := json.Unmarshal(body, out); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("upstash: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		return err
+	}
+	return nil
+}
+
+// String converts a command result to a string. nil results yield "".
+func String(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case float64:
+		return fmt.Sprintf("%.0f", s)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+// Int converts a command result to an int64. Redis integers arrive as JSON
+// numbers, while some commands (HGET etc.) return numeric strings.
+func Int(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case string:
+		var i int64
+		fmt.Sscan(n, &i)
+		return i
+	default:
+		return 0
+	}
+}
+
+// Strings converts an array reply (SMEMBERS, LRANGE, ...) to strings.
+func Strings(v interface{}) []string {
+	items, _ := v.([]interface{})
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		out = append(out, String(item))
+	}
+	return out
+}
+
+// Hash converts a flat [field, value, ...] reply (HGETALL, stream entry
+// fields) to a map.
+func Hash(v interface{}) map[string]interface{} {
+	items, _ := v.([]interface{})
+	out := make(map[string]interface{}, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		out[String(items[i])] = items[i+1]
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package upstashtest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+type hash map[string]string
+
+func (s *Server) hash(key string, create bool) (hash, error) {
+	switch v := s.data[key].(type) {
+	case nil:
+		if !create {
+			return nil, nil
+		}
+		h := hash{}
+		s.data[key] = h
+		return h, nil
+	case hash:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func cmdHSet(s *Server, args []string) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, fmt.Errorf("ERR wrong number of arguments for 'hset' command")
+	}
+	h, err := s.hash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := h[args[i]]; !ok {
+			n++
+		}
+		h[args[i]] = args[i+1]
+	}
+	return float64(n), nil
+}
+
+func cmdHSetNX(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, err
+	}
+	h, err := s.hash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := h[args[1]]; ok {
+		return float64(0), nil
+	}
+	h[args[1]] = args[2]
+	return float64(1), nil
+}
+
+func cmdHGet(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	h, err := s.hash(args[0], false)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	v, ok := h[args[1]]
+	if !ok {
+		return nil, nil
+	}
+	return v, nil
+}
+
+func cmdHGetAll(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	h, err := s.hash(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0, len(h))
+	for f := range h {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	out := make([]interface{}, 0, 2*len(h))
+	for _, f := range fields {
+		out = append(out, f, h[f])
+	}
+	return out, nil
+}
+
+func cmdHDel(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	h, err := s.hash(args[0], false)
+	if err != nil || h == nil {
+		return float64(0), err
+	}
+	n := 0
+	for _, f := range args[1:] {
+		if _, ok := h[f]; ok {
+			delete(h, f)
+			n++
+		}
+	}
+	if len(h) == 0 {
+		delete(s.data, args[0])
+	}
+	return float64(n), nil
+}
+
+func cmdHIncrBy(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, err
+	}
+	by, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	h, err := s.hash(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	var cur int64
+	if v, ok := h[args[1]]; ok {
+		if cur, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("ERR hash value is not an integer")
+		}
+	}
+	cur += by
+	h[args[1]] = strconv.FormatInt(cur, 10)
+	return float64(cur), nil
+}
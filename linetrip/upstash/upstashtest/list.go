@@ -0,0 +1,180 @@
+package upstashtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// list holds a Redis list, head (LEFT) first.
+type list struct {
+	items []string
+}
+
+func (s *Server) list(key string, create bool) (*list, error) {
+	switch v := s.data[key].(type) {
+	case nil:
+		if !create {
+			return nil, nil
+		}
+		l := &list{}
+		s.data[key] = l
+		return l, nil
+	case *list:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+// dropIfEmpty deletes emptied lists, as Redis does.
+func (s *Server) dropIfEmpty(key string, l *list) {
+	if l != nil && len(l.items) == 0 {
+		delete(s.data, key)
+	}
+}
+
+func cmdLPush(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	l, err := s.list(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range args[1:] {
+		l.items = append([]string{v}, l.items...)
+	}
+	return float64(len(l.items)), nil
+}
+
+func cmdRPush(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	l, err := s.list(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	l.items = append(l.items, args[1:]...)
+	return float64(len(l.items)), nil
+}
+
+func cmdLLen(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	l, err := s.list(args[0], false)
+	if err != nil || l == nil {
+		return float64(0), err
+	}
+	return float64(len(l.items)), nil
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func cmdLMove(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 4); err != nil {
+		return nil, err
+	}
+	from, to := strings.ToUpper(args[2]), strings.ToUpper(args[3])
+	for _, dir := range []string{from, to} {
+		if dir != "LEFT" && dir != "RIGHT" {
+			return nil, fmt.Errorf("ERR syntax error")
+		}
+	}
+	src, err := s.list(args[0], false)
+	if err != nil || src == nil || len(src.items) == 0 {
+		return nil, err
+	}
+	if _, err := s.list(args[1], false); err != nil {
+		return nil, err
+	}
+
+	var v string
+	if from == "LEFT" {
+		v, src.items = src.items[0], src.items[1:]
+	} else {
+		v, src.items = src.items[len(src.items)-1], src.items[:len(src.items)-1]
+	}
+	s.dropIfEmpty(args[0], src)
+
+	dst, _ := s.list(args[1], true)
+	if to == "LEFT" {
+		dst.items = append([]string{v}, dst.items...)
+	} else {
+		dst.items = append(dst.items, v)
+	}
+	return v, nil
+}
+
+// LREM key count value (count 0 removes all, negative removes from the tail)
+func cmdLRem(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	l, err := s.list(args[0], false)
+	if err != nil || l == nil {
+		return float64(0), err
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	keep := make([]string, 0, len(l.items))
+	if count >= 0 {
+		for _, v := range l.items {
+			if v == args[2] && (limit == 0 || removed < limit) {
+				removed++
+				continue
+			}
+			keep = append(keep, v)
+		}
+	} else {
+		for i := len(l.items) - 1; i >= 0; i-- {
+			if l.items[i] == args[2] && removed < limit {
+				removed++
+				continue
+			}
+			keep = append([]string{l.items[i]}, keep...)
+		}
+	}
+	l.items = keep
+	s.dropIfEmpty(args[0], l)
+	return float64(removed), nil
+}
+
+func cmdLRange(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, err
+	}
+	start, err1 := strconv.Atoi(args[1])
+	stop, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	l, err := s.list(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	if l == nil {
+		return out, nil
+	}
+	n := len(l.items)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	for i := start; i <= stop && i < n; i++ {
+		out = append(out, l.items[i])
+	}
+	return out, nil
+}
@@ -0,0 +1,363 @@
+// Package upstashtest provides an in-process fake of the Upstash REST API for
+// tests. It implements just the Redis commands this project uses, with each
+// command, and each /multi-exec transaction, applied atomically like a real
+// single-threaded Redis.
+package upstashtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/upstash"
+)
+
+const token = "test-token"
+
+// Server is a fake Upstash endpoint backed by in-memory data.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	data     map[string]interface{}
+	expireAt map[string]time.Time
+	lastID   streamID
+	commands int
+}
+
+// NewServer starts a fake Upstash server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		data:     make(map[string]interface{}),
+		expireAt: make(map[string]time.Time),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns an upstash.Client pointed at this server.
+func (s *Server) Client() *upstash.Client {
+	return upstash.New(s.URL, token)
+}
+
+// Commands reports how many commands the server has executed.
+func (s *Server) Commands() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+// Exec runs a command directly, bypassing HTTP. Useful for seeding state.
+func (s *Server) Exec(args ...interface{}) (interface{}, error) {
+	strs := make([]string, len(args))
+	for i, a := range args {
+		strs[i] = fmt.Sprint(a)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exec(strs)
+}
+
+type reply struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+token {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	if r.URL.Path == "/pipeline" || r.URL.Path == "/multi-exec" {
+		var cmds [][]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&cmds); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		// トランザクションは全コマンドを 1 回のロックで実行する
+		multi := r.URL.Path == "/multi-exec"
+		if multi {
+			s.mu.Lock()
+		}
+		replies := make([]reply, len(cmds))
+		for i, cmd := range cmds {
+			replies[i] = s.run(cmd, !multi)
+		}
+		if multi {
+			s.mu.Unlock()
+		}
+		json.NewEncoder(w).Encode(replies)
+		return
+	}
+
+	var cmd []interface{}
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	rep := s.run(cmd, true)
+	if rep.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
+
+// run executes cmd, taking the server lock unless the caller holds it.
+func (s *Server) run(cmd []interface{}, lock bool) reply {
+	args := make([]string, len(cmd))
+	for i, a := range cmd {
+		switch v := a.(type) {
+		case string:
+			args[i] = v
+		case float64:
+			args[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			args[i] = fmt.Sprint(v)
+		}
+	}
+	if lock {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	res, err := s.exec(args)
+	if err != nil {
+		return reply{Error: err.Error()}
+	}
+	return reply{Result: res}
+}
+
+var errWrongType = fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func (s *Server) exec(args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("ERR empty command")
+	}
+	s.commands++
+	name := strings.ToUpper(args[0])
+	fn, ok := commands[name]
+	if !ok {
+		return nil, fmt.Errorf("ERR unknown command '%s'", args[0])
+	}
+	for _, key := range keysOf(name, args[1:]) {
+		s.expire(key)
+	}
+	return fn(s, args[1:])
+}
+
+// keysOf returns the keys a command touches so expired ones can be evicted
+// lazily before the command runs.
+func keysOf(name string, args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	switch name {
+	case "DEL", "EXISTS":
+		return args
+	case "RENAME", "LMOVE":
+		return args[:min(2, len(args))]
+	default:
+		return args[:1]
+	}
+}
+
+func (s *Server) expire(key string) {
+	if at, ok := s.expireAt[key]; ok && !time.Now().Before(at) {
+		delete(s.data, key)
+		delete(s.expireAt, key)
+	}
+}
+
+type command func(s *Server, args []string) (interface{}, error)
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"GET":       cmdGet,
+		"GETDEL":    cmdGetDel,
+		"SET":       cmdSet,
+		"DEL":       cmdDel,
+		"EXISTS":    cmdExists,
+		"TYPE":      cmdType,
+		"RENAME":    cmdRename,
+		"EXPIRE":    cmdExpire,
+		"XADD":      cmdXAdd,
+		"XRANGE":    cmdXRange,
+		"XREVRANGE": cmdXRevRange,
+		"XLEN":      cmdXLen,
+		"HSET":      cmdHSet,
+		"HSETNX":    cmdHSetNX,
+		"HGET":      cmdHGet,
+		"HGETALL":   cmdHGetAll,
+		"HDEL":      cmdHDel,
+		"HINCRBY":   cmdHIncrBy,
+		"SADD":      cmdSAdd,
+		"SREM":      cmdSRem,
+		"SMEMBERS":  cmdSMembers,
+		"SISMEMBER": cmdSIsMember,
+		"ZADD":      cmdZAdd,
+		"ZREM":      cmdZRem,
+		"ZSCORE":    cmdZScore,
+		"ZRANGE":    cmdZRange,
+		"ZREVRANGE": cmdZRevRange,
+		"LPUSH":     cmdLPush,
+		"RPUSH":     cmdRPush,
+		"LLEN":      cmdLLen,
+		"LMOVE":     cmdLMove,
+		"LREM":      cmdLRem,
+		"LRANGE":    cmdLRange,
+	}
+}
+
+func arity(args []string, n int) error {
+	if len(args) < n {
+		return fmt.Errorf("ERR wrong number of arguments")
+	}
+	return nil
+}
+
+func cmdGet(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	switch v := s.data[args[0]].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func cmdGetDel(s *Server, args []string) (interface{}, error) {
+	v, err := cmdGet(s, args)
+	if err == nil && v != nil {
+		delete(s.data, args[0])
+		delete(s.expireAt, args[0])
+	}
+	return v, err
+}
+
+func cmdSet(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	key, value := args[0], args[1]
+	var nx bool
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("ERR syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("ERR value is not an integer or out of range")
+			}
+			unit := time.Second
+			if strings.ToUpper(args[i]) == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		}
+	}
+	if _, exists := s.data[key]; nx && exists {
+		return nil, nil
+	}
+	s.data[key] = value
+	delete(s.expireAt, key)
+	if ttl > 0 {
+		s.expireAt[key] = time.Now().Add(ttl)
+	}
+	return "OK", nil
+}
+
+func cmdDel(s *Server, args []string) (interface{}, error) {
+	n := 0
+	for _, key := range args {
+		if _, ok := s.data[key]; ok {
+			delete(s.data, key)
+			delete(s.expireAt, key)
+			n++
+		}
+	}
+	return float64(n), nil
+}
+
+func cmdExists(s *Server, args []string) (interface{}, error) {
+	n := 0
+	for _, key := range args {
+		if _, ok := s.data[key]; ok {
+			n++
+		}
+	}
+	return float64(n), nil
+}
+
+func cmdType(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	switch s.data[args[0]].(type) {
+	case nil:
+		return "none", nil
+	case string:
+		return "string", nil
+	case *stream:
+		return "stream", nil
+	case hash:
+		return "hash", nil
+	case set:
+		return "set", nil
+	case *zset:
+		return "zset", nil
+	case *list:
+		return "list", nil
+	default:
+		return "unknown", nil
+	}
+}
+
+func cmdRename(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	v, ok := s.data[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("ERR no such key")
+	}
+	delete(s.data, args[0])
+	delete(s.expireAt, args[0])
+	s.data[args[1]] = v
+	return "OK", nil
+}
+
+func cmdExpire(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	if _, ok := s.data[args[0]]; !ok {
+		return float64(0), nil
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	s.expireAt[args[0]] = time.Now().Add(time.Duration(n) * time.Second)
+	return float64(1), nil
+}
@@ -0,0 +1,94 @@
+package upstashtest
+
+import "sort"
+
+type set map[string]struct{}
+
+func (s *Server) set(key string, create bool) (set, error) {
+	switch v := s.data[key].(type) {
+	case nil:
+		if !create {
+			return nil, nil
+		}
+		st := set{}
+		s.data[key] = st
+		return st, nil
+	case set:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func cmdSAdd(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	st, err := s.set(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, m := range args[1:] {
+		if _, ok := st[m]; !ok {
+			st[m] = struct{}{}
+			n++
+		}
+	}
+	return float64(n), nil
+}
+
+func cmdSRem(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	st, err := s.set(args[0], false)
+	if err != nil || st == nil {
+		return float64(0), err
+	}
+	n := 0
+	for _, m := range args[1:] {
+		if _, ok := st[m]; ok {
+			delete(st, m)
+			n++
+		}
+	}
+	if len(st) == 0 {
+		delete(s.data, args[0])
+	}
+	return float64(n), nil
+}
+
+func cmdSMembers(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	st, err := s.set(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(st))
+	for m := range st {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	out := make([]interface{}, len(members))
+	for i, m := range members {
+		out[i] = m
+	}
+	return out, nil
+}
+
+func cmdSIsMember(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	st, err := s.set(args[0], false)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := st[args[1]]; ok {
+		return float64(1), nil
+	}
+	return float64(0), nil
+}
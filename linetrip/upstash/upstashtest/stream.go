@@ -0,0 +1,216 @@
+package upstashtest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || (id.ms == o.ms && id.seq < o.seq)
+}
+
+// parseStreamID parses "ms-seq" or "ms". A bare "ms" expands to seq 0 for
+// range starts and to the maximum seq for range ends.
+func parseStreamID(s string, end bool) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+	}
+	if !hasSeq {
+		if end {
+			return streamID{ms, ^uint64(0)}, nil
+		}
+		return streamID{ms, 0}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+	}
+	return streamID{ms, seq}, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+type stream struct {
+	entries []streamEntry
+}
+
+func (s *Server) stream(key string, create bool) (*stream, error) {
+	switch v := s.data[key].(type) {
+	case nil:
+		if !create {
+			return nil, nil
+		}
+		st := &stream{}
+		s.data[key] = st
+		return st, nil
+	case *stream:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func (s *Server) nextStreamID() streamID {
+	now := uint64(time.Now().UnixMilli())
+	if now > s.lastID.ms {
+		s.lastID = streamID{now, 0}
+	} else {
+		s.lastID.seq++
+	}
+	return s.lastID
+}
+
+func cmdXAdd(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 4); err != nil {
+		return nil, err
+	}
+	key := args[0]
+	i := 1
+	maxLen := -1
+	if strings.ToUpper(args[i]) == "MAXLEN" {
+		i++
+		if args[i] == "~" || args[i] == "=" {
+			i++
+		}
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			return nil, fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		maxLen = n
+		i++
+	}
+	if args[i] != "*" {
+		return nil, fmt.Errorf("ERR only auto-generated IDs are supported by the fake")
+	}
+	i++
+	fields := args[i:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("ERR wrong number of arguments for 'xadd' command")
+	}
+
+	st, err := s.stream(key, true)
+	if err != nil {
+		return nil, err
+	}
+	id := s.nextStreamID()
+	st.entries = append(st.entries, streamEntry{id: id, fields: append([]string(nil), fields...)})
+	if maxLen >= 0 && len(st.entries) > maxLen {
+		st.entries = st.entries[len(st.entries)-maxLen:]
+	}
+	return id.String(), nil
+}
+
+// rangeBounds parses XRANGE-style bounds, honouring "-", "+" and the
+// exclusive "(" prefix.
+func rangeBounds(startArg, endArg string) (lo, hi streamID, loEx, hiEx bool, err error) {
+	lo, hi = streamID{0, 0}, streamID{^uint64(0), ^uint64(0)}
+	if startArg != "-" {
+		loEx = strings.HasPrefix(startArg, "(")
+		if lo, err = parseStreamID(strings.TrimPrefix(startArg, "("), false); err != nil {
+			return
+		}
+	}
+	if endArg != "+" {
+		hiEx = strings.HasPrefix(endArg, "(")
+		if hi, err = parseStreamID(strings.TrimPrefix(endArg, "("), true); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func countArg(args []string) (int, error) {
+	if len(args) >= 2 && strings.ToUpper(args[0]) == "COUNT" {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return 0, fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		return n, nil
+	}
+	return -1, nil
+}
+
+func entryReply(e streamEntry) interface{} {
+	fields := make([]interface{}, len(e.fields))
+	for i, f := range e.fields {
+		fields[i] = f
+	}
+	return []interface{}{e.id.String(), fields}
+}
+
+func xrange(s *Server, key, startArg, endArg string, rest []string, reverse bool) (interface{}, error) {
+	st, err := s.stream(key, false)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi, loEx, hiEx, err := rangeBounds(startArg, endArg)
+	if err != nil {
+		return nil, err
+	}
+	count, err := countArg(rest)
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	if st == nil {
+		return out, nil
+	}
+	entries := st.entries
+	if reverse {
+		entries = make([]streamEntry, len(st.entries))
+		copy(entries, st.entries)
+		sort.Slice(entries, func(i, j int) bool { return entries[j].id.less(entries[i].id) })
+	}
+	for _, e := range entries {
+		if count >= 0 && len(out) >= count {
+			break
+		}
+		if e.id.less(lo) || (loEx && e.id == lo) || hi.less(e.id) || (hiEx && e.id == hi) {
+			continue
+		}
+		out = append(out, entryReply(e))
+	}
+	return out, nil
+}
+
+func cmdXRange(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, err
+	}
+	return xrange(s, args[0], args[1], args[2], args[3:], false)
+}
+
+func cmdXRevRange(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, err
+	}
+	// XREVRANGE key end start
+	return xrange(s, args[0], args[2], args[1], args[3:], true)
+}
+
+func cmdXLen(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	st, err := s.stream(args[0], false)
+	if err != nil || st == nil {
+		return float64(0), err
+	}
+	return float64(len(st.entries)), nil
+}
@@ -0,0 +1,219 @@
+package upstashtest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type zset struct {
+	scores map[string]float64
+}
+
+// sorted returns members ordered by score, then lexicographically.
+func (z *zset) sorted() []string {
+	members := make([]string, 0, len(z.scores))
+	for m := range z.scores {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := z.scores[members[i]], z.scores[members[j]]
+		if si != sj {
+			return si < sj
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+func (s *Server) zset(key string, create bool) (*zset, error) {
+	switch v := s.data[key].(type) {
+	case nil:
+		if !create {
+			return nil, nil
+		}
+		z := &zset{scores: map[string]float64{}}
+		s.data[key] = z
+		return z, nil
+	case *zset:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func formatScore(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func cmdZAdd(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, err
+	}
+	key := args[0]
+	var nx, xx, gt, lt bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "CH":
+		default:
+			break flags
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("ERR syntax error")
+	}
+	z, err := s.zset(key, true)
+	if err != nil {
+		return nil, err
+	}
+	added := 0
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := strconv.ParseFloat(pairs[j], 64)
+		if err != nil {
+			return nil, fmt.Errorf("ERR value is not a valid float")
+		}
+		member := pairs[j+1]
+		cur, exists := z.scores[member]
+		switch {
+		case exists && nx, !exists && xx:
+			continue
+		case exists && gt && score <= cur, exists && lt && score >= cur:
+			continue
+		}
+		if !exists {
+			added++
+		}
+		z.scores[member] = score
+	}
+	if len(z.scores) == 0 {
+		delete(s.data, key)
+	}
+	return float64(added), nil
+}
+
+func cmdZRem(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	z, err := s.zset(args[0], false)
+	if err != nil || z == nil {
+		return float64(0), err
+	}
+	n := 0
+	for _, m := range args[1:] {
+		if _, ok := z.scores[m]; ok {
+			delete(z.scores, m)
+			n++
+		}
+	}
+	if len(z.scores) == 0 {
+		delete(s.data, args[0])
+	}
+	return float64(n), nil
+}
+
+func cmdZScore(s *Server, args []string) (interface{}, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	z, err := s.zset(args[0], false)
+	if err != nil || z == nil {
+		return nil, err
+	}
+	score, ok := z.scores[args[1]]
+	if !ok {
+		return nil, nil
+	}
+	return formatScore(score), nil
+}
+
+// zrangeReply slices members by rank like ZRANGE start stop.
+func zrangeReply(z *zset, members []string, startArg, stopArg string, withScores bool) (interface{}, error) {
+	start, err1 := strconv.Atoi(startArg)
+	stop, err2 := strconv.Atoi(stopArg)
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	n := len(members)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	out := []interface{}{}
+	for i := start; i <= stop; i++ {
+		out = append(out, members[i])
+		if withScores {
+			out = append(out, formatScore(z.scores[members[i]]))
+		}
+	}
+	return out, nil
+}
+
+func zrangeArgs(s *Server, args []string) (*zset, bool, bool, error) {
+	if err := arity(args, 3); err != nil {
+		return nil, false, false, err
+	}
+	var withScores, rev bool
+	for _, a := range args[3:] {
+		switch strings.ToUpper(a) {
+		case "WITHSCORES":
+			withScores = true
+		case "REV":
+			rev = true
+		default:
+			return nil, false, false, fmt.Errorf("ERR only rank ranges are supported by the fake")
+		}
+	}
+	z, err := s.zset(args[0], false)
+	return z, withScores, rev, err
+}
+
+func cmdZRange(s *Server, args []string) (interface{}, error) {
+	z, withScores, rev, err := zrangeArgs(s, args)
+	if err != nil {
+		return nil, err
+	}
+	if z == nil {
+		return []interface{}{}, nil
+	}
+	members := z.sorted()
+	if rev {
+		reverse(members)
+	}
+	return zrangeReply(z, members, args[1], args[2], withScores)
+}
+
+func cmdZRevRange(s *Server, args []string) (interface{}, error) {
+	z, withScores, _, err := zrangeArgs(s, args)
+	if err != nil {
+		return nil, err
+	}
+	if z == nil {
+		return []interface{}{}, nil
+	}
+	members := z.sorted()
+	reverse(members)
+	return zrangeReply(z, members, args[1], args[2], withScores)
+}
+
+func reverse(ss []string) {
+	for i, j := 0, len(ss)-1; i < j; i, j = i+1, j-1 {
+		ss[i], ss[j] = ss[j], ss[i]
+	}
+}
@@ -4,25 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"html"
+	"log"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
 )
 
 // Message represents a stored LINE message
-type Message struct {
-	ID        int    `json:"id"`
-	GroupID   string `json:"group_id"`
-	UserID    string `json:"user_id"`
-	Message   string `json:"message"`
-	UserName  string `json:"user_name"`
-	Timestamp int64  `json:"timestamp"`
-	CreatedAt string `json:"created_at"`
-}
-
-// 一時的にメモリ内ストレージ（後でデータベースに置き換え）
-var messages []Message
-var messageID int = 1
+type Message = store.Message
 
 func Handler(w http.ResponseWriter, r *http.Request) {
 	// CORS設定
@@ -54,13 +45,18 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// IDと作成日時を設定
-		msg.ID = messageID
-		messageID++
-		msg.CreatedAt = time.Now().Format(time.RFC3339)
-
-		// メッセージを保存
-		messages = append(messages, msg)
+		// メッセージを保存（IDはストアが採番）
+		messageStore, err := store.Open()
+		if err == nil {
+			msg, err = messageStore.Append(r.Context(), msg)
+		}
+		if err != nil {
+			log.Printf("Error saving message: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save message"})
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -79,6 +75,9 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 func serveJSON(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+
+    messages := loadMessages(r)
+
     // クエリパラメータでフィルタ可能 (line_id)
     lineID := r.URL.Query().Get("line_id")
     filtered := messages
@@ -105,13 +104,27 @@ func serveJSON(w http.ResponseWriter, r *http.Request) {
     response := map[string]interface{}{
         "messages": filtered,
         "count":    len(filtered),
-        "note":     "Note: Messages are stored in memory and will be lost on restart. Use a database for production.",
     }
 	json.NewEncoder(w).Encode(response)
 }
 
+func loadMessages(r *http.Request) []Message {
+	messageStore, err := store.Open()
+	if err != nil {
+		log.Printf("Error opening message store: %v", err)
+		return nil
+	}
+	messages, err := store.AllGroups(r.Context(), messageStore, nil)
+	if err != nil {
+		log.Printf("Error reading messages: %v", err)
+	}
+	return messages
+}
+
 func serveHTML(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	messages := loadMessages(r)
 	
 	htmlContent := `<!DOCTYPE html>
 <html lang="ja">
@@ -324,14 +337,14 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
             </div>
             <div class="message-text">%s</div>
             <div class="message-meta">
-                <div class="meta-item">🆔 ID: %d</div>
+                <div class="meta-item">🆔 ID: %s</div>
                 <div class="meta-item">👥 Group: %s</div>
             </div>
         </div>`,
 				getInitial(msg.UserName),
 				html.EscapeString(msg.UserName),
 				timestamp,
-				html.EscapeString(msg.Message),
+				messageText(msg),
 				msg.ID,
 				truncate(msg.GroupID, 20))
 		}
@@ -339,8 +352,8 @@ func serveHTML(w http.ResponseWriter, r *http.Request) {
 
 	htmlContent += `
         <div class="note">
-            ⚠️ メッセージはメモリ内に保存されており、サーバー再起動時に消えます。<br>
-            本番環境ではデータベースの使用を推奨します。
+            ⚠️ 保存先は環境変数 MESSAGE_STORE（upstash / memory / bolt）で切り替えられます。<br>
+            memory を選んだ場合はサーバー再起動時に消えます。
         </div>
     </div>
 </body>
@@ -361,4 +374,12 @@ func truncate(s string, maxLen int) string {
 		return s
 	}
 	return s[:maxLen] + "..."
-}
\ No newline at end of file
+}
+
+// messageText renders a message body, replacing unsent messages with a note.
+func messageText(msg Message) string {
+	if msg.Deleted {
+		return `<span style="color: #999;">🗑️ 送信取消されたメッセージ</span>`
+	}
+	return html.EscapeString(msg.Message)
+}
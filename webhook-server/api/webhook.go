@@ -1,31 +1,22 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"sync"
+	"time"
 
-	"github.com/line/line-bot-sdk-go/v8/linebot"
 	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/archive"
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
 )
 
-// AppMessage represents a LINE message for the iOS app
-type AppMessage struct {
-	GroupID   string `json:"group_id"`
-	UserID    string `json:"user_id"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
-	UserName  string `json:"user_name"`
-}
-
-// 共有メモリストレージ（本番環境ではデータベースを使用すること）
-var (
-	receivedMessages []AppMessage
-	mu               sync.Mutex
-)
+// AppMessage is the message shape shared with /api/messages and the iOS app.
+type AppMessage = store.Message
 
 func Handler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -44,91 +35,93 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cb, err := webhook.ParseRequest(channelSecret, r)
+	body, err := queue.Verify(channelSecret, r)
 	if err != nil {
 		log.Printf("Webhook parse error: %v", err)
-		if err == linebot.ErrInvalidSignature {
+		if err == webhook.ErrInvalidSignature {
 			w.WriteHeader(http.StatusBadRequest)
 		} else {
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 		return
 	}
+	jobs, err := queue.Jobs(body)
+	if err != nil {
+		log.Printf("Webhook parse error: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	archiveCallback(r.Context(), body)
 
-	for _, event := range cb.Events {
-		switch e := event.(type) {
-		case webhook.MessageEvent:
-			switch message := e.Message.(type) {
-			case webhook.TextMessageContent:
-				handleTextMessage(e, message)
-			}
+	q, err := queue.Open()
+	if err != nil {
+		log.Printf("❌ Error opening webhook queue: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if q == nil {
+		// WEBHOOK_QUEUE=inline: キューを使わずにその場で処理する
+		if err := handleInline(r.Context(), jobs); err != nil {
+			log.Printf("❌ Error preparing inline processing: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
+	} else if err := q.Enqueue(r.Context(), jobs...); err != nil {
+		// 500 を返せば LINE の再送に任せられる
+		log.Printf("❌ Error enqueueing %d events: %v", len(jobs), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else {
+		log.Printf("📥 Enqueued %d events", len(jobs))
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func handleTextMessage(event webhook.MessageEvent, message webhook.TextMessageContent) {
-	// グループソースを取得
-	groupSource, ok := event.Source.(webhook.GroupSource)
-	if !ok {
-		log.Printf("Not a group message, skipping")
-		return
+// handleInline processes events before answering, as the webhook did before
+// the queue existed. Failed events are dead-lettered; LINE is still told
+// success.
+func handleInline(ctx context.Context, jobs []queue.Job) error {
+	messageStore, err := store.Open()
+	if err != nil {
+		return err
 	}
-
-	// ユーザー情報を取得
-	userName := "Unknown User"
-	userID := ""
-	
-	if groupSource.UserId != "" {
-		userID = groupSource.UserId
-		if len(userID) > 8 {
-			userName = fmt.Sprintf("User-%s", userID[:8])
-		} else {
-			userName = fmt.Sprintf("User-%s", userID)
+	deadLetters, err := queue.OpenDeadLetters()
+	if err != nil {
+		return err
+	}
+	ingester := ingest.FromEnv(messageStore)
+	ingester.OnStored = notifyiOSApp
+	for _, job := range jobs {
+		event, err := webhook.UnmarshalEvent(job.Event)
+		if err == nil {
+			err = ingester.HandleEvent(ctx, event)
+		}
+		if err != nil {
+			log.Printf("❌ Error handling event %s: %v", job.ID, err)
+			if err := deadLetters.Add(ctx, queue.NewDeadLetter(job, 1, err)); err != nil {
+				log.Printf("❌ Could not dead-letter event %s, it is lost: %v", job.ID, err)
+			}
 		}
 	}
+	return nil
+}
 
-	appMessage := AppMessage{
-		GroupID:   groupSource.GroupId,
-		UserID:    userID,
-		Message:   message.Text,
-		Timestamp: event.Timestamp,
-		UserName:  userName,
+// archiveCallback keeps the verified body for later rebuilds. Archiving is
+// best effort: events are still processed when it fails.
+func archiveCallback(ctx context.Context, body []byte) {
+	a, err := archive.Open()
+	if err == nil && a != nil {
+		err = a.Append(ctx, time.Now(), body)
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to archive webhook callback: %v", err)
 	}
-
-	// TODO: ここでデータベースに保存
-	notifyiOSApp(appMessage)
-
-	log.Printf("📱 Group Message: %s from %s in group %s", 
-		message.Text, userName, groupSource.GroupId)
 }
 
 func notifyiOSApp(message AppMessage) {
-	// メッセージログ出力
+	// iOSアプリは /api/messages をポーリングして取得する
 	messageJSON, _ := json.MarshalIndent(message, "", "  ")
 	log.Printf("📲 Received LINE Message:\n%s", messageJSON)
-	
-	// メモリ内に保存
-	saveToMemory(message)
 }
-
-func saveToMemory(message AppMessage) {
-	mu.Lock()
-	defer mu.Unlock()
-	
-	receivedMessages = append(receivedMessages, message)
-	log.Printf("✅ Message saved to memory. Total messages: %d", len(receivedMessages))
-}
-
-// GetReceivedMessages returns all received messages (used by messages.go)
-func GetReceivedMessages() []AppMessage {
-	mu.Lock()
-	defer mu.Unlock()
-	
-	// コピーを返す
-	messages := make([]AppMessage, len(receivedMessages))
-	copy(messages, receivedMessages)
-	return messages
-}
\ No newline at end of file
@@ -6,3 +6,12 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/line/line-bot-sdk-go/v8 v8.6.0
 )
+
+require github.com/takuto277/line-trip-list-api/linetrip v0.0.0
+
+require (
+	go.etcd.io/bbolt v1.3.10 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
+
+replace github.com/takuto277/line-trip-list-api/linetrip => ../linetrip
@@ -1,31 +1,50 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/line/line-bot-sdk-go/v8/linebot"
 	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
 	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/takuto277/line-trip-list-api/linetrip/admin"
+	"github.com/takuto277/line-trip-list-api/linetrip/archive"
+	"github.com/takuto277/line-trip-list-api/linetrip/attachment"
+	"github.com/takuto277/line-trip-list-api/linetrip/command"
+	"github.com/takuto277/line-trip-list-api/linetrip/geojson"
+	"github.com/takuto277/line-trip-list-api/linetrip/ingest"
+	"github.com/takuto277/line-trip-list-api/linetrip/lineapi"
+	"github.com/takuto277/line-trip-list-api/linetrip/outbound"
+	"github.com/takuto277/line-trip-list-api/linetrip/profile"
+	"github.com/takuto277/line-trip-list-api/linetrip/queue"
+	"github.com/takuto277/line-trip-list-api/linetrip/store"
+	"github.com/takuto277/line-trip-list-api/linetrip/trip"
 )
 
 type Server struct {
-	bot *messaging_api.MessagingApiAPI
-	blob *messaging_api.MessagingApiBlobAPI
+	bot         *messaging_api.MessagingApiAPI
+	blob        *messaging_api.MessagingApiBlobAPI
+	store       store.MessageStore
+	attachments attachment.Store
+	ingest      *ingest.Ingester
+	archive     archive.Archive
+	queue       *queue.Channel
+	deadLetters queue.DeadLetters
 }
 
-// iOSアプリに送信するメッセージ構造体
-type AppMessage struct {
-	GroupID   string `json:"group_id"`
-	UserID    string `json:"user_id"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
-	UserName  string `json:"user_name"`
-}
+// iOSアプリに送信するメッセージ構造体（/api/messages と共通）
+type AppMessage = store.Message
+
+// 予約送信を確認する間隔（Vercel 版は Cron で毎分）
+const dispatchInterval = 15 * time.Second
 
 func main() {
 	// 開発環境では.envファイルを読み込み
@@ -40,24 +59,106 @@ func main() {
 		log.Fatal("環境変数 LINE_CHANNEL_SECRET, LINE_CHANNEL_TOKEN が設定されていません")
 	}
 
-	bot, err := messaging_api.NewMessagingApiAPI(channelToken)
+	// LINE_API_ENDPOINT を設定すると cmd/fakeline などの偽 LINE API に向けられる
+	bot, err := lineapi.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	blob, err := lineapi.NewBlob()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// MESSAGE_STORE で保存先を切り替え（upstash / memory / bolt）
+	messageStore, err := store.Open()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer messageStore.Close()
 
-	blob, err := messaging_api.NewMessagingApiBlobAPI(channelToken)
+	// ATTACHMENT_STORE で画像などの保存先を切り替え（fs / s3）
+	attachments, err := attachment.Open()
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	server := &Server{
-		bot: bot,
-		blob: blob,
+		bot:         bot,
+		blob:        blob,
+		store:       messageStore,
+		attachments: attachments,
+		ingest:      ingest.New(messageStore),
+		queue:       queue.NewChannel(1000),
+	}
+	server.ingest.OnStored = server.notifyiOSApp
+	server.ingest.Blob = blob
+	server.ingest.Attachments = attachments
+	server.ingest.Profiles = profile.New(messageStore, bot)
+	server.ingest.Members = bot
+	server.ingest.Replier = bot
+	// 返信トークンが残っていれば Reply API、なければ Push で送る
+	sender := outbound.NewSender(bot, messageStore)
+
+	// send_at 付きの送信は予約として保存し、ディスパッチャーが時刻になったら Push する
+	schedule, err := outbound.OpenSchedule(messageStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// チャットコマンド（/trip new・/add 清水寺 など）の旅行と旅行リスト
+	trips, err := trip.Open(messageStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.ingest.Trips = trips
+	// iOS アプリから送ったメッセージも direction: outbound として /messages に残す
+	sender.Timeline = messageStore
+	sender.ActiveTrip = func(ctx context.Context, conversationID string) (string, error) {
+		return trip.ActiveID(ctx, trips, conversationID)
 	}
+	server.ingest.Commands = command.NewRegistry()
+	people := trip.Directory{Store: messageStore, Profiles: server.ingest.Profiles}
+	trip.Register(server.ingest.Commands, trips, people)
+
+	// ARCHIVE_STORE で受信した Webhook の保存先を切り替え（fs / upstash / off）
+	server.archive, err = archive.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 処理に失敗したイベントは KV_REST_API_URL があれば Upstash、なければメモリに残す
+	server.deadLetters, err = queue.OpenDeadLetters()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Webhook はキューに積んで即応答し、イベントはこのワーカーが処理する
+	worker := queue.NewWorker(server.queue, server.ingest.HandleEvent)
+	worker.DeadLetters = server.deadLetters
+	go worker.Run(context.Background())
+	go outbound.NewDispatcher(schedule, sender).Run(context.Background(), dispatchInterval)
 
 	http.HandleFunc("/webhook", server.handleWebhook)
 	http.HandleFunc("/health", server.healthCheck)
-	http.HandleFunc("/send", server.sendMessage) // iOSアプリからのメッセージ送信用
+	http.HandleFunc("/send", outbound.SendHandler(sender, schedule)) // iOSアプリからのメッセージ送信用
+	http.HandleFunc("/scheduled", outbound.ScheduledHandler(schedule))
+	http.HandleFunc("/messages", server.listMessages)
+	http.HandleFunc("/groups", server.listGroups)
+	http.HandleFunc("/content", server.serveContent)
+	http.HandleFunc("/locations", server.listLocations)
+	http.HandleFunc("/trips", trip.TripsHandler(trips))
+	http.HandleFunc("/trips/items", trip.ItemsHandler(trips))
+	http.HandleFunc("/trips/", trip.Subresources("/trips/", map[string]http.Handler{
+		"itinerary":  trip.ItineraryHandler(trips),
+		"settlement": trip.SettlementHandler(trips, people),
+		"budget":     trip.BudgetHandler(trips, people),
+		"share":      trip.ShareHandler(trips, sender),
+	}))
+	http.HandleFunc("/admin/rates", admin.Rates(trips))
+	http.HandleFunc("/admin/dead_letters", admin.DeadLetters(server.deadLetters, func(ctx context.Context, job queue.Job) error {
+		return server.queue.Enqueue(ctx, job)
+	}))
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -69,118 +170,144 @@ func main() {
 }
 
 func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	cb, err := webhook.ParseRequest(os.Getenv("LINE_CHANNEL_SECRET"), r)
+	body, err := queue.Verify(os.Getenv("LINE_CHANNEL_SECRET"), r)
 	if err != nil {
 		log.Printf("Webhook parse error: %v", err)
-		if err == linebot.ErrInvalidSignature {
+		if err == webhook.ErrInvalidSignature {
 			w.WriteHeader(400)
 		} else {
 			w.WriteHeader(500)
 		}
 		return
 	}
+	jobs, err := queue.Jobs(body)
+	if err != nil {
+		log.Printf("Webhook parse error: %v", err)
+		w.WriteHeader(400)
+		return
+	}
 
-	for _, event := range cb.Events {
-		switch e := event.(type) {
-		case webhook.MessageEvent:
-			switch message := e.Message.(type) {
-			case webhook.TextMessageContent:
-				s.handleTextMessage(e, message)
-			}
+	// 再構築用に受信したままの内容を残す（失敗しても処理は続ける）
+	if s.archive != nil {
+		if err := s.archive.Append(r.Context(), time.Now(), body); err != nil {
+			log.Printf("⚠️ Failed to archive webhook callback: %v", err)
 		}
 	}
-}
 
-func (s *Server) handleTextMessage(event webhook.MessageEvent, message webhook.TextMessageContent) {
-	// グループソースを取得
-	groupSource, ok := event.Source.(webhook.GroupSource)
-	if !ok {
-		// グループメッセージでない場合は処理しない
+	// 重複配信の除外・保存はワーカー経由で ingest パッケージ（Vercel 版と共通）が行う
+	if err := s.queue.Enqueue(r.Context(), jobs...); err != nil {
+		log.Printf("❌ Error enqueueing %d events: %v", len(jobs), err)
+		w.WriteHeader(500)
 		return
 	}
+}
 
-	// ユーザー情報を取得
-	userName := "Unknown User"
-	userID := ""
-	
-	// UserIdを安全に取得
-	if groupSource.UserId != "" {
-		userID = groupSource.UserId
-		userName = fmt.Sprintf("User-%s", userID[:8]) // 短縮表示
-	}
+func (s *Server) notifyiOSApp(message AppMessage) {
+	// TODO: ここでiOSアプリに通知（Firebase Cloud Messaging、WebSocket等）
+	// 現状 iOSアプリは /messages をポーリングして取得する
+	messageJSON, _ := json.MarshalIndent(message, "", "  ")
+	fmt.Printf("📲 Notifying iOS App:\n%s\n", messageJSON)
+}
+
+func (s *Server) listMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-	appMessage := AppMessage{
-		GroupID:   groupSource.GroupId,
-		UserID:    userID,
-		Message:   message.Text,
-		Timestamp: event.Timestamp,
-		UserName:  userName,
+	// クエリの解釈とレスポンスの形は Vercel の /api/messages と同じ
+	query, err := store.ParseQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
 	}
 
-	// ここでiOSアプリに通知を送信
-	// 実際の実装では、Firebase Cloud Messaging、WebSocket、
-	// または専用のプッシュサービスを使用
-	s.notifyiOSApp(appMessage)
+	page, err := store.Paginate(r.Context(), s.store, query)
+	if err != nil {
+		log.Printf("Error reading messages: %v", err)
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load messages"})
+		return
+	}
 
-	log.Printf("📱 Group Message: %s from %s in group %s", 
-		message.Text, userName, groupSource.GroupId)
+	attachment.Link(page.Messages, attachment.Endpoint(r, "/content"))
+	json.NewEncoder(w).Encode(page.Response())
 }
 
-func (s *Server) notifyiOSApp(message AppMessage) {
-	// TODO: ここでiOSアプリに通知
-	// 例: Firebase Cloud Messaging, WebSocket, HTTP POST等
-	
-	// とりあえずログ出力
-	messageJSON, _ := json.MarshalIndent(message, "", "  ")
-	fmt.Printf("📲 Notifying iOS App:\n%s\n", messageJSON)
-	
-	// 実際の実装例（HTTPエンドポイント経由）:
-	// - Firebase Cloud Messaging API
-	// - WebSocket接続
-	// - Database経由でポーリング
-}
+func (s *Server) listLocations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var groupIDs []string
+	if groupID := r.URL.Query().Get("group_id"); groupID != "" {
+		groupIDs = []string{groupID}
+	}
 
-func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		w.WriteHeader(405)
+	locations, err := store.Locations(r.Context(), s.store, groupIDs)
+	if err != nil {
+		log.Printf("Error reading locations: %v", err)
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load locations"})
 		return
 	}
 
-	var req struct {
-		GroupID string `json:"group_id"`
-		Message string `json:"message"`
+	if r.URL.Query().Get("format") == "geojson" || strings.Contains(r.Header.Get("Accept"), "application/geo+json") {
+		w.Header().Set("Content-Type", "application/geo+json")
+		json.NewEncoder(w).Encode(geojson.FromMessages(locations))
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"locations": locations,
+		"count":     len(locations),
+	})
+}
+
+func (s *Server) serveContent(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if !attachment.ValidKey(key) {
 		w.WriteHeader(400)
 		return
 	}
 
-	if req.GroupID == "" || req.Message == "" {
-		w.WriteHeader(400)
+	body, contentType, err := s.attachments.Get(r.Context(), key)
+	if errors.Is(err, attachment.ErrNotFound) {
+		w.WriteHeader(404)
 		return
 	}
+	if err != nil {
+		log.Printf("Error reading content %s: %v", key, err)
+		w.WriteHeader(500)
+		return
+	}
+	defer body.Close()
 
-	_, err := s.bot.PushMessage(&messaging_api.PushMessageRequest{
-		To: req.GroupID,
-		Messages: []messaging_api.MessageInterface{
-			&messaging_api.TextMessage{
-				Text: req.Message,
-			},
-		},
-	}, "")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	io.Copy(w, body)
+}
 
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	groups, err := s.store.Groups(r.Context())
 	if err != nil {
-		log.Printf("Send message error: %v", err)
+		log.Printf("Error reading groups: %v", err)
 		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load groups"})
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groups": groups,
+		"count":  len(groups),
+	})
 }
 
 func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	response := map[string]interface{}{"status": "ok"}
+	if counters, err := s.store.Counters(r.Context()); err == nil {
+		response["ingest"] = counters
+	}
+	json.NewEncoder(w).Encode(response)
 }